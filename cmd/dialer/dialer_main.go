@@ -0,0 +1,106 @@
+/*
+Places outbound calls for a campaign, the answered calls are connected to the cmd/twilio server.
+The contacts csv needs a "number" column, all other columns are passed to the call as stream parameters:
+number,agent_profile_id,name
++14155550100,default,Peter
+
+	go run cmd/dialer/dialer_main.go -contacts contacts.csv -from +14155550199 \
+	  -voice-url https://vocode-golang.fly.dev/twiml/voice -status-url https://vocode-golang.fly.dev/twiml/status \
+	  -concurrency 2 -calling-hours 09:00-18:00 -time-zone America/Los_Angeles -results output/results.csv
+*/
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/joho/godotenv"
+	"github.com/petrzlen/vocode-golang/internal/utils"
+	"github.com/petrzlen/vocode-golang/pkg/dialer"
+	"github.com/petrzlen/vocode-golang/pkg/telephony"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"syscall"
+	"time"
+)
+
+func main() {
+	utils.SetupZerolog()
+
+	contactsFilename := flag.String("contacts", "", "csv with a 'number' column, other columns are passed as stream parameters")
+	resultsFilename := flag.String("results", "output/dialer-results.csv", "csv to write a result per number into")
+	from := flag.String("from", "", "your Twilio phone number to call from")
+	voiceUrl := flag.String("voice-url", "", "the TwiML voice webhook of the cmd/twilio server, e.g. https://vocode-golang.fly.dev/twiml/voice")
+	statusUrl := flag.String("status-url", "", "optional call status webhook, e.g. https://vocode-golang.fly.dev/twiml/status")
+	concurrency := flag.Int("concurrency", 1, "max number of calls in progress at the same time")
+	callingHours := flag.String("calling-hours", "", "only call within these hours, e.g. 09:00-18:00")
+	timeZone := flag.String("time-zone", "Local", "time zone of the calling hours, e.g. America/Los_Angeles")
+	flag.Parse()
+	if *contactsFilename == "" || *from == "" || *voiceUrl == "" {
+		flag.Usage()
+		log.Fatal().Msg("-contacts, -from and -voice-url are required")
+	}
+
+	// Load the .env file
+	err := godotenv.Load()
+	if err != nil {
+		log.Warn().Msgf("Cannot load .env file")
+	}
+	accountSid := os.Getenv("TWILIO_ACCOUNT_SID")
+	authToken := os.Getenv("TWILIO_AUTH_TOKEN")
+	if accountSid == "" || authToken == "" {
+		log.Panic().Msgf("TWILIO_ACCOUNT_SID and TWILIO_AUTH_TOKEN have to be set")
+	}
+	client := telephony.NewTwilioClient(telephony.TwilioClientConfig{
+		AccountSid: accountSid,
+		AuthToken:  authToken,
+		BaseUrl:    os.Getenv("TWILIO_API_BASE_URL"),
+	})
+
+	contactsFile, err := os.Open(*contactsFilename)
+	ftl(err)
+	contacts, err := dialer.ReadContactsCsv(contactsFile)
+	ftl(err)
+	errLog(contactsFile.Close(), "contactsFile.Close")
+
+	hours, err := dialer.ParseCallingHours(*callingHours, *timeZone)
+	ftl(err)
+
+	resultsFile, err := os.Create(*resultsFilename)
+	ftl(err)
+	defer func() { errLog(resultsFile.Close(), "resultsFile.Close") }()
+	writeResult, err := dialer.NewResultsCsvWriter(resultsFile)
+	ftl(err)
+
+	// On Ctrl+C we stop dialing new numbers, and record the calls in progress as interrupted.
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	err = dialer.Run(ctx, client, dialer.Config{
+		From:              *from,
+		VoiceUrl:          *voiceUrl,
+		StatusCallbackUrl: *statusUrl,
+		Concurrency:       *concurrency,
+		CallingHours:      hours,
+		PollInterval:      5 * time.Second,
+		MaxCallDuration:   time.Hour,
+	}, contacts, func(result dialer.Result) {
+		errLog(writeResult(result), "writeResult")
+	})
+	errLog(err, "dialer.Run")
+}
+
+func ftl(err error) {
+	if err != nil {
+		log.Fatal().Err(err).Msg("sth essential failed")
+		debug.PrintStack()
+	}
+}
+
+func errLog(err error, what string) {
+	if err != nil {
+		log.Error().Err(errors.WithStack(err)).Msg(what)
+	}
+}
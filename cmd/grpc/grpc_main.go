@@ -0,0 +1,140 @@
+/*
+Serve the voice pipeline as a gRPC API, for clients which bring their own audio, e.g. a kiosk or a game:
+
+	go run cmd/grpc/grpc_main.go
+
+The API is vocode.voice.v1.VoiceService in pkg/voicepb/voice.proto, a single bidirectional Converse stream:
+the client sends StartConversation, then linear16 AudioFrame-s and control events,
+and gets back the transcript, the agent tokens and the synthesized audio.
+The StartConversation parameters are passed to the call, same as the Twilio <Parameter>-s.
+
+The clients authenticate with GRPC_API_KEY as the "authorization: Bearer <key>" metadata. Set GRPC_TLS_CERT_FILE
+and GRPC_TLS_KEY_FILE to serve over TLS, otherwise the key travels in plaintext, e.g. behind a TLS terminating proxy.
+*/
+package main
+
+import (
+	"context"
+	"github.com/joho/godotenv"
+	"github.com/petrzlen/vocode-golang/internal/networking"
+	"github.com/petrzlen/vocode-golang/internal/utils"
+	"github.com/petrzlen/vocode-golang/pkg/agent"
+	"github.com/petrzlen/vocode-golang/pkg/audioio"
+	"github.com/petrzlen/vocode-golang/pkg/pipeline"
+	"github.com/petrzlen/vocode-golang/pkg/synthesizer"
+	"github.com/petrzlen/vocode-golang/pkg/transcriber"
+	"github.com/petrzlen/vocode-golang/pkg/voicepb"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/sashabaranov/go-openai"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"net"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"syscall"
+	"time"
+)
+
+func main() {
+	utils.SetupZerolog()
+
+	// Load the .env file
+	err := godotenv.Load()
+	if err != nil {
+		log.Warn().Msgf("Cannot load .env file")
+	}
+	openAIAPIKey := os.Getenv("OPEN_AI_API_KEY")
+	if openAIAPIKey == "" {
+		log.Panic().Msgf("OPEN_AI_API_KEY is not set")
+	}
+	client := openai.NewClient(openAIAPIKey)
+	grpcApiKey := os.Getenv("GRPC_API_KEY")
+	if grpcApiKey == "" {
+		log.Panic().Msgf("GRPC_API_KEY is not set")
+	}
+
+	providers := pipeline.Providers{
+		Transcriber: transcriber.NewOpenAIWhisper(client),
+		ChatAgent:   agent.NewOpenAIChatAgent(client),
+		NewSynthesizer: func(voice string) synthesizer.Synthesizer {
+			return synthesizer.NewOpenAITTSWithVoice(openAIAPIKey, voice)
+		},
+	}
+
+	recordingsDir := os.Getenv("RECORDINGS_DIR")
+	if recordingsDir == "" {
+		recordingsDir = "output"
+	}
+	recordingSink := audioio.NewLocalDirRecordingSink(recordingsDir)
+
+	service := networking.NewGrpcVoiceService(func() networking.GrpcConversationHandler {
+		handler := audioio.NewGrpcHandler(func(device audioio.DuplexDevice, start *voicepb.StartConversation) error {
+			callConfig := pipeline.NewCallConfig(pipeline.DefaultAgentProfiles, start.Parameters)
+			return pipeline.Start(providers, callConfig, device, device)
+		})
+		handler.SetRecordingSink(recordingSink)
+		return handler
+	})
+
+	listenAddr := os.Getenv("GRPC_LISTEN_ADDR")
+	if listenAddr == "" {
+		listenAddr = ":50051"
+	}
+	// SHUTDOWN_DRAIN_TIMEOUT is how long active conversations can continue after SIGTERM.
+	drainTimeout := 25 * time.Second
+	if value := os.Getenv("SHUTDOWN_DRAIN_TIMEOUT"); value != "" {
+		drainTimeout, err = time.ParseDuration(value)
+		ftl(err)
+	}
+
+	listener, err := net.Listen("tcp", listenAddr)
+	ftl(err)
+	serverOptions := []grpc.ServerOption{grpc.StreamInterceptor(networking.RequireGrpcApiKey(grpcApiKey))}
+	tlsCertFile, tlsKeyFile := os.Getenv("GRPC_TLS_CERT_FILE"), os.Getenv("GRPC_TLS_KEY_FILE")
+	if tlsCertFile != "" || tlsKeyFile != "" {
+		creds, err := credentials.NewServerTLSFromFile(tlsCertFile, tlsKeyFile)
+		ftl(err)
+		serverOptions = append(serverOptions, grpc.Creds(creds))
+	} else {
+		log.Warn().Msg("GRPC_TLS_CERT_FILE is not set, serving plaintext, so the api key is only safe behind a TLS terminating proxy")
+	}
+	server := grpc.NewServer(serverOptions...)
+	voicepb.RegisterVoiceServiceServer(server, service)
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			ftl(err)
+		}
+	}()
+	log.Info().Str("listen_addr", listenAddr).Msg("grpc voice service listening")
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	log.Info().Dur("drain_timeout", drainTimeout).Int("num_conversations", service.Count()).Msg("shutting down")
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	if err := service.Shutdown(drainCtx); err != nil {
+		// The rest of the conversations get canceled.
+		errLog(err, "service.Shutdown")
+		server.Stop()
+	} else {
+		server.GracefulStop()
+	}
+	log.Info().Msg("shut down")
+}
+
+func ftl(err error) {
+	if err != nil {
+		log.Fatal().Err(err).Msg("sth essential failed")
+		debug.PrintStack()
+	}
+}
+
+func errLog(err error, what string) {
+	if err != nil {
+		log.Error().Err(errors.WithStack(err)).Msg(what)
+	}
+}
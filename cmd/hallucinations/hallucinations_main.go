@@ -0,0 +1,99 @@
+/*
+Runs the default hallucination filter over the corpus of real Whisper hallucinations, and lists the mismatches:
+
+	go run cmd/hallucinations/hallucinations_main.go -corpus pkg/transcriber/testdata/hallucinations.json
+
+Add the new ones you come across in the call logs to the corpus, with what should be left of them.
+*/
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/petrzlen/vocode-golang/internal/utils"
+	"github.com/petrzlen/vocode-golang/pkg/transcriber"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"os"
+	"runtime/debug"
+	"time"
+)
+
+type corpusSegment struct {
+	Text             string  `json:"text"`
+	Start            float64 `json:"start"`
+	End              float64 `json:"end"`
+	AvgLogProb       float64 `json:"avg_logprob"`
+	NoSpeechProb     float64 `json:"no_speech_prob"`
+	CompressionRatio float64 `json:"compression_ratio"`
+}
+
+type corpusEntry struct {
+	Name         string          `json:"name"`
+	Language     string          `json:"language"`
+	Text         string          `json:"text"`
+	AudioMs      int             `json:"audio_ms"`
+	PreviousText string          `json:"previous_text"`
+	Segments     []corpusSegment `json:"segments"`
+	// Expected is what is left after the filter, empty when it is all made up.
+	Expected string `json:"expected"`
+}
+
+func main() {
+	utils.SetupZerolog()
+	corpusPath := flag.String("corpus", "pkg/transcriber/testdata/hallucinations.json", "the corpus of transcriptions with what is expected to be left of them")
+	verbose := flag.Bool("v", false, "log why the filter changed each")
+	flag.Parse()
+	if !*verbose {
+		zerolog.SetGlobalLevel(zerolog.WarnLevel)
+	}
+
+	corpusBytes, err := os.ReadFile(*corpusPath)
+	ftl(err)
+	var corpus []corpusEntry
+	ftl(json.Unmarshal(corpusBytes, &corpus))
+
+	filter := transcriber.NewHallucinationFilterFromConfig(transcriber.DefaultHallucinationFilterConfig)
+	mismatches := 0
+	for _, entry := range corpus {
+		transcription := transcriber.Transcription{
+			Text:     entry.Text,
+			Language: entry.Language,
+			Segments: make([]transcriber.TranscriptionSegment, 0, len(entry.Segments)),
+		}
+		for _, segment := range entry.Segments {
+			transcription.Segments = append(transcription.Segments, transcriber.TranscriptionSegment{
+				Text:             segment.Text,
+				Start:            time.Duration(segment.Start * float64(time.Second)),
+				End:              time.Duration(segment.End * float64(time.Second)),
+				AvgLogProb:       segment.AvgLogProb,
+				NoSpeechProb:     segment.NoSpeechProb,
+				CompressionRatio: segment.CompressionRatio,
+			})
+		}
+
+		filtered := filter.Filter(transcriber.FilterInput{
+			Transcription: transcription,
+			AudioLength:   time.Duration(entry.AudioMs) * time.Millisecond,
+			PreviousText:  entry.PreviousText,
+			Language:      entry.Language,
+		})
+		if filtered != entry.Expected {
+			mismatches++
+			fmt.Printf("MISMATCH %s\n  text:     %q\n  expected: %q\n  filtered: %q\n", entry.Name, entry.Text, entry.Expected, filtered)
+		}
+	}
+
+	fmt.Printf("%d of %d as expected\n", len(corpus)-mismatches, len(corpus))
+	if mismatches > 0 {
+		os.Exit(1)
+	}
+}
+
+func ftl(err error) {
+	if err != nil {
+		log.Fatal().Err(err).Msg("sth essential failed")
+		debug.PrintStack()
+	}
+}
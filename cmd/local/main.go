@@ -40,9 +40,9 @@ func setupSignalHandler(cleanup func()) {
 }
 
 func compareToFullTranscript(transcriber transcriber.Transcriber, wavBytes []byte, finalTranscriptFromSlices string) {
-	fullResult, err := transcriber.SendAudio(bytes.NewReader(wavBytes), "wav", "")
+	fullResult, err := transcriber.SendAudio(bytes.NewReader(wavBytes), "wav", "", "")
 	dbg(err)
-	log.Info().Str("full_transcript", fullResult).Str("sliced_together_transcript", finalTranscriptFromSlices).Msg("comparing full transcript to from slices")
+	log.Info().Str("full_transcript", fullResult.Text).Str("sliced_together_transcript", finalTranscriptFromSlices).Msg("comparing full transcript to from slices")
 }
 
 func fillerWordRoutine(chatAgent agent.ChatAgent, tts synthesizer.Synthesizer, earlyTranscriptChan chan string, audioOutputChan chan models.AudioData) {
@@ -168,7 +168,7 @@ func main() {
 	inputAudioChunksChan := make(chan models.AudioData, 100000)
 	inputTextChunksChan := make(chan models.AudioData, 100000)
 	earlyTranscriptChan := make(chan string, 10)
-	go transcriber.TranscribeAudioRoutine(whisper, inputAudioChunksChan, inputTextChunksChan, earlyTranscriptChan)
+	go transcriber.TranscribeAudioRoutine(transcriber.NewVadStreamingTranscriber(whisper, transcriber.DefaultVadConfig), nil, inputAudioChunksChan, inputTextChunksChan, earlyTranscriptChan)
 	go audioio.PlayAudioChunksRoutine(audioOutput, audioToPlayChan)
 
 	fullConvo := &models.Conversation{}
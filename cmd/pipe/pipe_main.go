@@ -0,0 +1,98 @@
+/*
+Talk to the agent through raw PCM pipes, for Linux boxes where the malgo (cgo) build of cmd/local is a pain:
+
+	arecord -f S16_LE -r 16000 -c 1 -t raw | go run cmd/pipe/pipe_main.go -rate 16000 | aplay -f S16_LE -r 16000 -c 1 -t raw
+
+stdin is read as signed 16bit little-endian samples, and the agent is written to stdout in the same format,
+with silence in between so the player keeps going. The logs go to stderr.
+Works with a file too, e.g. `sox hello.wav -t raw -r 16000 -b 16 -e signed -c 1 - | go run cmd/pipe/pipe_main.go > out.raw`,
+then it waits for the -linger duration after the end of stdin, so the agent can reply.
+*/
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/joho/godotenv"
+	"github.com/petrzlen/vocode-golang/internal/utils"
+	"github.com/petrzlen/vocode-golang/pkg/agent"
+	"github.com/petrzlen/vocode-golang/pkg/audioio"
+	"github.com/petrzlen/vocode-golang/pkg/pipeline"
+	"github.com/petrzlen/vocode-golang/pkg/synthesizer"
+	"github.com/petrzlen/vocode-golang/pkg/transcriber"
+	"github.com/rs/zerolog/log"
+	"github.com/sashabaranov/go-openai"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func main() {
+	// stdout is for the audio.
+	utils.SetupZerologWithOutput(os.Stderr)
+
+	sampleRate := flag.Int("rate", 16000, "sample rate of both stdin and stdout")
+	channels := flag.Int("channels", 1, "number of interleaved channels of both stdin and stdout, 1 or 2")
+	parameters := flag.String("parameters", "", "comma separated name=value pairs, same as the Twilio <Parameter>-s")
+	linger := flag.Duration("linger", 10*time.Second, "how long to wait for the agent after stdin ended")
+	flag.Parse()
+
+	// Load the .env file
+	err := godotenv.Load()
+	if err != nil {
+		log.Warn().Msgf("Cannot load .env file")
+	}
+	openAIAPIKey := os.Getenv("OPEN_AI_API_KEY")
+	if openAIAPIKey == "" {
+		log.Panic().Msgf("OPEN_AI_API_KEY is not set")
+	}
+	client := openai.NewClient(openAIAPIKey)
+
+	providers := pipeline.Providers{
+		Transcriber: transcriber.NewOpenAIWhisper(client),
+		ChatAgent:   agent.NewOpenAIChatAgent(client),
+		NewSynthesizer: func(voice string) synthesizer.Synthesizer {
+			return synthesizer.NewOpenAITTSWithVoice(openAIAPIKey, voice)
+		},
+	}
+
+	callParameters := make(map[string]string)
+	for _, pair := range strings.Split(*parameters, ",") {
+		if name, value, found := strings.Cut(pair, "="); found {
+			callParameters[name] = value
+		}
+	}
+
+	device, err := audioio.NewPipeDevice(os.Stdin, os.Stdout, *sampleRate, *channels)
+	ftl(err)
+	ftl(pipeline.Start(providers, pipeline.NewCallConfig(pipeline.DefaultAgentProfiles, callParameters), device, device))
+	log.Info().Int("sample_rate", *sampleRate).Int("channels", *channels).Msg("piping started")
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	select {
+	case <-ctx.Done():
+	case <-device.Done():
+		log.Info().Dur("linger", *linger).Msg("stdin ended, waiting for the agent")
+		select {
+		case <-ctx.Done():
+		case <-time.After(*linger):
+		}
+		// The reply might have come in just now.
+		for device.IsPlaying() && ctx.Err() == nil {
+			time.Sleep(audioio.PipeFrameDuration)
+		}
+	}
+	ftl(device.Stop())
+	log.Info().Msg("piping done")
+}
+
+func ftl(err error) {
+	if err != nil {
+		log.Fatal().Err(err).Msg("sth essential failed")
+		debug.PrintStack()
+	}
+}
@@ -0,0 +1,135 @@
+/*
+Answers SIP calls from a PBX directly, without Twilio in between. Only UDP, PCMU / PCMA and no SIP auth,
+so it listens on 127.0.0.1:5060 and only answers the PBX-es in SIP_ALLOWED_SOURCES, e.g. with Asterisk PJSIP:
+
+	[vocode]
+	type=endpoint
+	context=from-vocode
+	disallow=all
+	allow=ulaw,alaw
+	aors=vocode
+	[vocode]
+	type=aor
+	contact=sip:vocode@10.0.0.5:5060
+
+and in the dialplan Dial(PJSIP/vocode), optionally with b(vocode-headers) setting PJSIP_HEADER(add,X-Vocode-Agent-Profile-Id).
+
+	SIP_LISTEN_ADDR=10.0.0.5:5060 SIP_ALLOWED_SOURCES=10.0.0.2 go run cmd/sip/sip_main.go
+
+To try it locally without a PBX, call it with the scripted peer:
+
+	go run cmd/sipcall/sipcall_main.go -target 127.0.0.1:5060 -play input/hello.wav
+*/
+package main
+
+import (
+	"context"
+	"github.com/joho/godotenv"
+	"github.com/petrzlen/vocode-golang/internal/networking"
+	"github.com/petrzlen/vocode-golang/internal/utils"
+	"github.com/petrzlen/vocode-golang/pkg/agent"
+	"github.com/petrzlen/vocode-golang/pkg/audioio"
+	"github.com/petrzlen/vocode-golang/pkg/pipeline"
+	"github.com/petrzlen/vocode-golang/pkg/synthesizer"
+	"github.com/petrzlen/vocode-golang/pkg/transcriber"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/sashabaranov/go-openai"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"syscall"
+	"time"
+)
+
+func main() {
+	utils.SetupZerolog()
+
+	// Load the .env file
+	err := godotenv.Load()
+	if err != nil {
+		log.Warn().Msgf("Cannot load .env file")
+	}
+	openAIAPIKey := os.Getenv("OPEN_AI_API_KEY")
+	if openAIAPIKey == "" {
+		log.Panic().Msgf("OPEN_AI_API_KEY is not set")
+	}
+	client := openai.NewClient(openAIAPIKey)
+
+	providers := pipeline.Providers{
+		Transcriber: transcriber.NewOpenAIWhisper(client),
+		ChatAgent:   agent.NewOpenAIChatAgent(client),
+		NewSynthesizer: func(voice string) synthesizer.Synthesizer {
+			return synthesizer.NewOpenAITTSWithVoice(openAIAPIKey, voice)
+		},
+	}
+
+	recordingsDir := os.Getenv("RECORDINGS_DIR")
+	if recordingsDir == "" {
+		recordingsDir = "output"
+	}
+	recordingSink := audioio.NewLocalDirRecordingSink(recordingsDir)
+
+	// SIP_LISTEN_ADDR is where the PBX sends the INVITEs to.
+	listenAddr := os.Getenv("SIP_LISTEN_ADDR")
+	if listenAddr == "" {
+		listenAddr = "127.0.0.1:5060"
+	}
+	// SIP_ALLOWED_SOURCES are comma separated IPs or CIDRs of the PBX-es, loopback is always allowed.
+	allowedSources, err := networking.ParseIpAllowlist(os.Getenv("SIP_ALLOWED_SOURCES"))
+	ftl(err)
+	if len(allowedSources) == 0 {
+		log.Warn().Msg("SIP_ALLOWED_SOURCES is not set, only calls from loopback are answered")
+	}
+	// SHUTDOWN_DRAIN_TIMEOUT is how long active calls can continue after SIGTERM.
+	drainTimeout := 25 * time.Second
+	if value := os.Getenv("SHUTDOWN_DRAIN_TIMEOUT"); value != "" {
+		drainTimeout, err = time.ParseDuration(value)
+		ftl(err)
+	}
+
+	server := networking.NewSipServer(networking.SipServerConfig{
+		ListenAddr: listenAddr,
+		// SIP_PUBLIC_IP is only needed when the PBX reaches us through NAT.
+		PublicIp:       os.Getenv("SIP_PUBLIC_IP"),
+		AllowedSources: allowedSources,
+	}, func(call networking.SipCall) (networking.SipCallHandler, error) {
+		handler := audioio.NewRtpHandler(call)
+		handler.SetRecordingSink(recordingSink)
+
+		parameters := map[string]string{"from": call.From, "to": call.To}
+		for name, value := range call.Parameters {
+			parameters[name] = value
+		}
+		callConfig := pipeline.NewCallConfig(pipeline.DefaultAgentProfiles, parameters)
+		return handler, pipeline.Start(providers, callConfig, handler, handler)
+	})
+	go func() {
+		if err := server.ListenAndServe(); !errors.Is(err, networking.ErrSipServerClosed) {
+			ftl(err)
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	log.Info().Dur("drain_timeout", drainTimeout).Int("num_calls", server.Count()).Msg("shutting down")
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	errLog(server.Shutdown(drainCtx), "server.Shutdown")
+	log.Info().Msg("shut down")
+}
+
+func ftl(err error) {
+	if err != nil {
+		log.Fatal().Err(err).Msg("sth essential failed")
+		debug.PrintStack()
+	}
+}
+
+func errLog(err error, what string) {
+	if err != nil {
+		log.Error().Err(errors.WithStack(err)).Msg(what)
+	}
+}
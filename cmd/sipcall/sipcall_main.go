@@ -0,0 +1,308 @@
+/*
+A scripted SIP peer to test cmd/sip locally over loopback UDP, it plays the role of the PBX:
+INVITE -> 200 OK -> ACK, then streams the wav file over RTP while recording what the agent says,
+and hangs up with BYE after the duration (or answers the BYE of the agent).
+
+	go run cmd/sip/sip_main.go
+	go run cmd/sipcall/sipcall_main.go -target 127.0.0.1:5060 -play input/hello.wav -duration 20s \
+	  -parameters agent_profile_id=default -record output/sipcall.wav
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/go-audio/audio"
+	"github.com/go-audio/wav"
+	"github.com/petrzlen/vocode-golang/internal/networking"
+	"github.com/petrzlen/vocode-golang/internal/utils"
+	"github.com/petrzlen/vocode-golang/pkg/audio_utils"
+	"github.com/petrzlen/vocode-golang/pkg/audioio"
+	"github.com/petrzlen/vocode-golang/pkg/telephony"
+	"github.com/rs/zerolog/log"
+	"net"
+	"os"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+const sipRetransmitInterval = 500 * time.Millisecond
+
+// sipPeer is the caller side of a single call.
+type sipPeer struct {
+	conn       *net.UDPConn
+	targetAddr *net.UDPAddr
+	localAddr  string
+	callId     string
+	fromTag    string
+	toHeader   string
+	cseq       int
+	responses  chan *telephony.SipMessage
+	byeChan    chan struct{}
+}
+
+func main() {
+	utils.SetupZerolog()
+
+	target := flag.String("target", "127.0.0.1:5060", "host:port of the cmd/sip server")
+	playFilename := flag.String("play", "", "optional mono wav to say to the agent, silence otherwise")
+	recordFilename := flag.String("record", "output/sipcall.wav", "wav to save what the agent said into")
+	duration := flag.Duration("duration", 15*time.Second, "how long to stay on the call before hanging up")
+	codec := flag.String("codec", "pcmu", "pcmu or pcma")
+	parameters := flag.String("parameters", "", "comma separated name=value pairs, sent as X-Vocode- headers")
+	flag.Parse()
+
+	payloadType := telephony.RtpPayloadTypePcmu
+	if *codec == "pcma" {
+		payloadType = telephony.RtpPayloadTypePcma
+	}
+	var playSamples []int
+	if *playFilename != "" {
+		playSamples = readWavSamples(*playFilename)
+	}
+
+	targetAddr, err := net.ResolveUDPAddr("udp", *target)
+	ftl(err)
+	conn, err := net.ListenUDP("udp", nil)
+	ftl(err)
+	defer conn.Close()
+	rtpConn, err := net.ListenUDP("udp", nil)
+	ftl(err)
+	localIp := getLocalIp(targetAddr)
+
+	peer := &sipPeer{
+		conn:       conn,
+		targetAddr: targetAddr,
+		localAddr:  net.JoinHostPort(localIp, fmt.Sprint(conn.LocalAddr().(*net.UDPAddr).Port)),
+		callId:     telephony.NewSipTag() + "@" + localIp,
+		fromTag:    telephony.NewSipTag(),
+		toHeader:   fmt.Sprintf("<sip:vocode@%s>", *target),
+		cseq:       0,
+		responses:  make(chan *telephony.SipMessage, 10),
+		byeChan:    make(chan struct{}),
+	}
+	go peer.readRoutine()
+
+	// == INVITE
+	offer := telephony.SdpSession{
+		Address:      localIp,
+		Port:         rtpConn.LocalAddr().(*net.UDPAddr).Port,
+		PayloadTypes: []int{payloadType},
+		SessionId:    time.Now().Unix(),
+	}
+	invite := peer.newRequest("INVITE")
+	for _, pair := range strings.Split(*parameters, ",") {
+		if name, value, found := strings.Cut(pair, "="); found {
+			invite.AddHeader(networking.SipParameterHeaderPrefix+strings.ReplaceAll(name, "_", "-"), value)
+		}
+	}
+	invite.AddHeader("Content-Type", "application/sdp")
+	invite.Body = telephony.RenderSdp(offer)
+	response := peer.sendUntilFinalResponse(invite)
+	if response.StatusCode != 200 {
+		log.Fatal().Int("status_code", response.StatusCode).Str("reason", response.Reason).Msg("call was not answered")
+	}
+	peer.toHeader = response.GetHeader("To")
+	answer, err := telephony.ParseSdp(response.Body)
+	ftl(err)
+	if len(answer.PayloadTypes) == 0 || answer.PayloadTypes[0] != payloadType {
+		log.Fatal().Msgf("unexpected payload types in the answer %v", answer.PayloadTypes)
+	}
+	remoteRtpAddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(answer.Address, fmt.Sprint(answer.Port)))
+	ftl(err)
+	peer.send(peer.newAckRequest())
+	log.Info().Str("call_id", peer.callId).Str("remote_rtp_addr", remoteRtpAddr.String()).Msg("call established")
+
+	// == RTP
+	var receivedMutex sync.Mutex
+	receivedSamples := make([]int, 0)
+	numPackets := 0
+	go func() {
+		buffer := make([]byte, 1500)
+		for {
+			n, _, err := rtpConn.ReadFromUDP(buffer)
+			if err != nil {
+				return
+			}
+			packet, err := audioio.ParseRtpPacket(buffer[:n])
+			if err != nil || packet.PayloadType != payloadType {
+				continue
+			}
+			receivedMutex.Lock()
+			receivedSamples = append(receivedSamples, decode(payloadType, packet.Payload)...)
+			numPackets++
+			receivedMutex.Unlock()
+		}
+	}()
+	go sendRtpRoutine(rtpConn, remoteRtpAddr, payloadType, encode(payloadType, playSamples), peer.byeChan)
+
+	// == Hangup
+	select {
+	case <-peer.byeChan:
+		log.Info().Msg("the agent hung up")
+	case <-time.After(*duration):
+		response = peer.sendUntilFinalResponse(peer.newRequest("BYE"))
+		log.Info().Int("status_code", response.StatusCode).Msg("we hung up")
+	}
+	ftl(rtpConn.Close())
+
+	receivedMutex.Lock()
+	defer receivedMutex.Unlock()
+	log.Info().Int("num_packets", numPackets).Float64("seconds", float64(len(receivedSamples))/telephony.SdpSampleRate).Msg("received agent audio")
+	wavBytes, err := audio_utils.EncodeToWavSimple(&audio.IntBuffer{
+		Data:           receivedSamples,
+		Format:         &audio.Format{SampleRate: telephony.SdpSampleRate, NumChannels: 1},
+		SourceBitDepth: 16,
+	})
+	ftl(err)
+	ftl(os.WriteFile(*recordFilename, wavBytes, 0644))
+	fmt.Printf("sip call done, saved %d packets of agent audio into %s\n", numPackets, *recordFilename)
+}
+
+func (p *sipPeer) newRequest(method string) *telephony.SipMessage {
+	p.cseq++
+	request := &telephony.SipMessage{Method: method, RequestUri: "sip:vocode@" + p.targetAddr.String()}
+	request.AddHeader("Via", fmt.Sprintf("SIP/2.0/UDP %s;branch=%s%s;rport", p.localAddr, telephony.SipBranchPrefix, telephony.NewSipTag()))
+	request.AddHeader("Max-Forwards", "70")
+	request.AddHeader("From", fmt.Sprintf("<sip:+14155550100@%s>;tag=%s", p.localAddr, p.fromTag))
+	request.AddHeader("To", p.toHeader)
+	request.AddHeader("Call-ID", p.callId)
+	request.AddHeader("CSeq", fmt.Sprintf("%d %s", p.cseq, method))
+	request.AddHeader("Contact", fmt.Sprintf("<sip:+14155550100@%s>", p.localAddr))
+	return request
+}
+
+// newAckRequest the ACK of a 200 OK has the CSeq number of the INVITE.
+func (p *sipPeer) newAckRequest() *telephony.SipMessage {
+	ack := p.newRequest("ACK")
+	p.cseq--
+	ack.SetHeader("CSeq", fmt.Sprintf("%d ACK", p.cseq))
+	return ack
+}
+
+func (p *sipPeer) send(msg *telephony.SipMessage) {
+	_, err := p.conn.WriteToUDP(msg.Bytes(), p.targetAddr)
+	ftl(err)
+}
+
+func (p *sipPeer) sendUntilFinalResponse(request *telephony.SipMessage) *telephony.SipMessage {
+	p.send(request)
+	isProvisional := false
+	for {
+		select {
+		case response := <-p.responses:
+			// E.g. a retransmitted 200 OK of the INVITE while waiting for the BYE one.
+			if _, method, err := response.GetCSeq(); err != nil || method != request.Method {
+				continue
+			}
+			if response.StatusCode >= 200 {
+				return response
+			}
+			log.Info().Int("status_code", response.StatusCode).Msgf("%s provisional response", request.Method)
+			isProvisional = true
+		case <-time.After(sipRetransmitInterval):
+			if !isProvisional {
+				p.send(request)
+			}
+		}
+	}
+}
+
+func (p *sipPeer) readRoutine() {
+	buffer := make([]byte, 65535)
+	for {
+		n, _, err := p.conn.ReadFromUDP(buffer)
+		if err != nil {
+			return
+		}
+		msg, err := telephony.ParseSipMessage(append([]byte(nil), buffer[:n]...))
+		if err != nil {
+			log.Warn().Err(err).Msg("ignoring malformed sip message")
+			continue
+		}
+		if !msg.IsRequest() {
+			p.responses <- msg
+			continue
+		}
+		log.Info().Str("method", msg.Method).Msg("received sip request")
+		p.send(telephony.NewSipResponse(msg, 200, "OK"))
+		if msg.Method == "BYE" {
+			select {
+			case <-p.byeChan:
+			default:
+				close(p.byeChan)
+			}
+		}
+	}
+}
+
+// sendRtpRoutine plays the payload in real time and then silence, same as a phone which is just listening.
+func sendRtpRoutine(conn *net.UDPConn, remoteAddr *net.UDPAddr, payloadType int, payload []byte, byeChan chan struct{}) {
+	silence := encode(payloadType, make([]int, audioio.RtpFrameSize))
+	ticker := time.NewTicker(audioio.RtpFrameDuration)
+	defer ticker.Stop()
+	packet := audioio.RtpPacket{PayloadType: payloadType, Ssrc: 0x5eed}
+	for offset := 0; ; offset += audioio.RtpFrameSize {
+		select {
+		case <-byeChan:
+			return
+		case <-ticker.C:
+		}
+		frame := append([]byte(nil), silence...)
+		if offset < len(payload) {
+			copy(frame, payload[offset:min(offset+audioio.RtpFrameSize, len(payload))])
+		}
+		packet.Payload = frame
+		if _, err := conn.WriteToUDP(packet.Bytes(), remoteAddr); err != nil {
+			return
+		}
+		packet.SequenceNumber++
+		packet.Timestamp += uint32(audioio.RtpFrameSize)
+	}
+}
+
+func encode(payloadType int, samples []int) []byte {
+	intBuffer := &audio.IntBuffer{Data: samples, Format: &audio.Format{SampleRate: telephony.SdpSampleRate, NumChannels: 1}, SourceBitDepth: 16}
+	if payloadType == telephony.RtpPayloadTypePcma {
+		return audio_utils.EncodeToAlaw(intBuffer, telephony.SdpSampleRate)
+	}
+	mulawBytes, err := audio_utils.EncodeToMulaw(intBuffer, telephony.SdpSampleRate)
+	ftl(err)
+	return mulawBytes
+}
+
+func decode(payloadType int, payload []byte) []int {
+	if payloadType == telephony.RtpPayloadTypePcma {
+		return audio_utils.DecodeFromAlaw(payload, telephony.SdpSampleRate).Data
+	}
+	return audio_utils.DecodeFromMulaw(payload, telephony.SdpSampleRate).Data
+}
+
+func readWavSamples(filename string) []int {
+	file, err := os.Open(filename)
+	ftl(err)
+	defer file.Close()
+	intBuffer, err := wav.NewDecoder(file).FullPCMBuffer()
+	ftl(err)
+	samples := intBuffer.Data
+	if intBuffer.Format.NumChannels == 2 {
+		samples = audio_utils.StereoToMono(samples)
+	}
+	return audio_utils.ResampleSimple(samples, intBuffer.Format.SampleRate, telephony.SdpSampleRate)
+}
+
+func getLocalIp(remoteAddr *net.UDPAddr) string {
+	conn, err := net.DialUDP("udp", nil, remoteAddr)
+	ftl(err)
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String()
+}
+
+func ftl(err error) {
+	if err != nil {
+		log.Fatal().Err(err).Msg("sth essential failed")
+		debug.PrintStack()
+	}
+}
@@ -3,76 +3,49 @@ To live test it:
 flyctl deploy
 flyctl launch
 ngrok http 8081
-# take the printed url s/https/wss and update the Twiml config (if not already)
+# take the printed url and set the phone number webhooks (if not already)
 # https://console.twilio.com/us1/develop/phone-numbers/manage/incoming
-# <?xml version="1.0" encoding="UTF-8"?>
-# <Response>
-#    <Connect>
-#        <!-- <Stream url="wss://vocode-golang.fly.dev/ws" /> -->
-#      	<Stream url="wss://7e98-24-130-57-37.ngrok-free.app/ws" />
-#    </Connect>
-# </Response>
+# * A call comes in: HTTP POST https://7e98-24-130-57-37.ngrok-free.app/twiml/voice
+# * Call status changes: https://7e98-24-130-57-37.ngrok-free.app/twiml/status
+# The /twiml/voice endpoint then generates the <Connect><Stream url="wss://.../ws"> for you,
+# set TWILIO_STREAM_URL if the websocket lives on a different host.
 go run cmd/twilio/twilio_main.go
 
 # websocat wss://vocode-golang.fly.dev/ws
 # call your Twilio number
+
+# Vonage numbers are served too with VONAGE_SIGNATURE_SECRET set, enable signed webhooks and set the Vonage application webhooks to
+# * Answer URL: https://7e98-24-130-57-37.ngrok-free.app/vonage/answer
+# * Event URL: https://7e98-24-130-57-37.ngrok-free.app/vonage/event
+# Telnyx and Plivo bidirectional streams can connect to /telnyx/ws and /plivo/ws respectively, these are only served
+# with TELNYX_STREAM_TOKEN_SECRET / PLIVO_STREAM_TOKEN_SECRET set, and the stream has to pass
+# telephony.NewStreamToken(secret, call_control_id / callId, ttl) as the stream_token custom parameter / extraHeader.
 */
 package main
 
 import (
+	"context"
 	"github.com/joho/godotenv"
 	"github.com/petrzlen/vocode-golang/internal/networking"
 	"github.com/petrzlen/vocode-golang/internal/utils"
 	"github.com/petrzlen/vocode-golang/pkg/agent"
 	"github.com/petrzlen/vocode-golang/pkg/audioio"
-	"github.com/petrzlen/vocode-golang/pkg/models"
+	"github.com/petrzlen/vocode-golang/pkg/pipeline"
 	"github.com/petrzlen/vocode-golang/pkg/synthesizer"
+	"github.com/petrzlen/vocode-golang/pkg/telephony"
 	"github.com/petrzlen/vocode-golang/pkg/transcriber"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 	"github.com/sashabaranov/go-openai"
 	"net/http"
 	"os"
+	"os/signal"
 	"runtime/debug"
+	"strconv"
+	"syscall"
+	"time"
 )
 
-func submitChatPromptRoutine(chatAgent agent.ChatAgent, transcribedTextChan chan models.AudioData, allChatOutputChan chan string) {
-	var fullConvo models.Conversation
-	fullConvo.Add("assistant", "You are an agent on a phone call, be concise.")
-
-	chatPrompt := ""
-	for inputTextChunk := range transcribedTextChan {
-		// NOTE: This also gets emitted when silence is detected, and if there is too much silence it can spam this.
-		if inputTextChunk.EventType == models.SubmitPrompt {
-			// TODO: There are garbage prompts like " ", " You ", "Bye-bye" which we should ignore
-			// IDEA: We might want to use words-per-minute as good detection if someone is speaking or not.
-			// * i.e. we can refactor models.AudioData into input/output ones, and trace steps for it,
-			//   then we submit ONLY IF there were say three words said within 3 seconds or so.
-			if len(chatPrompt) < 15 {
-				log.Warn().Msgf("chatPrompt is too short %d, skipping", len(chatPrompt))
-				chatPrompt = ""
-				continue
-			}
-
-			fullConvo.Add("user", chatPrompt)
-			chatPrompt = ""
-
-			// TODO: this can lead to multiple agents producing at the same time.
-			subChatOutputChan := make(chan string, 10)
-			go func() {
-				// TODO: memory of what system said
-				errLog(chatAgent.RunPrompt(agent.SlowerAndSmarter, fullConvo, subChatOutputChan), "chatAgent.RunPrompt")
-			}()
-			go func() {
-				for chatOutput := range subChatOutputChan {
-					allChatOutputChan <- chatOutput
-				}
-			}()
-		}
-		chatPrompt += inputTextChunk.Text + " "
-	}
-}
-
 func main() {
 	utils.SetupZerolog()
 
@@ -87,31 +60,129 @@ func main() {
 	}
 	client := openai.NewClient(openAIAPIKey)
 
-	whisper := transcriber.NewOpenAIWhisper(client)
-	chatAgent := agent.NewOpenAIChatAgent(client)
-	tts := synthesizer.NewOpenAITTS(openAIAPIKey)
+	providers := pipeline.Providers{
+		Transcriber: transcriber.NewOpenAIWhisper(client),
+		ChatAgent:   agent.NewOpenAIChatAgent(client),
+		NewSynthesizer: func(voice string) synthesizer.Synthesizer {
+			return synthesizer.NewOpenAITTSWithVoice(openAIAPIKey, voice)
+		},
+	}
+	// WHISPER_CPP_URL (of a whisper.cpp server) or WHISPER_CPP_MODEL (for its CLI) transcribe locally instead of OpenAI.
+	whisperCppConfig := transcriber.DefaultWhisperCppConfig
+	whisperCppConfig.ServerUrl = os.Getenv("WHISPER_CPP_URL")
+	if value := os.Getenv("WHISPER_CPP_MODEL"); value != "" {
+		whisperCppConfig.ModelPath = value
+	}
+	// WHISPER_CPP_THREADS is only for the CLI, WHISPER_CPP_LANGUAGE e.g. "auto" for a multilingual model.
+	if value := os.Getenv("WHISPER_CPP_THREADS"); value != "" {
+		whisperCppConfig.Threads, err = strconv.Atoi(value)
+		ftl(err)
+	}
+	if value := os.Getenv("WHISPER_CPP_LANGUAGE"); value != "" {
+		whisperCppConfig.Language = value
+	}
+	if whisperCppConfig.ServerUrl != "" || os.Getenv("WHISPER_CPP_MODEL") != "" {
+		providers.Transcriber = transcriber.NewWhisperCpp(whisperCppConfig)
+	}
+	// DEEPGRAM_API_KEY switches to live transcription with interim results, instead of Whisper on the VAD segments.
+	if deepgramApiKey := os.Getenv("DEEPGRAM_API_KEY"); deepgramApiKey != "" {
+		deepgramConfig := transcriber.DefaultDeepgramConfig
+		// The phone calls are mulaw at 8kHz, so their frames go to Deepgram as they are.
+		deepgramConfig.Encoding = "mulaw"
+		if value := os.Getenv("DEEPGRAM_URL"); value != "" {
+			deepgramConfig.Url = value
+		}
+		providers.StreamingTranscriber = transcriber.NewDeepgramLive(deepgramApiKey, deepgramConfig)
+	}
 
-	twilioHandlerFactory := func() networking.WebsocketMessageHandler {
-		handler := audioio.NewTwilioHandler()
+	// TWILIO_AUTH_TOKEN both validates the webhook signatures, and signs the stream token passed to the websocket.
+	// Without it anyone could open the websocket and spend the API budget, so it is required,
+	// unless INSECURE_SKIP_TWILIO_AUTH=true e.g. for a local test with websocat.
+	twilioAuthToken := os.Getenv("TWILIO_AUTH_TOKEN")
+	if twilioAuthToken == "" {
+		if os.Getenv("INSECURE_SKIP_TWILIO_AUTH") != "true" {
+			log.Panic().Msgf("TWILIO_AUTH_TOKEN is not set, set INSECURE_SKIP_TWILIO_AUTH=true to serve without it")
+		}
+		log.Warn().Msgf("INSECURE_SKIP_TWILIO_AUTH is set, anyone can call the webhooks and open the websocket")
+	}
 
-		inputAudioChunksChan := make(chan models.AudioData, 100000)
-		inputTextChunksChan := make(chan models.AudioData, 100000)
-		earlyTranscriptChan := make(chan string, 10)
+	// TWILIO_ACCOUNT_SID enables the agent to hangup, transfer or send digits through the REST API.
+	var callControlClient telephony.Client
+	if twilioAccountSid := os.Getenv("TWILIO_ACCOUNT_SID"); twilioAccountSid != "" && twilioAuthToken != "" {
+		callControlClient = telephony.NewTwilioClient(telephony.TwilioClientConfig{
+			AccountSid: twilioAccountSid,
+			AuthToken:  twilioAuthToken,
+			BaseUrl:    os.Getenv("TWILIO_API_BASE_URL"),
+			VoiceUrl:   os.Getenv("TWILIO_VOICE_URL"),
+		})
+	} else {
+		log.Warn().Msgf("TWILIO_ACCOUNT_SID or TWILIO_AUTH_TOKEN is not set, the agent cannot control calls")
+	}
 
-		allChatOutputChan := make(chan string, 100000)
-		allChatOutputChan <- "Hi this is Voxana AMA, ask me anything."
-		audioToPlayChan := make(chan models.AudioData) // non-buffer
+	recordingsDir := os.Getenv("RECORDINGS_DIR")
+	if recordingsDir == "" {
+		recordingsDir = "output"
+	}
+	recordingSink := audioio.NewLocalDirRecordingSink(recordingsDir)
 
-		go transcriber.TranscribeAudioRoutine(whisper, inputAudioChunksChan, inputTextChunksChan, earlyTranscriptChan)
-		go synthesizer.TextToSpeechAndEncodeRoutine(tts, allChatOutputChan, audioToPlayChan)
+	twilioHandlerFactory := func() networking.WebsocketMessageHandler {
+		// The pipeline is only built on "start", as that is when we learn the customParameters for this call.
+		handler := audioio.NewTwilioHandler(func(device audioio.DuplexDevice, start audioio.MediaStreamStart) error {
+			if twilioAuthToken != "" {
+				streamToken := start.CustomParameters[telephony.StreamTokenParameter]
+				if err := telephony.ValidateStreamToken(twilioAuthToken, start.CallId, streamToken); err != nil {
+					return err
+				}
+			}
 
-		go submitChatPromptRoutine(chatAgent, inputTextChunksChan, allChatOutputChan)
+			callConfig := pipeline.NewCallConfig(pipeline.DefaultAgentProfiles, start.CustomParameters, telephony.StreamTokenParameter)
+			callConfig.EnableCallActions = callControlClient != nil
+			return pipeline.Start(providers, callConfig, device, device)
+		})
+		handler.SetRecordingSink(recordingSink)
+		if callControlClient != nil {
+			handler.SetCallControlClient(callControlClient)
+		}
+		return handler
+	}
 
-		// TODO: need to add output buffer to collect what was actually played
-		go audioio.PlayAudioChunksRoutine(handler, audioToPlayChan)
+	// Other carriers with a Twilio-like media stream only need their protocol, the custom parameters
+	// come from their stream config, e.g. Telnyx stream_custom_parameters or Plivo extraHeaders.
+	// There is no signed webhook in front of them, so the stream token is required.
+	mediaStreamHandlerFactory := func(newProtocol func() audioio.MediaStreamProtocol, streamTokenSecret string) func() networking.WebsocketMessageHandler {
+		return func() networking.WebsocketMessageHandler {
+			handler := audioio.NewMediaStreamHandler(newProtocol(), func(device audioio.DuplexDevice, start audioio.MediaStreamStart) error {
+				streamToken := start.CustomParameters[telephony.StreamTokenParameter]
+				if err := telephony.ValidateStreamToken(streamTokenSecret, start.CallId, streamToken); err != nil {
+					return err
+				}
 
-		ftl(handler.StartRecording(inputAudioChunksChan))
+				callConfig := pipeline.NewCallConfig(pipeline.DefaultAgentProfiles, start.CustomParameters, telephony.StreamTokenParameter)
+				return pipeline.Start(providers, callConfig, device, device)
+			})
+			handler.SetRecordingSink(recordingSink)
+			return handler
+		}
+	}
 
+	// VONAGE_SIGNATURE_SECRET validates the signed webhooks, VONAGE_STREAM_TOKEN_SECRET (the same by default)
+	// signs the stream token passed to the Vonage websocket.
+	vonageSignatureSecret := os.Getenv("VONAGE_SIGNATURE_SECRET")
+	vonageStreamTokenSecret := os.Getenv("VONAGE_STREAM_TOKEN_SECRET")
+	if vonageStreamTokenSecret == "" {
+		vonageStreamTokenSecret = vonageSignatureSecret
+	}
+	vonageHandlerFactory := func() networking.TypedWebsocketMessageHandler {
+		handler := audioio.NewVonageHandler(func(device audioio.DuplexDevice, connected audioio.VonageConnectedMessage) error {
+			streamToken := connected.Headers[telephony.StreamTokenParameter]
+			if err := telephony.ValidateStreamToken(vonageStreamTokenSecret, connected.Headers[telephony.VonageCallUuidHeader], streamToken); err != nil {
+				return err
+			}
+
+			callConfig := pipeline.NewCallConfig(pipeline.DefaultAgentProfiles, connected.Headers, telephony.StreamTokenParameter, telephony.VonageCallUuidHeader)
+			return pipeline.Start(providers, callConfig, device, device)
+		})
+		handler.SetRecordingSink(recordingSink)
 		return handler
 	}
 
@@ -120,9 +191,79 @@ func main() {
 	if port == "" {
 		port = "8081"
 	}
+	// SHUTDOWN_DRAIN_TIMEOUT is how long active calls can continue after SIGTERM, keep it below the fly.io kill_timeout.
+	drainTimeout := 25 * time.Second
+	if value := os.Getenv("SHUTDOWN_DRAIN_TIMEOUT"); value != "" {
+		drainTimeout, err = time.ParseDuration(value)
+		ftl(err)
+	}
+
+	registry := networking.NewConnectionRegistry()
+	websocketConfig := networking.DefaultWebsocketConfig
+	websocketConfig.Registry = registry
+	http.HandleFunc("/ws", networking.NewWebsocketHandlerFuncWithConfig(websocketConfig, twilioHandlerFactory))
+	twimlVoiceHandler := networking.NewTwimlVoiceHandlerFunc(networking.TwimlVoiceConfig{
+		StreamPath:         "/ws",
+		StreamUrl:          os.Getenv("TWILIO_STREAM_URL"),
+		ForwardFormValues:  []string{"From", "To"},
+		ForwardQueryValues: true,
+		StreamTokenSecret:  twilioAuthToken,
+		StreamTokenTTL:     time.Minute,
+	})
+	twimlStatusHandler := networking.NewTwimlStatusHandlerFunc(nil)
+	if twilioAuthToken != "" {
+		twimlVoiceHandler = networking.RequireTwilioSignature(twilioAuthToken, twimlVoiceHandler)
+		twimlStatusHandler = networking.RequireTwilioSignature(twilioAuthToken, twimlStatusHandler)
+	}
+	http.HandleFunc("/twiml/voice", twimlVoiceHandler)
+	http.HandleFunc("/twiml/status", twimlStatusHandler)
+
+	if vonageSignatureSecret != "" {
+		http.HandleFunc("/vonage/ws", networking.NewTypedWebsocketHandlerFunc(websocketConfig, vonageHandlerFactory))
+		http.HandleFunc("/vonage/answer", networking.RequireVonageSignature(vonageSignatureSecret, networking.NewNccoAnswerHandlerFunc(networking.NccoAnswerConfig{
+			StreamPath:         "/vonage/ws",
+			StreamUrl:          os.Getenv("VONAGE_STREAM_URL"),
+			SampleRate:         audioio.VonageDefaultSampleRate,
+			ForwardValues:      []string{"from", "to"},
+			ForwardQueryValues: true,
+			StreamTokenSecret:  vonageStreamTokenSecret,
+			StreamTokenTTL:     time.Minute,
+		})))
+		http.HandleFunc("/vonage/event", networking.RequireVonageSignature(vonageSignatureSecret, networking.NewNccoEventHandlerFunc(nil)))
+	} else {
+		log.Warn().Msgf("VONAGE_SIGNATURE_SECRET is not set, not serving /vonage/...")
+	}
+
+	// Point the Telnyx / Plivo stream url to these, e.g. wss://.../telnyx/ws
+	if telnyxStreamTokenSecret := os.Getenv("TELNYX_STREAM_TOKEN_SECRET"); telnyxStreamTokenSecret != "" {
+		http.HandleFunc("/telnyx/ws", networking.NewWebsocketHandlerFuncWithConfig(websocketConfig, mediaStreamHandlerFactory(audioio.NewTelnyxProtocol, telnyxStreamTokenSecret)))
+	} else {
+		log.Warn().Msgf("TELNYX_STREAM_TOKEN_SECRET is not set, not serving /telnyx/ws")
+	}
+	if plivoStreamTokenSecret := os.Getenv("PLIVO_STREAM_TOKEN_SECRET"); plivoStreamTokenSecret != "" {
+		http.HandleFunc("/plivo/ws", networking.NewWebsocketHandlerFuncWithConfig(websocketConfig, mediaStreamHandlerFactory(audioio.NewPlivoProtocol, plivoStreamTokenSecret)))
+	} else {
+		log.Warn().Msgf("PLIVO_STREAM_TOKEN_SECRET is not set, not serving /plivo/ws")
+	}
+
+	server := &http.Server{Addr: ":" + port}
+	go func() {
+		if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			ftl(err)
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	log.Info().Dur("drain_timeout", drainTimeout).Int("num_connections", registry.Count()).Msg("shutting down")
 
-	http.HandleFunc("/ws", networking.NewWebsocketHandlerFunc(twilioHandlerFactory))
-	ftl(http.ListenAndServe(":"+port, nil))
+	// Stop accepting new calls first, http.Server.Shutdown does NOT wait for the (hijacked) websockets.
+	drainCtx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	errLog(server.Shutdown(drainCtx), "server.Shutdown")
+	errLog(registry.Shutdown(drainCtx), "registry.Shutdown")
+	log.Info().Msg("shut down")
 }
 
 func ftl(err error) {
@@ -0,0 +1,175 @@
+/*
+Talk to the agent from the browser, no phone needed:
+
+	go run cmd/web/web_main.go
+	open http://localhost:8080/?agent_profile_id=default
+
+The page streams the microphone as 16kHz linear16 over /ws, plays back the agent, and shows the transcript.
+With ?transport=webrtc it calls over WebRTC instead, offered through /webrtc/offer, which copes better
+with lossy networks. Set WEBRTC_PUBLIC_IP when the server is behind NAT, e.g. on a cloud VM.
+The WebRTC audio is PCMU, or Opus when built with libopus (apt install libopus-dev):
+
+	go run -tags opus cmd/web/web_main.go
+
+All the query parameters of the page are passed to the call, same as the Twilio <Parameter>-s.
+Browsers only allow the microphone on localhost or https, so put it behind e.g. ngrok to try it from a phone.
+
+The server listens on 127.0.0.1 only, as anybody who reaches it spends your OpenAI budget.
+Set LISTEN_HOST=0.0.0.0 to expose it, e.g. on a VM behind an authenticating proxy.
+Only the served page can connect, /ws and /webrtc/offer reject requests with a foreign Origin.
+*/
+package main
+
+import (
+	"context"
+	_ "embed"
+	"github.com/joho/godotenv"
+	"github.com/petrzlen/vocode-golang/internal/networking"
+	"github.com/petrzlen/vocode-golang/internal/utils"
+	"github.com/petrzlen/vocode-golang/pkg/agent"
+	"github.com/petrzlen/vocode-golang/pkg/audioio"
+	"github.com/petrzlen/vocode-golang/pkg/pipeline"
+	"github.com/petrzlen/vocode-golang/pkg/synthesizer"
+	"github.com/petrzlen/vocode-golang/pkg/transcriber"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/sashabaranov/go-openai"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"strings"
+	"syscall"
+	"time"
+)
+
+//go:embed index.html
+var indexHtml []byte
+
+func main() {
+	utils.SetupZerolog()
+
+	// Load the .env file
+	err := godotenv.Load()
+	if err != nil {
+		log.Warn().Msgf("Cannot load .env file")
+	}
+	openAIAPIKey := os.Getenv("OPEN_AI_API_KEY")
+	if openAIAPIKey == "" {
+		log.Panic().Msgf("OPEN_AI_API_KEY is not set")
+	}
+	client := openai.NewClient(openAIAPIKey)
+
+	providers := pipeline.Providers{
+		Transcriber: transcriber.NewOpenAIWhisper(client),
+		ChatAgent:   agent.NewOpenAIChatAgent(client),
+		NewSynthesizer: func(voice string) synthesizer.Synthesizer {
+			return synthesizer.NewOpenAITTSWithVoice(openAIAPIKey, voice)
+		},
+	}
+
+	recordingsDir := os.Getenv("RECORDINGS_DIR")
+	if recordingsDir == "" {
+		recordingsDir = "output"
+	}
+	recordingSink := audioio.NewLocalDirRecordingSink(recordingsDir)
+
+	browserHandlerFactory := func() networking.TypedWebsocketMessageHandler {
+		handler := audioio.NewBrowserHandler(func(device audioio.DuplexDevice, start audioio.BrowserStartMessage) error {
+			callConfig := pipeline.NewCallConfig(pipeline.DefaultAgentProfiles, start.Parameters, "transport")
+			return pipeline.Start(providers, callConfig, device, device)
+		})
+		handler.SetRecordingSink(recordingSink)
+		return handler
+	}
+
+	var iceServers []string
+	// WEBRTC_ICE_SERVERS is a comma separated list of STUN / TURN urls.
+	if value := os.Getenv("WEBRTC_ICE_SERVERS"); value != "" {
+		iceServers = strings.Split(value, ",")
+	}
+	webrtcServer, err := networking.NewWebrtcServer(networking.WebrtcServerConfig{
+		IceServers: iceServers,
+		PublicIp:   os.Getenv("WEBRTC_PUBLIC_IP"),
+	}, func(call networking.WebrtcCall) (networking.WebrtcCallHandler, error) {
+		handler, err := audioio.NewWebrtcHandler(call)
+		if err != nil {
+			return nil, err
+		}
+		handler.SetRecordingSink(recordingSink)
+
+		callConfig := pipeline.NewCallConfig(pipeline.DefaultAgentProfiles, call.Parameters, "transport")
+		return handler, pipeline.Start(providers, callConfig, handler, handler)
+	})
+	ftl(err)
+
+	listenHost := os.Getenv("LISTEN_HOST")
+	if listenHost == "" {
+		listenHost = "127.0.0.1"
+	}
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+	// SHUTDOWN_DRAIN_TIMEOUT is how long active calls can continue after SIGTERM.
+	drainTimeout := 25 * time.Second
+	if value := os.Getenv("SHUTDOWN_DRAIN_TIMEOUT"); value != "" {
+		drainTimeout, err = time.ParseDuration(value)
+		ftl(err)
+	}
+
+	registry := networking.NewConnectionRegistry()
+	websocketConfig := networking.DefaultWebsocketConfig
+	websocketConfig.Registry = registry
+	http.HandleFunc("/ws", networking.NewTypedWebsocketHandlerFunc(websocketConfig, browserHandlerFactory))
+	http.HandleFunc("/webrtc/offer", networking.RequireSameOrigin(webrtcServer.HandleOffer))
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, err := w.Write(indexHtml)
+		errLog(err, "write index.html")
+	})
+
+	server := &http.Server{Addr: net.JoinHostPort(listenHost, port)}
+	go func() {
+		if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			ftl(err)
+		}
+	}()
+	log.Info().Msgf("open http://localhost:%s/ to talk to the agent", port)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	log.Info().Dur("drain_timeout", drainTimeout).Int("num_connections", registry.Count()).Int("num_webrtc_calls", webrtcServer.Count()).Msg("shutting down")
+
+	// Stop accepting new calls first, http.Server.Shutdown does NOT wait for the (hijacked) websockets.
+	drainCtx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	errLog(server.Shutdown(drainCtx), "server.Shutdown")
+	webrtcDone := make(chan struct{})
+	go func() {
+		errLog(webrtcServer.Shutdown(drainCtx), "webrtcServer.Shutdown")
+		close(webrtcDone)
+	}()
+	errLog(registry.Shutdown(drainCtx), "registry.Shutdown")
+	<-webrtcDone
+	log.Info().Msg("shut down")
+}
+
+func ftl(err error) {
+	if err != nil {
+		log.Fatal().Err(err).Msg("sth essential failed")
+		debug.PrintStack()
+	}
+}
+
+func errLog(err error, what string) {
+	if err != nil {
+		log.Error().Err(errors.WithStack(err)).Msg(what)
+	}
+}
@@ -0,0 +1,120 @@
+package networking
+
+import (
+	"context"
+	"crypto/subtle"
+	"github.com/petrzlen/vocode-golang/pkg/voicepb"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"sync"
+	"time"
+)
+
+// GrpcAuthorizationMetadata is the metadata key of the per-RPC credential, as "Bearer <api key>".
+const GrpcAuthorizationMetadata = "authorization"
+
+// GrpcConversationHandler is the audio side of a gRPC conversation, e.g. audioio.NewGrpcHandler.
+type GrpcConversationHandler interface {
+	// Serve reads and writes the stream until the conversation is over, the stream ends once it returns.
+	Serve(stream voicepb.VoiceService_ConverseServer) error
+}
+
+// GrpcVoiceService implements voicepb.VoiceServiceServer with a new handler for each conversation,
+// and keeps track of them for a graceful shutdown, same as ConnectionRegistry does for the websockets.
+type GrpcVoiceService struct {
+	voicepb.UnimplementedVoiceServiceServer
+	createHandler func() GrpcConversationHandler
+
+	// mutex guards everything below.
+	mutex      sync.Mutex
+	isDraining bool
+	handlers   map[GrpcConversationHandler]struct{}
+}
+
+func NewGrpcVoiceService(createHandler func() GrpcConversationHandler) *GrpcVoiceService {
+	return &GrpcVoiceService{
+		createHandler: createHandler,
+		isDraining:    false,
+		handlers:      make(map[GrpcConversationHandler]struct{}),
+	}
+}
+
+// Converse implements voicepb.VoiceServiceServer.Converse
+func (s *GrpcVoiceService) Converse(stream voicepb.VoiceService_ConverseServer) error {
+	handler := s.createHandler()
+	s.mutex.Lock()
+	if s.isDraining {
+		s.mutex.Unlock()
+		log.Info().Msg("GrpcVoiceService refusing conversation as the server is shutting down")
+		return status.Error(codes.Unavailable, "server is shutting down")
+	}
+	s.handlers[handler] = struct{}{}
+	s.mutex.Unlock()
+	defer func() {
+		s.mutex.Lock()
+		delete(s.handlers, handler)
+		s.mutex.Unlock()
+	}()
+
+	log.Info().Msg("GrpcVoiceService conversation started")
+	return handler.Serve(stream)
+}
+
+// Count returns the number of active conversations.
+func (s *GrpcVoiceService) Count() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.handlers)
+}
+
+// Shutdown works like ConnectionRegistry.Shutdown: new conversations are refused, the ShutdownAwareHandler-s notified,
+// and the active conversations have until ctx is done to finish. The caller should then stop the grpc.Server,
+// which cancels the rest.
+func (s *GrpcVoiceService) Shutdown(ctx context.Context) error {
+	s.mutex.Lock()
+	s.isDraining = true
+	handlers := make([]GrpcConversationHandler, 0, len(s.handlers))
+	for handler := range s.handlers {
+		handlers = append(handlers, handler)
+	}
+	s.mutex.Unlock()
+
+	log.Info().Int("num_conversations", len(handlers)).Msg("grpc voice service draining")
+	for _, handler := range handlers {
+		if shutdownAware, ok := handler.(ShutdownAwareHandler); ok {
+			go shutdownAware.Shutdown()
+		}
+	}
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for s.Count() > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			log.Warn().Int("num_conversations", s.Count()).Msg("grpc voice service drain timeout")
+			return ctx.Err()
+		}
+	}
+	log.Info().Msg("grpc voice service drained")
+	return nil
+}
+
+// RequireGrpcApiKey is a grpc.StreamServerInterceptor which rejects the streams without the apiKey
+// in their GrpcAuthorizationMetadata, so only trusted clients can start conversations with their parameters.
+func RequireGrpcApiKey(apiKey string) grpc.StreamServerInterceptor {
+	expected := []byte("Bearer " + apiKey)
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		md, _ := metadata.FromIncomingContext(stream.Context())
+		for _, value := range md.Get(GrpcAuthorizationMetadata) {
+			if subtle.ConstantTimeCompare([]byte(value), expected) == 1 {
+				return handler(srv, stream)
+			}
+		}
+		log.Warn().Str("method", info.FullMethod).Msg("grpc stream rejected without a valid api key")
+		return status.Error(codes.Unauthenticated, "missing or invalid api key")
+	}
+}
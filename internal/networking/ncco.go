@@ -0,0 +1,172 @@
+package networking
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/petrzlen/vocode-golang/pkg/telephony"
+	"github.com/rs/zerolog/log"
+	"io"
+	"net/http"
+	"time"
+)
+
+// maxVonageWebhookBodySize is way more than any Vonage webhook sends.
+const maxVonageWebhookBodySize = 1 << 20
+
+// NccoAnswerConfig configures the NCCO returned to the Vonage answer webhook, the Vonage TwimlVoiceConfig.
+type NccoAnswerConfig struct {
+	// StreamPath is where the websocket handler is served, the full url is derived from the request host.
+	StreamPath string
+	// StreamUrl if set overrides the derived websocket url, e.g. "wss://vocode-golang.fly.dev/vonage/ws".
+	StreamUrl string
+	// SampleRate of the linear16 audio, Vonage supports 8000 and 16000.
+	SampleRate int
+	// Headers are passed to the websocket, so they end up in the "websocket:connected" message.
+	Headers map[string]string
+	// ForwardValues are answer webhook fields (e.g. "from", "to") to forward as headers too.
+	ForwardValues []string
+	// ForwardQueryValues forwards all url query parameters as headers, same as TwimlVoiceConfig.ForwardQueryValues.
+	ForwardQueryValues bool
+	// StreamTokenSecret if set, adds a telephony.StreamTokenParameter signed for the call uuid.
+	StreamTokenSecret string
+	StreamTokenTTL    time.Duration
+}
+
+// VonageCallEvent are the interesting fields of a Vonage event webhook
+// https://developer.vonage.com/en/voice/voice-api/webhook-reference#event-webhook
+type VonageCallEvent struct {
+	Uuid             string `json:"uuid"`
+	ConversationUuid string `json:"conversation_uuid"`
+	Status           string `json:"status"` // E.g. started, ringing, answered, completed, busy, failed or timeout
+	Direction        string `json:"direction"`
+	From             string `json:"from"`
+	To               string `json:"to"`
+	Duration         string `json:"duration"` // Only set for completed calls, in seconds
+	Timestamp        string `json:"timestamp"`
+}
+
+// getVonageWebhookValues reads the webhook fields, Vonage sends them as a query for GET,
+// or as a JSON body for POST (which is configurable per application).
+func getVonageWebhookValues(r *http.Request) (map[string]string, error) {
+	result := make(map[string]string)
+	for name := range r.URL.Query() {
+		result[name] = r.URL.Query().Get(name)
+	}
+	if r.Method != http.MethodPost {
+		return result, nil
+	}
+
+	var body map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	for name, value := range body {
+		if str, ok := value.(string); ok {
+			result[name] = str
+		}
+	}
+	return result, nil
+}
+
+// RequireVonageSignature only lets through webhook requests with a valid Vonage signed-webhook JWT,
+// all other requests are rejected with 403, so nobody else gets a stream token for a made up call uuid.
+// Enable "signed webhooks" of your Vonage application, the signatureSecret is the one of your account.
+func RequireVonageSignature(signatureSecret string, next func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxVonageWebhookBodySize))
+		if err != nil {
+			log.Warn().Err(err).Str("client_ip", getClientIpAddress(r)).Msg("vonage webhook cannot read body")
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+
+		if err := telephony.ValidateVonageSignature(signatureSecret, r.Header.Get("Authorization"), body); err != nil {
+			log.Warn().Err(err).Str("client_ip", getClientIpAddress(r)).Str("request_url", getRequestUrl(r)).Msg("vonage webhook signature invalid, rejecting")
+			http.Error(w, "invalid signature", http.StatusForbidden)
+			return
+		}
+
+		// The body was consumed, so the next handler gets it again.
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next(w, r)
+	}
+}
+
+// NewNccoAnswerHandlerFunc responds with the NCCO connecting the call to our websocket.
+// Set it as the "Answer URL" of your Vonage application, wrapped in RequireVonageSignature when it issues stream tokens.
+func NewNccoAnswerHandlerFunc(config NccoAnswerConfig) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		values, err := getVonageWebhookValues(r)
+		if err != nil {
+			log.Warn().Err(err).Str("client_ip", getClientIpAddress(r)).Msg("ncco answer webhook cannot parse request")
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+
+		streamUrl := config.StreamUrl
+		if streamUrl == "" {
+			streamUrl = getStreamUrl(r, config.StreamPath)
+		}
+		headers := make(map[string]string, len(config.Headers)+len(config.ForwardValues)+2)
+		for name, value := range config.Headers {
+			headers[name] = value
+		}
+		for _, name := range config.ForwardValues {
+			if value := values[name]; value != "" {
+				headers[name] = value
+			}
+		}
+		if config.ForwardQueryValues {
+			for name := range r.URL.Query() {
+				headers[name] = r.URL.Query().Get(name)
+			}
+		}
+		callUuid := values["uuid"]
+		headers[telephony.VonageCallUuidHeader] = callUuid
+		if config.StreamTokenSecret != "" {
+			headers[telephony.StreamTokenParameter] = telephony.NewStreamToken(config.StreamTokenSecret, callUuid, config.StreamTokenTTL)
+		}
+
+		ncco, err := telephony.RenderNcco(telephony.NewConnectWebsocketNcco(streamUrl, config.SampleRate, headers))
+		if err != nil {
+			errLog(err, "ncco answer webhook render")
+			http.Error(w, "cannot render ncco", http.StatusInternalServerError)
+			return
+		}
+
+		log.Info().Str("call_uuid", callUuid).Str("stream_url", streamUrl).Msg("ncco answer webhook connecting call to stream")
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(ncco)
+		errLog(err, "ncco answer webhook write")
+	}
+}
+
+// NewNccoEventHandlerFunc receives the call events, and passes them to onEvent.
+// Set it as the "Event URL" of your Vonage application.
+func NewNccoEventHandlerFunc(onEvent func(event VonageCallEvent)) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		values, err := getVonageWebhookValues(r)
+		if err != nil {
+			log.Warn().Err(err).Str("client_ip", getClientIpAddress(r)).Msg("ncco event webhook cannot parse request")
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+
+		event := VonageCallEvent{
+			Uuid:             values["uuid"],
+			ConversationUuid: values["conversation_uuid"],
+			Status:           values["status"],
+			Direction:        values["direction"],
+			From:             values["from"],
+			To:               values["to"],
+			Duration:         values["duration"],
+			Timestamp:        values["timestamp"],
+		}
+		log.Info().Str("call_uuid", event.Uuid).Str("status", event.Status).Str("direction", event.Direction).Str("duration", event.Duration).Msg("ncco event webhook received")
+		if onEvent != nil {
+			onEvent(event)
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,52 @@
+package networking
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/petrzlen/vocode-golang/pkg/telephony"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRequireVonageSignature(t *testing.T) {
+	handler := RequireVonageSignature("secret", NewNccoAnswerHandlerFunc(NccoAnswerConfig{
+		StreamPath:        "/vonage/ws",
+		SampleRate:        16000,
+		ForwardValues:     []string{"from"},
+		StreamTokenSecret: "stream-secret",
+		StreamTokenTTL:    time.Minute,
+	}))
+	body := []byte(`{"uuid":"call-1","from":"14155550100"}`)
+
+	t.Run("unsigned", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		handler(recorder, httptest.NewRequest(http.MethodPost, "/vonage/answer", bytes.NewReader(body)))
+		if recorder.Code != http.StatusForbidden {
+			t.Fatalf("expected %d, got %d", http.StatusForbidden, recorder.Code)
+		}
+	})
+
+	t.Run("signed", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/vonage/answer", bytes.NewReader(body))
+		request.Header.Set("Authorization", telephony.SignVonageWebhook("secret", body, time.Now()))
+		handler(recorder, request)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("expected %d, got %d: %s", http.StatusOK, recorder.Code, recorder.Body.String())
+		}
+
+		var ncco []telephony.NccoAction
+		if err := json.Unmarshal(recorder.Body.Bytes(), &ncco); err != nil {
+			t.Fatal(err)
+		}
+		headers := ncco[0].Endpoint[0].Headers
+		if headers["from"] != "14155550100" || headers[telephony.VonageCallUuidHeader] != "call-1" {
+			t.Errorf("unexpected headers %v", headers)
+		}
+		if err := telephony.ValidateStreamToken("stream-secret", "call-1", headers[telephony.StreamTokenParameter]); err != nil {
+			t.Errorf("expected a valid stream token, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,117 @@
+package networking
+
+import (
+	"context"
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog/log"
+	"sync"
+	"time"
+)
+
+// ShutdownAwareHandler is optionally implemented by websocket handlers which want to wrap up on server shutdown,
+// e.g. tell the caller to call back. The connection is closed once the handler closes its writer,
+// or forcefully when the drain timeout passes.
+type ShutdownAwareHandler interface {
+	Shutdown()
+}
+
+// drainPollInterval is how often ConnectionRegistry.Shutdown checks if all connections are gone.
+const drainPollInterval = 100 * time.Millisecond
+
+type registeredConnection struct {
+	ws      *websocket.Conn
+	handler TypedWebsocketMessageHandler
+}
+
+// ConnectionRegistry keeps track of the active websocket sessions, so they can be drained on server shutdown.
+// Set it as WebsocketConfig.Registry, one registry can be shared by multiple websocket routes.
+type ConnectionRegistry struct {
+	mutex       sync.Mutex
+	isDraining  bool
+	connections map[*registeredConnection]struct{}
+}
+
+func NewConnectionRegistry() *ConnectionRegistry {
+	return &ConnectionRegistry{
+		isDraining:  false,
+		connections: make(map[*registeredConnection]struct{}),
+	}
+}
+
+// Count returns the number of active connections.
+func (r *ConnectionRegistry) Count() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return len(r.connections)
+}
+
+// IsDraining is true once Shutdown was called, new connections are rejected since then.
+func (r *ConnectionRegistry) IsDraining() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.isDraining
+}
+
+// add returns nil when draining, i.e. the connection should be refused.
+func (r *ConnectionRegistry) add(ws *websocket.Conn, handler TypedWebsocketMessageHandler) *registeredConnection {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.isDraining {
+		return nil
+	}
+	conn := &registeredConnection{ws: ws, handler: handler}
+	r.connections[conn] = struct{}{}
+	return conn
+}
+
+func (r *ConnectionRegistry) remove(conn *registeredConnection) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.connections, conn)
+}
+
+func (r *ConnectionRegistry) snapshot() []*registeredConnection {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	result := make([]*registeredConnection, 0, len(r.connections))
+	for conn := range r.connections {
+		result = append(result, conn)
+	}
+	return result
+}
+
+// Shutdown stops accepting new connections, notifies the ShutdownAwareHandler-s, and waits for the active
+// connections to finish until ctx is done. Then the rest is closed with a close frame, and ctx.Err() returned.
+func (r *ConnectionRegistry) Shutdown(ctx context.Context) error {
+	r.mutex.Lock()
+	r.isDraining = true
+	r.mutex.Unlock()
+
+	connections := r.snapshot()
+	log.Info().Int("num_connections", len(connections)).Msg("connection registry draining")
+	for _, conn := range connections {
+		if handler, ok := conn.handler.(ShutdownAwareHandler); ok {
+			go handler.Shutdown()
+		}
+	}
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for r.Count() > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			connections = r.snapshot()
+			log.Warn().Int("num_connections", len(connections)).Msg("connection registry drain timeout, closing the rest")
+			for _, conn := range connections {
+				closeMsg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+				errLog(conn.ws.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(time.Second)), "websocket.CloseMessage on shutdown")
+				// Makes the reader routine fail, which then closes the handler reader and unregisters.
+				errLog(conn.ws.Close(), "websocket.Close() on shutdown")
+			}
+			return ctx.Err()
+		}
+	}
+	log.Info().Msg("connection registry drained")
+	return nil
+}
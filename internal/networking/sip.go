@@ -0,0 +1,579 @@
+package networking
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/petrzlen/vocode-golang/pkg/telephony"
+	"github.com/rs/zerolog/log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sipT1 is the RFC 3261 round-trip time estimate, retransmissions over UDP start at it and double up to sipT2.
+const sipT1 = 500 * time.Millisecond
+const sipT2 = 4 * time.Second
+
+// sipTransactionTimeout is 64*T1, after which an unanswered request (or an unacknowledged 200 OK) is given up.
+const sipTransactionTimeout = 64 * sipT1
+
+const sipMaxMessageSize = 65535
+
+// SipParameterHeaderPrefix marks the INVITE headers which are passed to the call as SipCall.Parameters,
+// the SIP counterpart of Twilio <Parameter>-s, e.g. "X-Vocode-Agent-Profile-Id: sales" set by Asterisk PJSIP_HEADER(add,...)
+// becomes the "agent_profile_id" parameter.
+const SipParameterHeaderPrefix = "X-Vocode-"
+
+var ErrSipServerClosed = errors.New("sip: server closed")
+
+// SipCallHandler is the audio side of a SIP call, e.g. audioio.NewRtpHandler.
+type SipCallHandler interface {
+	// StartRtp is called once the caller acknowledged our answer, conn is bound to the port in our SDP answer.
+	// The handler owns conn from now on.
+	StartRtp(conn *net.UDPConn, remote *net.UDPAddr)
+	// Stop is called when the caller hung up, or the call was never established.
+	Stop() error
+}
+
+// SipCall is what we know about an inbound call when it is offered.
+type SipCall struct {
+	CallId string
+	// From and To are the user parts of the URIs, usually the phone numbers.
+	From string
+	To   string
+	// Parameters are the SipParameterHeaderPrefix headers, in snake_case without the prefix.
+	Parameters map[string]string
+	// PayloadType is the negotiated codec, either telephony.RtpPayloadTypePcmu or telephony.RtpPayloadTypePcma.
+	PayloadType int
+	// Hangup sends BYE to the caller, it does nothing once the call ended.
+	Hangup func()
+}
+
+type SipServerConfig struct {
+	// ListenAddr is the UDP address for SIP signaling, e.g. ":5060".
+	ListenAddr string
+	// PublicIp is put into the SDP answer and Contact, by default the local IP address towards the caller is used.
+	PublicIp string
+	// AllowedSources are the networks of the PBX-es, requests from anywhere else are rejected,
+	// as each call spends the API budget. Loopback is always allowed, e.g. for cmd/sipcall.
+	AllowedSources []*net.IPNet
+}
+
+// ParseIpAllowlist parses comma separated IPs or CIDRs, e.g. "10.0.0.5,192.168.1.0/24".
+func ParseIpAllowlist(value string) ([]*net.IPNet, error) {
+	var result []*net.IPNet
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid ip %q", item)
+			}
+			bits := 8 * net.IPv4len
+			if ip.To4() == nil {
+				bits = 8 * net.IPv6len
+			}
+			result = append(result, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cidr %q: %w", item, err)
+		}
+		result = append(result, ipNet)
+	}
+	return result, nil
+}
+
+// sipDialog is the state of a single call, keyed by its Call-ID.
+type sipDialog struct {
+	callId     string
+	localTag   string
+	invite     *telephony.SipMessage
+	remoteAddr *net.UDPAddr
+	// response is the final INVITE response, retransmitted until the ACK arrives.
+	response  *telephony.SipMessage
+	ackedChan chan struct{}
+	isAcked   bool
+
+	rtpConn       *net.UDPConn
+	remoteRtpAddr *net.UDPAddr
+	handler       SipCallHandler
+	localCSeq     int
+}
+
+// SipServer is a minimal SIP user agent server, it answers INVITEs with a single PCMU / PCMA audio stream,
+// so a PBX like Asterisk or FreeSWITCH can route calls straight to the agent.
+// Only UDP is supported, and there is no SIP authentication, so the PBX-es are allowed by their source IP.
+// TODO(P1, ux): Record-Route is ignored, so the calls must not go through a stateful proxy.
+type SipServer struct {
+	config        SipServerConfig
+	createHandler func(call SipCall) (SipCallHandler, error)
+
+	conn *net.UDPConn
+	// mutex guards everything below.
+	mutex      sync.Mutex
+	isDraining bool
+	dialogs    map[string]*sipDialog
+	// pendingRequests are our own requests (e.g. BYE) waiting for a response, by Via branch.
+	pendingRequests map[string]chan *telephony.SipMessage
+}
+
+// NewSipServer createHandler is called on every INVITE, returning an error declines the call.
+func NewSipServer(config SipServerConfig, createHandler func(call SipCall) (SipCallHandler, error)) *SipServer {
+	return &SipServer{
+		config:          config,
+		createHandler:   createHandler,
+		dialogs:         make(map[string]*sipDialog),
+		pendingRequests: make(map[string]chan *telephony.SipMessage),
+	}
+}
+
+// ListenAndServe blocks until Shutdown (returning ErrSipServerClosed), or the socket fails.
+func (s *SipServer) ListenAndServe() error {
+	addr, err := net.ResolveUDPAddr("udp", s.config.ListenAddr)
+	if err != nil {
+		return fmt.Errorf("cannot resolve sip listen address: %w", err)
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return fmt.Errorf("cannot listen for sip: %w", err)
+	}
+	s.mutex.Lock()
+	s.conn = conn
+	s.mutex.Unlock()
+	log.Info().Str("addr", conn.LocalAddr().String()).Msg("sip server listening")
+
+	buffer := make([]byte, sipMaxMessageSize)
+	for {
+		n, remoteAddr, err := conn.ReadFromUDP(buffer)
+		if errors.Is(err, net.ErrClosed) {
+			return ErrSipServerClosed
+		}
+		if err != nil {
+			return fmt.Errorf("cannot read sip message: %w", err)
+		}
+		// Keep-alives are just CRLF-s.
+		if strings.TrimSpace(string(buffer[:n])) == "" {
+			continue
+		}
+
+		msg, err := telephony.ParseSipMessage(append([]byte(nil), buffer[:n]...))
+		if err != nil {
+			log.Warn().Err(err).Str("remote_addr", remoteAddr.String()).Msg("ignoring malformed sip message")
+			continue
+		}
+		if msg.IsRequest() {
+			s.handleRequest(msg, remoteAddr)
+		} else {
+			s.handleResponse(msg)
+		}
+	}
+}
+
+// LocalAddr is where the server listens, nil until ListenAndServe started, e.g. to find the port of ":0".
+func (s *SipServer) LocalAddr() net.Addr {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.LocalAddr()
+}
+
+// Count returns the number of active calls.
+func (s *SipServer) Count() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.dialogs)
+}
+
+// Shutdown works like ConnectionRegistry.Shutdown: new calls are declined, the ShutdownAwareHandler-s notified,
+// and the active calls have until ctx is done to finish. Then the rest gets a BYE, and ctx.Err() is returned.
+func (s *SipServer) Shutdown(ctx context.Context) error {
+	s.mutex.Lock()
+	s.isDraining = true
+	handlers := make([]SipCallHandler, 0, len(s.dialogs))
+	for _, dialog := range s.dialogs {
+		handlers = append(handlers, dialog.handler)
+	}
+	s.mutex.Unlock()
+
+	log.Info().Int("num_calls", len(handlers)).Msg("sip server draining")
+	for _, handler := range handlers {
+		if shutdownAware, ok := handler.(ShutdownAwareHandler); ok {
+			go shutdownAware.Shutdown()
+		}
+	}
+
+	var err error
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for s.Count() > 0 && err == nil {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			log.Warn().Int("num_calls", s.Count()).Msg("sip server drain timeout, hanging up the rest")
+			s.mutex.Lock()
+			callIds := make([]string, 0, len(s.dialogs))
+			for callId := range s.dialogs {
+				callIds = append(callIds, callId)
+			}
+			s.mutex.Unlock()
+			for _, callId := range callIds {
+				s.hangup(callId, true)
+			}
+			err = ctx.Err()
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.conn != nil {
+		errLog(s.conn.Close(), "sip conn.Close() on shutdown")
+	}
+	log.Info().Msg("sip server shut down")
+	return err
+}
+
+func (s *SipServer) send(msg *telephony.SipMessage, remoteAddr *net.UDPAddr) {
+	_, err := s.conn.WriteToUDP(msg.Bytes(), remoteAddr)
+	if errors.Is(err, net.ErrClosed) {
+		log.Debug().Str("call_id", msg.GetHeader("Call-ID")).Msg("sip server closed, not sending")
+		return
+	}
+	errLog(err, "sip WriteToUDP")
+}
+
+func (s *SipServer) respond(request *telephony.SipMessage, remoteAddr *net.UDPAddr, statusCode int, reason string) {
+	s.send(telephony.NewSipResponse(request, statusCode, reason), remoteAddr)
+}
+
+func (s *SipServer) handleRequest(request *telephony.SipMessage, remoteAddr *net.UDPAddr) {
+	callId := request.GetHeader("Call-ID")
+	log.Debug().Str("method", request.Method).Str("call_id", callId).Str("remote_addr", remoteAddr.String()).Msg("received sip request")
+	if !s.isAllowedSource(remoteAddr.IP) {
+		// Neither a call (nor its X-Vocode- parameters), nor a BYE for somebody else's call.
+		log.Warn().Str("method", request.Method).Str("call_id", callId).Str("remote_addr", remoteAddr.String()).Msg("sip request from a source which is not allowed, rejecting")
+		if request.Method != "ACK" {
+			s.respond(request, remoteAddr, 403, "Forbidden")
+		}
+		return
+	}
+
+	switch request.Method {
+	case "INVITE":
+		s.handleInvite(request, remoteAddr)
+	case "ACK":
+		s.handleAck(callId)
+	case "BYE":
+		s.handleBye(request, remoteAddr)
+	case "CANCEL":
+		// We answer right away, so the INVITE is already done and there is nothing to cancel.
+		s.mutex.Lock()
+		_, ok := s.dialogs[callId]
+		s.mutex.Unlock()
+		if ok {
+			s.respond(request, remoteAddr, 200, "OK")
+		} else {
+			s.respond(request, remoteAddr, 481, "Call/Transaction Does Not Exist")
+		}
+	case "OPTIONS":
+		// PBX-es use OPTIONS to check if we are alive.
+		response := telephony.NewSipResponse(request, 200, "OK")
+		response.AddHeader("Allow", "INVITE, ACK, BYE, CANCEL, OPTIONS")
+		s.send(response, remoteAddr)
+	default:
+		s.respond(request, remoteAddr, 501, "Not Implemented")
+	}
+}
+
+func (s *SipServer) handleInvite(invite *telephony.SipMessage, remoteAddr *net.UDPAddr) {
+	callId := invite.GetHeader("Call-ID")
+	s.mutex.Lock()
+	dialog, ok := s.dialogs[callId]
+	isDraining := s.isDraining
+	s.mutex.Unlock()
+	if ok {
+		// A retransmission, or a re-INVITE (e.g. hold) for which we keep the session as is.
+		if dialog.response != nil {
+			s.send(dialog.response, remoteAddr)
+		}
+		return
+	}
+	if isDraining {
+		s.respond(invite, remoteAddr, 503, "Service Unavailable")
+		return
+	}
+	s.respond(invite, remoteAddr, 100, "Trying")
+
+	offer, err := telephony.ParseSdp(invite.Body)
+	if err != nil {
+		log.Warn().Err(err).Str("call_id", callId).Msg("sip INVITE without a usable SDP offer")
+		s.respond(invite, remoteAddr, 488, "Not Acceptable Here")
+		return
+	}
+	payloadType, err := telephony.NegotiateSdpPayloadType(offer)
+	if err != nil {
+		log.Warn().Err(err).Str("call_id", callId).Msg("sip INVITE with unsupported codecs")
+		s.respond(invite, remoteAddr, 488, "Not Acceptable Here")
+		return
+	}
+	localIp := s.getLocalIp(remoteAddr)
+	rtpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(localIp)})
+	if err != nil {
+		errLog(err, "cannot listen for rtp")
+		s.respond(invite, remoteAddr, 500, "Server Internal Error")
+		return
+	}
+	remoteRtpAddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(offer.Address, fmt.Sprint(offer.Port)))
+	if err != nil {
+		errLog(rtpConn.Close(), "rtpConn.Close() after invalid sdp address")
+		s.respond(invite, remoteAddr, 488, "Not Acceptable Here")
+		return
+	}
+
+	dialog = &sipDialog{
+		callId:        callId,
+		localTag:      telephony.NewSipTag(),
+		invite:        invite,
+		remoteAddr:    remoteAddr,
+		ackedChan:     make(chan struct{}),
+		rtpConn:       rtpConn,
+		remoteRtpAddr: remoteRtpAddr,
+		localCSeq:     0,
+	}
+	handler, err := s.createHandler(SipCall{
+		CallId:      callId,
+		From:        telephony.GetSipUser(telephony.GetSipUri(invite.GetHeader("From"))),
+		To:          telephony.GetSipUser(telephony.GetSipUri(invite.GetHeader("To"))),
+		Parameters:  getSipParameters(invite),
+		PayloadType: payloadType,
+		Hangup:      func() { s.hangup(callId, false) },
+	})
+	if err != nil {
+		log.Warn().Err(err).Str("call_id", callId).Msg("sip call declined")
+		errLog(rtpConn.Close(), "rtpConn.Close() after declined call")
+		s.respond(invite, remoteAddr, 603, "Decline")
+		return
+	}
+	dialog.handler = handler
+
+	answer := telephony.SdpSession{
+		Address:      localIp,
+		Port:         rtpConn.LocalAddr().(*net.UDPAddr).Port,
+		PayloadTypes: []int{payloadType},
+		SessionId:    time.Now().Unix(),
+	}
+	response := telephony.NewSipResponse(invite, 200, "OK")
+	response.SetHeader("To", invite.GetHeader("To")+";tag="+dialog.localTag)
+	response.AddHeader("Contact", fmt.Sprintf("<sip:vocode@%s>", net.JoinHostPort(localIp, fmt.Sprint(s.conn.LocalAddr().(*net.UDPAddr).Port))))
+	response.AddHeader("Allow", "INVITE, ACK, BYE, CANCEL, OPTIONS")
+	response.AddHeader("Content-Type", "application/sdp")
+	response.Body = telephony.RenderSdp(answer)
+	dialog.response = response
+
+	s.mutex.Lock()
+	s.dialogs[callId] = dialog
+	s.mutex.Unlock()
+	log.Info().Str("call_id", callId).Int("payload_type", payloadType).Str("remote_rtp_addr", remoteRtpAddr.String()).Msg("sip call answered")
+
+	s.send(response, remoteAddr)
+	go s.retransmitUntilAcked(dialog)
+}
+
+// retransmitUntilAcked as UDP can lose the 200 OK, after sipTransactionTimeout the call is given up.
+func (s *SipServer) retransmitUntilAcked(dialog *sipDialog) {
+	interval := sipT1
+	timeout := time.After(sipTransactionTimeout)
+	for {
+		select {
+		case <-dialog.ackedChan:
+			return
+		case <-timeout:
+			log.Warn().Str("call_id", dialog.callId).Msg("sip 200 OK was never acknowledged, hanging up")
+			s.hangup(dialog.callId, true)
+			return
+		case <-time.After(interval):
+			s.send(dialog.response, dialog.remoteAddr)
+			interval = min(2*interval, sipT2)
+		}
+	}
+}
+
+func (s *SipServer) handleAck(callId string) {
+	s.mutex.Lock()
+	dialog, ok := s.dialogs[callId]
+	if !ok || dialog.isAcked {
+		s.mutex.Unlock()
+		return
+	}
+	dialog.isAcked = true
+	close(dialog.ackedChan)
+	s.mutex.Unlock()
+
+	log.Info().Str("call_id", callId).Msg("sip call established")
+	dialog.handler.StartRtp(dialog.rtpConn, dialog.remoteRtpAddr)
+}
+
+func (s *SipServer) handleBye(bye *telephony.SipMessage, remoteAddr *net.UDPAddr) {
+	callId := bye.GetHeader("Call-ID")
+	dialog := s.removeDialog(callId)
+	if dialog == nil {
+		s.respond(bye, remoteAddr, 481, "Call/Transaction Does Not Exist")
+		return
+	}
+	s.respond(bye, remoteAddr, 200, "OK")
+	log.Info().Str("call_id", callId).Msg("sip call hung up by the caller")
+	s.endDialog(dialog, true)
+}
+
+func (s *SipServer) handleResponse(response *telephony.SipMessage) {
+	branch := telephony.GetSipParameter(response.GetHeader("Via"), "branch")
+	s.mutex.Lock()
+	responseChan, ok := s.pendingRequests[branch]
+	s.mutex.Unlock()
+	if !ok {
+		log.Debug().Int("status_code", response.StatusCode).Str("call_id", response.GetHeader("Call-ID")).Msg("ignoring sip response to an unknown request")
+		return
+	}
+	select {
+	case responseChan <- response:
+	default:
+	}
+}
+
+func (s *SipServer) removeDialog(callId string) *sipDialog {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	dialog, ok := s.dialogs[callId]
+	if !ok {
+		return nil
+	}
+	delete(s.dialogs, callId)
+	return dialog
+}
+
+// endDialog releases the call resources, the RTP conn is owned by the handler once it started.
+func (s *SipServer) endDialog(dialog *sipDialog, stopHandler bool) {
+	s.mutex.Lock()
+	isAcked := dialog.isAcked
+	if !isAcked {
+		dialog.isAcked = true
+		close(dialog.ackedChan)
+	}
+	s.mutex.Unlock()
+
+	if !isAcked {
+		errLog(dialog.rtpConn.Close(), "rtpConn.Close() of never established call")
+	}
+	if stopHandler {
+		errLog(dialog.handler.Stop(), "SipCallHandler.Stop")
+	}
+}
+
+// hangup sends BYE and ends the call, stopHandler is false when the handler itself is stopping.
+func (s *SipServer) hangup(callId string, stopHandler bool) {
+	dialog := s.removeDialog(callId)
+	if dialog == nil {
+		return
+	}
+	log.Info().Str("call_id", callId).Msg("sip call hung up by us")
+	s.sendBye(dialog)
+	s.endDialog(dialog, stopHandler)
+}
+
+// sendBye is sent within the dialog, i.e. we are the callee so From / To are swapped.
+// The first BYE is sent right away, the retransmissions in the background until answered.
+func (s *SipServer) sendBye(dialog *sipDialog) {
+	invite := dialog.invite
+	dialog.localCSeq++
+	branch := telephony.SipBranchPrefix + telephony.NewSipTag()
+	localAddr := net.JoinHostPort(s.getLocalIp(dialog.remoteAddr), fmt.Sprint(s.conn.LocalAddr().(*net.UDPAddr).Port))
+
+	requestUri := telephony.GetSipUri(invite.GetHeader("Contact"))
+	if requestUri == "" {
+		requestUri = telephony.GetSipUri(invite.GetHeader("From"))
+	}
+	bye := &telephony.SipMessage{Method: "BYE", RequestUri: requestUri}
+	bye.AddHeader("Via", fmt.Sprintf("SIP/2.0/UDP %s;branch=%s;rport", localAddr, branch))
+	bye.AddHeader("Max-Forwards", "70")
+	bye.AddHeader("From", invite.GetHeader("To")+";tag="+dialog.localTag)
+	bye.AddHeader("To", invite.GetHeader("From"))
+	bye.AddHeader("Call-ID", dialog.callId)
+	bye.AddHeader("CSeq", fmt.Sprintf("%d BYE", dialog.localCSeq))
+
+	responseChan := make(chan *telephony.SipMessage, 1)
+	s.mutex.Lock()
+	s.pendingRequests[branch] = responseChan
+	s.mutex.Unlock()
+	s.send(bye, dialog.remoteAddr)
+	go s.retransmitUntilAnswered(bye, dialog, branch, responseChan)
+}
+
+func (s *SipServer) retransmitUntilAnswered(request *telephony.SipMessage, dialog *sipDialog, branch string, responseChan chan *telephony.SipMessage) {
+	defer func() {
+		s.mutex.Lock()
+		delete(s.pendingRequests, branch)
+		s.mutex.Unlock()
+	}()
+
+	interval := sipT1
+	timeout := time.After(sipTransactionTimeout)
+	for {
+		select {
+		case response := <-responseChan:
+			log.Debug().Str("call_id", dialog.callId).Int("status_code", response.StatusCode).Msgf("sip %s answered", request.Method)
+			return
+		case <-timeout:
+			log.Warn().Str("call_id", dialog.callId).Msgf("sip %s was never answered", request.Method)
+			return
+		case <-time.After(interval):
+			s.send(request, dialog.remoteAddr)
+			interval = min(2*interval, sipT2)
+		}
+	}
+}
+
+func (s *SipServer) isAllowedSource(ip net.IP) bool {
+	if ip.IsLoopback() {
+		return true
+	}
+	for _, ipNet := range s.config.AllowedSources {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// getLocalIp is the PublicIp, or the address of the interface the OS would use to reach remoteAddr.
+func (s *SipServer) getLocalIp(remoteAddr *net.UDPAddr) string {
+	if s.config.PublicIp != "" {
+		return s.config.PublicIp
+	}
+	// Nothing is sent over a connected UDP socket until written to.
+	conn, err := net.DialUDP("udp", nil, remoteAddr)
+	if err != nil {
+		errLog(err, "cannot find the local ip towards the sip peer")
+		return "127.0.0.1"
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String()
+}
+
+func getSipParameters(invite *telephony.SipMessage) map[string]string {
+	result := make(map[string]string)
+	for _, header := range invite.Headers {
+		if len(header.Name) > len(SipParameterHeaderPrefix) && strings.EqualFold(header.Name[:len(SipParameterHeaderPrefix)], SipParameterHeaderPrefix) {
+			name := strings.ReplaceAll(strings.ToLower(header.Name[len(SipParameterHeaderPrefix):]), "-", "_")
+			result[name] = header.Value
+		}
+	}
+	return result
+}
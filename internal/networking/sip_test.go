@@ -0,0 +1,43 @@
+package networking
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParseIpAllowlist(t *testing.T) {
+	allowedSources, err := ParseIpAllowlist("10.0.0.5, 192.168.1.0/24,,2001:db8::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(allowedSources) != 3 {
+		t.Fatalf("expected 3 networks, got %v", allowedSources)
+	}
+	for _, value := range []string{"10.0.0.256", "pbx.example.com", "10.0.0.0/33"} {
+		if _, err := ParseIpAllowlist(value); err == nil {
+			t.Errorf("expected %q rejected", value)
+		}
+	}
+}
+
+func TestSipServerOnlyAllowsConfiguredSources(t *testing.T) {
+	allowedSources, err := ParseIpAllowlist("10.0.0.5,192.168.1.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewSipServer(SipServerConfig{ListenAddr: "127.0.0.1:0", AllowedSources: allowedSources}, nil)
+
+	expected := map[string]bool{
+		"127.0.0.1":   true,
+		"::1":         true,
+		"10.0.0.5":    true,
+		"192.168.1.7": true,
+		"10.0.0.6":    false,
+		"203.0.113.9": false,
+	}
+	for ip, isAllowed := range expected {
+		if got := server.isAllowedSource(net.ParseIP(ip)); got != isAllowed {
+			t.Errorf("expected %s allowed %v, got %v", ip, isAllowed, got)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package networking
+
+import (
+	"github.com/petrzlen/vocode-golang/pkg/telephony"
+	"github.com/rs/zerolog/log"
+	"net/http"
+)
+
+// getRequestUrl reconstructs the url the way Twilio has called it, which is what it signs.
+func getRequestUrl(r *http.Request) string {
+	return getForwardedProto(r) + "://" + r.Host + r.URL.RequestURI()
+}
+
+// RequireTwilioSignature only lets through webhook requests with a valid X-Twilio-Signature header,
+// all other requests are rejected with 403 so nobody else can trigger calls into our pipeline.
+func RequireTwilioSignature(authToken string, next func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			log.Warn().Err(err).Str("client_ip", getClientIpAddress(r)).Msg("twilio webhook cannot parse form")
+			http.Error(w, "invalid form", http.StatusBadRequest)
+			return
+		}
+
+		requestUrl := getRequestUrl(r)
+		if !telephony.ValidateTwilioSignature(authToken, requestUrl, r.PostForm, r.Header.Get("X-Twilio-Signature")) {
+			log.Warn().Str("client_ip", getClientIpAddress(r)).Str("request_url", requestUrl).Msg("twilio webhook signature invalid, rejecting")
+			http.Error(w, "invalid signature", http.StatusForbidden)
+			return
+		}
+
+		next(w, r)
+	}
+}
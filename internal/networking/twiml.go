@@ -0,0 +1,141 @@
+package networking
+
+import (
+	"fmt"
+	"github.com/petrzlen/vocode-golang/pkg/telephony"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"time"
+)
+
+// TwimlVoiceConfig configures the TwiML returned to Twilio's "A call comes in" webhook.
+type TwimlVoiceConfig struct {
+	// StreamPath is where the websocket handler is served, the full url is derived from the request host.
+	StreamPath string
+	// StreamUrl if set overrides the derived websocket url, e.g. "wss://vocode-golang.fly.dev/ws".
+	StreamUrl string
+	// Parameters are passed as <Parameter> to the stream, so they end up in the "start" message.
+	Parameters map[string]string
+	// ForwardFormValues are webhook form fields (e.g. "From", "To") to forward as stream parameters too.
+	ForwardFormValues []string
+	// ForwardQueryValues forwards all url query parameters as stream parameters, e.g. the per-call variables
+	// of outbound calls placed by the dialer with VoiceUrl "https://.../twiml/voice?agent_profile_id=sales".
+	ForwardQueryValues bool
+	// StreamTokenSecret if set, adds a telephony.StreamTokenParameter signed for the CallSid,
+	// so the websocket can verify the stream belongs to a call we have answered.
+	StreamTokenSecret string
+	// StreamTokenTTL is how long the stream token is valid, Twilio connects the stream within a few seconds.
+	StreamTokenTTL time.Duration
+}
+
+// TwilioCallStatus are the interesting fields of a Twilio call status callback
+// https://www.twilio.com/docs/voice/api/call-resource#statuscallback
+type TwilioCallStatus struct {
+	AccountSid   string
+	CallSid      string
+	CallStatus   string // One of queued, initiated, ringing, in-progress, completed, busy, failed or no-answer
+	Direction    string
+	From         string
+	To           string
+	CallDuration string // Only set for completed calls, in seconds
+	Timestamp    string
+}
+
+// getForwardedProto returns "https" or "http" how the client reached us, also behind a proxy like fly.io or ngrok.
+func getForwardedProto(r *http.Request) string {
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		return proto
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// getStreamUrl derives the websocket url from the host Twilio used to reach the webhook.
+func getStreamUrl(r *http.Request, streamPath string) string {
+	scheme := "ws"
+	if getForwardedProto(r) == "https" {
+		scheme = "wss"
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, r.Host, streamPath)
+}
+
+// NewTwimlVoiceHandlerFunc responds with the <Connect><Stream> TwiML so Twilio connects the call to our websocket.
+// Set it as the "A call comes in" webhook of your Twilio phone number.
+func NewTwimlVoiceHandlerFunc(config TwimlVoiceConfig) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			log.Warn().Err(err).Str("client_ip", getClientIpAddress(r)).Msg("twiml voice webhook cannot parse form")
+			http.Error(w, "invalid form", http.StatusBadRequest)
+			return
+		}
+
+		streamUrl := config.StreamUrl
+		if streamUrl == "" {
+			streamUrl = getStreamUrl(r, config.StreamPath)
+		}
+		parameters := make(map[string]string, len(config.Parameters)+len(config.ForwardFormValues))
+		for name, value := range config.Parameters {
+			parameters[name] = value
+		}
+		for _, name := range config.ForwardFormValues {
+			if value := r.Form.Get(name); value != "" {
+				parameters[name] = value
+			}
+		}
+		if config.ForwardQueryValues {
+			for name := range r.URL.Query() {
+				parameters[name] = r.URL.Query().Get(name)
+			}
+		}
+		if config.StreamTokenSecret != "" {
+			parameters[telephony.StreamTokenParameter] = telephony.NewStreamToken(config.StreamTokenSecret, r.Form.Get("CallSid"), config.StreamTokenTTL)
+		}
+
+		twiml, err := telephony.NewConnectStreamTwiml(streamUrl, parameters).Render()
+		if err != nil {
+			errLog(err, "twiml voice webhook render")
+			http.Error(w, "cannot render twiml", http.StatusInternalServerError)
+			return
+		}
+
+		log.Info().Str("call_sid", r.Form.Get("CallSid")).Str("stream_url", streamUrl).Msg("twiml voice webhook connecting call to stream")
+		w.Header().Set("Content-Type", "text/xml")
+		_, err = w.Write(twiml)
+		errLog(err, "twiml voice webhook write")
+	}
+}
+
+// NewTwimlStatusHandlerFunc receives the call status callbacks, and passes them to onStatus.
+// Set it as the "Call status changes" webhook of your Twilio phone number, with the default POST method.
+func NewTwimlStatusHandlerFunc(onStatus func(status TwilioCallStatus)) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			log.Warn().Err(err).Str("client_ip", getClientIpAddress(r)).Msg("twiml status webhook cannot parse form")
+			http.Error(w, "invalid form", http.StatusBadRequest)
+			return
+		}
+
+		status := TwilioCallStatus{
+			AccountSid:   r.Form.Get("AccountSid"),
+			CallSid:      r.Form.Get("CallSid"),
+			CallStatus:   r.Form.Get("CallStatus"),
+			Direction:    r.Form.Get("Direction"),
+			From:         r.Form.Get("From"),
+			To:           r.Form.Get("To"),
+			CallDuration: r.Form.Get("CallDuration"),
+			Timestamp:    r.Form.Get("Timestamp"),
+		}
+		log.Info().Str("call_sid", status.CallSid).Str("call_status", status.CallStatus).Str("direction", status.Direction).Str("call_duration", status.CallDuration).Msg("twiml status webhook received")
+		if onStatus != nil {
+			onStatus(status)
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
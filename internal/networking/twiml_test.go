@@ -0,0 +1,140 @@
+package networking
+
+import (
+	"encoding/xml"
+	"github.com/petrzlen/vocode-golang/pkg/telephony"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTwilioWebhookRequest is a webhook as Twilio sends it, a POST with a form body.
+func newTwilioWebhookRequest(target string, form url.Values) *http.Request {
+	request := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return request
+}
+
+func TestTwimlVoiceHandlerFunc(t *testing.T) {
+	handler := NewTwimlVoiceHandlerFunc(TwimlVoiceConfig{
+		StreamPath:         "/ws",
+		Parameters:         map[string]string{"agent_profile_id": "default"},
+		ForwardFormValues:  []string{"From", "To"},
+		ForwardQueryValues: true,
+		StreamTokenSecret:  "auth-token",
+		StreamTokenTTL:     time.Minute,
+	})
+	request := newTwilioWebhookRequest("https://vocode-golang.fly.dev/twiml/voice?agent_profile_id=sales", url.Values{
+		"CallSid":    {"CA1234567890ABCDE"},
+		"From":       {"+14158675309"},
+		"To":         {"+18005551212"},
+		"CallStatus": {"ringing"},
+	})
+	// fly.io terminates the TLS, so the websocket has to be wss as the webhook was https.
+	request.Header.Set("X-Forwarded-Proto", "https")
+	recorder := httptest.NewRecorder()
+	handler(recorder, request)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d: %s", http.StatusOK, recorder.Code, recorder.Body.String())
+	}
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "text/xml" {
+		t.Errorf("expected text/xml, got %s", contentType)
+	}
+	var twiml telephony.TwimlResponse
+	if err := xml.Unmarshal(recorder.Body.Bytes(), &twiml); err != nil {
+		t.Fatal(err)
+	}
+	if twiml.Connect == nil {
+		t.Fatalf("expected <Connect><Stream>, got %s", recorder.Body.String())
+	}
+	stream := twiml.Connect.Stream
+	if stream.Url != "wss://vocode-golang.fly.dev/ws" {
+		t.Errorf("expected the stream url derived from the webhook, got %s", stream.Url)
+	}
+
+	parameters := make(map[string]string)
+	for _, parameter := range stream.Parameters {
+		parameters[parameter.Name] = parameter.Value
+	}
+	// The query overrides the configured parameters, CallStatus is not forwarded.
+	expected := map[string]string{"agent_profile_id": "sales", "From": "+14158675309", "To": "+18005551212"}
+	for name, value := range expected {
+		if parameters[name] != value {
+			t.Errorf("expected parameter %s=%s, got %q", name, value, parameters[name])
+		}
+	}
+	if len(parameters) != len(expected)+1 {
+		t.Errorf("unexpected parameters %v", parameters)
+	}
+	if err := telephony.ValidateStreamToken("auth-token", "CA1234567890ABCDE", parameters[telephony.StreamTokenParameter]); err != nil {
+		t.Errorf("expected a valid stream token, got %v", err)
+	}
+}
+
+func TestTwimlVoiceHandlerFuncStreamUrl(t *testing.T) {
+	handler := NewTwimlVoiceHandlerFunc(TwimlVoiceConfig{StreamPath: "/ws", StreamUrl: "wss://example.com/custom"})
+	recorder := httptest.NewRecorder()
+	handler(recorder, newTwilioWebhookRequest("http://localhost:8081/twiml/voice", url.Values{"CallSid": {"CA1"}}))
+
+	var twiml telephony.TwimlResponse
+	if err := xml.Unmarshal(recorder.Body.Bytes(), &twiml); err != nil {
+		t.Fatal(err)
+	}
+	if twiml.Connect == nil || twiml.Connect.Stream.Url != "wss://example.com/custom" || len(twiml.Connect.Stream.Parameters) != 0 {
+		t.Errorf("expected only the configured stream url, got %s", recorder.Body.String())
+	}
+}
+
+func TestTwimlStatusHandlerFunc(t *testing.T) {
+	var statuses []TwilioCallStatus
+	handler := NewTwimlStatusHandlerFunc(func(status TwilioCallStatus) {
+		statuses = append(statuses, status)
+	})
+
+	t.Run("completed", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		handler(recorder, newTwilioWebhookRequest("/twiml/status", url.Values{
+			"AccountSid":   {"AC0123456789"},
+			"CallSid":      {"CA1234567890ABCDE"},
+			"CallStatus":   {"completed"},
+			"Direction":    {"outbound-api"},
+			"From":         {"+18005551212"},
+			"To":           {"+14158675309"},
+			"CallDuration": {"42"},
+		}))
+		if recorder.Code != http.StatusNoContent {
+			t.Fatalf("expected %d, got %d", http.StatusNoContent, recorder.Code)
+		}
+		if len(statuses) != 1 {
+			t.Fatalf("expected a single status, got %v", statuses)
+		}
+		expected := TwilioCallStatus{AccountSid: "AC0123456789", CallSid: "CA1234567890ABCDE", CallStatus: "completed", Direction: "outbound-api", From: "+18005551212", To: "+14158675309", CallDuration: "42"}
+		if statuses[0] != expected {
+			t.Errorf("expected %+v, got %+v", expected, statuses[0])
+		}
+	})
+
+	t.Run("not a post", func(t *testing.T) {
+		statuses = nil
+		recorder := httptest.NewRecorder()
+		handler(recorder, httptest.NewRequest(http.MethodGet, "/twiml/status?CallSid=CA1&CallStatus=completed", nil))
+		if recorder.Code != http.StatusMethodNotAllowed || len(statuses) != 0 {
+			t.Errorf("expected %d without a status, got %d and %v", http.StatusMethodNotAllowed, recorder.Code, statuses)
+		}
+	})
+
+	t.Run("bad form", func(t *testing.T) {
+		statuses = nil
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/twiml/status", strings.NewReader("CallSid=%zz&CallStatus=completed"))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		handler(recorder, request)
+		if recorder.Code != http.StatusBadRequest || len(statuses) != 0 {
+			t.Errorf("expected %d without a status, got %d and %v", http.StatusBadRequest, recorder.Code, statuses)
+		}
+	})
+}
@@ -0,0 +1,343 @@
+package networking
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/petrzlen/vocode-golang/pkg/audio_utils"
+	"github.com/pion/interceptor"
+	"github.com/pion/webrtc/v4"
+	"github.com/rs/zerolog/log"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// webrtcGatheringTimeout caps how long an offer waits for our ICE candidates, as we answer without trickle ICE.
+const webrtcGatheringTimeout = 10 * time.Second
+
+// webrtcMaxOfferSize in bytes, a browser offer with a couple of candidates is around 4KB.
+const webrtcMaxOfferSize = 64 << 10
+
+// webrtcOpusCodec is what the browsers offer, Opus is always 48kHz stereo in SDP whatever is actually sent.
+var webrtcOpusCodec = webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2, SDPFmtpLine: "minptime=10;useinbandfec=1"}
+
+var webrtcPcmuCodec = webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypePCMU, ClockRate: 8000, Channels: 1}
+
+// WebrtcCallHandler is the audio side of a WebRTC call, e.g. audioio.NewWebrtcHandler.
+type WebrtcCallHandler interface {
+	// Start is called once the peer connection is connected, the outbound audio can flow from then on.
+	Start()
+	// Stop is called when the peer connection failed or was closed by the other peer.
+	Stop() error
+}
+
+// WebrtcCall is what we know about an inbound call when it is offered.
+// The handler should register its PeerConnection callbacks (OnTrack, OnDataChannel) when it is created,
+// as the remote description is only set after that.
+type WebrtcCall struct {
+	CallId string
+	// Parameters are the query values of the offer request, the WebRTC counterpart of Twilio <Parameter>-s.
+	Parameters     map[string]string
+	PeerConnection *webrtc.PeerConnection
+	// LocalTrack is where the handler writes the outbound audio in LocalTrack.Codec(), i.e. Opus when both sides
+	// support it, PCMU otherwise. It is already added to PeerConnection.
+	LocalTrack *webrtc.TrackLocalStaticSample
+	// Hangup closes the peer connection, it does nothing once the call ended.
+	Hangup func()
+}
+
+type WebrtcServerConfig struct {
+	// IceServers are the STUN / TURN urls, e.g. "stun:stun.l.google.com:19302", none are needed on a public IP.
+	IceServers []string
+	// PublicIp is put into our ICE candidates when the server is behind a 1:1 NAT, e.g. on a cloud VM.
+	PublicIp string
+	// IncludeLoopbackCandidates for calls from the same machine, e.g. a local dev server only listening on 127.0.0.1.
+	IncludeLoopbackCandidates bool
+}
+
+// webrtcSession is the state of a single call, keyed by its CallId.
+type webrtcSession struct {
+	callId         string
+	peerConnection *webrtc.PeerConnection
+	handler        WebrtcCallHandler
+}
+
+// WebrtcServer answers WebRTC calls with a single audio track, the signaling is a plain HTTP offer / answer:
+// the client POSTs its SDP offer (with all ICE candidates gathered) and gets our SDP answer back.
+// Opus is negotiated when built with the opus tag (cgo libopus), as there is no pure Go Opus encoder, PCMU otherwise.
+type WebrtcServer struct {
+	config        WebrtcServerConfig
+	api           *webrtc.API
+	createHandler func(call WebrtcCall) (WebrtcCallHandler, error)
+
+	// mutex guards everything below.
+	mutex      sync.Mutex
+	isDraining bool
+	sessions   map[string]*webrtcSession
+}
+
+// NewWebrtcServer createHandler is called on every offer, returning an error declines the call.
+func NewWebrtcServer(config WebrtcServerConfig, createHandler func(call WebrtcCall) (WebrtcCallHandler, error)) (*WebrtcServer, error) {
+	mediaEngine := &webrtc.MediaEngine{}
+	// Registered first, so it is preferred in the answer.
+	if audio_utils.IsOpusSupported {
+		err := mediaEngine.RegisterCodec(webrtc.RTPCodecParameters{RTPCodecCapability: webrtcOpusCodec, PayloadType: 111}, webrtc.RTPCodecTypeAudio)
+		if err != nil {
+			return nil, fmt.Errorf("cannot register opus: %w", err)
+		}
+	}
+	err := mediaEngine.RegisterCodec(webrtc.RTPCodecParameters{RTPCodecCapability: webrtcPcmuCodec, PayloadType: 0}, webrtc.RTPCodecTypeAudio)
+	if err != nil {
+		return nil, fmt.Errorf("cannot register pcmu: %w", err)
+	}
+	// NACK-s and RTCP reports, so the browser can adapt to the network.
+	interceptorRegistry := &interceptor.Registry{}
+	if err := webrtc.RegisterDefaultInterceptors(mediaEngine, interceptorRegistry); err != nil {
+		return nil, fmt.Errorf("cannot register webrtc interceptors: %w", err)
+	}
+	settingEngine := webrtc.SettingEngine{}
+	if config.PublicIp != "" {
+		settingEngine.SetNAT1To1IPs([]string{config.PublicIp}, webrtc.ICECandidateTypeHost)
+	}
+	settingEngine.SetIncludeLoopbackCandidate(config.IncludeLoopbackCandidates)
+
+	return &WebrtcServer{
+		config: config,
+		api: webrtc.NewAPI(
+			webrtc.WithMediaEngine(mediaEngine),
+			webrtc.WithInterceptorRegistry(interceptorRegistry),
+			webrtc.WithSettingEngine(settingEngine),
+		),
+		createHandler: createHandler,
+		sessions:      make(map[string]*webrtcSession),
+	}, nil
+}
+
+// HandleOffer is the http.HandlerFunc for POST-ing the "application/sdp" offer, the answer comes back the same way.
+func (s *WebrtcServer) HandleOffer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	s.mutex.Lock()
+	isDraining := s.isDraining
+	s.mutex.Unlock()
+	if isDraining {
+		log.Info().Str("client_ip", getClientIpAddress(r)).Msg("WebrtcServer refusing call as the server is shutting down")
+		http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	offer, err := io.ReadAll(io.LimitReader(r.Body, webrtcMaxOfferSize))
+	if err != nil {
+		http.Error(w, "cannot read the offer", http.StatusBadRequest)
+		return
+	}
+
+	parameters := make(map[string]string)
+	for name := range r.URL.Query() {
+		parameters[name] = r.URL.Query().Get(name)
+	}
+
+	answer, err := s.answer(r.Context(), string(offer), parameters)
+	if err != nil {
+		log.Warn().Err(err).Str("client_ip", getClientIpAddress(r)).Msg("webrtc offer not answered")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/sdp")
+	w.WriteHeader(http.StatusCreated)
+	_, err = w.Write([]byte(answer))
+	errLog(err, "write webrtc answer")
+}
+
+// answer sets up the peer connection and its handler, and returns our SDP answer with all our ICE candidates.
+func (s *WebrtcServer) answer(ctx context.Context, offer string, parameters map[string]string) (string, error) {
+	iceServers := make([]webrtc.ICEServer, 0, len(s.config.IceServers))
+	for _, url := range s.config.IceServers {
+		iceServers = append(iceServers, webrtc.ICEServer{URLs: []string{url}})
+	}
+	peerConnection, err := s.api.NewPeerConnection(webrtc.Configuration{ICEServers: iceServers})
+	if err != nil {
+		return "", fmt.Errorf("cannot create peer connection: %w", err)
+	}
+	// Unless answered, the peer connection is closed on the way out.
+	isAnswered := false
+	defer func() {
+		if !isAnswered {
+			errLog(peerConnection.Close(), "peerConnection.Close() of unanswered offer")
+		}
+	}()
+
+	localTrack, err := webrtc.NewTrackLocalStaticSample(getOutboundCodec(offer), "audio", "vocode")
+	if err != nil {
+		return "", fmt.Errorf("cannot create local track: %w", err)
+	}
+	sender, err := peerConnection.AddTrack(localTrack)
+	if err != nil {
+		return "", fmt.Errorf("cannot add local track: %w", err)
+	}
+	// The inbound RTCP has to be read, so the interceptors get to process it.
+	go func() {
+		buffer := make([]byte, 1500)
+		for {
+			if _, _, err := sender.Read(buffer); err != nil {
+				return
+			}
+		}
+	}()
+
+	session := &webrtcSession{
+		callId:         newWebrtcCallId(),
+		peerConnection: peerConnection,
+	}
+	handler, err := s.createHandler(WebrtcCall{
+		CallId:         session.callId,
+		Parameters:     parameters,
+		PeerConnection: peerConnection,
+		LocalTrack:     localTrack,
+		Hangup:         func() { s.hangup(session.callId, false) },
+	})
+	if err != nil {
+		return "", fmt.Errorf("call declined: %w", err)
+	}
+	session.handler = handler
+	peerConnection.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		log.Info().Str("call_id", session.callId).Str("state", state.String()).Msg("webrtc connection state changed")
+		switch state {
+		case webrtc.PeerConnectionStateConnected:
+			handler.Start()
+		case webrtc.PeerConnectionStateFailed, webrtc.PeerConnectionStateClosed:
+			s.hangup(session.callId, true)
+		default:
+		}
+	})
+
+	if err := peerConnection.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offer}); err != nil {
+		errLog(handler.Stop(), "WebrtcCallHandler.Stop after invalid offer")
+		return "", fmt.Errorf("invalid offer: %w", err)
+	}
+	answer, err := peerConnection.CreateAnswer(nil)
+	if err != nil {
+		errLog(handler.Stop(), "WebrtcCallHandler.Stop after failed answer")
+		return "", fmt.Errorf("cannot create answer: %w", err)
+	}
+	gatheringComplete := webrtc.GatheringCompletePromise(peerConnection)
+	if err := peerConnection.SetLocalDescription(answer); err != nil {
+		errLog(handler.Stop(), "WebrtcCallHandler.Stop after failed answer")
+		return "", fmt.Errorf("cannot set local description: %w", err)
+	}
+	gatheringCtx, cancel := context.WithTimeout(ctx, webrtcGatheringTimeout)
+	defer cancel()
+	select {
+	case <-gatheringComplete:
+	case <-gatheringCtx.Done():
+		errLog(handler.Stop(), "WebrtcCallHandler.Stop after gathering timeout")
+		return "", fmt.Errorf("ice gathering did not complete: %w", gatheringCtx.Err())
+	}
+
+	s.mutex.Lock()
+	s.sessions[session.callId] = session
+	s.mutex.Unlock()
+	isAnswered = true
+	log.Info().Str("call_id", session.callId).Interface("parameters", parameters).Msg("webrtc call answered")
+	return peerConnection.LocalDescription().SDP, nil
+}
+
+// Count returns the number of active calls.
+func (s *WebrtcServer) Count() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.sessions)
+}
+
+// Shutdown works like ConnectionRegistry.Shutdown: new calls are declined, the ShutdownAwareHandler-s notified,
+// and the active calls have until ctx is done to finish. Then the rest gets closed, and ctx.Err() is returned.
+func (s *WebrtcServer) Shutdown(ctx context.Context) error {
+	s.mutex.Lock()
+	s.isDraining = true
+	handlers := make([]WebrtcCallHandler, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		handlers = append(handlers, session.handler)
+	}
+	s.mutex.Unlock()
+
+	log.Info().Int("num_calls", len(handlers)).Msg("webrtc server draining")
+	for _, handler := range handlers {
+		if shutdownAware, ok := handler.(ShutdownAwareHandler); ok {
+			go shutdownAware.Shutdown()
+		}
+	}
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for s.Count() > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			log.Warn().Int("num_calls", s.Count()).Msg("webrtc server drain timeout, closing the rest")
+			s.mutex.Lock()
+			callIds := make([]string, 0, len(s.sessions))
+			for callId := range s.sessions {
+				callIds = append(callIds, callId)
+			}
+			s.mutex.Unlock()
+			for _, callId := range callIds {
+				s.hangup(callId, true)
+			}
+			return ctx.Err()
+		}
+	}
+	log.Info().Msg("webrtc server shut down")
+	return nil
+}
+
+// hangup closes the peer connection and ends the call, stopHandler is false when the handler itself is stopping.
+func (s *WebrtcServer) hangup(callId string, stopHandler bool) {
+	s.mutex.Lock()
+	session, ok := s.sessions[callId]
+	delete(s.sessions, callId)
+	s.mutex.Unlock()
+	if !ok {
+		return
+	}
+	log.Info().Str("call_id", callId).Msg("webrtc call ended")
+	errLog(session.peerConnection.Close(), "peerConnection.Close()")
+	if stopHandler {
+		errLog(session.handler.Stop(), "WebrtcCallHandler.Stop")
+	}
+}
+
+// getOutboundCodec is Opus if the offer has it and we support it, PCMU otherwise.
+// An invalid offer gets PCMU, it fails on SetRemoteDescription anyway.
+func getOutboundCodec(offer string) webrtc.RTPCodecCapability {
+	if !audio_utils.IsOpusSupported {
+		return webrtcPcmuCodec
+	}
+	description := webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offer}
+	parsed, err := description.Unmarshal()
+	if err != nil {
+		return webrtcPcmuCodec
+	}
+	for _, media := range parsed.MediaDescriptions {
+		if media.MediaName.Media != "audio" {
+			continue
+		}
+		for _, attribute := range media.Attributes {
+			// e.g. "a=rtpmap:111 opus/48000/2"
+			if attribute.Key == "rtpmap" && strings.Contains(strings.ToLower(attribute.Value), " opus/48000") {
+				return webrtcOpusCodec
+			}
+		}
+	}
+	return webrtcPcmuCodec
+}
+
+func newWebrtcCallId() string {
+	randomBytes := make([]byte, 8)
+	_, _ = rand.Read(randomBytes)
+	return hex.EncodeToString(randomBytes)
+}
@@ -4,15 +4,19 @@ import (
 	"github.com/gorilla/websocket"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
+	"net"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 )
 
 // WebsocketMessageHandler usage:
 // * Read from GetReader chan until closed (which means the other party closed it)
 // * Write into GetWriter chan until you want - if you close it than the websocket will be closed gracefully.
 //
-// NOTE: This assumes the message encoding is websocket.TestMessage type (NOT websocket.Binary).
-// TextMessage should cover most of the real-world cases, as these days everyone just sends JSON stuff.
+// NOTE: All written messages are sent as websocket.TextMessage, which covers JSON protocols like Twilio's.
+// Received binary messages are passed as they are, use TypedWebsocketMessageHandler to tell them apart.
 type WebsocketMessageHandler interface {
 	// GetReader is where websocket.ReadMessage will produce messages into UNTIL the websocket is closed,
 	// then the Reader chan will be CLOSED, i.e. do NOT close this channel yourself as panic is a guaranteed.
@@ -22,10 +26,103 @@ type WebsocketMessageHandler interface {
 	GetWriter() <-chan []byte
 }
 
+// WebsocketMessage is a message together with its websocket type,
+// i.e. websocket.TextMessage for JSON and websocket.BinaryMessage for raw audio.
+type WebsocketMessage struct {
+	Type int
+	Data []byte
+}
+
+func NewTextMessage(data []byte) WebsocketMessage {
+	return WebsocketMessage{Type: websocket.TextMessage, Data: data}
+}
+
+func NewBinaryMessage(data []byte) WebsocketMessage {
+	return WebsocketMessage{Type: websocket.BinaryMessage, Data: data}
+}
+
+// TypedWebsocketMessageHandler is WebsocketMessageHandler for protocols which mix text and binary messages,
+// e.g. a JSON header followed by raw PCM frames. Same usage and channel ownership rules apply.
+type TypedWebsocketMessageHandler interface {
+	GetReader() chan<- WebsocketMessage
+	GetWriter() <-chan WebsocketMessage
+}
+
+// textMessageAdapter makes a WebsocketMessageHandler a TypedWebsocketMessageHandler,
+// by converting the messages between the channels in both directions.
+type textMessageAdapter struct {
+	handler   WebsocketMessageHandler
+	readChan  chan WebsocketMessage
+	writeChan chan WebsocketMessage
+}
+
+func newTextMessageAdapter(handler WebsocketMessageHandler) *textMessageAdapter {
+	result := &textMessageAdapter{
+		handler:   handler,
+		readChan:  make(chan WebsocketMessage),
+		writeChan: make(chan WebsocketMessage),
+	}
+	go func() {
+		for msg := range result.readChan {
+			handler.GetReader() <- msg.Data
+		}
+		close(handler.GetReader())
+	}()
+	go func() {
+		for data := range handler.GetWriter() {
+			result.writeChan <- NewTextMessage(data)
+		}
+		close(result.writeChan)
+	}()
+	return result
+}
+
+// Shutdown implements ShutdownAwareHandler.Shutdown if the adapted handler does.
+func (a *textMessageAdapter) Shutdown() {
+	if handler, ok := a.handler.(ShutdownAwareHandler); ok {
+		handler.Shutdown()
+	}
+}
+
+// GetReader implements TypedWebsocketMessageHandler.GetReader
+func (a *textMessageAdapter) GetReader() chan<- WebsocketMessage {
+	return a.readChan
+}
+
+// GetWriter implements TypedWebsocketMessageHandler.GetWriter
+func (a *textMessageAdapter) GetWriter() <-chan WebsocketMessage {
+	return a.writeChan
+}
+
 var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true // Adjust the origin check as needed
-	},
+	CheckOrigin: IsSameOrigin,
+}
+
+// IsSameOrigin only lets a browser in from the page we served, so no other web page the user visits
+// can open our websocket (or post an offer) and spend the API budget on their behalf.
+// Requests without an Origin come from no browser, e.g. Twilio or Vonage, and are let through.
+func IsSameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	originUrl, err := url.Parse(origin)
+	if err == nil && strings.EqualFold(originUrl.Host, r.Host) {
+		return true
+	}
+	log.Warn().Str("client_ip", getClientIpAddress(r)).Str("origin", origin).Str("host", r.Host).Msg("rejecting cross origin request")
+	return false
+}
+
+// RequireSameOrigin rejects the requests failing IsSameOrigin with 403.
+func RequireSameOrigin(next func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !IsSameOrigin(r) {
+			http.Error(w, "cross origin request", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
 }
 
 func getClientIpAddress(r *http.Request) (clientIP string) {
@@ -41,64 +138,160 @@ func getClientIpAddress(r *http.Request) (clientIP string) {
 	return
 }
 
+// WebsocketConfig tunes the keepalive and limits of each connection, so half-open TCP connections
+// (e.g. the phone network dropped) are detected and cleaned up instead of hanging forever.
+type WebsocketConfig struct {
+	// PingInterval is how often we ping the other party, it should be well below IdleTimeout.
+	PingInterval time.Duration
+	// IdleTimeout closes the connection when nothing (not even a pong) was received within it.
+	IdleTimeout time.Duration
+	// WriteTimeout is the deadline of a single write, including pings and close frames.
+	WriteTimeout time.Duration
+	// MaxMessageSize in bytes, larger received messages close the connection.
+	MaxMessageSize int64
+	// Registry if set tracks the connections for a graceful shutdown, and refuses new ones while draining.
+	Registry *ConnectionRegistry
+}
+
+// DefaultWebsocketConfig fits Twilio which sends a media message every 20ms, and our 2-3s long TTS chunks.
+var DefaultWebsocketConfig = WebsocketConfig{
+	PingInterval:   10 * time.Second,
+	IdleTimeout:    30 * time.Second,
+	WriteTimeout:   10 * time.Second,
+	MaxMessageSize: 1 << 20,
+}
+
 // NewWebsocketHandlerFunc takes the raw http reader / writer,
 // and abstracts it into WebsocketMessageHandler which works at the chan []byte message level.
 func NewWebsocketHandlerFunc(createHandler func() WebsocketMessageHandler) func(w http.ResponseWriter, r *http.Request) {
+	return NewWebsocketHandlerFuncWithConfig(DefaultWebsocketConfig, createHandler)
+}
+
+// NewWebsocketHandlerFuncWithConfig is NewWebsocketHandlerFunc with custom keepalive, limits or registry.
+func NewWebsocketHandlerFuncWithConfig(config WebsocketConfig, createHandler func() WebsocketMessageHandler) func(w http.ResponseWriter, r *http.Request) {
+	return NewTypedWebsocketHandlerFunc(config, func() TypedWebsocketMessageHandler {
+		return newTextMessageAdapter(createHandler())
+	})
+}
+
+// NewTypedWebsocketHandlerFunc is NewWebsocketHandlerFunc which keeps the websocket message type in both directions.
+func NewTypedWebsocketHandlerFunc(config WebsocketConfig, createHandler func() TypedWebsocketMessageHandler) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		handler := createHandler()
-		log.Info().Str("client_ip", getClientIpAddress(r)).Str("method", r.Method).Str("request_url", r.URL.String()).Msg("NewWebsocketHandlerFunc attempting to establish a websocket connection")
+		if config.Registry != nil && config.Registry.IsDraining() {
+			log.Info().Str("client_ip", getClientIpAddress(r)).Msg("NewWebsocketHandlerFunc refusing connection as the server is shutting down")
+			http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+			return
+		}
 
-		defer func() { close(handler.GetReader()) }()
+		log.Info().Str("client_ip", getClientIpAddress(r)).Str("method", r.Method).Str("request_url", r.URL.String()).Msg("NewWebsocketHandlerFunc attempting to establish a websocket connection")
 
+		// The origin is checked by the upgrade, so a rejected connection never creates a handler.
 		ws, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
 			errLog(err, "websocket upgrader.Upgrade")
 			return
 		}
-		defer func() { errLog(ws.Close(), "websocket.Close()") }()
-
-		// Start a goroutine for sending messages
-		// TODO(P1, ux): Interrupts / sigkills should also clean up all alive websocket connections.
-		go func() {
-			for {
-				msg, ok := <-handler.GetWriter()
-				// Channel closed by the user, attempt to close connection gracefully.
-				// That will also end up the reader routine.
-				if !ok {
-					log.Info().Msg("websocket writer channel closed, attempting to close connection gracefully")
-					msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
-					errLog(ws.WriteMessage(websocket.CloseMessage, msg), "websocket.CloseMessage gracefully")
-					return
-				}
-
-				if err := ws.WriteMessage(websocket.TextMessage, msg); err != nil {
-					if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseNoStatusReceived) {
-						log.Info().Msg("websocket too late to write message, as already closed")
-					} else if errors.Is(err, websocket.ErrCloseSent) {
-						// ErrCloseSent is returned when the application writes a message to the
-						// connection after sending a close message.
-						log.Info().Msg("websocket too late to write message, as already closed")
-					} else {
-						errLog(err, "ws.WriteMessage")
-					}
-					return
-				}
+		handler := createHandler()
+		defer func() { close(handler.GetReader()) }()
+		defer func() {
+			// Might be already closed by ConnectionRegistry.Shutdown
+			if err := ws.Close(); !errors.Is(err, net.ErrClosed) {
+				errLog(err, "websocket.Close()")
 			}
 		}()
 
+		if config.Registry != nil {
+			conn := config.Registry.add(ws, handler)
+			if conn == nil {
+				log.Info().Msg("NewWebsocketHandlerFunc closing connection as the server is shutting down")
+				closeMsg := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "server shutting down")
+				errLog(ws.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(config.WriteTimeout)), "websocket.CloseMessage on shutdown")
+				return
+			}
+			defer config.Registry.remove(conn)
+		}
+
+		ws.SetReadLimit(config.MaxMessageSize)
+		extendReadDeadline := func() {
+			errLog(ws.SetReadDeadline(time.Now().Add(config.IdleTimeout)), "websocket.SetReadDeadline")
+		}
+		extendReadDeadline()
+		ws.SetPongHandler(func(string) error {
+			extendReadDeadline()
+			return nil
+		})
+
+		// Start a goroutine for sending messages
+		readerDone := make(chan struct{})
+		defer close(readerDone)
+		go writeMessagesRoutine(ws, config, handler.GetWriter(), readerDone)
+
 		log.Info().Msg("NewWebsocketHandlerFunc starting to read from the websocket")
 		for {
-			_, msg, err := ws.ReadMessage()
+			messageType, msg, err := ws.ReadMessage()
 			if err != nil {
+				var netErr net.Error
 				if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseNoStatusReceived) {
 					log.Info().Msg("websocket connection closed normally from the other party")
+				} else if errors.As(err, &netErr) && netErr.Timeout() {
+					log.Warn().Dur("idle_timeout", config.IdleTimeout).Msg("websocket connection idle for too long, closing it")
+					closeMsg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "idle timeout")
+					errLog(ws.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(config.WriteTimeout)), "websocket.CloseMessage idle timeout")
 				} else {
 					log.Error().Err(err).Msgf("couldn't read message from websocket: %s", string(msg))
 				}
 				// Usually, nothing good will happen ever after a bad websocket message
 				return
 			}
-			handler.GetReader() <- msg
+			extendReadDeadline()
+			handler.GetReader() <- WebsocketMessage{Type: messageType, Data: msg}
+		}
+	}
+}
+
+// writeMessagesRoutine is the only writer of ws (as gorilla/websocket requires), including the keepalive pings.
+// Once it stops, the rest of writerChan is discarded so its producers never block on a dead connection,
+// i.e. the handler should still close its writer, e.g. when its reader gets closed.
+func writeMessagesRoutine(ws *websocket.Conn, config WebsocketConfig, writerChan <-chan WebsocketMessage, readerDone <-chan struct{}) {
+	pingTicker := time.NewTicker(config.PingInterval)
+	defer pingTicker.Stop()
+	defer func() {
+		for range writerChan {
+		}
+	}()
+
+	for {
+		select {
+		case <-readerDone:
+			return
+		case <-pingTicker.C:
+			if err := ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(config.WriteTimeout)); err != nil {
+				log.Info().Err(err).Msg("websocket cannot ping, stopping the writer")
+				return
+			}
+		case msg, ok := <-writerChan:
+			// Channel closed by the user, attempt to close connection gracefully.
+			// That will also end up the reader routine.
+			if !ok {
+				log.Info().Msg("websocket writer channel closed, attempting to close connection gracefully")
+				closeMsg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+				errLog(ws.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(config.WriteTimeout)), "websocket.CloseMessage gracefully")
+				return
+			}
+
+			errLog(ws.SetWriteDeadline(time.Now().Add(config.WriteTimeout)), "websocket.SetWriteDeadline")
+			if err := ws.WriteMessage(msg.Type, msg.Data); err != nil {
+				if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseNoStatusReceived) {
+					log.Info().Msg("websocket too late to write message, as already closed")
+				} else if errors.Is(err, websocket.ErrCloseSent) {
+					// ErrCloseSent is returned when the application writes a message to the
+					// connection after sending a close message.
+					log.Info().Msg("websocket too late to write message, as already closed")
+				} else {
+					errLog(err, "ws.WriteMessage")
+				}
+				return
+			}
 		}
 	}
 }
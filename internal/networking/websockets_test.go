@@ -0,0 +1,132 @@
+package networking
+
+import (
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// chanTypedHandler passes everything received to readChan, the test closes writeChan once readChan is closed.
+type chanTypedHandler struct {
+	readChan  chan WebsocketMessage
+	writeChan chan WebsocketMessage
+}
+
+func (h *chanTypedHandler) GetReader() chan<- WebsocketMessage {
+	return h.readChan
+}
+
+func (h *chanTypedHandler) GetWriter() <-chan WebsocketMessage {
+	return h.writeChan
+}
+
+func TestWebsocketClosesSilentPeerAfterIdleTimeout(t *testing.T) {
+	config := WebsocketConfig{
+		PingInterval:   50 * time.Millisecond,
+		IdleTimeout:    300 * time.Millisecond,
+		WriteTimeout:   100 * time.Millisecond,
+		MaxMessageSize: 1 << 10,
+	}
+	handler := &chanTypedHandler{readChan: make(chan WebsocketMessage, 10), writeChan: make(chan WebsocketMessage)}
+	defer close(handler.writeChan)
+	server := httptest.NewServer(http.HandlerFunc(NewTypedWebsocketHandlerFunc(config, func() TypedWebsocketMessageHandler {
+		return handler
+	})))
+	defer server.Close()
+
+	peer, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	// The peer answers the pings until it goes silent, e.g. the phone network dropped without a FIN.
+	var isSilent atomic.Bool
+	var lastPongTime atomic.Int64
+	peer.SetPingHandler(func(data string) error {
+		if isSilent.Load() {
+			return nil
+		}
+		lastPongTime.Store(time.Now().UnixNano())
+		return peer.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(config.WriteTimeout))
+	})
+	peerErrChan := make(chan error, 1)
+	go func() {
+		for {
+			if _, _, err := peer.ReadMessage(); err != nil {
+				peerErrChan <- err
+				return
+			}
+		}
+	}()
+
+	// Answering the pings keeps the connection open well beyond the IdleTimeout, even without any message.
+	select {
+	case _, ok := <-handler.readChan:
+		if !ok {
+			t.Fatal("the connection was closed while the peer answered the pings")
+		}
+		t.Fatal("unexpected message")
+	case <-time.After(3 * config.IdleTimeout):
+	}
+
+	isSilent.Store(true)
+	select {
+	case _, ok := <-handler.readChan:
+		if ok {
+			t.Fatal("unexpected message")
+		}
+	case <-time.After(5 * config.IdleTimeout):
+		t.Fatal("the connection of the silent peer was not closed")
+	}
+	// The last pong extended the read deadline by IdleTimeout, the slack is for the pong still on its way.
+	if silentFor := time.Since(time.Unix(0, lastPongTime.Load())); silentFor < config.IdleTimeout-10*time.Millisecond {
+		t.Errorf("expected the connection closed after the idle timeout, got closed %s after the last pong", silentFor)
+	}
+
+	select {
+	case err := <-peerErrChan:
+		if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+			t.Errorf("expected the idle timeout close, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the peer did not get closed")
+	}
+}
+
+func TestWebsocketRejectsForeignOrigin(t *testing.T) {
+	handlerChan := make(chan *chanTypedHandler, 10)
+	server := httptest.NewServer(http.HandlerFunc(NewTypedWebsocketHandlerFunc(DefaultWebsocketConfig, func() TypedWebsocketMessageHandler {
+		handler := &chanTypedHandler{readChan: make(chan WebsocketMessage, 10), writeChan: make(chan WebsocketMessage)}
+		handlerChan <- handler
+		return handler
+	})))
+	defer server.Close()
+	wsUrl := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	// Any other web page the user visits.
+	_, resp, err := websocket.DefaultDialer.Dial(wsUrl, http.Header{"Origin": []string{"https://evil.example"}})
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected a foreign origin rejected with 403, got %v", err)
+	}
+
+	// The page we served, and a carrier which sends no Origin at all.
+	for _, header := range []http.Header{{"Origin": []string{server.URL}}, nil} {
+		peer, _, err := websocket.DefaultDialer.Dial(wsUrl, header)
+		if err != nil {
+			t.Fatalf("expected the origin %v accepted, got %v", header, err)
+		}
+		handler := <-handlerChan
+		_ = peer.Close()
+		// The reader gets closed once the peer went away, then the handler closes its writer.
+		for range handler.readChan {
+		}
+		close(handler.writeChan)
+	}
+	if len(handlerChan) != 0 {
+		t.Error("expected no handler created for the rejected connection")
+	}
+}
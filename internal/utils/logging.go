@@ -3,14 +3,20 @@ package utils
 import (
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"io"
 	"os"
 	"time"
 )
 
 func SetupZerolog() {
+	SetupZerologWithOutput(os.Stdout)
+}
+
+// SetupZerologWithOutput is SetupZerolog logging into out, e.g. os.Stderr when stdout is used for the audio.
+func SetupZerologWithOutput(out io.Writer) {
 	// Set up zerolog with custom output to include milliseconds in the timestamp
 	log.Logger = zerolog.New(zerolog.ConsoleWriter{
-		Out:        os.Stdout,
+		Out:        out,
 		TimeFormat: "2006-01-02T15:04:05.000-07:00", // Fake news, BUT we need milliseconds to debug stuff.
 	}).With().Timestamp().Logger()
 	// https://github.com/rs/zerolog/issues/114
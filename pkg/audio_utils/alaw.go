@@ -0,0 +1,49 @@
+package audio_utils
+
+// G.711 A-law, the European counterpart of mulaw, which SIP trunks offer as PCMA.
+// https://en.wikipedia.org/wiki/A-law_algorithm
+// Unlike mulaw.go this computes the segments directly, as the tables would be twice as long for no gain.
+
+const aLawXorMask = 0x55
+
+// AlawSilenceByte is the A-law encoded zero sample.
+const AlawSilenceByte = 0xd5
+
+func int16ToALaw(s int16) uint8 {
+	sample := int(s)
+	sign := uint8(0x80)
+	if sample < 0 {
+		sign = 0
+		sample = -sample - 1
+	}
+	// A-law works on 13bit samples.
+	sample >>= 3
+	if sample > 0xfff {
+		sample = 0xfff
+	}
+
+	var compressed uint8
+	if sample < 32 {
+		compressed = uint8(sample >> 1)
+	} else {
+		segment := uint8(1)
+		for value := sample >> 5; value > 1; value >>= 1 {
+			segment++
+		}
+		compressed = segment<<4 | uint8((sample>>segment)&0x0f)
+	}
+	return (compressed | sign) ^ aLawXorMask
+}
+
+func aLawToInt16(s uint8) int16 {
+	s ^= aLawXorMask
+	segment := (s & 0x70) >> 4
+	sample := int(s&0x0f)<<4 + 8
+	if segment > 0 {
+		sample = (sample + 0x100) << (segment - 1)
+	}
+	if s&0x80 == 0 {
+		return int16(-sample)
+	}
+	return int16(sample)
+}
@@ -0,0 +1,260 @@
+package audio_utils
+
+import (
+	"math"
+	"time"
+)
+
+type AnsweringMachineEventKind int
+
+const (
+	// AnsweringMachineHuman a short greeting like "Hello?" followed by silence.
+	AnsweringMachineHuman AnsweringMachineEventKind = iota
+	// AnsweringMachineMachine a long greeting, many words or a long initial silence.
+	AnsweringMachineMachine
+	// AnsweringMachineNotSure nothing conclusive within TotalAnalysisTime, usually best treated as a human.
+	AnsweringMachineNotSure
+	// AnsweringMachineBeep the voicemail beep has just ended, i.e. it's time to leave a message.
+	AnsweringMachineBeep
+)
+
+func (k AnsweringMachineEventKind) String() string {
+	names := [...]string{
+		"Human",
+		"Machine",
+		"NotSure",
+		"Beep",
+	}
+
+	if k < AnsweringMachineHuman || k > AnsweringMachineBeep {
+		return "Unknown"
+	}
+
+	return names[k]
+}
+
+type AnsweringMachineEvent struct {
+	Kind AnsweringMachineEventKind
+	// At is the audio position since the start of the detection.
+	At time.Duration
+	// Reason explains the verdict for debugging, e.g. "greeting too long".
+	Reason string
+}
+
+// AnsweringMachineConfig thresholds mostly follow the Asterisk AMD defaults
+// https://docs.asterisk.org/Asterisk_18_Documentation/API_Documentation/Dialplan_Applications/AMD/
+type AnsweringMachineConfig struct {
+	InitialSilence       time.Duration
+	Greeting             time.Duration
+	AfterGreetingSilence time.Duration
+	TotalAnalysisTime    time.Duration
+	MinWordLength        time.Duration
+	BetweenWordsSilence  time.Duration
+	MaximumNumberOfWords int
+	// SilenceThreshold is the average absolute 16bit amplitude of a frame under which it is silence.
+	SilenceThreshold float64
+	// MinBeepLength of a pure tone to be considered the voicemail beep.
+	MinBeepLength time.Duration
+	// BeepToneRatio is how much of the frame energy has to be in a single frequency, 1.0 is a pure sine.
+	BeepToneRatio float64
+}
+
+var DefaultAnsweringMachineConfig = AnsweringMachineConfig{
+	InitialSilence:       2500 * time.Millisecond,
+	Greeting:             1500 * time.Millisecond,
+	AfterGreetingSilence: 800 * time.Millisecond,
+	TotalAnalysisTime:    5000 * time.Millisecond,
+	MinWordLength:        100 * time.Millisecond,
+	BetweenWordsSilence:  50 * time.Millisecond,
+	MaximumNumberOfWords: 3,
+	SilenceThreshold:     256,
+	MinBeepLength:        100 * time.Millisecond,
+	BeepToneRatio:        0.7,
+}
+
+// Voicemail beeps are usually a single tone between 400Hz and 2000Hz, we search it in 25Hz steps.
+// A 20ms frame resolves only 50Hz, so with 50Hz steps a tone right between two of them, e.g. 1025Hz,
+// would leak into both and hold too little of the energy in either.
+const (
+	beepMinFrequency  = 400
+	beepMaxFrequency  = 2000
+	beepFrequencyStep = 25
+)
+
+const answeringMachineFrameDuration = 20 * time.Millisecond
+
+// AnsweringMachineDetector classifies the first seconds of the inbound audio of an outbound call as a human
+// or a machine, by the cadence of the speech (greeting length, number of words, silences).
+// After a machine verdict it keeps listening for the voicemail beep.
+// It is NOT safe for concurrent use.
+type AnsweringMachineDetector struct {
+	config     AnsweringMachineConfig
+	sampleRate int
+	frameSize  int
+	pending    []int
+
+	position        time.Duration
+	verdict         *AnsweringMachineEventKind
+	silenceDuration time.Duration
+	voiceDuration   time.Duration
+	greetingStart   time.Duration // -1 until the first word
+	numWords        int
+	inWord          bool
+
+	beepFrequency int
+	beepDuration  time.Duration
+	beepDetected  bool
+}
+
+func NewAnsweringMachineDetector(sampleRate int, config AnsweringMachineConfig) *AnsweringMachineDetector {
+	return &AnsweringMachineDetector{
+		config:        config,
+		sampleRate:    sampleRate,
+		frameSize:     sampleRate * int(answeringMachineFrameDuration/time.Millisecond) / 1000,
+		pending:       make([]int, 0),
+		greetingStart: -1,
+	}
+}
+
+// IsDone is true when there is nothing more to detect, i.e. a human verdict or the beep after a machine one.
+func (d *AnsweringMachineDetector) IsDone() bool {
+	if d.verdict == nil {
+		return false
+	}
+	return *d.verdict != AnsweringMachineMachine || d.beepDetected
+}
+
+// Process consumes more 16bit mono samples, and returns the events detected in them.
+func (d *AnsweringMachineDetector) Process(samples []int) []AnsweringMachineEvent {
+	var result []AnsweringMachineEvent
+	d.pending = append(d.pending, samples...)
+	for len(d.pending) >= d.frameSize && !d.IsDone() {
+		frame := d.pending[:d.frameSize]
+		d.pending = d.pending[d.frameSize:]
+		d.position += answeringMachineFrameDuration
+
+		if d.verdict == nil {
+			if event := d.processCadence(frame); event != nil {
+				d.verdict = &event.Kind
+				result = append(result, *event)
+			}
+		} else if event := d.processBeep(frame); event != nil {
+			d.beepDetected = true
+			result = append(result, *event)
+		}
+	}
+	return result
+}
+
+func (d *AnsweringMachineDetector) newEvent(kind AnsweringMachineEventKind, reason string) *AnsweringMachineEvent {
+	return &AnsweringMachineEvent{Kind: kind, At: d.position, Reason: reason}
+}
+
+// processCadence is a simplified version of the Asterisk AMD state machine.
+func (d *AnsweringMachineDetector) processCadence(frame []int) *AnsweringMachineEvent {
+	if averageAbsAmplitude(frame) < d.config.SilenceThreshold {
+		d.silenceDuration += answeringMachineFrameDuration
+		d.voiceDuration = 0
+		if d.inWord && d.silenceDuration >= d.config.BetweenWordsSilence {
+			d.inWord = false
+		}
+
+		if d.greetingStart < 0 && d.silenceDuration >= d.config.InitialSilence {
+			return d.newEvent(AnsweringMachineMachine, "initial silence too long")
+		}
+		if d.greetingStart >= 0 && d.silenceDuration >= d.config.AfterGreetingSilence {
+			return d.newEvent(AnsweringMachineHuman, "short greeting followed by silence")
+		}
+	} else {
+		d.voiceDuration += answeringMachineFrameDuration
+		d.silenceDuration = 0
+		if !d.inWord && d.voiceDuration >= d.config.MinWordLength {
+			d.inWord = true
+			d.numWords++
+			if d.greetingStart < 0 {
+				d.greetingStart = d.position - d.voiceDuration
+			}
+			if d.numWords >= d.config.MaximumNumberOfWords {
+				return d.newEvent(AnsweringMachineMachine, "too many words")
+			}
+		}
+		if d.greetingStart >= 0 && d.position-d.greetingStart >= d.config.Greeting {
+			return d.newEvent(AnsweringMachineMachine, "greeting too long")
+		}
+	}
+
+	if d.position >= d.config.TotalAnalysisTime {
+		return d.newEvent(AnsweringMachineNotSure, "total analysis time reached")
+	}
+	return nil
+}
+
+// processBeep reports the beep once a steady tone of at least MinBeepLength ends.
+func (d *AnsweringMachineDetector) processBeep(frame []int) *AnsweringMachineEvent {
+	frequency := 0
+	if averageAbsAmplitude(frame) >= d.config.SilenceThreshold {
+		frequency = dominantFrequency(frame, d.sampleRate, d.config.BeepToneRatio)
+	}
+
+	isSameTone := frequency > 0 && d.beepFrequency > 0 && math.Abs(float64(frequency-d.beepFrequency)) <= beepFrequencyStep
+	if isSameTone {
+		d.beepDuration += answeringMachineFrameDuration
+		return nil
+	}
+
+	// The tone (if any) has just ended.
+	wasBeep := d.beepDuration >= d.config.MinBeepLength
+	d.beepFrequency = frequency
+	d.beepDuration = 0
+	if frequency > 0 {
+		d.beepDuration = answeringMachineFrameDuration
+	}
+	if wasBeep {
+		return d.newEvent(AnsweringMachineBeep, "steady tone ended")
+	}
+	return nil
+}
+
+func averageAbsAmplitude(frame []int) float64 {
+	sum := 0.0
+	for _, sample := range frame {
+		sum += math.Abs(float64(sample))
+	}
+	return sum / float64(len(frame))
+}
+
+// dominantFrequency returns the beep candidate frequency holding at least minRatio of the frame energy, or 0.
+// Uses the Goertzel algorithm https://en.wikipedia.org/wiki/Goertzel_algorithm as we only need a few frequencies.
+func dominantFrequency(frame []int, sampleRate int, minRatio float64) int {
+	energy := 0.0
+	for _, sample := range frame {
+		energy += float64(sample) * float64(sample)
+	}
+	if energy == 0 {
+		return 0
+	}
+
+	n := float64(len(frame))
+	bestFrequency := 0
+	bestRatio := 0.0
+	for frequency := beepMinFrequency; frequency <= beepMaxFrequency; frequency += beepFrequencyStep {
+		coefficient := 2 * math.Cos(2*math.Pi*float64(frequency)/float64(sampleRate))
+		var s1, s2 float64
+		for _, sample := range frame {
+			s0 := float64(sample) + coefficient*s1 - s2
+			s2, s1 = s1, s0
+		}
+		power := s1*s1 + s2*s2 - coefficient*s1*s2
+		// For a pure sine at frequency: power = (n*A/2)^2 and energy = n*A^2/2, so the ratio is 1.
+		ratio := power / (n / 2 * energy)
+		if ratio > bestRatio {
+			bestRatio = ratio
+			bestFrequency = frequency
+		}
+	}
+
+	if bestRatio < minRatio {
+		return 0
+	}
+	return bestFrequency
+}
@@ -0,0 +1,168 @@
+package audio_utils
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+const answeringMachineTestSampleRate = 8000
+
+// newTestTone a sine at frequency, e.g. 1000 for the voicemail beep, or a low one with amplitude well above
+// the SilenceThreshold to stand in for speech.
+func newTestTone(frequency float64, duration time.Duration) []int {
+	result := make([]int, int(duration.Seconds()*answeringMachineTestSampleRate))
+	for i := range result {
+		result[i] = int(8000 * math.Sin(2*math.Pi*frequency*float64(i)/answeringMachineTestSampleRate))
+	}
+	return result
+}
+
+func newTestSilence(duration time.Duration) []int {
+	return make([]int, int(duration.Seconds()*answeringMachineTestSampleRate))
+}
+
+// newTestWords alternates word-long voice and pause-long silence, like the cadence of a greeting.
+func newTestWords(numWords int, word time.Duration, pause time.Duration) []int {
+	var result []int
+	for i := 0; i < numWords; i++ {
+		result = append(result, newTestTone(200, word)...)
+		result = append(result, newTestSilence(pause)...)
+	}
+	return result
+}
+
+func concatSamples(parts ...[]int) []int {
+	var result []int
+	for _, part := range parts {
+		result = append(result, part...)
+	}
+	return result
+}
+
+// processInChunks feeds odd sized chunks, as the frames do not align with the 20ms of the detector.
+func processInChunks(detector *AnsweringMachineDetector, samples []int) []AnsweringMachineEvent {
+	var result []AnsweringMachineEvent
+	for len(samples) > 0 {
+		chunkSize := min(333, len(samples))
+		result = append(result, detector.Process(samples[:chunkSize])...)
+		samples = samples[chunkSize:]
+	}
+	return result
+}
+
+func TestAnsweringMachineDetectorCadence(t *testing.T) {
+	tests := []struct {
+		name     string
+		samples  []int
+		expected AnsweringMachineEventKind
+		reason   string
+		at       time.Duration
+	}{
+		{
+			name:     "short hello followed by silence",
+			samples:  concatSamples(newTestSilence(500*time.Millisecond), newTestTone(200, 400*time.Millisecond), newTestSilence(2*time.Second)),
+			expected: AnsweringMachineHuman,
+			reason:   "short greeting followed by silence",
+			at:       500*time.Millisecond + 400*time.Millisecond + DefaultAnsweringMachineConfig.AfterGreetingSilence,
+		},
+		{
+			name:     "long greeting",
+			samples:  concatSamples(newTestSilence(300*time.Millisecond), newTestTone(200, 3*time.Second)),
+			expected: AnsweringMachineMachine,
+			reason:   "greeting too long",
+			at:       300*time.Millisecond + DefaultAnsweringMachineConfig.Greeting,
+		},
+		{
+			// "Hi, you have reached ..."
+			name:     "many words",
+			samples:  newTestWords(5, 200*time.Millisecond, 100*time.Millisecond),
+			expected: AnsweringMachineMachine,
+			reason:   "too many words",
+			at:       2*300*time.Millisecond + DefaultAnsweringMachineConfig.MinWordLength,
+		},
+		{
+			name:     "initial silence",
+			samples:  newTestSilence(3 * time.Second),
+			expected: AnsweringMachineMachine,
+			reason:   "initial silence too long",
+			at:       DefaultAnsweringMachineConfig.InitialSilence,
+		},
+		{
+			// Clicks and noise bursts too short to be words, and too frequent for the initial silence.
+			name:     "timeout",
+			samples:  newTestWords(100, 60*time.Millisecond, 60*time.Millisecond),
+			expected: AnsweringMachineNotSure,
+			reason:   "total analysis time reached",
+			at:       DefaultAnsweringMachineConfig.TotalAnalysisTime,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			detector := NewAnsweringMachineDetector(answeringMachineTestSampleRate, DefaultAnsweringMachineConfig)
+			events := processInChunks(detector, test.samples)
+			if len(events) != 1 {
+				t.Fatalf("expected a single event, got %+v", events)
+			}
+			event := events[0]
+			if event.Kind != test.expected || event.Reason != test.reason || event.At != test.at {
+				t.Errorf("expected %s (%s) at %s, got %s (%s) at %s", test.expected, test.reason, test.at, event.Kind, event.Reason, event.At)
+			}
+			if isDone := test.expected != AnsweringMachineMachine; detector.IsDone() != isDone {
+				t.Errorf("expected done %v after %s", isDone, event.Kind)
+			}
+		})
+	}
+}
+
+func TestAnsweringMachineDetectorBeep(t *testing.T) {
+	greeting := concatSamples(newTestTone(200, 2*time.Second), newTestSilence(500*time.Millisecond))
+	// The verdict is at the end of the Greeting, the rest is the beep detection.
+	beepStart := 2*time.Second + 500*time.Millisecond
+	// A dual tone spreads the energy across two frequencies, so it is not a beep.
+	dualTone := newTestTone(700, 400*time.Millisecond)
+	for i, sample := range newTestTone(1400, 400*time.Millisecond) {
+		dualTone[i] = (dualTone[i] + sample) / 2
+	}
+	tests := []struct {
+		name    string
+		beep    []int
+		isBeep  bool
+		beepEnd time.Duration
+	}{
+		{"1kHz beep", newTestTone(1000, 400*time.Millisecond), true, 400 * time.Millisecond},
+		{"beep between the search steps", newTestTone(1012, 400*time.Millisecond), true, 400 * time.Millisecond},
+		{"440Hz beep", newTestTone(440, 240*time.Millisecond), true, 240 * time.Millisecond},
+		{"too short", newTestTone(1000, 60*time.Millisecond), false, 0},
+		{"above the beep frequencies", newTestTone(3000, 400*time.Millisecond), false, 0},
+		// Speech is not a single tone.
+		{"speech", newTestWords(3, 300*time.Millisecond, 20*time.Millisecond), false, 0},
+		{"dual tone", dualTone, false, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			detector := NewAnsweringMachineDetector(answeringMachineTestSampleRate, DefaultAnsweringMachineConfig)
+			events := processInChunks(detector, concatSamples(greeting, test.beep, newTestSilence(time.Second)))
+			if len(events) == 0 || events[0].Kind != AnsweringMachineMachine {
+				t.Fatalf("expected the machine verdict first, got %+v", events)
+			}
+			if !test.isBeep {
+				if len(events) != 1 || detector.IsDone() {
+					t.Errorf("expected no beep, got %+v", events)
+				}
+				return
+			}
+			if len(events) != 2 || events[1].Kind != AnsweringMachineBeep {
+				t.Fatalf("expected the beep, got %+v", events)
+			}
+			// Reported with the first frame after the tone, so the message starts right after it.
+			if expected := beepStart + test.beepEnd + answeringMachineFrameDuration; events[1].At != expected {
+				t.Errorf("expected the beep at %s, got %s", expected, events[1].At)
+			}
+			if !detector.IsDone() {
+				t.Error("expected done after the beep")
+			}
+		})
+	}
+}
@@ -3,6 +3,8 @@
 // * OpenAI TTS produces mp3, flac, opus with 24,000 sample rate
 // * OpenAI Whisper takes wav
 // * Twilio Telephony requires mulaw encoded 8bit with 8khz sample rate
+// * Vonage Telephony uses linear16, i.e. raw 16bit little-endian with 16khz sample rate
+// * SIP trunks use either mulaw (PCMU) or alaw (PCMA) with 8khz sample rate
 // Usage:
 // 1.) Convert your format to audio.IntBuffer
 // 2.) Convert audio.IntBuffer to your desired format
@@ -67,6 +69,70 @@ func EncodeToMulaw(intBuffer *audio.IntBuffer, outputSampleRate int) ([]byte, er
 	return outputBytes, nil
 }
 
+// DecodeFromAlaw is DecodeFromMulaw for A-law, i.e. one channel and one byte per value.
+func DecodeFromAlaw(byteData []byte, inputSampleRate int) *audio.IntBuffer {
+	intData := make([]int, len(byteData))
+	for i, b := range byteData {
+		intData[i] = int(aLawToInt16(b))
+	}
+
+	return &audio.IntBuffer{
+		Data: intData,
+		Format: &audio.Format{
+			SampleRate:  inputSampleRate,
+			NumChannels: 1,
+		},
+		SourceBitDepth: 16,
+	}
+}
+
+// EncodeToAlaw is the inverse of DecodeFromAlaw, assumes the intBuffer is mono.
+func EncodeToAlaw(intBuffer *audio.IntBuffer, outputSampleRate int) []byte {
+	intData := intBuffer.Data
+	if intBuffer.Format.SampleRate != outputSampleRate {
+		log.Debug().Int("input_sample_rate", intBuffer.Format.SampleRate).Int("output_sample_rate", outputSampleRate).Msg("gonna resample alaw intData")
+		intData = ResampleSimple(intData, intBuffer.Format.SampleRate, outputSampleRate)
+	}
+
+	outputBytes := make([]byte, len(intData))
+	for i, intVal := range intData {
+		outputBytes[i] = int16ToALaw(int16(intVal))
+	}
+	return outputBytes
+}
+
+// DecodeFromLinear16 assumes one channel of signed 16bit little-endian samples without any header.
+func DecodeFromLinear16(byteData []byte, inputSampleRate int) *audio.IntBuffer {
+	intData := make([]int, len(byteData)/2)
+	for i := range intData {
+		intData[i] = int(int16(binary.LittleEndian.Uint16(byteData[2*i : 2*i+2])))
+	}
+
+	return &audio.IntBuffer{
+		Data: intData,
+		Format: &audio.Format{
+			SampleRate:  inputSampleRate,
+			NumChannels: 1,
+		},
+		SourceBitDepth: 16,
+	}
+}
+
+// EncodeToLinear16 is the inverse of DecodeFromLinear16, assumes the intBuffer is mono.
+func EncodeToLinear16(intBuffer *audio.IntBuffer, outputSampleRate int) []byte {
+	intData := intBuffer.Data
+	if intBuffer.Format.SampleRate != outputSampleRate {
+		log.Debug().Int("input_sample_rate", intBuffer.Format.SampleRate).Int("output_sample_rate", outputSampleRate).Msg("gonna resample linear16 intData")
+		intData = ResampleSimple(intData, intBuffer.Format.SampleRate, outputSampleRate)
+	}
+
+	outputBytes := make([]byte, 2*len(intData))
+	for i, intVal := range intData {
+		binary.LittleEndian.PutUint16(outputBytes[2*i:], uint16(int16(intVal)))
+	}
+	return outputBytes
+}
+
 // EncodeToWavSimple is like EncodeToWav, but just uses the same sample formats as in the input.
 func EncodeToWavSimple(inputBuffer *audio.IntBuffer) (result []byte, err error) {
 	// sampleRate := inputBuffer.Format.SampleRate
@@ -183,6 +249,29 @@ func DecodeFromFlac(rawFlacBytes []byte) (*audio.IntBuffer, error) {
 	}, nil
 }
 
+// DecodeFromWav reads a PCM .wav, stereo is mixed down to mono as elsewhere in vocode-golang.
+func DecodeFromWav(rawWavBytes []byte) (*audio.IntBuffer, error) {
+	decoder := wav.NewDecoder(bytes.NewReader(rawWavBytes))
+	if !decoder.IsValidFile() {
+		return nil, fmt.Errorf("not a valid wav file")
+	}
+	intBuffer, err := decoder.FullPCMBuffer()
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode wav: %w", err)
+	}
+	if intBuffer.SourceBitDepth != 16 {
+		return nil, fmt.Errorf("unsupported wav bit depth %d, only 16 is", intBuffer.SourceBitDepth)
+	}
+	if intBuffer.Format.NumChannels == 2 {
+		intBuffer.Data = StereoToMono(intBuffer.Data)
+		intBuffer.Format.NumChannels = 1
+	}
+	if intBuffer.Format.NumChannels != 1 {
+		return nil, fmt.Errorf("unsupported number of wav channels %d", intBuffer.Format.NumChannels)
+	}
+	return intBuffer, nil
+}
+
 func DecodeFromMp3(rawAudioBytes []byte) (*audio.IntBuffer, error) {
 	decodedMp3, err := mp3.NewDecoder(bytes.NewReader(rawAudioBytes))
 	if err != nil && !errors.Is(err, io.EOF) {
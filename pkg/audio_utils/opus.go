@@ -0,0 +1,104 @@
+//go:build opus
+
+package audio_utils
+
+/*
+#cgo pkg-config: opus
+#include <opus.h>
+*/
+import "C"
+
+import (
+	"fmt"
+	"math"
+	"unsafe"
+)
+
+// IsOpusSupported is true when built with the opus tag, i.e. with cgo libopus (apt install libopus-dev).
+const IsOpusSupported = true
+
+// opusMaxPacketSize is the recommended max_data_bytes of opus_encode, way more than a 20ms frame needs.
+const opusMaxPacketSize = 4000
+
+// OpusEncoder encodes mono frames of 2.5, 5, 10, 20, 40 or 60 ms, it is NOT safe for concurrent use.
+type OpusEncoder struct {
+	// state is Go memory so it is garbage collected, libopus only needs it for the duration of each call.
+	state []byte
+}
+
+// NewOpusEncoder sampleRate is one of 8000, 12000, 16000, 24000 or 48000, tuned for voice.
+func NewOpusEncoder(sampleRate int) (*OpusEncoder, error) {
+	result := &OpusEncoder{state: make([]byte, C.opus_encoder_get_size(1))}
+	errno := C.opus_encoder_init(result.get(), C.opus_int32(sampleRate), 1, C.OPUS_APPLICATION_VOIP)
+	if errno != C.OPUS_OK {
+		return nil, fmt.Errorf("cannot init opus encoder: %s", C.GoString(C.opus_strerror(errno)))
+	}
+	return result, nil
+}
+
+func (e *OpusEncoder) get() *C.OpusEncoder {
+	return (*C.OpusEncoder)(unsafe.Pointer(&e.state[0]))
+}
+
+// Encode returns one Opus packet of the samples, which have to be exactly one frame long.
+func (e *OpusEncoder) Encode(samples []int) ([]byte, error) {
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("cannot encode an empty opus frame")
+	}
+	pcm := make([]C.opus_int16, len(samples))
+	for i, sample := range samples {
+		pcm[i] = C.opus_int16(clampToInt16(sample))
+	}
+	packet := make([]byte, opusMaxPacketSize)
+	size := C.opus_encode(e.get(), &pcm[0], C.int(len(pcm)), (*C.uchar)(unsafe.Pointer(&packet[0])), C.opus_int32(len(packet)))
+	if size < 0 {
+		return nil, fmt.Errorf("cannot encode opus frame: %s", C.GoString(C.opus_strerror(size)))
+	}
+	return packet[:size], nil
+}
+
+// OpusDecoder decodes into mono samples, it is NOT safe for concurrent use.
+type OpusDecoder struct {
+	state      []byte
+	sampleRate int
+}
+
+// NewOpusDecoder sampleRate is the one of the decoded samples, whatever the encoder had.
+func NewOpusDecoder(sampleRate int) (*OpusDecoder, error) {
+	result := &OpusDecoder{state: make([]byte, C.opus_decoder_get_size(1)), sampleRate: sampleRate}
+	errno := C.opus_decoder_init(result.get(), C.opus_int32(sampleRate), 1)
+	if errno != C.OPUS_OK {
+		return nil, fmt.Errorf("cannot init opus decoder: %s", C.GoString(C.opus_strerror(errno)))
+	}
+	return result, nil
+}
+
+func (d *OpusDecoder) get() *C.OpusDecoder {
+	return (*C.OpusDecoder)(unsafe.Pointer(&d.state[0]))
+}
+
+// Decode returns the samples of one Opus packet, a nil packet conceals a lost one of frameSize samples.
+func (d *OpusDecoder) Decode(packet []byte, frameSize int) ([]int, error) {
+	// An Opus packet is at most 120ms.
+	pcm := make([]C.opus_int16, d.sampleRate*120/1000)
+	var data *C.uchar
+	if len(packet) > 0 {
+		data = (*C.uchar)(unsafe.Pointer(&packet[0]))
+	} else {
+		pcm = pcm[:frameSize]
+	}
+	size := C.opus_decode(d.get(), data, C.opus_int32(len(packet)), &pcm[0], C.int(len(pcm)), 0)
+	if size < 0 {
+		return nil, fmt.Errorf("cannot decode opus packet: %s", C.GoString(C.opus_strerror(size)))
+	}
+
+	result := make([]int, size)
+	for i := range result {
+		result[i] = int(pcm[i])
+	}
+	return result, nil
+}
+
+func clampToInt16(sample int) int16 {
+	return int16(max(min(sample, math.MaxInt16), math.MinInt16))
+}
@@ -0,0 +1,32 @@
+//go:build !opus
+
+package audio_utils
+
+import "fmt"
+
+// IsOpusSupported is false unless built with the opus tag, as there is no pure Go Opus encoder.
+const IsOpusSupported = false
+
+var errOpusNotSupported = fmt.Errorf("opus is not supported, build with -tags opus")
+
+// OpusEncoder see opus.go, this one always fails.
+type OpusEncoder struct{}
+
+func NewOpusEncoder(sampleRate int) (*OpusEncoder, error) {
+	return nil, errOpusNotSupported
+}
+
+func (e *OpusEncoder) Encode(samples []int) ([]byte, error) {
+	return nil, errOpusNotSupported
+}
+
+// OpusDecoder see opus.go, this one always fails.
+type OpusDecoder struct{}
+
+func NewOpusDecoder(sampleRate int) (*OpusDecoder, error) {
+	return nil, errOpusNotSupported
+}
+
+func (d *OpusDecoder) Decode(packet []byte, frameSize int) ([]int, error) {
+	return nil, errOpusNotSupported
+}
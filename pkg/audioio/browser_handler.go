@@ -0,0 +1,346 @@
+package audioio
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-audio/audio"
+	"github.com/gorilla/websocket"
+	"github.com/petrzlen/vocode-golang/internal/networking"
+	"github.com/petrzlen/vocode-golang/pkg/audio_utils"
+	"github.com/petrzlen/vocode-golang/pkg/models"
+	"github.com/rs/zerolog/log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// BrowserMaxFrameDuration caps the outbound binary frames, so long TTS chunks stay well below the websocket limits.
+const BrowserMaxFrameDuration = time.Second
+
+// browserSilenceAmplitude below which a linear16 sample counts as silence,
+// the page asks for echo cancellation and noise suppression so the mic noise stays low.
+const browserSilenceAmplitude = 200
+
+// BrowserStartHandler is the MediaStreamStartHandler of the web page, called on its "start" message.
+type BrowserStartHandler func(device DuplexDevice, start BrowserStartMessage) error
+
+// browserHandler is the vonageHandler for our own web page (cmd/web), also binary linear16 frames,
+// but the page schedules the playback itself, so the audio is sent right away instead of in real time.
+// It implements TranscriptListener, so the page can show the conversation as it goes.
+type browserHandler struct {
+	// Browser Protocol
+	start      *BrowserStartMessage // To keep the initial config
+	callId     string
+	startTime  time.Time
+	onStart    BrowserStartHandler
+	isAccepted bool // Only true once onStart succeeded, audio before that is dropped.
+	readChan   chan networking.WebsocketMessage
+	writeChan  chan networking.WebsocketMessage
+	// writeMutex guards isStopped, so nothing is sent after writeChan is closed. Also guards onShutdown.
+	writeMutex sync.Mutex
+	isStopped  bool
+	onShutdown func()
+
+	// playMutex guards playEndTime, when the page finishes playing all the audio sent so far.
+	playMutex   sync.Mutex
+	playEndTime time.Time
+
+	// Both sides of the call for QA, saved into recordingSink when the call ends.
+	recorder      *callRecorder
+	recordingSink RecordingSink
+
+	// Package interface
+	recordingChan chan models.AudioData
+	chunker       *speechChunker
+}
+
+// NewBrowserHandler onStart can be nil, in which case all pages are accepted.
+func NewBrowserHandler(onStart BrowserStartHandler) *browserHandler {
+	result := &browserHandler{
+		// Browser Protocol
+		start:      nil,
+		callId:     "",
+		onStart:    onStart,
+		isAccepted: false,
+		readChan:   make(chan networking.WebsocketMessage, 100),
+		writeChan:  make(chan networking.WebsocketMessage, 100),
+		isStopped:  false,
+		onShutdown: nil,
+
+		playEndTime: time.Time{},
+
+		recorder:      nil,
+		recordingSink: NewLocalDirRecordingSink("output"),
+
+		// Package interface
+		recordingChan: nil,
+		chunker:       nil,
+	}
+	go result.readMessagesUntilChanClosed()
+	return result
+}
+
+// GetReader implements networking.TypedWebsocketMessageHandler.GetReader
+func (bh *browserHandler) GetReader() chan<- networking.WebsocketMessage {
+	return bh.readChan
+}
+
+// GetWriter implements networking.TypedWebsocketMessageHandler.GetWriter
+func (bh *browserHandler) GetWriter() <-chan networking.WebsocketMessage {
+	return bh.writeChan
+}
+
+// StartRecording implements InputDevice.StartRecording
+func (bh *browserHandler) StartRecording(recordingChan chan models.AudioData) error {
+	bh.recordingChan = recordingChan
+	return nil
+}
+
+// SetRecordingSink sets where the stereo call recording is saved once the call ends, nil disables it.
+func (bh *browserHandler) SetRecordingSink(sink RecordingSink) {
+	bh.recordingSink = sink
+}
+
+// SetSilenceThresholds implements SilenceThresholdSetter.SetSilenceThresholds
+// Only called from onStart, i.e. after the chunker was created for the page sample rate.
+func (bh *browserHandler) SetSilenceThresholds(speech time.Duration, silence time.Duration) {
+	bh.chunker.SetSilenceThresholds(speech, silence)
+}
+
+// StreamAudioFrames implements AudioFrameStreamer.StreamAudioFrames
+// Only called from onStart, i.e. after the chunker was created for the page sample rate.
+func (bh *browserHandler) StreamAudioFrames() {
+	bh.chunker.StreamAudioFrames()
+}
+
+// OnShutdown implements ShutdownNotifier.OnShutdown
+func (bh *browserHandler) OnShutdown(callback func()) {
+	bh.writeMutex.Lock()
+	defer bh.writeMutex.Unlock()
+	bh.onShutdown = callback
+}
+
+// Shutdown implements networking.ShutdownAwareHandler.Shutdown
+func (bh *browserHandler) Shutdown() {
+	bh.writeMutex.Lock()
+	onShutdown := bh.onShutdown
+	bh.writeMutex.Unlock()
+
+	if onShutdown == nil {
+		errLog(bh.Stop(), "browserHandler.Stop on shutdown")
+		return
+	}
+	log.Info().Str("call_id", bh.callId).Msg("browserHandler wrapping up the call on shutdown")
+	onShutdown()
+}
+
+// OnTranscript implements TranscriptListener.OnTranscript
+func (bh *browserHandler) OnTranscript(role string, text string) {
+	bh.sendEvent(BrowserEvent{Event: "transcript", Role: role, Text: text})
+}
+
+// Hangup implements CallController.Hangup
+// The page is told right away so it stops sending audio, but the websocket stays open until it played everything.
+func (bh *browserHandler) Hangup() error {
+	bh.sendEvent(BrowserEvent{Event: "hangup"})
+
+	bh.playMutex.Lock()
+	untilPlayed := max(0, time.Until(bh.playEndTime))
+	bh.playMutex.Unlock()
+	log.Info().Str("call_id", bh.callId).Dur("until_played", untilPlayed).Msg("browserHandler hanging up once played")
+	time.Sleep(untilPlayed)
+	return bh.Stop()
+}
+
+// Transfer implements CallController.Transfer
+func (bh *browserHandler) Transfer(target string) error {
+	return fmt.Errorf("cannot transfer a browser call to %s", target)
+}
+
+// SendDigits implements CallController.SendDigits
+func (bh *browserHandler) SendDigits(digits string) error {
+	return fmt.Errorf("cannot send digits %s to a browser call", digits)
+}
+
+func (bh *browserHandler) StopRecording() ([]byte, error) {
+	err := bh.Stop()
+
+	return nil, err
+}
+
+func (bh *browserHandler) Stop() error {
+	bh.writeMutex.Lock()
+	defer bh.writeMutex.Unlock()
+	if bh.isStopped {
+		log.Debug().Str("call_id", bh.callId).Msg("browserHandler already stopped")
+		return nil
+	}
+	bh.isStopped = true
+	log.Info().Str("call_id", bh.callId).Msg("writeChan close")
+
+	// Same as for vonageHandler, this closes the websocket, then the readChan and then the recordingChan.
+	close(bh.writeChan)
+
+	return nil
+}
+
+// Play implements OutputDevice.Play
+func (bh *browserHandler) Play(intBuffer *audio.IntBuffer) (*sync.WaitGroup, error) {
+	if intBuffer.Format == nil || intBuffer.Format.NumChannels != 1 {
+		return nil, fmt.Errorf("browserHandler can only play mono audio")
+	}
+	if bh.start == nil {
+		return nil, fmt.Errorf("browserHandler cannot play before the page started")
+	}
+	sampleRate := bh.start.SampleRate
+	linear16Bytes := audio_utils.EncodeToLinear16(intBuffer, sampleRate)
+	duration := time.Duration(len(linear16Bytes)/2) * time.Second / time.Duration(sampleRate)
+
+	bh.playMutex.Lock()
+	// The page was silent for a while, so this continues after a gap of silence.
+	if now := time.Now(); bh.playEndTime.Before(now) {
+		bh.playEndTime = now
+	}
+	if bh.recorder != nil {
+		bh.recorder.AddOutbound(bh.playEndTime.Sub(bh.startTime).Milliseconds(), audio_utils.DecodeFromLinear16(linear16Bytes, sampleRate).Data)
+	}
+	bh.playEndTime = bh.playEndTime.Add(duration)
+	bh.playMutex.Unlock()
+
+	maxFrameSize := 2 * int(BrowserMaxFrameDuration.Seconds()*float64(sampleRate))
+	for start := 0; start < len(linear16Bytes); start += maxFrameSize {
+		bh.sendMessage(networking.NewBinaryMessage(linear16Bytes[start:min(start+maxFrameSize, len(linear16Bytes))]))
+	}
+	return nil, nil
+}
+
+func (bh *browserHandler) handleStartMessage(msgBytes []byte) {
+	var start BrowserStartMessage
+	err := json.Unmarshal(msgBytes, &start)
+	if err == nil {
+		err = start.validate()
+	}
+	if err != nil {
+		log.Error().Err(err).Msgf("invalid first msg from browser websocket: %s", string(msgBytes))
+		errLog(bh.Stop(), "browserHandler.Stop after invalid start message")
+		return
+	}
+
+	bh.start = &start
+	bh.startTime = time.Now()
+	bh.callId = strconv.FormatInt(bh.startTime.UnixMilli(), 10)
+	bh.chunker = newSpeechChunker(start.SampleRate, "browser", func(sample int16) bool {
+		return -browserSilenceAmplitude < sample && sample < browserSilenceAmplitude
+	})
+	bh.recorder = newCallRecorder(start.SampleRate)
+	bh.chunker.onSpeech = bh.recorder.AddCallerTurn
+
+	if bh.onStart != nil {
+		if err := bh.onStart(bh, start); err != nil {
+			log.Warn().Err(err).Str("call_id", bh.callId).Msg("page rejected on start, closing")
+			errLog(bh.Stop(), "browserHandler.Stop after rejected start")
+			return
+		}
+	}
+	bh.isAccepted = true
+}
+
+func (bh *browserHandler) handleAudioMessage(linear16Bytes []byte) {
+	if !bh.isAccepted {
+		log.Trace().Msg("received audio before the page was accepted, ignoring")
+		return
+	}
+	if len(linear16Bytes)%2 != 0 {
+		log.Warn().Str("call_id", bh.callId).Int("byte_size", len(linear16Bytes)).Msg("received odd number of linear16 bytes, ignoring")
+		return
+	}
+
+	samples := audio_utils.DecodeFromLinear16(linear16Bytes, bh.start.SampleRate).Data
+	// The page sends the mic audio as it is captured, so its position on the timeline is the number of samples so far.
+	bh.recorder.AddInbound(int64(bh.chunker.Len()*1000/bh.start.SampleRate), samples)
+	for _, audioData := range bh.chunker.Add(samples) {
+		bh.recordingChan <- audioData
+	}
+}
+
+func (bh *browserHandler) handleEventMessage(msgBytes []byte) {
+	logMessage("received", msgBytes)
+	var event BrowserEvent
+	if err := json.Unmarshal(msgBytes, &event); err != nil {
+		log.Error().Err(err).Msgf("couldn't decode msg from browser websocket: %s", string(msgBytes))
+		return
+	}
+
+	switch event.Event {
+	case "stop":
+		log.Info().Str("call_id", bh.callId).Msg("the user ended the browser call")
+		errLog(bh.Stop(), "browserHandler.Stop on stop event")
+	default:
+		log.Debug().Str("call_id", bh.callId).Msgf("ignoring browser event %s", event.Event)
+	}
+}
+
+func (bh *browserHandler) sendEvent(event BrowserEvent) {
+	msgBytes, err := json.Marshal(event)
+	if err != nil {
+		errLog(err, "json.Marshal browser event")
+		return
+	}
+	logMessage("sending", msgBytes)
+	bh.sendMessage(networking.NewTextMessage(msgBytes))
+}
+
+func (bh *browserHandler) sendMessage(msg networking.WebsocketMessage) {
+	bh.writeMutex.Lock()
+	defer bh.writeMutex.Unlock()
+	if bh.isStopped {
+		log.Trace().Str("call_id", bh.callId).Msg("cannot send message after browserHandler isStopped")
+		return
+	}
+	bh.writeChan <- msg
+}
+
+func (bh *browserHandler) readMessagesUntilChanClosed() {
+	for msg := range bh.readChan {
+		switch {
+		case msg.Type == websocket.BinaryMessage:
+			bh.handleAudioMessage(msg.Data)
+		case bh.start == nil:
+			logMessage("received", msg.Data)
+			bh.handleStartMessage(msg.Data)
+		default:
+			bh.handleEventMessage(msg.Data)
+		}
+	}
+
+	// After reading done, there is no more to produce, nor anyone to write to.
+	errLog(bh.Stop(), "browserHandler.Stop after readChan closed")
+	if bh.recordingChan != nil {
+		log.Info().Str("call_id", bh.callId).Msg("bh.recordingChan CLOSE")
+		close(bh.recordingChan)
+	}
+
+	bh.saveRecording()
+}
+
+// saveRecording stores the stereo recording of both sides of the call into the recordingSink.
+func (bh *browserHandler) saveRecording() {
+	if bh.recorder == nil || bh.recordingSink == nil {
+		log.Debug().Str("call_id", bh.callId).Msg("no call recording to save")
+		return
+	}
+
+	wavBytes, metadata, err := bh.recorder.EncodeStereoWav()
+	if err != nil {
+		errLog(err, "callRecorder.EncodeStereoWav")
+		return
+	}
+	if len(wavBytes) == 0 {
+		log.Info().Str("call_id", bh.callId).Msg("call recording is empty, not saving")
+		return
+	}
+	metadata.CallSid = bh.callId
+
+	log.Info().Str("call_id", bh.callId).Msgf("websocket finished, gonna save %d bytes of call recording", len(wavBytes))
+	errLog(bh.recordingSink.Save("call-browser-"+bh.callId, wavBytes, metadata), "recordingSink.Save")
+}
@@ -0,0 +1,34 @@
+package audioio
+
+import "fmt"
+
+// BrowserStartMessage is the first (text) message on the browser websocket, all the other inbound messages
+// are binary linear16 frames at SampleRate. The outbound audio is sent in the same format.
+type BrowserStartMessage struct {
+	// Event is "start"
+	Event      string `json:"event"`
+	SampleRate int    `json:"sampleRate"`
+	// Parameters are the Twilio <Parameter>-s counterpart, e.g. agent_profile_id picked on the page.
+	Parameters map[string]string `json:"parameters,omitempty"`
+}
+
+// BrowserEvent is any text message in either direction after "start":
+// * "transcript" (outbound) with Role and Text, a piece of the conversation to show on the page,
+// * "hangup" (outbound) the agent ended the call, the page should finish playing what it got,
+// * "stop" (inbound) the user ended the call.
+type BrowserEvent struct {
+	Event string `json:"event"`
+	Role  string `json:"role,omitempty"`
+	Text  string `json:"text,omitempty"`
+}
+
+// validate caps the sample rate at 48000, browsers can go higher but that is a waste for speech.
+func (m BrowserStartMessage) validate() error {
+	if m.Event != "start" {
+		return fmt.Errorf("unexpected first browser event %q", m.Event)
+	}
+	if m.SampleRate < 8000 || m.SampleRate > 48000 {
+		return fmt.Errorf("unsupported browser sample rate %d", m.SampleRate)
+	}
+	return nil
+}
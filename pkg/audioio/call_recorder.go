@@ -0,0 +1,203 @@
+package audioio
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/go-audio/audio"
+	"github.com/petrzlen/vocode-golang/pkg/audio_utils"
+	"github.com/rs/zerolog/log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	RecordingSpeakerCaller = "caller"
+	RecordingSpeakerBot    = "bot"
+)
+
+// RecordingTurn is a time range when one of the speakers was talking, relative to the start of the recording.
+type RecordingTurn struct {
+	Speaker string `json:"speaker"`
+	StartMs int64  `json:"start_ms"`
+	EndMs   int64  `json:"end_ms"`
+}
+
+// RecordingMetadata is stored next to the recording, so QA can find the call and jump to the turns.
+type RecordingMetadata struct {
+	StreamSid  string          `json:"stream_sid"`
+	CallSid    string          `json:"call_sid"`
+	StartedAt  time.Time       `json:"started_at"`
+	DurationMs int64           `json:"duration_ms"`
+	SampleRate int             `json:"sample_rate"`
+	Channels   []string        `json:"channels"`
+	Turns      []RecordingTurn `json:"turns"`
+}
+
+// RecordingSink stores finished call recordings, e.g. on the local disk or in a bucket.
+type RecordingSink interface {
+	Save(name string, wavBytes []byte, metadata RecordingMetadata) error
+}
+
+type localDirRecordingSink struct {
+	dir string
+}
+
+// NewLocalDirRecordingSink writes <dir>/<name>.wav together with <dir>/<name>.json for the metadata.
+func NewLocalDirRecordingSink(dir string) RecordingSink {
+	return &localDirRecordingSink{dir: dir}
+}
+
+// Save implements RecordingSink.Save
+// The name must be a plain file name, so a recording never ends up outside of dir.
+func (s *localDirRecordingSink) Save(name string, wavBytes []byte, metadata RecordingMetadata) error {
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.Contains(name, "..") {
+		return fmt.Errorf("invalid recording name %q", name)
+	}
+	metadataBytes, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return fmt.Errorf("cannot marshal recording metadata: %w", err)
+	}
+
+	wavFilename := filepath.Join(s.dir, name+".wav")
+	if err = os.WriteFile(wavFilename, wavBytes, 0644); err != nil {
+		return fmt.Errorf("cannot write recording %s: %w", wavFilename, err)
+	}
+	metadataFilename := filepath.Join(s.dir, name+".json")
+	if err = os.WriteFile(metadataFilename, metadataBytes, 0644); err != nil {
+		return fmt.Errorf("cannot write recording metadata %s: %w", metadataFilename, err)
+	}
+	log.Info().Str("filename", wavFilename).Int("byte_size", len(wavBytes)).Int("num_turns", len(metadata.Turns)).Msg("call recording saved")
+	return nil
+}
+
+// newRecordingName is unique for each call, and never made of what the other party sent (e.g. a SIP Call-ID),
+// the ids of the call are in the RecordingMetadata instead.
+func newRecordingName(kind string) string {
+	randomBytes := make([]byte, 8)
+	_, _ = rand.Read(randomBytes)
+	return fmt.Sprintf("call-%s-%s", kind, hex.EncodeToString(randomBytes))
+}
+
+// callRecorder keeps both sides of a call on a shared timeline (in samples since the start of the call),
+// so the stereo output also shows when the caller and the bot talked over each other.
+// Samples are kept as int16 to keep memory reasonable for long calls.
+type callRecorder struct {
+	mutex      sync.Mutex
+	sampleRate int
+	startedAt  time.Time
+	inbound    []int16 // left channel
+	outbound   []int16 // right channel
+	turns      []RecordingTurn
+}
+
+func newCallRecorder(sampleRate int) *callRecorder {
+	return &callRecorder{
+		sampleRate: sampleRate,
+		startedAt:  time.Now(),
+		inbound:    make([]int16, 0),
+		outbound:   make([]int16, 0),
+		turns:      make([]RecordingTurn, 0),
+	}
+}
+
+func (r *callRecorder) msToOffset(ms int64) int {
+	return int(ms * int64(r.sampleRate) / 1000)
+}
+
+func (r *callRecorder) offsetToMs(offset int) int64 {
+	return int64(offset) * 1000 / int64(r.sampleRate)
+}
+
+// writeAt puts samples into track at offset, growing it with silence if needed.
+// Overlapping samples are overwritten, as a track only has one speaker.
+func writeAt(track []int16, offset int, samples []int) []int16 {
+	if end := offset + len(samples); end > len(track) {
+		track = append(track, make([]int16, end-len(track))...)
+	}
+	for i, sample := range samples {
+		track[offset+i] = int16(sample)
+	}
+	return track
+}
+
+// AddInbound records what the caller said at atMs since the start of the call.
+func (r *callRecorder) AddInbound(atMs int64, samples []int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.inbound = writeAt(r.inbound, r.msToOffset(atMs), samples)
+}
+
+// AddOutbound records what the bot played at atMs since the start of the call, and marks it as a bot turn.
+func (r *callRecorder) AddOutbound(atMs int64, samples []int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	offset := r.msToOffset(atMs)
+	r.outbound = writeAt(r.outbound, offset, samples)
+	r.addTurnLocked(RecordingSpeakerBot, atMs, r.offsetToMs(offset+len(samples)))
+}
+
+// AddCallerTurn marks the caller talking between the inbound sample offsets.
+func (r *callRecorder) AddCallerTurn(startOffset int, endOffset int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.addTurnLocked(RecordingSpeakerCaller, r.offsetToMs(startOffset), r.offsetToMs(endOffset))
+}
+
+// addTurnLocked merges the turn with the last one if it is the same speaker continuing right away.
+func (r *callRecorder) addTurnLocked(speaker string, startMs int64, endMs int64) {
+	for i := len(r.turns) - 1; i >= 0; i-- {
+		last := &r.turns[i]
+		if last.Speaker != speaker {
+			continue
+		}
+		if startMs <= last.EndMs {
+			last.EndMs = max(last.EndMs, endMs)
+			return
+		}
+		break
+	}
+	r.turns = append(r.turns, RecordingTurn{Speaker: speaker, StartMs: startMs, EndMs: endMs})
+}
+
+// EncodeStereoWav returns the call as a 16bit stereo wav with the caller on the left and the bot on the right.
+func (r *callRecorder) EncodeStereoWav() ([]byte, RecordingMetadata, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	numFrames := max(len(r.inbound), len(r.outbound))
+	interleaved := make([]int, 2*numFrames)
+	for i := 0; i < numFrames; i++ {
+		if i < len(r.inbound) {
+			interleaved[2*i] = int(r.inbound[i])
+		}
+		if i < len(r.outbound) {
+			interleaved[2*i+1] = int(r.outbound[i])
+		}
+	}
+
+	metadata := RecordingMetadata{
+		StartedAt:  r.startedAt,
+		DurationMs: r.offsetToMs(numFrames),
+		SampleRate: r.sampleRate,
+		Channels:   []string{RecordingSpeakerCaller, RecordingSpeakerBot},
+		Turns:      append([]RecordingTurn{}, r.turns...),
+	}
+
+	wavBytes, err := audio_utils.EncodeToWavSimple(&audio.IntBuffer{
+		Data: interleaved,
+		Format: &audio.Format{
+			SampleRate:  r.sampleRate,
+			NumChannels: 2,
+		},
+		SourceBitDepth: 16,
+	})
+	if err != nil {
+		return nil, metadata, fmt.Errorf("cannot encode stereo call recording: %w", err)
+	}
+	return wavBytes, metadata, nil
+}
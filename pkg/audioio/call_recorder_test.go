@@ -0,0 +1,36 @@
+package audioio
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalDirRecordingSinkRejectsNamesOutsideDir(t *testing.T) {
+	parentDir := t.TempDir()
+	dir := filepath.Join(parentDir, "recordings")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	sink := NewLocalDirRecordingSink(dir)
+
+	// E.g. a SIP Call-ID made up by the caller.
+	for _, name := range []string{"call-sip-../pwned", "../pwned", "call/pwned", `call\pwned`, ""} {
+		if err := sink.Save(name, []byte("RIFF"), RecordingMetadata{}); err == nil {
+			t.Errorf("expected the recording name %q rejected", name)
+		}
+	}
+	if entries, _ := os.ReadDir(parentDir); len(entries) != 1 {
+		t.Errorf("expected nothing written next to the recordings dir, got %d entries", len(entries))
+	}
+
+	name := newRecordingName("sip")
+	if err := sink.Save(name, []byte("RIFF"), RecordingMetadata{CallSid: "../../../tmp/pwned"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, filename := range []string{name + ".wav", name + ".json"} {
+		if _, err := os.Stat(filepath.Join(dir, filename)); err != nil {
+			t.Errorf("expected %s saved: %v", filename, err)
+		}
+	}
+}
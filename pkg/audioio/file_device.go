@@ -0,0 +1,294 @@
+package audioio
+
+import (
+	"fmt"
+	"github.com/go-audio/audio"
+	"github.com/petrzlen/vocode-golang/pkg/audio_utils"
+	"github.com/petrzlen/vocode-golang/pkg/models"
+	"github.com/rs/zerolog/log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileFrameDuration is how much of the file is fed to the chunker at once, same as a carrier media frame.
+const FileFrameDuration = 20 * time.Millisecond
+
+// closedTimeChan makes a select proceed right away, for playing the file as fast as possible.
+var closedTimeChan = func() <-chan time.Time {
+	result := make(chan time.Time)
+	close(result)
+	return result
+}()
+
+// fileSilenceAmplitude is higher than for the telephony handlers, as recordings tend to have some room noise.
+const fileSilenceAmplitude = 200
+
+// FileInputConfig tunes how a file is played into the pipeline.
+type FileInputConfig struct {
+	// Speed 1 plays the file in real time, 10 ten times faster, 0 as fast as possible.
+	// Keep it low enough for the transcriber to keep up, if the test cares about the agent replies.
+	Speed float64
+	// TrailingSilence is appended to the file, so the last sentence gets submitted on silence as on a live call,
+	// and the agent has time to reply before the recordingChan gets closed.
+	TrailingSilence time.Duration
+}
+
+// fileInput is an InputDevice playing an audio file as if it was said into a microphone,
+// so the pipeline can run end-to-end in CI or on a headless server.
+type fileInput struct {
+	filename string
+	config   FileInputConfig
+	samples  []int
+	// sampleRate of the file, the transcriber is fine with any.
+	sampleRate int
+	chunker    *speechChunker
+
+	// mutex guards isStopped, which ends the playRoutine early.
+	mutex         sync.Mutex
+	isStopped     bool
+	stoppedChan   chan struct{}
+	doneChan      chan struct{}
+	recordingChan chan models.AudioData
+}
+
+// NewFileInput decodes the whole filename upfront, based on its extension:
+// .wav, .mp3, or .ulaw / .mulaw for raw mu-law at 8kHz (as Twilio streams it).
+func NewFileInput(filename string, config FileInputConfig) (*fileInput, error) {
+	intBuffer, err := ReadAudioFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	sampleRate := intBuffer.Format.SampleRate
+	log.Info().Str("filename", filename).Int("sample_rate", sampleRate).Dur("duration", time.Duration(len(intBuffer.Data))*time.Second/time.Duration(sampleRate)).Msg("file input loaded")
+
+	return &fileInput{
+		filename:   filename,
+		config:     config,
+		samples:    intBuffer.Data,
+		sampleRate: sampleRate,
+		chunker: newSpeechChunker(sampleRate, "file", func(sample int16) bool {
+			return -fileSilenceAmplitude < sample && sample < fileSilenceAmplitude
+		}),
+		isStopped:     false,
+		stoppedChan:   make(chan struct{}),
+		doneChan:      make(chan struct{}),
+		recordingChan: nil,
+	}, nil
+}
+
+// ReadAudioFile decodes filename into mono samples, see NewFileInput for the supported formats.
+func ReadAudioFile(filename string) (*audio.IntBuffer, error) {
+	rawBytes, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read audio file %s: %w", filename, err)
+	}
+
+	var intBuffer *audio.IntBuffer
+	switch extension := strings.ToLower(filepath.Ext(filename)); extension {
+	case ".wav":
+		intBuffer, err = audio_utils.DecodeFromWav(rawBytes)
+	case ".mp3":
+		if intBuffer, err = audio_utils.DecodeFromMp3(rawBytes); err == nil {
+			// go-mp3 gives the 16bit samples as unsigned, this makes them signed for the chunker.
+			for i, sample := range intBuffer.Data {
+				intBuffer.Data[i] = int(int16(sample))
+			}
+		}
+	case ".ulaw", ".mulaw":
+		intBuffer = audio_utils.DecodeFromMulaw(rawBytes, MediaStreamSampleRate)
+	default:
+		return nil, fmt.Errorf("unsupported audio file extension %s", extension)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode audio file %s: %w", filename, err)
+	}
+	return intBuffer, nil
+}
+
+// StartRecording implements InputDevice.StartRecording
+// The file is played in the background, and recordingChan is closed once it is done.
+func (fi *fileInput) StartRecording(recordingChan chan models.AudioData) error {
+	fi.recordingChan = recordingChan
+	go fi.playRoutine()
+	return nil
+}
+
+// StopRecording implements InputDevice.StopRecording, it returns once the recordingChan got closed.
+func (fi *fileInput) StopRecording() ([]byte, error) {
+	fi.mutex.Lock()
+	if !fi.isStopped {
+		fi.isStopped = true
+		close(fi.stoppedChan)
+	}
+	fi.mutex.Unlock()
+
+	if fi.recordingChan != nil {
+		<-fi.doneChan
+	}
+	return nil, nil
+}
+
+// SetSilenceThresholds implements SilenceThresholdSetter.SetSilenceThresholds
+func (fi *fileInput) SetSilenceThresholds(speech time.Duration, silence time.Duration) {
+	fi.chunker.SetSilenceThresholds(speech, silence)
+}
+
+// StreamAudioFrames implements AudioFrameStreamer.StreamAudioFrames
+func (fi *fileInput) StreamAudioFrames() {
+	fi.chunker.StreamAudioFrames()
+}
+
+// Done is closed once the whole file (with the TrailingSilence) was played, or StopRecording was called.
+func (fi *fileInput) Done() <-chan struct{} {
+	return fi.doneChan
+}
+
+func (fi *fileInput) playRoutine() {
+	defer close(fi.doneChan)
+
+	samples := append(fi.samples[:len(fi.samples):len(fi.samples)], make([]int, int(fi.config.TrailingSilence.Seconds()*float64(fi.sampleRate)))...)
+	frameSize := fi.sampleRate * int(FileFrameDuration/time.Millisecond) / 1000
+	startTime := time.Now()
+	for start := 0; start < len(samples) && !fi.waitForFrame(startTime, start); start += frameSize {
+		fi.submit(fi.chunker.Add(samples[start:min(start+frameSize, len(samples))]))
+	}
+	// Whatever was said last gets submitted, even if the file ends mid-sentence.
+	fi.submit(fi.chunker.Flush())
+
+	log.Info().Str("filename", fi.filename).Dur("duration", time.Since(startTime)).Msg("fi.recordingChan CLOSE")
+	close(fi.recordingChan)
+}
+
+// waitForFrame sleeps until the frame at sample idx is due, and returns true if StopRecording was called meanwhile.
+func (fi *fileInput) waitForFrame(startTime time.Time, idx int) bool {
+	// Scheduled from startTime, so the sleep inaccuracies do not add up.
+	var frameDue <-chan time.Time
+	if fi.config.Speed > 0 {
+		frameAt := time.Duration(float64(idx) / float64(fi.sampleRate) / fi.config.Speed * float64(time.Second))
+		frameDue = time.After(time.Until(startTime.Add(frameAt)))
+	} else {
+		frameDue = closedTimeChan
+	}
+
+	select {
+	case <-fi.stoppedChan:
+		log.Info().Str("filename", fi.filename).Msg("file input stopped early")
+		return true
+	case <-frameDue:
+		return false
+	}
+}
+
+func (fi *fileInput) submit(audioDataList []models.AudioData) {
+	for _, audioData := range audioDataList {
+		fi.recordingChan <- audioData
+	}
+}
+
+// fileOutput is an OutputDevice writing everything played into a wav file, on the timeline of when it was played,
+// i.e. with the silence between the agent turns, so it can be listened to along with the fileInput.
+type fileOutput struct {
+	filename   string
+	sampleRate int
+	// speed should be the same as FileInputConfig.Speed, so both are on the same timeline.
+	speed     float64
+	startTime time.Time
+
+	// mutex guards everything below.
+	mutex     sync.Mutex
+	isStopped bool
+	samples   []int16
+	// playEndIdx is where the last Play ends, the next one cannot start before it.
+	playEndIdx int
+}
+
+// NewFileOutput writes filename on Stop, with the timeline starting now.
+func NewFileOutput(filename string, sampleRate int, speed float64) *fileOutput {
+	return &fileOutput{
+		filename:   filename,
+		sampleRate: sampleRate,
+		speed:      speed,
+		startTime:  time.Now(),
+		isStopped:  false,
+		samples:    make([]int16, 0),
+		playEndIdx: 0,
+	}
+}
+
+// Play implements OutputDevice.Play
+// The returned WaitGroup is done once intBuffer would have been played at speed, right away with speed 0.
+func (fo *fileOutput) Play(intBuffer *audio.IntBuffer) (*sync.WaitGroup, error) {
+	if intBuffer.Format == nil || intBuffer.Format.NumChannels != 1 {
+		return nil, fmt.Errorf("fileOutput can only play mono audio")
+	}
+	samples := make([]int, len(intBuffer.Data))
+	for i, sample := range intBuffer.Data {
+		samples[i] = int(int16(sample))
+	}
+	samples = audio_utils.ResampleSimple(samples, intBuffer.Format.SampleRate, fo.sampleRate)
+
+	fo.mutex.Lock()
+	defer fo.mutex.Unlock()
+	if fo.isStopped {
+		return nil, fmt.Errorf("fileOutput %s is already stopped", fo.filename)
+	}
+	startIdx := fo.playEndIdx
+	if fo.speed > 0 {
+		// There was a pause, e.g. while the agent was thinking.
+		startIdx = max(startIdx, fo.timeToIdx(time.Since(fo.startTime)))
+	}
+	fo.samples = writeAt(fo.samples, startIdx, samples)
+	fo.playEndIdx = startIdx + len(samples)
+
+	waitGroup := &sync.WaitGroup{}
+	if fo.speed > 0 {
+		waitGroup.Add(1)
+		time.AfterFunc(time.Until(fo.startTime.Add(fo.idxToTime(fo.playEndIdx))), waitGroup.Done)
+	}
+	return waitGroup, nil
+}
+
+// Stop implements OutputDevice.Stop by writing the wav file.
+func (fo *fileOutput) Stop() error {
+	fo.mutex.Lock()
+	defer fo.mutex.Unlock()
+	if fo.isStopped {
+		log.Debug().Str("filename", fo.filename).Msg("fileOutput already stopped")
+		return nil
+	}
+	fo.isStopped = true
+
+	intData := make([]int, len(fo.samples))
+	for i, sample := range fo.samples {
+		intData[i] = int(sample)
+	}
+	wavBytes, err := audio_utils.EncodeToWavSimple(&audio.IntBuffer{
+		Data:           intData,
+		Format:         &audio.Format{SampleRate: fo.sampleRate, NumChannels: 1},
+		SourceBitDepth: 16,
+	})
+	if err != nil {
+		return fmt.Errorf("cannot encode file output: %w", err)
+	}
+	if len(wavBytes) == 0 {
+		log.Info().Str("filename", fo.filename).Msg("nothing was played, not writing file output")
+		return nil
+	}
+	if err := os.WriteFile(fo.filename, wavBytes, 0644); err != nil {
+		return fmt.Errorf("cannot write file output %s: %w", fo.filename, err)
+	}
+	log.Info().Str("filename", fo.filename).Int("byte_size", len(wavBytes)).Msg("file output written")
+	return nil
+}
+
+// timeToIdx converts the wall clock duration to the sample index on the timeline, adjusted for speed.
+func (fo *fileOutput) timeToIdx(elapsed time.Duration) int {
+	return int(elapsed.Seconds() * fo.speed * float64(fo.sampleRate))
+}
+
+func (fo *fileOutput) idxToTime(idx int) time.Duration {
+	return time.Duration(float64(idx) / float64(fo.sampleRate) / fo.speed * float64(time.Second))
+}
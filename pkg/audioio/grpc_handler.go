@@ -0,0 +1,401 @@
+package audioio
+
+import (
+	"fmt"
+	"github.com/go-audio/audio"
+	"github.com/petrzlen/vocode-golang/pkg/audio_utils"
+	"github.com/petrzlen/vocode-golang/pkg/models"
+	"github.com/petrzlen/vocode-golang/pkg/voicepb"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// GrpcFrameDuration is the length of each outbound AudioFrame.
+const GrpcFrameDuration = 20 * time.Millisecond
+
+// GrpcStartHandler is the MediaStreamStartHandler of the gRPC API, called on the StartConversation message.
+type GrpcStartHandler func(device DuplexDevice, start *voicepb.StartConversation) error
+
+// grpcHandler is the vonageHandler for voicepb.VoiceService, with the audio and events as protobuf messages.
+// It implements TranscriptListener, so the client gets the transcript and the agent tokens as they come.
+type grpcHandler struct {
+	// Voice Protocol
+	start          *voicepb.StartConversation // To keep the initial config
+	conversationId string
+	startTime      time.Time
+	onStart        GrpcStartHandler
+	writeChan      chan *voicepb.ServerMessage
+	// writeMutex guards isStopped, so nothing is sent after writeChan is closed. Also guards onShutdown.
+	writeMutex  sync.Mutex
+	isStopped   bool
+	onShutdown  func()
+	stoppedChan chan struct{}
+
+	// The client plays whatever it gets right away, so the outbound frames wait in playQueue to be sent in real time,
+	// which also lets an Interrupt drop the rest.
+	playMutex sync.Mutex
+	playQueue [][]byte
+	// Timestamp (since startTime) of the end of playQueue, to put the outbound audio on the recording timeline.
+	playNextTimestamp time.Duration
+
+	// Both sides of the call for QA, saved into recordingSink when the call ends.
+	recorder      *callRecorder
+	recordingSink RecordingSink
+
+	// Package interface
+	recordingChan chan models.AudioData
+	chunker       *speechChunker
+}
+
+// NewGrpcHandler onStart can be nil, in which case all conversations are accepted.
+func NewGrpcHandler(onStart GrpcStartHandler) *grpcHandler {
+	return &grpcHandler{
+		// Voice Protocol
+		start:          nil,
+		conversationId: "",
+		onStart:        onStart,
+		writeChan:      make(chan *voicepb.ServerMessage, 100),
+		isStopped:      false,
+		onShutdown:     nil,
+		stoppedChan:    make(chan struct{}),
+
+		playQueue:         make([][]byte, 0),
+		playNextTimestamp: 0,
+
+		recorder:      nil,
+		recordingSink: NewLocalDirRecordingSink("output"),
+
+		// Package interface
+		recordingChan: nil,
+		chunker:       nil,
+	}
+}
+
+// Serve implements networking.GrpcConversationHandler.Serve
+// The calling goroutine is the only one sending into the stream, as gRPC requires.
+func (gh *grpcHandler) Serve(stream voicepb.VoiceService_ConverseServer) error {
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	if err := gh.handleStartMessage(first.GetStart()); err != nil {
+		return err
+	}
+	go gh.readMessagesUntilEnd(stream)
+	go gh.playQueueRoutine()
+
+	isSendFailed := false
+	for msg := range gh.writeChan {
+		if isSendFailed {
+			// The rest of writeChan is discarded, so its producers never block on a dead stream.
+			continue
+		}
+		if err := stream.Send(msg); err != nil {
+			log.Warn().Err(err).Str("conversation_id", gh.conversationId).Msg("cannot send to the grpc stream")
+			isSendFailed = true
+			// Not inline, as stop waits for writeMutex, which a producer can hold while blocked on a full writeChan,
+			// i.e. until this loop reads on.
+			go func() {
+				errLog(gh.stop("send_failed"), "grpcHandler.stop after failed send")
+			}()
+		}
+	}
+	log.Info().Str("conversation_id", gh.conversationId).Msg("grpc conversation finished")
+	return nil
+}
+
+// StartRecording implements InputDevice.StartRecording
+func (gh *grpcHandler) StartRecording(recordingChan chan models.AudioData) error {
+	gh.recordingChan = recordingChan
+	return nil
+}
+
+// SetRecordingSink sets where the stereo call recording is saved once the call ends, nil disables it.
+func (gh *grpcHandler) SetRecordingSink(sink RecordingSink) {
+	gh.recordingSink = sink
+}
+
+// SetSilenceThresholds implements SilenceThresholdSetter.SetSilenceThresholds
+// Only called from onStart, i.e. after the chunker was created for the client sample rate.
+func (gh *grpcHandler) SetSilenceThresholds(speech time.Duration, silence time.Duration) {
+	gh.chunker.SetSilenceThresholds(speech, silence)
+}
+
+// StreamAudioFrames implements AudioFrameStreamer.StreamAudioFrames
+// Only called from onStart, i.e. after the chunker was created for the client sample rate.
+func (gh *grpcHandler) StreamAudioFrames() {
+	gh.chunker.StreamAudioFrames()
+}
+
+// OnShutdown implements ShutdownNotifier.OnShutdown
+func (gh *grpcHandler) OnShutdown(callback func()) {
+	gh.writeMutex.Lock()
+	defer gh.writeMutex.Unlock()
+	gh.onShutdown = callback
+}
+
+// Shutdown implements networking.ShutdownAwareHandler.Shutdown
+func (gh *grpcHandler) Shutdown() {
+	gh.writeMutex.Lock()
+	onShutdown := gh.onShutdown
+	gh.writeMutex.Unlock()
+
+	if onShutdown == nil {
+		errLog(gh.stop("shutdown"), "grpcHandler.stop on shutdown")
+		return
+	}
+	log.Info().Str("conversation_id", gh.conversationId).Msg("grpcHandler wrapping up the conversation on shutdown")
+	onShutdown()
+}
+
+// OnTranscript implements TranscriptListener.OnTranscript
+func (gh *grpcHandler) OnTranscript(role string, text string) {
+	if role == "user" {
+		gh.sendMessage(&voicepb.ServerMessage{Message: &voicepb.ServerMessage_Transcript{Transcript: &voicepb.Transcript{Text: text}}})
+		return
+	}
+	gh.sendMessage(&voicepb.ServerMessage{Message: &voicepb.ServerMessage_AgentToken{AgentToken: &voicepb.AgentToken{Text: text}}})
+}
+
+// Hangup implements CallController.Hangup, once everything queued was sent to the client.
+func (gh *grpcHandler) Hangup() error {
+	go func() {
+		for {
+			gh.playMutex.Lock()
+			isPlaying := len(gh.playQueue) > 0
+			gh.playMutex.Unlock()
+			if !isPlaying {
+				errLog(gh.stop("agent_hangup"), "grpcHandler.stop on hangup")
+				return
+			}
+			select {
+			case <-gh.stoppedChan:
+				return
+			case <-time.After(GrpcFrameDuration):
+			}
+		}
+	}()
+	return nil
+}
+
+// Transfer implements CallController.Transfer
+func (gh *grpcHandler) Transfer(target string) error {
+	return fmt.Errorf("cannot transfer a grpc conversation to %s", target)
+}
+
+// SendDigits implements CallController.SendDigits
+func (gh *grpcHandler) SendDigits(digits string) error {
+	return fmt.Errorf("cannot send digits %s to a grpc conversation", digits)
+}
+
+// ClearPlayback drops the audio which was not sent yet, e.g. when the client barges in.
+func (gh *grpcHandler) ClearPlayback() {
+	gh.playMutex.Lock()
+	defer gh.playMutex.Unlock()
+	// The recording keeps the whole agent turn, as we do not know how much of it the client played.
+	log.Info().Str("conversation_id", gh.conversationId).Int("num_frames", len(gh.playQueue)).Msg("grpcHandler clearing playback")
+	gh.playQueue = gh.playQueue[:0]
+}
+
+func (gh *grpcHandler) StopRecording() ([]byte, error) {
+	err := gh.Stop()
+
+	return nil, err
+}
+
+func (gh *grpcHandler) Stop() error {
+	return gh.stop("stopped")
+}
+
+// stop tells the client why the conversation ended, and closes the writeChan which ends Serve.
+func (gh *grpcHandler) stop(reason string) error {
+	gh.writeMutex.Lock()
+	defer gh.writeMutex.Unlock()
+	if gh.isStopped {
+		log.Debug().Str("conversation_id", gh.conversationId).Msg("grpcHandler already stopped")
+		return nil
+	}
+	gh.isStopped = true
+	log.Info().Str("conversation_id", gh.conversationId).Str("reason", reason).Msg("writeChan close")
+
+	// Nobody gets the Ended message of a failed stream, and a full writeChan must not block the stop.
+	if reason != "send_failed" {
+		select {
+		case gh.writeChan <- &voicepb.ServerMessage{Message: &voicepb.ServerMessage_Ended{Ended: &voicepb.ConversationEnded{Reason: reason}}}:
+		default:
+			log.Warn().Str("conversation_id", gh.conversationId).Msg("writeChan is full, the client does not get the ended message")
+		}
+	}
+	close(gh.stoppedChan)
+	close(gh.writeChan)
+
+	return nil
+}
+
+// Play implements OutputDevice.Play
+// The audio is queued, and sent one GrpcFrameDuration frame at a time by playQueueRoutine.
+func (gh *grpcHandler) Play(intBuffer *audio.IntBuffer) (*sync.WaitGroup, error) {
+	if intBuffer.Format == nil || intBuffer.Format.NumChannels != 1 {
+		return nil, fmt.Errorf("grpcHandler can only play mono audio")
+	}
+	sampleRate := int(gh.start.SampleRate)
+	linear16Bytes := audio_utils.EncodeToLinear16(intBuffer, sampleRate)
+
+	frameSize := 2 * sampleRate * int(GrpcFrameDuration/time.Millisecond) / 1000
+	gh.playMutex.Lock()
+	defer gh.playMutex.Unlock()
+	// The queue was empty for a while, so this continues after a gap of silence.
+	if sinceStart := time.Since(gh.startTime); len(gh.playQueue) == 0 && gh.playNextTimestamp < sinceStart {
+		gh.playNextTimestamp = sinceStart.Truncate(time.Millisecond)
+	}
+	gh.recorder.AddOutbound(gh.playNextTimestamp.Milliseconds(), audio_utils.DecodeFromLinear16(linear16Bytes, sampleRate).Data)
+
+	for start := 0; start < len(linear16Bytes); start += frameSize {
+		frame := make([]byte, frameSize) // Pads the last frame with silence.
+		copy(frame, linear16Bytes[start:min(start+frameSize, len(linear16Bytes))])
+		gh.playQueue = append(gh.playQueue, frame)
+		gh.playNextTimestamp += GrpcFrameDuration
+	}
+	return nil, nil
+}
+
+func (gh *grpcHandler) playQueueRoutine() {
+	ticker := time.NewTicker(GrpcFrameDuration)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-gh.stoppedChan:
+			return
+		case <-ticker.C:
+			gh.playMutex.Lock()
+			if len(gh.playQueue) == 0 {
+				gh.playMutex.Unlock()
+				continue
+			}
+			frame := gh.playQueue[0]
+			gh.playQueue = gh.playQueue[1:]
+			gh.playMutex.Unlock()
+
+			gh.sendMessage(&voicepb.ServerMessage{Message: &voicepb.ServerMessage_Audio{Audio: &voicepb.AudioFrame{Linear16: frame}}})
+		}
+	}
+}
+
+// handleStartMessage returns the gRPC status to end the stream with, if the conversation cannot start.
+func (gh *grpcHandler) handleStartMessage(start *voicepb.StartConversation) error {
+	if start == nil {
+		return status.Error(codes.InvalidArgument, "the first message must be start")
+	}
+	if start.SampleRate < 8000 || start.SampleRate > 48000 {
+		return status.Errorf(codes.InvalidArgument, "unsupported sample rate %d", start.SampleRate)
+	}
+
+	gh.start = start
+	gh.startTime = time.Now()
+	gh.conversationId = strconv.FormatInt(gh.startTime.UnixMilli(), 10)
+	gh.chunker = newSpeechChunker(int(start.SampleRate), "grpc", func(sample int16) bool {
+		return -browserSilenceAmplitude < sample && sample < browserSilenceAmplitude
+	})
+	gh.recorder = newCallRecorder(int(start.SampleRate))
+	gh.chunker.onSpeech = gh.recorder.AddCallerTurn
+	log.Info().Str("conversation_id", gh.conversationId).Int32("sample_rate", start.SampleRate).Interface("parameters", start.Parameters).Msg("grpc conversation starting")
+
+	if gh.onStart != nil {
+		if err := gh.onStart(gh, start); err != nil {
+			log.Warn().Err(err).Str("conversation_id", gh.conversationId).Msg("conversation rejected on start")
+			return status.Errorf(codes.FailedPrecondition, "conversation rejected: %v", err)
+		}
+	}
+	gh.sendMessage(&voicepb.ServerMessage{Message: &voicepb.ServerMessage_Started{Started: &voicepb.ConversationStarted{ConversationId: gh.conversationId}}})
+	return nil
+}
+
+func (gh *grpcHandler) handleAudioMessage(linear16Bytes []byte) {
+	if len(linear16Bytes)%2 != 0 {
+		log.Warn().Str("conversation_id", gh.conversationId).Int("byte_size", len(linear16Bytes)).Msg("received odd number of linear16 bytes, ignoring")
+		return
+	}
+
+	sampleRate := int(gh.start.SampleRate)
+	samples := audio_utils.DecodeFromLinear16(linear16Bytes, sampleRate).Data
+	// The client sends the audio as it is captured, so its position on the timeline is the number of samples so far.
+	gh.recorder.AddInbound(int64(gh.chunker.Len()*1000/sampleRate), samples)
+	gh.submit(gh.chunker.Add(samples))
+}
+
+func (gh *grpcHandler) submit(audioDataList []models.AudioData) {
+	for _, audioData := range audioDataList {
+		gh.recordingChan <- audioData
+	}
+}
+
+func (gh *grpcHandler) sendMessage(msg *voicepb.ServerMessage) {
+	gh.writeMutex.Lock()
+	defer gh.writeMutex.Unlock()
+	if gh.isStopped {
+		log.Trace().Str("conversation_id", gh.conversationId).Msg("cannot send message after grpcHandler isStopped")
+		return
+	}
+	gh.writeChan <- msg
+}
+
+func (gh *grpcHandler) readMessagesUntilEnd(stream voicepb.VoiceService_ConverseServer) {
+	for {
+		msg, err := stream.Recv()
+		if err != nil {
+			// io.EOF when the client closed its side, or the stream context got canceled.
+			log.Info().Err(err).Str("conversation_id", gh.conversationId).Msg("grpc stream receive ended")
+			break
+		}
+
+		switch message := msg.Message.(type) {
+		case *voicepb.ClientMessage_Audio:
+			gh.handleAudioMessage(message.Audio.Linear16)
+		case *voicepb.ClientMessage_EndOfSpeech:
+			log.Info().Str("conversation_id", gh.conversationId).Msg("the client detected the end of speech")
+			gh.submit(gh.chunker.Flush())
+		case *voicepb.ClientMessage_Interrupt:
+			gh.ClearPlayback()
+		case *voicepb.ClientMessage_HangUp:
+			log.Info().Str("conversation_id", gh.conversationId).Msg("the client hung up")
+			errLog(gh.stop("client_hangup"), "grpcHandler.stop on hang up")
+		default:
+			log.Warn().Str("conversation_id", gh.conversationId).Msgf("ignoring unexpected grpc client message %T", msg.Message)
+		}
+	}
+
+	// After reading done, there is no more to produce, nor anyone to write to.
+	errLog(gh.stop("client_closed"), "grpcHandler.stop after the stream ended")
+	if gh.recordingChan != nil {
+		log.Info().Str("conversation_id", gh.conversationId).Msg("gh.recordingChan CLOSE")
+		close(gh.recordingChan)
+	}
+
+	gh.saveRecording()
+}
+
+// saveRecording stores the stereo recording of both sides of the call into the recordingSink.
+func (gh *grpcHandler) saveRecording() {
+	if gh.recorder == nil || gh.recordingSink == nil {
+		log.Debug().Str("conversation_id", gh.conversationId).Msg("no call recording to save")
+		return
+	}
+
+	wavBytes, metadata, err := gh.recorder.EncodeStereoWav()
+	if err != nil {
+		errLog(err, "callRecorder.EncodeStereoWav")
+		return
+	}
+	if len(wavBytes) == 0 {
+		log.Info().Str("conversation_id", gh.conversationId).Msg("call recording is empty, not saving")
+		return
+	}
+	metadata.CallSid = gh.conversationId
+
+	log.Info().Str("conversation_id", gh.conversationId).Msgf("grpc stream finished, gonna save %d bytes of call recording", len(wavBytes))
+	errLog(gh.recordingSink.Save("call-grpc-"+gh.conversationId, wavBytes, metadata), "recordingSink.Save")
+}
@@ -0,0 +1,267 @@
+package audioio
+
+import (
+	"context"
+	"errors"
+	"github.com/go-audio/audio"
+	"github.com/petrzlen/vocode-golang/internal/networking"
+	"github.com/petrzlen/vocode-golang/pkg/audio_utils"
+	"github.com/petrzlen/vocode-golang/pkg/models"
+	"github.com/petrzlen/vocode-golang/pkg/voicepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"io"
+	"math"
+	"net"
+	"testing"
+	"time"
+)
+
+const grpcTestApiKey = "test-api-key"
+
+// newGrpcTestClient serves the GrpcVoiceService in memory, behind the same api key check as cmd/grpc.
+func newGrpcTestClient(t *testing.T, createHandler func() networking.GrpcConversationHandler) voicepb.VoiceServiceClient {
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer(grpc.StreamInterceptor(networking.RequireGrpcApiKey(grpcTestApiKey)))
+	voicepb.RegisterVoiceServiceServer(server, networking.NewGrpcVoiceService(createHandler))
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return voicepb.NewVoiceServiceClient(conn)
+}
+
+// newGrpcToneFrames is an "aaa" of the client, as linear16 frames of GrpcFrameDuration.
+func newGrpcToneFrames(sampleRate int, duration time.Duration) [][]byte {
+	samples := make([]int, int(duration.Seconds()*float64(sampleRate)))
+	for i := range samples {
+		samples[i] = int(8000 * math.Sin(2*math.Pi*440*float64(i)/float64(sampleRate)))
+	}
+	linear16Bytes := audio_utils.EncodeToLinear16(&audio.IntBuffer{Data: samples, Format: &audio.Format{SampleRate: sampleRate, NumChannels: 1}}, sampleRate)
+
+	frameSize := 2 * sampleRate * int(GrpcFrameDuration/time.Millisecond) / 1000
+	var frames [][]byte
+	for start := 0; start < len(linear16Bytes); start += frameSize {
+		frames = append(frames, linear16Bytes[start:min(start+frameSize, len(linear16Bytes))])
+	}
+	return frames
+}
+
+func recvServerMessage(t *testing.T, stream voicepb.VoiceService_ConverseClient) *voicepb.ServerMessage {
+	msgChan := make(chan *voicepb.ServerMessage, 1)
+	errChan := make(chan error, 1)
+	go func() {
+		msg, err := stream.Recv()
+		if err != nil {
+			errChan <- err
+			return
+		}
+		msgChan <- msg
+	}()
+	select {
+	case msg := <-msgChan:
+		return msg
+	case err := <-errChan:
+		t.Fatalf("cannot receive from the grpc stream: %v", err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("no message from the grpc stream")
+	}
+	return nil
+}
+
+func TestGrpcHandlerRejectsMissingApiKey(t *testing.T) {
+	client := newGrpcTestClient(t, func() networking.GrpcConversationHandler {
+		t.Error("the handler must not be created without an api key")
+		return NewGrpcHandler(nil)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	ctx = metadata.AppendToOutgoingContext(ctx, networking.GrpcAuthorizationMetadata, "Bearer wrong-key")
+	stream, err := client.Converse(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = stream.Recv()
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated, got %v", err)
+	}
+}
+
+func TestGrpcHandlerConversation(t *testing.T) {
+	const sampleRate = 16000
+	handlerChan := make(chan *grpcHandler, 1)
+	recordingChan := make(chan models.AudioData, 100)
+	sink := chanRecordingSink{savedChan: make(chan RecordingMetadata, 1)}
+	client := newGrpcTestClient(t, func() networking.GrpcConversationHandler {
+		handler := NewGrpcHandler(func(device DuplexDevice, start *voicepb.StartConversation) error {
+			if start.Parameters["agent_profile_id"] != "support" {
+				t.Errorf("unexpected parameters %v", start.Parameters)
+			}
+			// Same as pipeline.Start does.
+			device.(SilenceThresholdSetter).SetSilenceThresholds(200*time.Millisecond, 300*time.Millisecond)
+			return device.StartRecording(recordingChan)
+		})
+		handler.SetRecordingSink(sink)
+		handlerChan <- handler
+		return handler
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ctx = metadata.AppendToOutgoingContext(ctx, networking.GrpcAuthorizationMetadata, "Bearer "+grpcTestApiKey)
+	stream, err := client.Converse(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := &voicepb.StartConversation{SampleRate: sampleRate, Parameters: map[string]string{"agent_profile_id": "support"}}
+	if err := stream.Send(&voicepb.ClientMessage{Message: &voicepb.ClientMessage_Start{Start: start}}); err != nil {
+		t.Fatal(err)
+	}
+	if started := recvServerMessage(t, stream).GetStarted(); started == nil || started.ConversationId == "" {
+		t.Fatal("expected the conversation started first")
+	}
+	handler := <-handlerChan
+
+	// The client speaks, and detects the end of speech itself.
+	for _, frame := range newGrpcToneFrames(sampleRate, 600*time.Millisecond) {
+		if err := stream.Send(&voicepb.ClientMessage{Message: &voicepb.ClientMessage_Audio{Audio: &voicepb.AudioFrame{Linear16: frame}}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := stream.Send(&voicepb.ClientMessage{Message: &voicepb.ClientMessage_EndOfSpeech{EndOfSpeech: &voicepb.EndOfSpeech{}}}); err != nil {
+		t.Fatal(err)
+	}
+	var events []models.AudioData
+	for len(events) < 2 {
+		select {
+		case audioData := <-recordingChan:
+			events = append(events, audioData)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("expected the speech followed by a submit, got %d events", len(events))
+		}
+	}
+	if events[0].EventType != models.AudioInput || events[1].EventType != models.SubmitPrompt {
+		t.Fatalf("expected the speech followed by a submit, got %v and %v", events[0].EventType, events[1].EventType)
+	}
+	if events[0].Length < 500*time.Millisecond || events[0].Length > 700*time.Millisecond {
+		t.Errorf("expected about 600ms of speech, got %s", events[0].Length)
+	}
+
+	// The agent answers, the transcript first, then the audio in frames of GrpcFrameDuration.
+	handler.OnTranscript("user", "aaa")
+	if transcript := recvServerMessage(t, stream).GetTranscript(); transcript == nil || transcript.Text != "aaa" {
+		t.Fatal("expected the user transcript")
+	}
+	_, err = handler.Play(&audio.IntBuffer{
+		Data:   make([]int, 640),
+		Format: &audio.Format{SampleRate: sampleRate, NumChannels: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if frame := recvServerMessage(t, stream).GetAudio(); frame == nil || len(frame.Linear16) != 640 {
+			t.Fatal("expected an audio frame of 640 bytes")
+		}
+	}
+
+	// The client hangs up, which ends the stream, the recording and saves the call.
+	if err := stream.Send(&voicepb.ClientMessage{Message: &voicepb.ClientMessage_HangUp{HangUp: &voicepb.HangUp{}}}); err != nil {
+		t.Fatal(err)
+	}
+	if ended := recvServerMessage(t, stream).GetEnded(); ended == nil || ended.Reason != "client_hangup" {
+		t.Fatal("expected the conversation ended by the client hangup")
+	}
+	if _, err := stream.Recv(); err != io.EOF {
+		t.Errorf("expected the stream to end, got %v", err)
+	}
+	for audioData := range recordingChan {
+		t.Errorf("unexpected event %v after the submit", audioData.EventType)
+	}
+	select {
+	case metadata := <-sink.savedChan:
+		if metadata.CallSid != handler.conversationId || metadata.SampleRate != sampleRate {
+			t.Errorf("unexpected call recording metadata %+v", metadata)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the call recording was not saved")
+	}
+}
+
+// failingConverseStream starts a conversation, and then fails every send once sendGate is closed,
+// as a client which went away does.
+type failingConverseStream struct {
+	grpc.ServerStream
+	ctx       context.Context
+	sendGate  chan struct{}
+	isStarted bool
+}
+
+func (s *failingConverseStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *failingConverseStream) Send(*voicepb.ServerMessage) error {
+	<-s.sendGate
+	return errors.New("transport is closing")
+}
+
+func (s *failingConverseStream) Recv() (*voicepb.ClientMessage, error) {
+	if !s.isStarted {
+		s.isStarted = true
+		return &voicepb.ClientMessage{Message: &voicepb.ClientMessage_Start{Start: &voicepb.StartConversation{SampleRate: 16000}}}, nil
+	}
+	<-s.ctx.Done()
+	return nil, s.ctx.Err()
+}
+
+func TestGrpcHandlerServeReturnsAfterFailedSendWithFullWriteChan(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var handler *grpcHandler
+	handler = NewGrpcHandler(func(device DuplexDevice, start *voicepb.StartConversation) error {
+		// The agent keeps producing more than writeChan holds, so a producer is blocked on it when the send fails.
+		go func() {
+			for i := 0; i < 3*cap(handler.writeChan); i++ {
+				handler.OnTranscript("assistant", "token")
+			}
+		}()
+		return nil
+	})
+	handler.SetRecordingSink(nil)
+
+	stream := &failingConverseStream{ctx: ctx, sendGate: make(chan struct{})}
+	serveErrChan := make(chan error, 1)
+	go func() {
+		serveErrChan <- handler.Serve(stream)
+	}()
+	for len(handler.writeChan) < cap(handler.writeChan) {
+		time.Sleep(time.Millisecond)
+	}
+	close(stream.sendGate)
+	select {
+	case err := <-serveErrChan:
+		if err != nil {
+			t.Errorf("unexpected Serve error %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Serve deadlocked after the failed send")
+	}
+}
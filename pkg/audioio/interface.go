@@ -2,8 +2,10 @@ package audioio
 
 import (
 	"github.com/go-audio/audio"
+	"github.com/petrzlen/vocode-golang/pkg/audio_utils"
 	"github.com/petrzlen/vocode-golang/pkg/models"
 	"sync"
+	"time"
 )
 
 // InputDevice
@@ -18,3 +20,50 @@ type OutputDevice interface {
 	Play(intBuffer *audio.IntBuffer) (*sync.WaitGroup, error)
 	Stop() error
 }
+
+// DuplexDevice is both the InputDevice and OutputDevice of a conversation, e.g. a phone call.
+type DuplexDevice interface {
+	InputDevice
+	OutputDevice
+}
+
+// SilenceThresholdSetter is implemented by InputDevice-s which chunk audio on detected silence.
+// speech is how much audio is worth submitting on a short pause, silence is the pause length to submit the prompt.
+type SilenceThresholdSetter interface {
+	SetSilenceThresholds(speech time.Duration, silence time.Duration)
+}
+
+// AudioFrameStreamer is implemented by InputDevice-s which can pass on the raw audio frames as models.AudioFrame,
+// instead of chunking them on silence, so a transcriber.StreamingTranscriber can do the turn detection.
+// It has to be called before StartRecording, and the SilenceThresholdSetter thresholds are ignored from then on.
+type AudioFrameStreamer interface {
+	StreamAudioFrames()
+}
+
+// CallController is implemented by telephony transports which can act on the call itself, not just its audio.
+// Actions should only take effect after the audio already passed to Play was heard by the caller.
+type CallController interface {
+	Hangup() error
+	// Transfer connects the caller to target, which is either a phone number in E.164 or a "sip:" URI.
+	Transfer(target string) error
+	// SendDigits plays DTMF tones into the call.
+	SendDigits(digits string) error
+}
+
+// AnsweringMachineDetectable is implemented by InputDevice-s of phone calls which can tell if a machine answered,
+// so outbound calls can leave a voicemail after the beep (or hangup) instead of talking to a recording.
+type AnsweringMachineDetectable interface {
+	DetectAnsweringMachine(config audio_utils.AnsweringMachineConfig, onEvent func(event audio_utils.AnsweringMachineEvent))
+}
+
+// ShutdownNotifier is implemented by devices which know when the server is shutting down,
+// so the conversation can be wrapped up instead of just dropped.
+type ShutdownNotifier interface {
+	OnShutdown(callback func())
+}
+
+// TranscriptListener is implemented by devices which can show the conversation as text, e.g. a web page.
+// role is "user" or "assistant", and the text comes in pieces as it gets transcribed or generated.
+type TranscriptListener interface {
+	OnTranscript(role string, text string)
+}
@@ -0,0 +1,71 @@
+package audioio
+
+import "time"
+
+// MediaStreamSampleRate all the JSON media streams we support carry mulaw 8kHz audio, i.e. the phone network native.
+const MediaStreamSampleRate = 8000
+
+// MediaStreamFrameDuration is how much audio we put into a single outbound media message,
+// it matches the 20ms frames the carriers send us on the inbound track.
+const MediaStreamFrameDuration = 20 * time.Millisecond
+
+// MediaStreamFrameSize is the number of mulaw bytes (one byte per sample) in one MediaStreamFrameDuration.
+const MediaStreamFrameSize = MediaStreamSampleRate * int(MediaStreamFrameDuration/time.Millisecond) / 1000
+
+type MediaStreamEventKind int
+
+const (
+	// MediaStreamIgnored are events we do not need, e.g. "connected" or the outbound track echo.
+	MediaStreamIgnored MediaStreamEventKind = iota
+	MediaStreamStarted
+	MediaStreamMedia
+	MediaStreamMarked
+	MediaStreamStopped
+)
+
+// MediaStreamEvent is an inbound websocket message translated from the carrier protocol.
+type MediaStreamEvent struct {
+	Kind MediaStreamEventKind
+	// Start for MediaStreamStarted
+	Start *MediaStreamStart
+	// Media is the inbound mulaw audio for MediaStreamMedia.
+	Media []byte
+	// Chunk of MediaStreamMedia starting from 1, or 0 when the carrier does not number them.
+	Chunk int
+	// TimestampMs of MediaStreamMedia since the stream start, or -1 when the carrier does not send it.
+	TimestampMs int
+	// MarkName for MediaStreamMarked, i.e. the carrier played everything sent before the mark.
+	MarkName string
+}
+
+// MediaStreamStart is what we learn about the call when the stream starts.
+type MediaStreamStart struct {
+	StreamId string
+	// CallId is what the carrier REST API uses for the call, e.g. Twilio CallSid or Telnyx call_control_id.
+	CallId string
+	// CustomParameters are the per-call parameters we passed to the stream, e.g. Twilio <Parameter>-s.
+	CustomParameters map[string]string
+}
+
+// MediaStreamProtocol encodes and decodes the carrier specific JSON messages of a bidirectional media stream,
+// everything else (buffering, silence detection, playback, recording) is shared in mediaStreamHandler.
+// A new protocol instance is used for each stream, so it can keep state like sequence numbers.
+type MediaStreamProtocol interface {
+	// Name is used for logs and traces, e.g. "twilio".
+	Name() string
+	// ParseEvent decodes one inbound message, an error means the message is malformed.
+	ParseEvent(msgBytes []byte) (MediaStreamEvent, error)
+	// NewMediaMessage wraps one MediaStreamFrameSize outbound frame, chunk starts from 1 and
+	// timestamp is since the stream start.
+	NewMediaMessage(start MediaStreamStart, frame []byte, chunk int, timestamp time.Duration) ([]byte, error)
+	// NewMarkMessage asks the carrier to send us back a MediaStreamMarked event with name,
+	// once it played all the media sent before.
+	NewMarkMessage(start MediaStreamStart, name string) ([]byte, error)
+	// NewClearMessage asks the carrier to drop all the media sent, but not played yet.
+	NewClearMessage(start MediaStreamStart) ([]byte, error)
+}
+
+// MediaStreamStartHandler is called once the stream starts, before any media is processed.
+// As the custom parameters are only known now, this is where the per-call pipeline should be started.
+// Returning an error (e.g. the stream is not authorized) closes the stream right away.
+type MediaStreamStartHandler func(device DuplexDevice, start MediaStreamStart) error
@@ -0,0 +1,25 @@
+package audioio
+
+import (
+	"github.com/petrzlen/vocode-golang/pkg/audio_utils"
+	"github.com/rs/zerolog/log"
+)
+
+// DetectAnsweringMachine implements AnsweringMachineDetectable.DetectAnsweringMachine
+// Call it from the MediaStreamStartHandler, so no inbound media is missed. onEvent runs on the reader goroutine.
+func (sh *mediaStreamHandler) DetectAnsweringMachine(config audio_utils.AnsweringMachineConfig, onEvent func(event audio_utils.AnsweringMachineEvent)) {
+	sh.answeringMachineDetector = audio_utils.NewAnsweringMachineDetector(MediaStreamSampleRate, config)
+	sh.onAnsweringMachineEvent = onEvent
+}
+
+func (sh *mediaStreamHandler) maybeDetectAnsweringMachine(samples []int) {
+	if sh.answeringMachineDetector == nil || sh.answeringMachineDetector.IsDone() {
+		return
+	}
+	for _, event := range sh.answeringMachineDetector.Process(samples) {
+		log.Info().Str("stream_id", sh.getStreamId()).Str("kind", event.Kind.String()).Dur("at", event.At).Str("reason", event.Reason).Msg("answering machine detection event")
+		if sh.onAnsweringMachineEvent != nil {
+			sh.onAnsweringMachineEvent(event)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package audioio
+
+import (
+	"fmt"
+	"github.com/petrzlen/vocode-golang/pkg/telephony"
+	"github.com/rs/zerolog/log"
+	"strconv"
+)
+
+// SetCallControlClient makes the mediaStreamHandler a working CallController, the actions go through the REST API
+// of the same carrier as the stream.
+func (sh *mediaStreamHandler) SetCallControlClient(client telephony.Client) {
+	sh.callControlClient = client
+}
+
+// Hangup implements CallController.Hangup
+// Without a call control client the stream is closed instead, e.g. to drain the calls on shutdown,
+// which also ends a Twilio call with nothing after its <Connect><Stream>.
+func (sh *mediaStreamHandler) Hangup() error {
+	return sh.afterPlayback("hangup", func(callSid string) error {
+		if sh.callControlClient == nil {
+			return sh.Stop()
+		}
+		return sh.callControlClient.Hangup(callSid)
+	})
+}
+
+// Transfer implements CallController.Transfer
+func (sh *mediaStreamHandler) Transfer(target string) error {
+	if sh.callControlClient == nil {
+		return fmt.Errorf("cannot transfer as no call control client is set")
+	}
+	return sh.afterPlayback("transfer", func(callSid string) error {
+		return sh.callControlClient.Transfer(callSid, target)
+	})
+}
+
+// SendDigits implements CallController.SendDigits
+func (sh *mediaStreamHandler) SendDigits(digits string) error {
+	if sh.callControlClient == nil {
+		return fmt.Errorf("cannot send digits as no call control client is set")
+	}
+	return sh.afterPlayback("dtmf", func(callSid string) error {
+		return sh.callControlClient.SendDigits(callSid, digits)
+	})
+}
+
+// afterPlayback sends a mark message and only runs action once the carrier echoes it back,
+// which happens after all the media we sent before was played to the caller.
+func (sh *mediaStreamHandler) afterPlayback(name string, action func(callSid string) error) error {
+	if sh.start == nil {
+		return fmt.Errorf("cannot %s before the stream started", name)
+	}
+	callSid := sh.start.CallId
+	run := func() {
+		log.Info().Str("stream_id", sh.getStreamId()).Str("call_sid", callSid).Msgf("call control %s", name)
+		errLog(action(callSid), "call control "+name)
+	}
+
+	sh.writeMutex.Lock()
+	isStopped := sh.isStopped
+	sh.writeMutex.Unlock()
+	if isStopped {
+		// Nothing is playing anymore.
+		go run()
+		return nil
+	}
+
+	sh.marksMutex.Lock()
+	sh.markLastSeqNum++
+	markName := name + "-" + strconv.Itoa(sh.markLastSeqNum)
+	sh.pendingMarks[markName] = run
+	sh.marksMutex.Unlock()
+
+	sh.sendMessage("mark", true, func(start MediaStreamStart) ([]byte, error) {
+		return sh.protocol.NewMarkMessage(start, markName)
+	})
+	return nil
+}
+
+// runPendingMark is called when the carrier echoes a mark we sent.
+func (sh *mediaStreamHandler) runPendingMark(markName string) {
+	sh.marksMutex.Lock()
+	run, ok := sh.pendingMarks[markName]
+	delete(sh.pendingMarks, markName)
+	sh.marksMutex.Unlock()
+
+	if !ok {
+		log.Debug().Str("stream_id", sh.getStreamId()).Str("mark", markName).Msg("no pending action for mark")
+		return
+	}
+	// The REST call should not block reading from the websocket.
+	go run()
+}
@@ -0,0 +1,120 @@
+package audioio
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-audio/audio"
+	"github.com/gorilla/websocket"
+	"github.com/petrzlen/vocode-golang/internal/networking"
+	"github.com/petrzlen/vocode-golang/pkg/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestConnectionRegistryShutdownDrainsTelnyxCall a Telnyx call has no call control client,
+// so the goodbye is played and then the stream closed, well before the drain deadline.
+func TestConnectionRegistryShutdownDrainsTelnyxCall(t *testing.T) {
+	registry := networking.NewConnectionRegistry()
+	config := networking.DefaultWebsocketConfig
+	config.Registry = registry
+	recordingChan := make(chan models.AudioData, 100)
+	startedChan := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(networking.NewWebsocketHandlerFuncWithConfig(config, func() networking.WebsocketMessageHandler {
+		handler := NewTelnyxHandler(func(device DuplexDevice, start MediaStreamStart) error {
+			// Same as pipeline.Start does with the ShutdownMessage.
+			device.(ShutdownNotifier).OnShutdown(func() {
+				_, err := device.Play(&audio.IntBuffer{
+					Data:   newTestToneSamples(100 * time.Millisecond),
+					Format: &audio.Format{SampleRate: MediaStreamSampleRate, NumChannels: 1},
+				})
+				if err != nil {
+					t.Error(err)
+				}
+				if err := device.(CallController).Hangup(); err != nil {
+					t.Errorf("cannot hangup without a call control client: %v", err)
+				}
+			})
+			close(startedChan)
+			return device.StartRecording(recordingChan)
+		})
+		handler.SetRecordingSink(nil)
+		return handler
+	})))
+	defer server.Close()
+
+	peer, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	for _, msg := range []string{
+		`{"event": "connected", "version": "1.0.0"}`,
+		`{"event": "start", "sequence_number": "1", "stream_id": "32de0dea-53cb-4b21-89d4-9d5ee4d93a0f", "start": {"call_control_id": "v3:abc", "media_format": {"encoding": "PCMU", "sample_rate": 8000, "channels": 1}}}`,
+	} {
+		if err := peer.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-startedChan
+
+	const drainTimeout = 3 * time.Second
+	shutdownStart := time.Now()
+	shutdownErrChan := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+		defer cancel()
+		shutdownErrChan <- registry.Shutdown(ctx)
+	}()
+
+	// Telnyx echoes the mark once it played the goodbye.
+	numMediaFrames := 0
+	for {
+		if err := peer.SetReadDeadline(time.Now().Add(drainTimeout)); err != nil {
+			t.Fatal(err)
+		}
+		_, msgBytes, err := peer.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				t.Fatalf("expected the stream closed normally, got %v", err)
+			}
+			break
+		}
+		var msg TelnyxMessage
+		if err := json.Unmarshal(msgBytes, &msg); err != nil {
+			t.Fatal(err)
+		}
+		switch msg.Event {
+		case "media":
+			numMediaFrames++
+		case "mark":
+			if err := peer.WriteMessage(websocket.TextMessage, msgBytes); err != nil {
+				t.Fatal(err)
+			}
+		default:
+			t.Errorf("unexpected message %s", msgBytes)
+		}
+	}
+	if numMediaFrames != 5 {
+		t.Errorf("expected the goodbye played in 5 frames before the close, got %d", numMediaFrames)
+	}
+
+	select {
+	case err := <-shutdownErrChan:
+		if err != nil {
+			t.Errorf("expected the call drained, got %v", err)
+		}
+		if elapsed := time.Since(shutdownStart); elapsed >= drainTimeout/2 {
+			t.Errorf("expected the call drained well before the deadline, took %s", elapsed)
+		}
+	case <-time.After(drainTimeout + time.Second):
+		t.Fatal("registry.Shutdown did not return")
+	}
+	if registry.Count() != 0 {
+		t.Errorf("expected no connections left, got %d", registry.Count())
+	}
+	for range recordingChan {
+	}
+}
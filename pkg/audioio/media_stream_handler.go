@@ -0,0 +1,376 @@
+package audioio
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/go-audio/audio"
+	"github.com/petrzlen/vocode-golang/pkg/audio_utils"
+	"github.com/petrzlen/vocode-golang/pkg/models"
+	"github.com/petrzlen/vocode-golang/pkg/telephony"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"sync"
+	"time"
+)
+
+// mediaStreamHandler is the InputDevice, OutputDevice and networking.WebsocketMessageHandler of a carrier media stream,
+// the carrier specific messages are left to its MediaStreamProtocol, e.g. NewTwilioHandler.
+type mediaStreamHandler struct {
+	// Carrier Protocol
+	protocol   MediaStreamProtocol
+	start      *MediaStreamStart // To keep the initial config
+	startTime  time.Time
+	onStart    MediaStreamStartHandler
+	isAccepted bool // Only true once onStart succeeded, media before that is dropped.
+	readChan   chan []byte
+
+	mediaLastChunk int
+	// Presentation timestamp (since startTime) of the next outbound frame, so frames never overlap.
+	mediaNextTimestamp time.Duration
+	writeChan          chan []byte
+	// writeMutex guards isStopped and the protocol message building, so messages are sent in the order built,
+	// and nothing is sent after writeChan is closed. Also guards onShutdown.
+	writeMutex sync.Mutex
+	isStopped  bool
+	onShutdown func()
+
+	// Call control, actions wait in pendingMarks until everything sent before them was played.
+	callControlClient telephony.Client
+	marksMutex        sync.Mutex
+	markLastSeqNum    int
+	pendingMarks      map[string]func()
+
+	// Both sides of the call for QA, saved into recordingSink when the call ends.
+	recorder      *callRecorder
+	recordingSink RecordingSink
+
+	// Answering machine detection of outbound calls, nil when not enabled, see DetectAnsweringMachine.
+	answeringMachineDetector *audio_utils.AnsweringMachineDetector
+	onAnsweringMachineEvent  func(event audio_utils.AnsweringMachineEvent)
+
+	// Package interface
+	recordingChan chan models.AudioData
+	chunker       *speechChunker
+}
+
+// NewMediaStreamHandler onStart can be nil, in which case all streams are accepted.
+func NewMediaStreamHandler(protocol MediaStreamProtocol, onStart MediaStreamStartHandler) *mediaStreamHandler {
+	result := &mediaStreamHandler{
+		// Carrier Protocol
+		protocol:           protocol,
+		start:              nil,
+		onStart:            onStart,
+		isAccepted:         false,
+		readChan:           make(chan []byte, 100),
+		mediaLastChunk:     0,
+		mediaNextTimestamp: 0,
+		writeChan:          make(chan []byte, 100),
+		isStopped:          false,
+		onShutdown:         nil,
+
+		callControlClient: nil,
+		markLastSeqNum:    0,
+		pendingMarks:      make(map[string]func()),
+
+		recorder:      nil,
+		recordingSink: NewLocalDirRecordingSink("output"),
+
+		answeringMachineDetector: nil,
+		onAnsweringMachineEvent:  nil,
+
+		// Package interface
+		recordingChan: nil,
+
+		// The carriers send MulawSilenceByte-s when nobody talks, which decode to zero.
+		chunker: newSpeechChunker(MediaStreamSampleRate, protocol.Name(), func(sample int16) bool { return sample == 0 }),
+	}
+	go result.readMessagesUntilChanClosed()
+	return result
+}
+
+// GetReader implements WebsocketMessageHandler.GetReader
+func (sh *mediaStreamHandler) GetReader() chan<- []byte {
+	return sh.readChan
+}
+
+// GetWriter implements WebsocketMessageHandler.GetWriter
+func (sh *mediaStreamHandler) GetWriter() <-chan []byte {
+	return sh.writeChan
+}
+
+// StartRecording implements InputDevice.StartRecording
+// -- NOTE: The recording actually starts when the Websocket is established.
+// When the readChan is closed (i.e. connection is dropped), then it will also call StopRecording()
+func (sh *mediaStreamHandler) StartRecording(recordingChan chan models.AudioData) error {
+	sh.recordingChan = recordingChan
+	return nil
+}
+
+// SetRecordingSink overrides where the stereo call recording is saved when the call ends, nil disables it.
+func (sh *mediaStreamHandler) SetRecordingSink(sink RecordingSink) {
+	sh.recordingSink = sink
+}
+
+// SetSilenceThresholds implements SilenceThresholdSetter.SetSilenceThresholds
+func (sh *mediaStreamHandler) SetSilenceThresholds(speech time.Duration, silence time.Duration) {
+	sh.chunker.SetSilenceThresholds(speech, silence)
+}
+
+// StreamAudioFrames implements AudioFrameStreamer.StreamAudioFrames
+func (sh *mediaStreamHandler) StreamAudioFrames() {
+	sh.chunker.StreamAudioFrames()
+}
+
+// OnShutdown implements ShutdownNotifier.OnShutdown
+func (sh *mediaStreamHandler) OnShutdown(callback func()) {
+	sh.writeMutex.Lock()
+	defer sh.writeMutex.Unlock()
+	sh.onShutdown = callback
+}
+
+// Shutdown implements networking.ShutdownAwareHandler.Shutdown
+// Lets the pipeline wrap up the call, or just ends the stream if there is nothing to wrap up.
+func (sh *mediaStreamHandler) Shutdown() {
+	sh.writeMutex.Lock()
+	onShutdown := sh.onShutdown
+	sh.writeMutex.Unlock()
+
+	if onShutdown == nil {
+		errLog(sh.Stop(), "mediaStreamHandler.Stop on shutdown")
+		return
+	}
+	log.Info().Str("stream_id", sh.getStreamId()).Msg("mediaStreamHandler wrapping up the call on shutdown")
+	onShutdown()
+}
+
+// StopRecording implements InputDevice.StopRecording
+func (sh *mediaStreamHandler) StopRecording() ([]byte, error) {
+	err := sh.Stop()
+
+	return nil, err
+}
+
+// Stop implements OutputDevice.Stop
+func (sh *mediaStreamHandler) Stop() error {
+	sh.writeMutex.Lock()
+	defer sh.writeMutex.Unlock()
+	if sh.isStopped {
+		log.Debug().Str("stream_id", sh.getStreamId()).Msg("mediaStreamHandler already stopped")
+		return nil
+	}
+	sh.isStopped = true
+	log.Info().Str("stream_id", sh.getStreamId()).Msg("writeChan close")
+
+	// TODO(P0, race): There are definitely race conditions here, think about it. Especially around
+	// graceful shutdown and channel closes.
+	// This will trigger the websocket close,
+	// which will then trigger the readChan to close,
+	// which then triggers the recordingChan to close.
+	close(sh.writeChan)
+
+	return nil
+}
+
+// Play implements OutputDevice.Play
+// The audio is split into MediaStreamFrameSize frames, each sent as its own media message,
+// so that logs stay readable and a Stop can cut the playback at frame precision.
+// TODO(P1, devx): Technically we can implement the sync.WaitGroup with Mark messages
+func (sh *mediaStreamHandler) Play(intBuffer *audio.IntBuffer) (*sync.WaitGroup, error) {
+	mulawBytes, err := audio_utils.EncodeToMulaw(intBuffer, MediaStreamSampleRate)
+	if err != nil {
+		return nil, fmt.Errorf("cannot convert intBuffer into mulawBytes: %w", err)
+	}
+
+	sh.sendMediaFrames(mulawBytes)
+	return nil, nil
+}
+
+// ClearPlayback drops the audio which was sent, but not yet played by the carrier, e.g. when the caller barges in.
+func (sh *mediaStreamHandler) ClearPlayback() {
+	sh.sendMessage("clear", true, func(start MediaStreamStart) ([]byte, error) {
+		return sh.protocol.NewClearMessage(start)
+	})
+}
+
+// sendMediaFrames chunks mulawBytes into frames with consecutive timestamps.
+// A new Play continues right after the previous one, unless there was a gap of silence in between.
+func (sh *mediaStreamHandler) sendMediaFrames(mulawBytes []byte) {
+	if sh.startTime.IsZero() {
+		log.Debug().Msg("sending media frames before the stream started, timestamps start at zero")
+	} else if sinceStart := time.Since(sh.startTime); sh.mediaNextTimestamp < sinceStart {
+		sh.mediaNextTimestamp = sinceStart.Truncate(time.Millisecond)
+	}
+	if sh.recorder != nil {
+		sh.recorder.AddOutbound(sh.mediaNextTimestamp.Milliseconds(), audio_utils.DecodeFromMulaw(mulawBytes, MediaStreamSampleRate).Data)
+	}
+
+	for start := 0; start < len(mulawBytes); start += MediaStreamFrameSize {
+		frame := mulawBytes[start:min(start+MediaStreamFrameSize, len(mulawBytes))]
+		if len(frame) < MediaStreamFrameSize {
+			// Pad the last frame with silence, so all frames have the same duration.
+			frame = append(frame, bytes.Repeat([]byte{MulawSilenceByte}, MediaStreamFrameSize-len(frame))...)
+		}
+
+		sh.mediaLastChunk++
+		chunk := sh.mediaLastChunk
+		timestamp := sh.mediaNextTimestamp
+		sh.mediaNextTimestamp += MediaStreamFrameDuration
+
+		// Same as for received, we only log every 100th media message.
+		sh.sendMessage("media", chunk%100 == 0, func(start MediaStreamStart) ([]byte, error) {
+			return sh.protocol.NewMediaMessage(start, frame, chunk, timestamp)
+		})
+	}
+}
+
+func (sh *mediaStreamHandler) getStreamId() string {
+	if sh.start == nil {
+		log.Debug().Msg("tried to get getStreamId before the stream started")
+		return ""
+	}
+	return sh.start.StreamId
+}
+
+func (sh *mediaStreamHandler) handleStartEvent(start MediaStreamStart) {
+	sh.start = &start
+	sh.startTime = time.Now()
+	sh.recorder = newCallRecorder(MediaStreamSampleRate)
+	sh.chunker.onSpeech = sh.recorder.AddCallerTurn
+
+	if sh.onStart != nil {
+		if err := sh.onStart(sh, start); err != nil {
+			log.Warn().Err(err).Str("stream_id", sh.getStreamId()).Str("call_id", start.CallId).Msg("stream rejected on start, closing")
+			errLog(sh.Stop(), "mediaStreamHandler.Stop after rejected start")
+			return
+		}
+	}
+	sh.isAccepted = true
+}
+
+func (sh *mediaStreamHandler) handleMediaEvent(event MediaStreamEvent) {
+	if !sh.isAccepted {
+		log.Trace().Str("stream_id", sh.getStreamId()).Msg("received media before the stream was accepted, ignoring")
+		return
+	}
+
+	timestampMs := event.TimestampMs
+	if timestampMs < 0 {
+		timestampMs = sh.chunker.Len() * 1000 / MediaStreamSampleRate
+	}
+	samples := audio_utils.DecodeFromMulaw(event.Media, MediaStreamSampleRate).Data
+	sh.recorder.AddInbound(int64(timestampMs), samples)
+	sh.maybeDetectAnsweringMachine(samples)
+
+	for _, audioData := range sh.chunker.AddMulaw(event.Media, samples) {
+		sh.recordingChan <- audioData
+	}
+}
+
+func logMessage(direction string, msg []byte) {
+	// For outbound media events, it can be VERY long so we truncate to avoid logspam.
+	msgStr := truncatePayload(string(msg))
+	log.Debug().Msgf("%s message: %v", direction, msgStr)
+}
+
+// sendMessage builds the message with the protocol under writeMutex, so messages go out in the order built.
+func (sh *mediaStreamHandler) sendMessage(what string, shouldLog bool, newMessage func(start MediaStreamStart) ([]byte, error)) {
+	sh.writeMutex.Lock()
+	defer sh.writeMutex.Unlock()
+	if sh.isStopped {
+		log.Debug().Str("stream_id", sh.getStreamId()).Msgf("cannot send %s message after mediaStreamHandler isStopped", what)
+		return
+	}
+
+	start := MediaStreamStart{}
+	if sh.start != nil {
+		start = *sh.start
+	}
+	msgBytes, err := newMessage(start)
+	if err != nil {
+		errLog(err, sh.protocol.Name()+" new "+what+" message") // shouldn't happen
+		return
+	}
+	if shouldLog {
+		logMessage("sending", msgBytes)
+	}
+
+	sh.writeChan <- msgBytes
+}
+
+func (sh *mediaStreamHandler) readMessagesUntilChanClosed() {
+	for msg := range sh.readChan {
+		sh.handleMessage(msg)
+	}
+
+	// After reading done, there is no more to produce, nor anyone to write to.
+	errLog(sh.Stop(), "mediaStreamHandler.Stop after readChan closed")
+	if sh.recordingChan != nil {
+		log.Info().Str("stream_id", sh.getStreamId()).Msg("sh.recordingChan CLOSE")
+		close(sh.recordingChan)
+	}
+
+	sh.saveRecording()
+}
+
+func (sh *mediaStreamHandler) handleMessage(msgBytes []byte) {
+	event, err := sh.protocol.ParseEvent(msgBytes)
+	if err != nil {
+		log.Error().Err(err).Str("stream_id", sh.getStreamId()).Msgf("couldn't decode msg from websocket: %s", truncatePayload(string(msgBytes)))
+		return
+	}
+
+	// To prevent log-spam, we only log non-media messages, or every 100th media message.
+	if event.Kind != MediaStreamMedia || event.Chunk%100 == 0 {
+		logMessage("received", msgBytes)
+	}
+
+	switch event.Kind {
+	case MediaStreamStarted:
+		sh.handleStartEvent(*event.Start)
+	case MediaStreamMedia:
+		sh.handleMediaEvent(event)
+	case MediaStreamMarked:
+		sh.runPendingMark(event.MarkName)
+	case MediaStreamStopped:
+		log.Info().Str("stream_id", sh.getStreamId()).Msg("stream stopped by the carrier")
+	}
+}
+
+// saveRecording stores the stereo recording of both sides of the call into the recordingSink.
+func (sh *mediaStreamHandler) saveRecording() {
+	if sh.recorder == nil || sh.recordingSink == nil || sh.start == nil {
+		log.Debug().Str("stream_id", sh.getStreamId()).Msg("no call recording to save")
+		return
+	}
+
+	wavBytes, metadata, err := sh.recorder.EncodeStereoWav()
+	if err != nil {
+		errLog(err, "callRecorder.EncodeStereoWav")
+		return
+	}
+	if len(wavBytes) == 0 {
+		log.Info().Str("stream_id", sh.getStreamId()).Msg("call recording is empty, not saving")
+		return
+	}
+	metadata.StreamSid = sh.getStreamId()
+	metadata.CallSid = sh.start.CallId
+
+	log.Info().Str("stream_id", sh.getStreamId()).Msgf("websocket finished, gonna save %d bytes of call recording", len(wavBytes))
+	errLog(sh.recordingSink.Save(newRecordingName("stream"), wavBytes, metadata), "recordingSink.Save")
+}
+
+func errLog(err error, what string) {
+	if err != nil {
+		log.Error().Err(errors.WithStack(err)).Msg(what)
+	}
+}
+
+// isInList checks if a string is present in a slice of strings.
+func isInList(str string, list []string) bool {
+	for _, v := range list {
+		if v == str {
+			return true
+		}
+	}
+	return false
+}
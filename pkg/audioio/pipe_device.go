@@ -0,0 +1,234 @@
+package audioio
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/go-audio/audio"
+	"github.com/petrzlen/vocode-golang/pkg/audio_utils"
+	"github.com/petrzlen/vocode-golang/pkg/models"
+	"github.com/rs/zerolog/log"
+	"io"
+	"sync"
+	"time"
+)
+
+// PipeFrameDuration is how much audio is read or written at once.
+const PipeFrameDuration = 20 * time.Millisecond
+
+// pipeSilenceAmplitude same as fileSilenceAmplitude, as the input is most likely a microphone.
+const pipeSilenceAmplitude = 200
+
+// pipeDevice is a DuplexDevice on raw signed 16bit little-endian PCM streams, e.g. stdin and stdout,
+// so the pipeline can run as `arecord | vocode-pipe | aplay` without the malgo (cgo) build.
+// The output is written at a steady pace, with silence between the agent turns,
+// so the player never underruns and the output has the same timeline as the input.
+type pipeDevice struct {
+	reader     io.Reader
+	writer     io.Writer
+	sampleRate int
+	channels   int
+
+	// writeMutex guards isStopped.
+	writeMutex  sync.Mutex
+	isStopped   bool
+	stoppedChan chan struct{}
+	doneChan    chan struct{}
+
+	// The mono frames waiting to be written by the clockRoutine.
+	playMutex sync.Mutex
+	playQueue [][]int
+
+	// Package interface
+	recordingChan chan models.AudioData
+	chunker       *speechChunker
+}
+
+// NewPipeDevice both streams are interleaved when channels > 1, the input is mixed down to mono.
+func NewPipeDevice(reader io.Reader, writer io.Writer, sampleRate int, channels int) (*pipeDevice, error) {
+	if sampleRate < 8000 || sampleRate > 48000 {
+		return nil, fmt.Errorf("unsupported sample rate %d", sampleRate)
+	}
+	if channels < 1 || channels > 2 {
+		return nil, fmt.Errorf("unsupported number of channels %d", channels)
+	}
+
+	return &pipeDevice{
+		reader:      reader,
+		writer:      writer,
+		sampleRate:  sampleRate,
+		channels:    channels,
+		isStopped:   false,
+		stoppedChan: make(chan struct{}),
+		doneChan:    make(chan struct{}),
+
+		playQueue: make([][]int, 0),
+
+		// Package interface
+		recordingChan: nil,
+		chunker: newSpeechChunker(sampleRate, "pipe", func(sample int16) bool {
+			return -pipeSilenceAmplitude < sample && sample < pipeSilenceAmplitude
+		}),
+	}, nil
+}
+
+// StartRecording implements InputDevice.StartRecording
+// The reader is read until EOF, then recordingChan is closed.
+func (pd *pipeDevice) StartRecording(recordingChan chan models.AudioData) error {
+	pd.recordingChan = recordingChan
+	go pd.readRoutine()
+	go pd.clockRoutine()
+	return nil
+}
+
+// StopRecording implements InputDevice.StopRecording
+// NOTE: A blocked Read cannot be interrupted, so the recordingChan only gets closed on the next frame or EOF.
+func (pd *pipeDevice) StopRecording() ([]byte, error) {
+	return nil, pd.Stop()
+}
+
+// SetSilenceThresholds implements SilenceThresholdSetter.SetSilenceThresholds
+func (pd *pipeDevice) SetSilenceThresholds(speech time.Duration, silence time.Duration) {
+	pd.chunker.SetSilenceThresholds(speech, silence)
+}
+
+// StreamAudioFrames implements AudioFrameStreamer.StreamAudioFrames
+func (pd *pipeDevice) StreamAudioFrames() {
+	pd.chunker.StreamAudioFrames()
+}
+
+// Done is closed once the reader got to EOF (or failed), and the recordingChan was closed.
+func (pd *pipeDevice) Done() <-chan struct{} {
+	return pd.doneChan
+}
+
+// IsPlaying is true while there is some audio which was not written yet.
+func (pd *pipeDevice) IsPlaying() bool {
+	pd.playMutex.Lock()
+	defer pd.playMutex.Unlock()
+	return len(pd.playQueue) > 0
+}
+
+// Play implements OutputDevice.Play
+// The audio is queued, and written one PipeFrameDuration frame at a time by clockRoutine.
+func (pd *pipeDevice) Play(intBuffer *audio.IntBuffer) (*sync.WaitGroup, error) {
+	if intBuffer.Format == nil || intBuffer.Format.NumChannels != 1 {
+		return nil, fmt.Errorf("pipeDevice can only play mono audio")
+	}
+	samples := make([]int, len(intBuffer.Data))
+	for i, sample := range intBuffer.Data {
+		samples[i] = int(int16(sample))
+	}
+	samples = audio_utils.ResampleSimple(samples, intBuffer.Format.SampleRate, pd.sampleRate)
+
+	frameSize := pd.frameSize()
+	pd.playMutex.Lock()
+	defer pd.playMutex.Unlock()
+	for start := 0; start < len(samples); start += frameSize {
+		frame := make([]int, frameSize) // Pads the last frame with silence.
+		copy(frame, samples[start:min(start+frameSize, len(samples))])
+		pd.playQueue = append(pd.playQueue, frame)
+	}
+	return nil, nil
+}
+
+// Stop implements OutputDevice.Stop, also stops reading the input.
+func (pd *pipeDevice) Stop() error {
+	pd.writeMutex.Lock()
+	defer pd.writeMutex.Unlock()
+	if pd.isStopped {
+		log.Debug().Msg("pipeDevice already stopped")
+		return nil
+	}
+	pd.isStopped = true
+	close(pd.stoppedChan)
+	return nil
+}
+
+func (pd *pipeDevice) frameSize() int {
+	return pd.sampleRate * int(PipeFrameDuration/time.Millisecond) / 1000
+}
+
+func (pd *pipeDevice) readRoutine() {
+	defer close(pd.doneChan)
+
+	buffer := make([]byte, 2*pd.channels*pd.frameSize())
+readLoop:
+	for {
+		n, err := io.ReadFull(pd.reader, buffer)
+		// A partial frame at the end is still processed, only a partial sample is dropped.
+		n -= n % (2 * pd.channels)
+		if n > 0 {
+			samples := audio_utils.DecodeFromLinear16(buffer[:n], pd.sampleRate).Data
+			if pd.channels == 2 {
+				samples = audio_utils.StereoToMono(samples)
+			}
+			pd.submit(pd.chunker.Add(samples))
+		}
+
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			log.Info().Int("num_samples", pd.chunker.Len()).Msg("pipeDevice input ended")
+			break
+		}
+		if err != nil {
+			errLog(err, "pipeDevice read")
+			break
+		}
+		select {
+		case <-pd.stoppedChan:
+			log.Info().Msg("pipeDevice stopped reading")
+			break readLoop
+		default:
+		}
+	}
+	// Whatever was said last gets submitted, even if the input ends mid-sentence.
+	pd.submit(pd.chunker.Flush())
+	log.Info().Msg("pd.recordingChan CLOSE")
+	close(pd.recordingChan)
+}
+
+// clockRoutine writes a frame every PipeFrameDuration, either from the playQueue or silence.
+func (pd *pipeDevice) clockRoutine() {
+	ticker := time.NewTicker(PipeFrameDuration)
+	defer ticker.Stop()
+	silence := make([]int, pd.frameSize())
+	for {
+		select {
+		case <-pd.stoppedChan:
+			return
+		case <-ticker.C:
+		}
+
+		frame := silence
+		pd.playMutex.Lock()
+		if len(pd.playQueue) > 0 {
+			frame = pd.playQueue[0]
+			pd.playQueue = pd.playQueue[1:]
+		}
+		pd.playMutex.Unlock()
+
+		if _, err := pd.writer.Write(pd.encodeFrame(frame)); err != nil {
+			// e.g. the player exited, nothing more to do.
+			errLog(err, "pipeDevice write")
+			errLog(pd.Stop(), "pipeDevice.Stop after failed write")
+			return
+		}
+	}
+}
+
+// encodeFrame duplicates the mono frame into all the output channels.
+func (pd *pipeDevice) encodeFrame(frame []int) []byte {
+	result := make([]byte, 2*pd.channels*len(frame))
+	for i, sample := range frame {
+		for channel := 0; channel < pd.channels; channel++ {
+			binary.LittleEndian.PutUint16(result[2*(pd.channels*i+channel):], uint16(int16(sample)))
+		}
+	}
+	return result
+}
+
+func (pd *pipeDevice) submit(audioDataList []models.AudioData) {
+	for _, audioData := range audioDataList {
+		pd.recordingChan <- audioData
+	}
+}
@@ -0,0 +1,171 @@
+package audioio
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PlivoMessage is a base struct for all Websocket events with Plivo audio streams.
+// https://www.plivo.com/docs/voice/xml/the-stream-element
+type PlivoMessage struct {
+	// Event either of "start", "media", "playedStream", "clearedAudio" inbound,
+	// or "playAudio", "checkpoint", "clearAudio" outbound.
+	Event    string `json:"event"`
+	StreamId string `json:"streamId,omitempty"`
+
+	Start *PlivoStartPayload `json:"start,omitempty"`
+	Media *PlivoMediaPayload `json:"media,omitempty"`
+	// ExtraHeaders for event = "start", the extraHeaders attribute of <Stream>
+	ExtraHeaders string `json:"extra_headers,omitempty"`
+	// Name for event = "playedStream" and "checkpoint"
+	Name string `json:"name,omitempty"`
+}
+
+type PlivoStartPayload struct {
+	CallId      string           `json:"callId"`
+	StreamId    string           `json:"streamId"`
+	Tracks      []string         `json:"tracks"`
+	MediaFormat PlivoMediaFormat `json:"mediaFormat"`
+}
+
+type PlivoMediaFormat struct {
+	Encoding   string `json:"encoding"`
+	SampleRate int    `json:"sampleRate"`
+}
+
+type PlivoMediaPayload struct {
+	// Track is only set inbound.
+	Track string `json:"track,omitempty"`
+	// Timestamp is only set inbound, in milliseconds since the stream start.
+	Timestamp string `json:"timestamp,omitempty"`
+	// ContentType and SampleRate are only set outbound.
+	ContentType string `json:"contentType,omitempty"`
+	SampleRate  int    `json:"sampleRate,omitempty"`
+	Payload     string `json:"payload"`
+}
+
+// plivoProtocol is the MediaStreamProtocol of Plivo bidirectional audio streams with mulaw audio.
+type plivoProtocol struct{}
+
+// NewPlivoProtocol returns a MediaStreamProtocol for a single Plivo stream.
+func NewPlivoProtocol() MediaStreamProtocol {
+	return &plivoProtocol{}
+}
+
+// NewPlivoHandler onStart can be nil, in which case all streams are accepted.
+func NewPlivoHandler(onStart MediaStreamStartHandler) *mediaStreamHandler {
+	return NewMediaStreamHandler(NewPlivoProtocol(), onStart)
+}
+
+// Name implements MediaStreamProtocol.Name
+func (p *plivoProtocol) Name() string {
+	return "plivo"
+}
+
+// ParseEvent implements MediaStreamProtocol.ParseEvent
+func (p *plivoProtocol) ParseEvent(msgBytes []byte) (MediaStreamEvent, error) {
+	result := MediaStreamEvent{Kind: MediaStreamIgnored, TimestampMs: -1}
+	var msg PlivoMessage
+	if err := json.Unmarshal(msgBytes, &msg); err != nil {
+		return result, fmt.Errorf("couldn't decode plivo message: %w", err)
+	}
+
+	switch msg.Event {
+	case "start":
+		if msg.Start == nil {
+			return result, fmt.Errorf("msg.Start is nil for msg.event = 'start'")
+		}
+		if msg.Start.MediaFormat.Encoding != "audio/x-mulaw" || msg.Start.MediaFormat.SampleRate != MediaStreamSampleRate {
+			log.Error().Msgf("unexpected media format in start.mediaFormat: %v", msg.Start.MediaFormat)
+		}
+		result.Kind = MediaStreamStarted
+		result.Start = &MediaStreamStart{
+			StreamId:         msg.Start.StreamId,
+			CallId:           msg.Start.CallId,
+			CustomParameters: parsePlivoExtraHeaders(msg.ExtraHeaders),
+		}
+	case "media":
+		if msg.Media == nil {
+			return result, fmt.Errorf("msg.Media is nil for msg.event = 'media'")
+		}
+		if msg.Media.Track != "" && msg.Media.Track != "inbound" {
+			log.Debug().Msgf("received track='%s' media type, ignoring", msg.Media.Track)
+			return result, nil
+		}
+		mulawAudioData, err := base64.StdEncoding.DecodeString(msg.Media.Payload)
+		if err != nil {
+			return result, fmt.Errorf("failed to decode base64 audio data: %w", err)
+		}
+		result.Kind = MediaStreamMedia
+		result.Media = mulawAudioData
+		// Plivo does not number the chunks, so mediaStreamHandler never logs them.
+		result.Chunk = 1
+		if timestampMs, err := strconv.Atoi(msg.Media.Timestamp); err == nil {
+			result.TimestampMs = timestampMs
+		}
+	case "playedStream":
+		result.Kind = MediaStreamMarked
+		result.MarkName = msg.Name
+	case "clearedAudio":
+	case "stop":
+		result.Kind = MediaStreamStopped
+	default:
+		return result, fmt.Errorf("unknown msg.Event %s", msg.Event)
+	}
+	return result, nil
+}
+
+// parsePlivoExtraHeaders accepts both a JSON object and "key1=value1,key2=value2" as Plivo documents both.
+func parsePlivoExtraHeaders(extraHeaders string) map[string]string {
+	result := make(map[string]string)
+	if extraHeaders == "" {
+		return result
+	}
+	if err := json.Unmarshal([]byte(extraHeaders), &result); err == nil {
+		return result
+	}
+	for _, pair := range strings.Split(extraHeaders, ",") {
+		name, value, found := strings.Cut(pair, "=")
+		if !found {
+			log.Warn().Msgf("ignoring plivo extra header without a value: %q", pair)
+			continue
+		}
+		result[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	return result
+}
+
+// NewMediaMessage implements MediaStreamProtocol.NewMediaMessage
+func (p *plivoProtocol) NewMediaMessage(_ MediaStreamStart, frame []byte, _ int, _ time.Duration) ([]byte, error) {
+	return json.Marshal(PlivoMessage{
+		Event: "playAudio",
+		Media: &PlivoMediaPayload{
+			ContentType: "audio/x-mulaw",
+			SampleRate:  MediaStreamSampleRate,
+			Payload:     base64.StdEncoding.EncodeToString(frame),
+		},
+	})
+}
+
+// NewMarkMessage implements MediaStreamProtocol.NewMarkMessage
+// Plivo calls marks "checkpoints", and echoes them back as "playedStream".
+func (p *plivoProtocol) NewMarkMessage(start MediaStreamStart, name string) ([]byte, error) {
+	return json.Marshal(PlivoMessage{
+		Event:    "checkpoint",
+		StreamId: start.StreamId,
+		Name:     name,
+	})
+}
+
+// NewClearMessage implements MediaStreamProtocol.NewClearMessage
+func (p *plivoProtocol) NewClearMessage(start MediaStreamStart) ([]byte, error) {
+	return json.Marshal(PlivoMessage{
+		Event:    "clearAudio",
+		StreamId: start.StreamId,
+	})
+}
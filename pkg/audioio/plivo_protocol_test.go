@@ -0,0 +1,133 @@
+package audioio
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+const plivoTestCallId = "aa9ca4e9-4f6a-4b1b-a1f3-0bdae3c1a1a5"
+const plivoTestStreamId = "b6a1d0d4-6c2e-4a8d-9a8a-2d0d3a5b7c11"
+
+func TestPlivoProtocolParseEvent(t *testing.T) {
+	protocol := NewPlivoProtocol()
+	startMsg := func(extraHeaders string) string {
+		return `{"event": "start", "sequenceNumber": 0, "start": {"callId": "` + plivoTestCallId + `", "streamId": "` + plivoTestStreamId + `", "accountId": "MAXXXXXXXXXXXXXXXXXX", "tracks": ["inbound"], "mediaFormat": {"encoding": "audio/x-mulaw", "sampleRate": 8000}}, "extra_headers": ` + extraHeaders + `}`
+	}
+	newStartEvent := func(customParameters map[string]string) MediaStreamEvent {
+		return MediaStreamEvent{Kind: MediaStreamStarted, TimestampMs: -1, Start: &MediaStreamStart{
+			StreamId:         plivoTestStreamId,
+			CallId:           plivoTestCallId,
+			CustomParameters: customParameters,
+		}}
+	}
+	tests := []struct {
+		name     string
+		msg      string
+		expected MediaStreamEvent
+	}{
+		{
+			name:     "start with json extra headers",
+			msg:      startMsg(`"{\"agent_profile_id\": \"sales\", \"name\": \"Alice\"}"`),
+			expected: newStartEvent(map[string]string{"agent_profile_id": "sales", "name": "Alice"}),
+		},
+		{
+			name:     "start with key value extra headers",
+			msg:      startMsg(`"agent_profile_id=sales, name=Alice,broken"`),
+			expected: newStartEvent(map[string]string{"agent_profile_id": "sales", "name": "Alice"}),
+		},
+		{
+			name:     "start without extra headers",
+			msg:      startMsg(`""`),
+			expected: newStartEvent(map[string]string{}),
+		},
+		{
+			name:     "inbound media",
+			msg:      `{"event": "media", "sequenceNumber": 3, "streamId": "` + plivoTestStreamId + `", "media": {"track": "inbound", "timestamp": "40", "chunk": 3, "payload": "/v7+/n9/f38="}}`,
+			expected: MediaStreamEvent{Kind: MediaStreamMedia, Media: mediaStreamTestPayload, Chunk: 1, TimestampMs: 40},
+		},
+		{
+			name:     "outbound media",
+			msg:      `{"event": "media", "sequenceNumber": 4, "streamId": "` + plivoTestStreamId + `", "media": {"track": "outbound", "timestamp": "40", "chunk": 3, "payload": "/v7+/n9/f38="}}`,
+			expected: MediaStreamEvent{Kind: MediaStreamIgnored, TimestampMs: -1},
+		},
+		{
+			name:     "played stream",
+			msg:      `{"event": "playedStream", "sequenceNumber": 5, "streamId": "` + plivoTestStreamId + `", "name": "utterance-1"}`,
+			expected: MediaStreamEvent{Kind: MediaStreamMarked, TimestampMs: -1, MarkName: "utterance-1"},
+		},
+		{
+			name:     "cleared audio",
+			msg:      `{"event": "clearedAudio", "sequenceNumber": 6, "streamId": "` + plivoTestStreamId + `"}`,
+			expected: MediaStreamEvent{Kind: MediaStreamIgnored, TimestampMs: -1},
+		},
+		{
+			name:     "stop",
+			msg:      `{"event": "stop", "sequenceNumber": 7, "streamId": "` + plivoTestStreamId + `"}`,
+			expected: MediaStreamEvent{Kind: MediaStreamStopped, TimestampMs: -1},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			event, err := protocol.ParseEvent([]byte(test.msg))
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkMediaStreamEvent(t, test.expected, event)
+		})
+	}
+}
+
+func TestPlivoProtocolParseEventMalformed(t *testing.T) {
+	protocol := NewPlivoProtocol()
+	for _, msg := range []string{
+		`{"event": "media"`,
+		`{"event": "start", "streamId": "` + plivoTestStreamId + `"}`,
+		`{"event": "media", "streamId": "` + plivoTestStreamId + `"}`,
+		`{"event": "media", "media": {"track": "inbound", "payload": "not base64!"}}`,
+		// What we send is never received.
+		`{"event": "playAudio", "media": {"contentType": "audio/x-mulaw", "sampleRate": 8000, "payload": "/v7+/n9/f38="}}`,
+	} {
+		if _, err := protocol.ParseEvent([]byte(msg)); err == nil {
+			t.Errorf("expected %s rejected", msg)
+		}
+	}
+}
+
+func TestPlivoProtocolOutboundMessages(t *testing.T) {
+	protocol := NewPlivoProtocol()
+	start := MediaStreamStart{StreamId: plivoTestStreamId, CallId: plivoTestCallId}
+
+	mediaMsg, err := protocol.NewMediaMessage(start, mediaStreamTestPayload, 3, 40*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkJson(t, `{"event":"playAudio","media":{"contentType":"audio/x-mulaw","sampleRate":8000,"payload":"/v7+/n9/f38="}}`, mediaMsg)
+	var playAudio PlivoMessage
+	if err := json.Unmarshal(mediaMsg, &playAudio); err != nil {
+		t.Fatal(err)
+	}
+	if payload, err := base64.StdEncoding.DecodeString(playAudio.Media.Payload); err != nil || !bytes.Equal(payload, mediaStreamTestPayload) {
+		t.Errorf("expected the frame round trip, got %v %v", payload, err)
+	}
+
+	markMsg, err := protocol.NewMarkMessage(start, "utterance-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkJson(t, `{"event":"checkpoint","streamId":"`+plivoTestStreamId+`","name":"utterance-1"}`, markMsg)
+	// Plivo answers the checkpoint with playedStream once played.
+	event, err := protocol.ParseEvent([]byte(`{"event": "playedStream", "streamId": "` + plivoTestStreamId + `", "name": "utterance-1"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkMediaStreamEvent(t, MediaStreamEvent{Kind: MediaStreamMarked, TimestampMs: -1, MarkName: "utterance-1"}, event)
+
+	clearMsg, err := protocol.NewClearMessage(start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkJson(t, `{"event":"clearAudio","streamId":"`+plivoTestStreamId+`"}`, clearMsg)
+}
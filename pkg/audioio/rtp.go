@@ -0,0 +1,170 @@
+package audioio
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const rtpVersion = 2
+const rtpHeaderSize = 12
+
+// RtpPacket https://datatracker.ietf.org/doc/html/rfc3550#section-5.1
+// CSRC-s and header extensions are skipped when parsing, and never sent.
+type RtpPacket struct {
+	PayloadType    int
+	Marker         bool
+	SequenceNumber uint16
+	Timestamp      uint32
+	Ssrc           uint32
+	Payload        []byte
+}
+
+// ParseRtpPacket parses a single RTP packet from one UDP datagram, the payload is NOT copied.
+func ParseRtpPacket(data []byte) (*RtpPacket, error) {
+	if len(data) < rtpHeaderSize {
+		return nil, fmt.Errorf("rtp packet too short: %d bytes", len(data))
+	}
+	if version := data[0] >> 6; version != rtpVersion {
+		return nil, fmt.Errorf("unsupported rtp version %d", version)
+	}
+	hasPadding := data[0]&0x20 != 0
+	hasExtension := data[0]&0x10 != 0
+	csrcCount := int(data[0] & 0x0f)
+
+	payloadStart := rtpHeaderSize + 4*csrcCount
+	if hasExtension {
+		if len(data) < payloadStart+4 {
+			return nil, fmt.Errorf("rtp packet too short for its header extension")
+		}
+		extensionLength := int(binary.BigEndian.Uint16(data[payloadStart+2:]))
+		payloadStart += 4 + 4*extensionLength
+	}
+	payloadEnd := len(data)
+	if hasPadding && payloadEnd > 0 {
+		payloadEnd -= int(data[payloadEnd-1])
+	}
+	if payloadStart > payloadEnd {
+		return nil, fmt.Errorf("rtp packet too short for its header")
+	}
+
+	return &RtpPacket{
+		PayloadType:    int(data[1] & 0x7f),
+		Marker:         data[1]&0x80 != 0,
+		SequenceNumber: binary.BigEndian.Uint16(data[2:]),
+		Timestamp:      binary.BigEndian.Uint32(data[4:]),
+		Ssrc:           binary.BigEndian.Uint32(data[8:]),
+		Payload:        data[payloadStart:payloadEnd],
+	}, nil
+}
+
+// Bytes serializes the packet with the fixed 12 byte header.
+func (p *RtpPacket) Bytes() []byte {
+	result := make([]byte, rtpHeaderSize+len(p.Payload))
+	result[0] = rtpVersion << 6
+	result[1] = byte(p.PayloadType & 0x7f)
+	if p.Marker {
+		result[1] |= 0x80
+	}
+	binary.BigEndian.PutUint16(result[2:], p.SequenceNumber)
+	binary.BigEndian.PutUint32(result[4:], p.Timestamp)
+	binary.BigEndian.PutUint32(result[8:], p.Ssrc)
+	copy(result[rtpHeaderSize:], p.Payload)
+	return result
+}
+
+// rtpJitterBuffer reorders the inbound packets, and hides the network jitter by holding back delay packets.
+// It is read on the receiver clock with Pop, so a packet which arrives too late is dropped,
+// and a missing one is played as silence by the caller.
+// Not safe for concurrent use.
+type rtpJitterBuffer struct {
+	// delay is how many packets are buffered before the playout starts, e.g. 3 for 60ms of 20ms packets.
+	delay int
+	// maxSize caps the buffer, e.g. when the sender clock runs faster than ours.
+	maxSize int
+
+	packets map[uint16]*RtpPacket
+	// nextSeq is the sequence number Pop returns next, only valid once isStarted.
+	nextSeq   uint16
+	isStarted bool
+	// isPlaying is false until delay packets are buffered, and again after the sender paused,
+	// e.g. with silence suppression.
+	isPlaying   bool
+	missedCount int
+}
+
+func newRtpJitterBuffer(delay int) *rtpJitterBuffer {
+	return &rtpJitterBuffer{
+		delay:       delay,
+		maxSize:     4 * delay,
+		packets:     make(map[uint16]*RtpPacket),
+		nextSeq:     0,
+		isStarted:   false,
+		isPlaying:   false,
+		missedCount: 0,
+	}
+}
+
+// Push returns false if the packet was dropped, as it came after its playout time, or is a duplicate.
+func (b *rtpJitterBuffer) Push(packet *RtpPacket) bool {
+	if !b.isStarted {
+		b.nextSeq = packet.SequenceNumber
+		b.isStarted = true
+	}
+	// The int16 cast makes the comparison work across the sequence number wrap around.
+	if int16(packet.SequenceNumber-b.nextSeq) < 0 {
+		if b.isPlaying {
+			return false
+		}
+		// Still buffering, so a packet reordered before the first one pushed is not late.
+		b.nextSeq = packet.SequenceNumber
+	}
+	if _, ok := b.packets[packet.SequenceNumber]; ok {
+		return false
+	}
+	b.packets[packet.SequenceNumber] = packet
+
+	// Too far behind, skip ahead to the oldest packet we have so the latency stays bounded.
+	for len(b.packets) > b.maxSize {
+		delete(b.packets, b.nextSeq)
+		b.nextSeq++
+		for b.packets[b.nextSeq] == nil {
+			b.nextSeq++
+		}
+	}
+	return true
+}
+
+// Pop is called once per packet duration, it returns:
+// * the next packet and true,
+// * nil and true when the packet is lost, i.e. the caller should play silence,
+// * nil and false when there is nothing to play, e.g. still buffering.
+func (b *rtpJitterBuffer) Pop() (*RtpPacket, bool) {
+	if !b.isPlaying {
+		if len(b.packets) < b.delay {
+			return nil, false
+		}
+		b.isPlaying = true
+		b.missedCount = 0
+		// The packets before the first one buffered might be lost.
+		for b.packets[b.nextSeq] == nil {
+			b.nextSeq++
+		}
+	}
+
+	packet := b.packets[b.nextSeq]
+	if packet == nil {
+		b.missedCount++
+		if len(b.packets) == 0 && b.missedCount >= b.delay {
+			// The sender paused, so buffer again once it resumes, from whatever sequence number it resumes with.
+			b.isPlaying = false
+			b.isStarted = false
+			return nil, false
+		}
+		b.nextSeq++
+		return nil, true
+	}
+	delete(b.packets, b.nextSeq)
+	b.nextSeq++
+	b.missedCount = 0
+	return packet, true
+}
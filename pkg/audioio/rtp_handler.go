@@ -0,0 +1,436 @@
+package audioio
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/go-audio/audio"
+	"github.com/petrzlen/vocode-golang/internal/networking"
+	"github.com/petrzlen/vocode-golang/pkg/audio_utils"
+	"github.com/petrzlen/vocode-golang/pkg/models"
+	"github.com/petrzlen/vocode-golang/pkg/telephony"
+	"github.com/rs/zerolog/log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RtpFrameDuration is the packetization time we send with, and expect from the PBX (a=ptime:20).
+const RtpFrameDuration = 20 * time.Millisecond
+
+// RtpFrameSize is the number of samples (and PCMU / PCMA bytes) in one RtpFrameDuration.
+const RtpFrameSize = telephony.SdpSampleRate * int(RtpFrameDuration/time.Millisecond) / 1000
+
+// rtpJitterDelay in packets, i.e. 60ms, which is plenty on a LAN with the PBX.
+const rtpJitterDelay = 3
+
+// rtpSilenceAmplitude below which a sample counts as silence, the PBX forwards the line noise same as Vonage.
+const rtpSilenceAmplitude = 100
+
+// rtpHandler is the twilioHandler for a direct SIP call, the audio goes over RTP in PCMU or PCMA.
+// The SIP signaling itself is done by networking.SipServer, which creates it for each INVITE.
+type rtpHandler struct {
+	call        networking.SipCall
+	payloadType int
+	conn        *net.UDPConn
+	// remoteAddr is where we send to, it is updated to the source of the inbound packets (symmetric RTP),
+	// so it works even when the PBX is behind NAT.
+	remoteAddr atomic.Pointer[net.UDPAddr]
+	startTime  time.Time
+	// writeMutex guards isStopped, conn and onShutdown.
+	writeMutex  sync.Mutex
+	isStopped   bool
+	onShutdown  func()
+	stoppedChan chan struct{}
+	finishOnce  sync.Once
+	// clockWaitGroup lets finish wait for clockRoutine, as it writes into recordingChan.
+	clockWaitGroup sync.WaitGroup
+
+	// The PBX expects RTP packets at a steady pace, so the outbound frames wait in playQueue to be sent in real time.
+	playMutex sync.Mutex
+	playQueue [][]byte
+	// Timestamp (since startTime) of the end of playQueue, to put the outbound audio on the recording timeline.
+	playNextTimestamp time.Duration
+	ssrc              uint32
+	sequenceNumber    uint16
+	rtpTimestamp      uint32
+
+	jitterMutex  sync.Mutex
+	jitterBuffer *rtpJitterBuffer
+
+	// Both sides of the call for QA, saved into recordingSink when the call ends.
+	recorder      *callRecorder
+	recordingSink RecordingSink
+
+	// Package interface
+	recordingChan chan models.AudioData
+	chunker       *speechChunker
+}
+
+// NewRtpHandler the audio only starts flowing on networking.SipCallHandler.StartRtp, what is played before is queued.
+func NewRtpHandler(call networking.SipCall) *rtpHandler {
+	randomBytes := make([]byte, 8)
+	_, _ = rand.Read(randomBytes)
+
+	return &rtpHandler{
+		call:        call,
+		payloadType: call.PayloadType,
+		conn:        nil,
+		isStopped:   false,
+		onShutdown:  nil,
+		stoppedChan: make(chan struct{}),
+
+		playQueue:         make([][]byte, 0),
+		playNextTimestamp: 0,
+		// Both should be random https://datatracker.ietf.org/doc/html/rfc3550#section-5.1
+		ssrc:           binary.BigEndian.Uint32(randomBytes),
+		sequenceNumber: binary.BigEndian.Uint16(randomBytes[4:]),
+		rtpTimestamp:   0,
+
+		jitterBuffer: newRtpJitterBuffer(rtpJitterDelay),
+
+		recorder:      newCallRecorder(telephony.SdpSampleRate),
+		recordingSink: NewLocalDirRecordingSink("output"),
+
+		// Package interface
+		recordingChan: nil,
+		chunker: newSpeechChunker(telephony.SdpSampleRate, "sip", func(sample int16) bool {
+			return -rtpSilenceAmplitude < sample && sample < rtpSilenceAmplitude
+		}),
+	}
+}
+
+// StartRtp implements networking.SipCallHandler.StartRtp
+func (rh *rtpHandler) StartRtp(conn *net.UDPConn, remote *net.UDPAddr) {
+	rh.writeMutex.Lock()
+	defer rh.writeMutex.Unlock()
+	if rh.isStopped {
+		errLog(conn.Close(), "rtp conn.Close() of stopped rtpHandler")
+		return
+	}
+	rh.conn = conn
+	rh.remoteAddr.Store(remote)
+	// Play reads startTime under playMutex.
+	rh.playMutex.Lock()
+	rh.startTime = time.Now()
+	rh.playMutex.Unlock()
+	rh.chunker.onSpeech = rh.recorder.AddCallerTurn
+
+	log.Info().Str("call_id", rh.call.CallId).Str("local_addr", conn.LocalAddr().String()).Str("remote_addr", remote.String()).Int("payload_type", rh.payloadType).Msg("rtp started")
+	rh.clockWaitGroup.Add(1)
+	go rh.receiveRoutine()
+	go rh.clockRoutine()
+}
+
+// StartRecording implements InputDevice.StartRecording
+func (rh *rtpHandler) StartRecording(recordingChan chan models.AudioData) error {
+	rh.recordingChan = recordingChan
+	return nil
+}
+
+// SetRecordingSink sets where the stereo call recording is saved once the call ends, nil disables it.
+func (rh *rtpHandler) SetRecordingSink(sink RecordingSink) {
+	rh.recordingSink = sink
+}
+
+// SetSilenceThresholds implements SilenceThresholdSetter.SetSilenceThresholds
+func (rh *rtpHandler) SetSilenceThresholds(speech time.Duration, silence time.Duration) {
+	rh.chunker.SetSilenceThresholds(speech, silence)
+}
+
+// StreamAudioFrames implements AudioFrameStreamer.StreamAudioFrames
+func (rh *rtpHandler) StreamAudioFrames() {
+	rh.chunker.StreamAudioFrames()
+}
+
+// OnShutdown implements ShutdownNotifier.OnShutdown
+func (rh *rtpHandler) OnShutdown(callback func()) {
+	rh.writeMutex.Lock()
+	defer rh.writeMutex.Unlock()
+	rh.onShutdown = callback
+}
+
+// Shutdown implements networking.ShutdownAwareHandler.Shutdown
+func (rh *rtpHandler) Shutdown() {
+	rh.writeMutex.Lock()
+	onShutdown := rh.onShutdown
+	rh.writeMutex.Unlock()
+
+	if onShutdown == nil {
+		errLog(rh.Stop(), "rtpHandler.Stop on shutdown")
+		return
+	}
+	log.Info().Str("call_id", rh.call.CallId).Msg("rtpHandler wrapping up the call on shutdown")
+	onShutdown()
+}
+
+func (rh *rtpHandler) StopRecording() ([]byte, error) {
+	err := rh.Stop()
+
+	return nil, err
+}
+
+// Stop implements OutputDevice.Stop and networking.SipCallHandler.Stop
+// It hangs up the call, unless the caller already did.
+func (rh *rtpHandler) Stop() error {
+	rh.writeMutex.Lock()
+	if rh.isStopped {
+		rh.writeMutex.Unlock()
+		log.Debug().Str("call_id", rh.call.CallId).Msg("rtpHandler already stopped")
+		return nil
+	}
+	rh.isStopped = true
+	conn := rh.conn
+	close(rh.stoppedChan)
+	rh.writeMutex.Unlock()
+	log.Info().Str("call_id", rh.call.CallId).Msg("rtpHandler stop")
+
+	if rh.call.Hangup != nil {
+		rh.call.Hangup()
+	}
+	if conn == nil {
+		// Never started, so there is no receiveRoutine to finish.
+		rh.finish()
+		return nil
+	}
+	// This ends the receiveRoutine, which then closes the recordingChan.
+	return conn.Close()
+}
+
+// Hangup implements CallController.Hangup, once everything queued was played to the caller.
+func (rh *rtpHandler) Hangup() error {
+	go func() {
+		for {
+			rh.playMutex.Lock()
+			isPlaying := len(rh.playQueue) > 0
+			rh.playMutex.Unlock()
+			if !isPlaying {
+				errLog(rh.Stop(), "rtpHandler.Stop on hangup")
+				return
+			}
+			select {
+			case <-rh.stoppedChan:
+				return
+			case <-time.After(RtpFrameDuration):
+			}
+		}
+	}()
+	return nil
+}
+
+// Transfer implements CallController.Transfer
+// TODO(P1, ux): Implement with SIP REFER.
+func (rh *rtpHandler) Transfer(target string) error {
+	return fmt.Errorf("transfer to %s is not supported over SIP yet", target)
+}
+
+// SendDigits implements CallController.SendDigits
+// TODO(P1, ux): Implement with RFC 4733 telephone-event.
+func (rh *rtpHandler) SendDigits(digits string) error {
+	return fmt.Errorf("sending digits %s is not supported over SIP yet", digits)
+}
+
+// Play implements OutputDevice.Play
+// The audio is queued, and sent one RtpFrameDuration packet at a time by clockRoutine.
+func (rh *rtpHandler) Play(intBuffer *audio.IntBuffer) (*sync.WaitGroup, error) {
+	if intBuffer.Format == nil || intBuffer.Format.NumChannels != 1 {
+		return nil, fmt.Errorf("rtpHandler can only play mono audio")
+	}
+	payload, err := rh.encode(intBuffer)
+	if err != nil {
+		return nil, err
+	}
+
+	rh.playMutex.Lock()
+	defer rh.playMutex.Unlock()
+	// The queue was empty for a while, so this continues after a gap of silence.
+	if sinceStart := time.Since(rh.startTime); len(rh.playQueue) == 0 && !rh.startTime.IsZero() && rh.playNextTimestamp < sinceStart {
+		rh.playNextTimestamp = sinceStart.Truncate(time.Millisecond)
+	}
+	rh.recorder.AddOutbound(rh.playNextTimestamp.Milliseconds(), rh.decode(payload))
+
+	for start := 0; start < len(payload); start += RtpFrameSize {
+		frame := bytes.Repeat([]byte{rh.getSilenceByte()}, RtpFrameSize) // Pads the last frame with silence.
+		copy(frame, payload[start:min(start+RtpFrameSize, len(payload))])
+		rh.playQueue = append(rh.playQueue, frame)
+		rh.playNextTimestamp += RtpFrameDuration
+	}
+	return nil, nil
+}
+
+func (rh *rtpHandler) encode(intBuffer *audio.IntBuffer) ([]byte, error) {
+	if rh.payloadType == telephony.RtpPayloadTypePcma {
+		return audio_utils.EncodeToAlaw(intBuffer, telephony.SdpSampleRate), nil
+	}
+	mulawBytes, err := audio_utils.EncodeToMulaw(intBuffer, telephony.SdpSampleRate)
+	if err != nil {
+		return nil, fmt.Errorf("cannot convert intBuffer into mulawBytes: %w", err)
+	}
+	return mulawBytes, nil
+}
+
+func (rh *rtpHandler) decode(payload []byte) []int {
+	if rh.payloadType == telephony.RtpPayloadTypePcma {
+		return audio_utils.DecodeFromAlaw(payload, telephony.SdpSampleRate).Data
+	}
+	return audio_utils.DecodeFromMulaw(payload, telephony.SdpSampleRate).Data
+}
+
+// getSilenceByte is the encoded zero sample.
+func (rh *rtpHandler) getSilenceByte() byte {
+	if rh.payloadType == telephony.RtpPayloadTypePcma {
+		return audio_utils.AlawSilenceByte
+	}
+	return MulawSilenceByte
+}
+
+// clockRoutine sends one packet, and plays out one inbound packet each RtpFrameDuration.
+func (rh *rtpHandler) clockRoutine() {
+	defer rh.clockWaitGroup.Done()
+	ticker := time.NewTicker(RtpFrameDuration)
+	defer ticker.Stop()
+	isFirst := true
+	for {
+		select {
+		case <-rh.stoppedChan:
+			return
+		case <-ticker.C:
+			rh.sendFrame(isFirst)
+			isFirst = false
+			rh.playoutFrame()
+		}
+	}
+}
+
+// sendFrame sends silence when there is nothing to play, so the PBX does not time out the media,
+// and the NAT bindings on the way stay open.
+func (rh *rtpHandler) sendFrame(isFirst bool) {
+	rh.playMutex.Lock()
+	frame := bytes.Repeat([]byte{rh.getSilenceByte()}, RtpFrameSize)
+	if len(rh.playQueue) > 0 {
+		frame = rh.playQueue[0]
+		rh.playQueue = rh.playQueue[1:]
+	}
+	rh.playMutex.Unlock()
+
+	packet := RtpPacket{
+		PayloadType:    rh.payloadType,
+		Marker:         isFirst,
+		SequenceNumber: rh.sequenceNumber,
+		Timestamp:      rh.rtpTimestamp,
+		Ssrc:           rh.ssrc,
+		Payload:        frame,
+	}
+	rh.sequenceNumber++
+	rh.rtpTimestamp += uint32(RtpFrameSize)
+
+	_, err := rh.conn.WriteToUDP(packet.Bytes(), rh.remoteAddr.Load())
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		errLog(err, "rtp WriteToUDP")
+	}
+}
+
+// playoutFrame takes the next inbound packet out of the jitter buffer, lost packets are replaced by silence.
+func (rh *rtpHandler) playoutFrame() {
+	rh.jitterMutex.Lock()
+	packet, ok := rh.jitterBuffer.Pop()
+	rh.jitterMutex.Unlock()
+	if !ok {
+		return
+	}
+
+	samples := make([]int, RtpFrameSize)
+	if packet != nil {
+		samples = rh.decode(packet.Payload)
+	}
+	// RTP timestamps are not wall-clock, so the inbound timeline is just the samples received.
+	rh.recorder.AddInbound(int64(rh.chunker.Len()*1000/telephony.SdpSampleRate), samples)
+	for _, audioData := range rh.chunker.Add(samples) {
+		// Nobody might read anymore once stopped, and finish waits for us.
+		select {
+		case rh.recordingChan <- audioData:
+		case <-rh.stoppedChan:
+			return
+		}
+	}
+}
+
+func (rh *rtpHandler) receiveRoutine() {
+	buffer := make([]byte, 1500)
+	isSymmetric := false
+	for {
+		n, sourceAddr, err := rh.conn.ReadFromUDP(buffer)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				errLog(err, "rtp ReadFromUDP")
+			}
+			break
+		}
+		packet, err := ParseRtpPacket(append([]byte(nil), buffer[:n]...))
+		if err != nil {
+			log.Debug().Err(err).Str("call_id", rh.call.CallId).Msg("ignoring malformed rtp packet")
+			continue
+		}
+		if packet.PayloadType != rh.payloadType {
+			// E.g. comfort noise, or telephone-event for DTMF.
+			log.Trace().Str("call_id", rh.call.CallId).Int("payload_type", packet.PayloadType).Msg("ignoring rtp packet of other payload type")
+			continue
+		}
+		if !isSymmetric {
+			if sourceAddr.String() != rh.remoteAddr.Load().String() {
+				log.Info().Str("call_id", rh.call.CallId).Str("sdp_addr", rh.remoteAddr.Load().String()).Str("source_addr", sourceAddr.String()).Msg("rtp source differs from sdp, sending there")
+				rh.remoteAddr.Store(sourceAddr)
+			}
+			isSymmetric = true
+		}
+
+		rh.jitterMutex.Lock()
+		isPushed := rh.jitterBuffer.Push(packet)
+		rh.jitterMutex.Unlock()
+		if !isPushed {
+			log.Trace().Str("call_id", rh.call.CallId).Uint16("sequence_number", packet.SequenceNumber).Msg("dropped late rtp packet")
+		}
+	}
+
+	// After reading done, there is no more to produce, nor anyone to write to.
+	errLog(rh.Stop(), "rtpHandler.Stop after conn closed")
+	rh.finish()
+}
+
+// finish closes the recordingChan and saves the recording, only once.
+// It must be called after Stop, so clockRoutine returns and no longer writes into recordingChan.
+func (rh *rtpHandler) finish() {
+	rh.finishOnce.Do(func() {
+		rh.clockWaitGroup.Wait()
+		if rh.recordingChan != nil {
+			log.Info().Str("call_id", rh.call.CallId).Msg("rh.recordingChan CLOSE")
+			close(rh.recordingChan)
+		}
+		rh.saveRecording()
+	})
+}
+
+// saveRecording stores the stereo recording of both sides of the call into the recordingSink.
+func (rh *rtpHandler) saveRecording() {
+	if rh.recordingSink == nil {
+		log.Debug().Str("call_id", rh.call.CallId).Msg("no call recording to save")
+		return
+	}
+
+	wavBytes, metadata, err := rh.recorder.EncodeStereoWav()
+	if err != nil {
+		errLog(err, "callRecorder.EncodeStereoWav")
+		return
+	}
+	if len(wavBytes) == 0 {
+		log.Info().Str("call_id", rh.call.CallId).Msg("call recording is empty, not saving")
+		return
+	}
+	metadata.CallSid = rh.call.CallId
+
+	log.Info().Str("call_id", rh.call.CallId).Msgf("rtp finished, gonna save %d bytes of call recording", len(wavBytes))
+	errLog(rh.recordingSink.Save(newRecordingName("sip"), wavBytes, metadata), "recordingSink.Save")
+}
@@ -0,0 +1,278 @@
+package audioio
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-audio/audio"
+	"github.com/petrzlen/vocode-golang/internal/networking"
+	"github.com/petrzlen/vocode-golang/pkg/audio_utils"
+	"github.com/petrzlen/vocode-golang/pkg/models"
+	"github.com/petrzlen/vocode-golang/pkg/telephony"
+	"math"
+	"net"
+	"testing"
+	"time"
+)
+
+// sipTestPeer is the PBX side of a call, same as cmd/sipcall but scripted by the test.
+type sipTestPeer struct {
+	t          *testing.T
+	conn       *net.UDPConn
+	serverAddr *net.UDPAddr
+	callId     string
+	fromTag    string
+	toHeader   string
+	cseq       int
+	messages   chan *telephony.SipMessage
+}
+
+func newSipTestPeer(t *testing.T, serverAddr *net.UDPAddr, callId string) *sipTestPeer {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	peer := &sipTestPeer{
+		t:          t,
+		conn:       conn,
+		serverAddr: serverAddr,
+		callId:     callId,
+		fromTag:    telephony.NewSipTag(),
+		toHeader:   fmt.Sprintf("<sip:vocode@%s>", serverAddr),
+		messages:   make(chan *telephony.SipMessage, 100),
+	}
+	go func() {
+		buffer := make([]byte, 65535)
+		for {
+			n, _, err := conn.ReadFromUDP(buffer)
+			if err != nil {
+				return
+			}
+			msg, err := telephony.ParseSipMessage(append([]byte(nil), buffer[:n]...))
+			if err != nil {
+				t.Errorf("malformed sip message from the server: %v", err)
+				continue
+			}
+			peer.messages <- msg
+		}
+	}()
+	return peer
+}
+
+func (p *sipTestPeer) newRequest(method string) *telephony.SipMessage {
+	p.cseq++
+	localAddr := p.conn.LocalAddr().String()
+	request := &telephony.SipMessage{Method: method, RequestUri: "sip:vocode@" + p.serverAddr.String()}
+	request.AddHeader("Via", fmt.Sprintf("SIP/2.0/UDP %s;branch=%s%s;rport", localAddr, telephony.SipBranchPrefix, telephony.NewSipTag()))
+	request.AddHeader("Max-Forwards", "70")
+	request.AddHeader("From", fmt.Sprintf("<sip:+14155550100@%s>;tag=%s", localAddr, p.fromTag))
+	request.AddHeader("To", p.toHeader)
+	request.AddHeader("Call-ID", p.callId)
+	request.AddHeader("CSeq", fmt.Sprintf("%d %s", p.cseq, method))
+	request.AddHeader("Contact", fmt.Sprintf("<sip:+14155550100@%s>", localAddr))
+	return request
+}
+
+func (p *sipTestPeer) send(msg *telephony.SipMessage) {
+	if _, err := p.conn.WriteToUDP(msg.Bytes(), p.serverAddr); err != nil {
+		p.t.Fatal(err)
+	}
+}
+
+// recvFinalResponse skips the provisional responses, and the retransmissions of the earlier ones.
+func (p *sipTestPeer) recvFinalResponse(method string) *telephony.SipMessage {
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case msg := <-p.messages:
+			if msg.IsRequest() {
+				p.t.Fatalf("unexpected sip request %s", msg.Method)
+			}
+			if _, cseqMethod, err := msg.GetCSeq(); err != nil || cseqMethod != method || msg.StatusCode < 200 {
+				continue
+			}
+			return msg
+		case <-timeout:
+			p.t.Fatalf("no final response to %s", method)
+		}
+	}
+}
+
+func newTestToneSamples(duration time.Duration) []int {
+	samples := make([]int, int(duration.Seconds()*telephony.SdpSampleRate))
+	for i := range samples {
+		samples[i] = int(8000 * math.Sin(2*math.Pi*440*float64(i)/telephony.SdpSampleRate))
+	}
+	return samples
+}
+
+func getMaxAmplitude(samples []int) int {
+	result := 0
+	for _, sample := range samples {
+		result = max(result, sample, -sample)
+	}
+	return result
+}
+
+func TestRtpHandlerSipCallOverLoopback(t *testing.T) {
+	recordingChan := make(chan models.AudioData, 100)
+	sink := chanRecordingSink{savedChan: make(chan RecordingMetadata, 1)}
+	callChan := make(chan networking.SipCall, 1)
+	server := networking.NewSipServer(networking.SipServerConfig{ListenAddr: "127.0.0.1:0", PublicIp: "127.0.0.1"}, func(call networking.SipCall) (networking.SipCallHandler, error) {
+		callChan <- call
+		handler := NewRtpHandler(call)
+		handler.SetRecordingSink(sink)
+		handler.SetSilenceThresholds(200*time.Millisecond, 300*time.Millisecond)
+		if err := handler.StartRecording(recordingChan); err != nil {
+			return nil, err
+		}
+		// The greeting is queued until the caller acknowledged the answer, and then played for a few seconds,
+		// so the BYE comes in the middle of it.
+		_, err := handler.Play(&audio.IntBuffer{
+			Data:   newTestToneSamples(3 * time.Second),
+			Format: &audio.Format{SampleRate: telephony.SdpSampleRate, NumChannels: 1},
+		})
+		return handler, err
+	})
+	serveErrChan := make(chan error, 1)
+	go func() {
+		serveErrChan <- server.ListenAndServe()
+	}()
+	for server.LocalAddr() == nil {
+		time.Sleep(time.Millisecond)
+	}
+
+	// == INVITE -> 200 OK -> ACK
+	peer := newSipTestPeer(t, server.LocalAddr().(*net.UDPAddr), "call-1@127.0.0.1")
+	rtpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rtpConn.Close()
+	invite := peer.newRequest("INVITE")
+	invite.AddHeader(networking.SipParameterHeaderPrefix+"Agent-Profile-Id", "sales")
+	invite.AddHeader("Content-Type", "application/sdp")
+	invite.Body = telephony.RenderSdp(telephony.SdpSession{
+		Address:      "127.0.0.1",
+		Port:         rtpConn.LocalAddr().(*net.UDPAddr).Port,
+		PayloadTypes: []int{telephony.RtpPayloadTypePcmu},
+		SessionId:    1,
+	})
+	peer.send(invite)
+	response := peer.recvFinalResponse("INVITE")
+	if response.StatusCode != 200 {
+		t.Fatalf("expected the call answered, got %d %s", response.StatusCode, response.Reason)
+	}
+	call := <-callChan
+	if call.Parameters["agent_profile_id"] != "sales" || call.From != "+14155550100" || call.PayloadType != telephony.RtpPayloadTypePcmu {
+		t.Errorf("unexpected call %+v", call)
+	}
+	answer, err := telephony.ParseSdp(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverRtpAddr := &net.UDPAddr{IP: net.ParseIP(answer.Address), Port: answer.Port}
+	peer.toHeader = response.GetHeader("To")
+	ack := peer.newRequest("ACK")
+	peer.cseq--
+	ack.SetHeader("CSeq", fmt.Sprintf("%d ACK", peer.cseq))
+	peer.send(ack)
+
+	// == RTP both ways, the caller says "aaa" for 600ms and then stays silent.
+	inboundPayload, err := audio_utils.EncodeToMulaw(&audio.IntBuffer{
+		Data:   append(newTestToneSamples(600*time.Millisecond), make([]int, 2*telephony.SdpSampleRate)...),
+		Format: &audio.Format{SampleRate: telephony.SdpSampleRate, NumChannels: 1},
+	}, telephony.SdpSampleRate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		packet := RtpPacket{PayloadType: telephony.RtpPayloadTypePcmu, Ssrc: 0x5eed}
+		for start := 0; start+RtpFrameSize <= len(inboundPayload); start += RtpFrameSize {
+			packet.Payload = inboundPayload[start : start+RtpFrameSize]
+			if _, err := rtpConn.WriteToUDP(packet.Bytes(), serverRtpAddr); err != nil {
+				return
+			}
+			packet.SequenceNumber++
+			packet.Timestamp += uint32(RtpFrameSize)
+			time.Sleep(RtpFrameDuration)
+		}
+	}()
+
+	// The greeting comes first, in 20ms packets of a single stream.
+	buffer := make([]byte, 1500)
+	var firstPacket *RtpPacket
+	for i := 0; i < 10; i++ {
+		if err := rtpConn.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+			t.Fatal(err)
+		}
+		n, _, err := rtpConn.ReadFromUDP(buffer)
+		if err != nil {
+			t.Fatalf("no rtp from the server: %v", err)
+		}
+		packet, err := ParseRtpPacket(append([]byte(nil), buffer[:n]...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if firstPacket == nil {
+			firstPacket = packet
+			if !packet.Marker {
+				t.Error("expected the marker bit on the first packet")
+			}
+		}
+		if packet.PayloadType != telephony.RtpPayloadTypePcmu || packet.Ssrc != firstPacket.Ssrc || len(packet.Payload) != RtpFrameSize ||
+			packet.SequenceNumber != firstPacket.SequenceNumber+uint16(i) || packet.Timestamp != firstPacket.Timestamp+uint32(i*RtpFrameSize) {
+			t.Fatalf("unexpected rtp packet %d: %+v", i, packet)
+		}
+		if amplitude := getMaxAmplitude(audio_utils.DecodeFromMulaw(packet.Payload, telephony.SdpSampleRate).Data); amplitude < 4000 {
+			t.Errorf("expected the greeting in rtp packet %d, got max amplitude %d", i, amplitude)
+		}
+	}
+
+	var events []models.AudioData
+	for len(events) < 2 {
+		select {
+		case audioData := <-recordingChan:
+			events = append(events, audioData)
+		case <-time.After(3 * time.Second):
+			t.Fatalf("expected the speech followed by a submit, got %d events", len(events))
+		}
+	}
+	if events[0].EventType != models.AudioInput || events[1].EventType != models.SubmitPrompt {
+		t.Fatalf("expected the speech followed by a submit, got %v and %v", events[0].EventType, events[1].EventType)
+	}
+	if events[0].Length < 500*time.Millisecond || events[0].Length > 700*time.Millisecond {
+		t.Errorf("expected about 600ms of speech, got %s", events[0].Length)
+	}
+
+	// == BYE while the greeting is still playing.
+	peer.send(peer.newRequest("BYE"))
+	if response := peer.recvFinalResponse("BYE"); response.StatusCode != 200 {
+		t.Errorf("expected the BYE answered, got %d", response.StatusCode)
+	}
+	for audioData := range recordingChan {
+		t.Errorf("unexpected event %v after the submit", audioData.EventType)
+	}
+	select {
+	case metadata := <-sink.savedChan:
+		if metadata.CallSid != call.CallId || metadata.SampleRate != telephony.SdpSampleRate {
+			t.Errorf("unexpected call recording metadata %+v", metadata)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the call recording was not saved")
+	}
+	if numCalls := server.Count(); numCalls != 0 {
+		t.Errorf("expected no active calls, got %d", numCalls)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Error(err)
+	}
+	if err := <-serveErrChan; err != networking.ErrSipServerClosed {
+		t.Errorf("expected ErrSipServerClosed, got %v", err)
+	}
+}
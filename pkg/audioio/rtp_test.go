@@ -0,0 +1,128 @@
+package audioio
+
+import (
+	"bytes"
+	"testing"
+)
+
+func newTestRtpPacket(sequenceNumber uint16) *RtpPacket {
+	return &RtpPacket{PayloadType: 0, SequenceNumber: sequenceNumber, Payload: []byte{byte(sequenceNumber)}}
+}
+
+// popAll pops n times, and returns the sequence numbers, -1 for a lost packet and -2 for nothing to play.
+func popAll(b *rtpJitterBuffer, n int) []int {
+	var result []int
+	for i := 0; i < n; i++ {
+		packet, ok := b.Pop()
+		switch {
+		case !ok:
+			result = append(result, -2)
+		case packet == nil:
+			result = append(result, -1)
+		default:
+			result = append(result, int(packet.SequenceNumber))
+		}
+	}
+	return result
+}
+
+func checkSequence(t *testing.T, expected []int, got []int) {
+	t.Helper()
+	if len(expected) != len(got) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+	for i := range expected {
+		if expected[i] != got[i] {
+			t.Fatalf("expected %v, got %v", expected, got)
+		}
+	}
+}
+
+func TestRtpPacketRoundTrip(t *testing.T) {
+	packet := RtpPacket{PayloadType: 8, Marker: true, SequenceNumber: 65535, Timestamp: 160, Ssrc: 0x5eed, Payload: []byte{1, 2, 3}}
+	parsed, err := ParseRtpPacket(packet.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.PayloadType != 8 || !parsed.Marker || parsed.SequenceNumber != 65535 || parsed.Timestamp != 160 || parsed.Ssrc != 0x5eed || !bytes.Equal(parsed.Payload, packet.Payload) {
+		t.Errorf("unexpected round trip %+v", parsed)
+	}
+	if _, err := ParseRtpPacket([]byte{0x80, 0}); err == nil {
+		t.Error("expected a too short packet rejected")
+	}
+}
+
+func TestRtpJitterBufferReordersPackets(t *testing.T) {
+	b := newRtpJitterBuffer(3)
+	for _, sequenceNumber := range []uint16{11, 10, 13, 12} {
+		if !b.Push(newTestRtpPacket(sequenceNumber)) {
+			t.Fatalf("packet %d dropped", sequenceNumber)
+		}
+	}
+	// The first packet pushed was not the earliest one.
+	checkSequence(t, []int{10, 11, 12, 13}, popAll(b, 4))
+}
+
+func TestRtpJitterBufferBuffersBeforePlayout(t *testing.T) {
+	b := newRtpJitterBuffer(3)
+	b.Push(newTestRtpPacket(1))
+	b.Push(newTestRtpPacket(2))
+	checkSequence(t, []int{-2}, popAll(b, 1))
+	b.Push(newTestRtpPacket(3))
+	checkSequence(t, []int{1, 2, 3}, popAll(b, 3))
+}
+
+func TestRtpJitterBufferDropsDuplicatesAndLatePackets(t *testing.T) {
+	b := newRtpJitterBuffer(3)
+	for _, sequenceNumber := range []uint16{1, 2, 3} {
+		b.Push(newTestRtpPacket(sequenceNumber))
+	}
+	if b.Push(newTestRtpPacket(2)) {
+		t.Error("expected the duplicate of a buffered packet dropped")
+	}
+	checkSequence(t, []int{1, 2}, popAll(b, 2))
+	if b.Push(newTestRtpPacket(1)) {
+		t.Error("expected the duplicate of a played packet dropped")
+	}
+	if b.Push(newTestRtpPacket(0)) {
+		t.Error("expected a packet after its playout time dropped")
+	}
+	checkSequence(t, []int{3}, popAll(b, 1))
+}
+
+func TestRtpJitterBufferLostPackets(t *testing.T) {
+	b := newRtpJitterBuffer(3)
+	for _, sequenceNumber := range []uint16{20, 21, 23, 24} {
+		b.Push(newTestRtpPacket(sequenceNumber))
+	}
+	// The lost 22 is played as silence, and once the sender paused for the delay, it buffers again.
+	checkSequence(t, []int{20, 21, -1, 23, 24, -1, -1, -2}, popAll(b, 8))
+
+	// The sender resumes with whatever sequence number.
+	for _, sequenceNumber := range []uint16{500, 501, 502} {
+		if !b.Push(newTestRtpPacket(sequenceNumber)) {
+			t.Fatalf("packet %d after the pause dropped", sequenceNumber)
+		}
+	}
+	checkSequence(t, []int{500, 501, 502}, popAll(b, 3))
+}
+
+func TestRtpJitterBufferSequenceWrapAround(t *testing.T) {
+	b := newRtpJitterBuffer(3)
+	for _, sequenceNumber := range []uint16{65534, 0, 65535} {
+		b.Push(newTestRtpPacket(sequenceNumber))
+	}
+	checkSequence(t, []int{65534, 65535, 0}, popAll(b, 3))
+	if b.Push(newTestRtpPacket(65535)) {
+		t.Error("expected the packet before the wrap around dropped as late")
+	}
+}
+
+func TestRtpJitterBufferSkipsAheadWhenFull(t *testing.T) {
+	b := newRtpJitterBuffer(3)
+	// The sender clock runs ahead of ours, so the oldest packet gives way.
+	for sequenceNumber := uint16(0); sequenceNumber <= uint16(b.maxSize); sequenceNumber++ {
+		b.Push(newTestRtpPacket(sequenceNumber))
+	}
+	checkSequence(t, []int{1, 2}, popAll(b, 2))
+}
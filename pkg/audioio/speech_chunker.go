@@ -0,0 +1,225 @@
+package audioio
+
+import (
+	"fmt"
+	"github.com/go-audio/audio"
+	"github.com/petrzlen/vocode-golang/pkg/audio_utils"
+	"github.com/petrzlen/vocode-golang/pkg/models"
+	"github.com/rs/zerolog/log"
+	"os"
+	"time"
+)
+
+// speechChunker buffers the inbound audio of a call, and cuts it into chunks of speech on silence,
+// so the transcriber gets the audio early, and the agent gets the prompt after a long enough pause.
+// It is shared by the telephony handlers, as only how silence looks differs between them.
+//
+// TODO(P1, devx): Feels like the "VAD" or "silence detection" should be abstracted away
+// as this custom logic is getting overly complex.
+// BUT then every input method has different sensitivity / properties / expectations from UX.
+type speechChunker struct {
+	sampleRate int
+	traceName  string
+	isSilence  func(sample int16) bool
+	// onSpeech is called with the sample offsets of each speech chunk submitted, e.g. to record the caller turn.
+	onSpeech func(startIdx int, endIdx int)
+	// streamFrames passes the samples on as models.AudioFrame right away, instead of chunking them on silence.
+	streamFrames bool
+
+	// All samples are kept as int16 to keep memory reasonable for long calls.
+	allSamples []int16
+	// Both thresholds are in samples, see SetSilenceThresholds.
+	speechThresholdCount  int
+	silenceThresholdCount int
+	// speechStartsIdx <= silenceStartsIdx || silenceStartsIdx == -1
+	speechStartsIdx  int
+	silenceStartsIdx int
+	currentWindowIdx int
+}
+
+func newSpeechChunker(sampleRate int, traceName string, isSilence func(sample int16) bool) *speechChunker {
+	return &speechChunker{
+		sampleRate:            sampleRate,
+		traceName:             traceName,
+		isSilence:             isSilence,
+		onSpeech:              nil,
+		streamFrames:          false,
+		allSamples:            make([]int16, 0),
+		speechThresholdCount:  2 * sampleRate,
+		silenceThresholdCount: 5 * sampleRate,
+		speechStartsIdx:       -1,
+		silenceStartsIdx:      -1,
+		currentWindowIdx:      0,
+	}
+}
+
+// SetSilenceThresholds implements SilenceThresholdSetter.SetSilenceThresholds
+func (c *speechChunker) SetSilenceThresholds(speech time.Duration, silence time.Duration) {
+	c.speechThresholdCount = int(speech.Seconds() * float64(c.sampleRate))
+	c.silenceThresholdCount = int(silence.Seconds() * float64(c.sampleRate))
+}
+
+// StreamAudioFrames implements AudioFrameStreamer.StreamAudioFrames
+func (c *speechChunker) StreamAudioFrames() {
+	c.streamFrames = true
+}
+
+// Len is the number of samples received so far.
+func (c *speechChunker) Len() int {
+	return len(c.allSamples)
+}
+
+// Add buffers the samples, and returns the models.AudioInput and models.SubmitPrompt events to submit.
+func (c *speechChunker) Add(samples []int) []models.AudioData {
+	for _, sample := range samples {
+		c.allSamples = append(c.allSamples, int16(sample))
+	}
+	if c.streamFrames {
+		linear16Bytes := audio_utils.EncodeToLinear16(&audio.IntBuffer{
+			Data:   samples,
+			Format: &audio.Format{SampleRate: c.sampleRate, NumChannels: 1},
+		}, c.sampleRate)
+		return []models.AudioData{models.NewAudioDataFrame(linear16Bytes, c.sampleRate, c.traceName+".frame")}
+	}
+	return c.maybeSubmitAudioOutput()
+}
+
+// AddMulaw is Add for the mu-law devices, the frame goes on as it is when streaming, so it is not re-encoded.
+func (c *speechChunker) AddMulaw(mulawBytes []byte, samples []int) []models.AudioData {
+	if !c.streamFrames {
+		return c.Add(samples)
+	}
+	for _, sample := range samples {
+		c.allSamples = append(c.allSamples, int16(sample))
+	}
+	// The device might reuse its buffer.
+	frame := append([]byte(nil), mulawBytes...)
+	return []models.AudioData{models.NewAudioDataMulawFrame(frame, c.sampleRate, c.traceName+".frame")}
+}
+
+func (c *speechChunker) maybeSubmitAudioOutput() []models.AudioData {
+	var result []models.AudioData
+	maxSilenceLength := 0
+
+	if len(c.allSamples) < c.speechThresholdCount {
+		return result
+	}
+
+	for ; c.currentWindowIdx < len(c.allSamples); c.currentWindowIdx++ {
+		if c.currentWindowIdx%10000 == 0 {
+			log.Trace().Int("all_size", len(c.allSamples)).Int("speechStartsIdx", c.speechStartsIdx).Int("silenceStartsIdx", c.silenceStartsIdx).Int("currentWindowIdx", c.currentWindowIdx).Int("longestSilence", maxSilenceLength).Msg("maybeSubmitAudioOutput")
+		}
+
+		isSilence := c.isSilence(c.allSamples[c.currentWindowIdx])
+
+		// TODO(P1, ux): Use some kind of a VAD
+		// Detect start of non-silence, interpreted as speech.
+		if !isSilence && c.speechStartsIdx < 0 {
+			c.speechStartsIdx = c.currentWindowIdx
+		}
+		if c.speechStartsIdx < 0 {
+			continue
+		}
+		// From now on true that: c.speechStartsIdx >= 0
+		submitAudio := false
+		submitPrompt := false
+
+		// Evaluate if there was enough silence after a speech has started
+		if isSilence {
+			if c.silenceStartsIdx == -1 {
+				c.silenceStartsIdx = c.currentWindowIdx
+			}
+			silenceLength := c.currentWindowIdx - c.silenceStartsIdx
+			if silenceLength >= c.silenceThresholdCount {
+				submitAudio = true
+				submitPrompt = true
+			}
+			// A debug param mostly to adjust thresholds when they fail
+			if silenceLength > maxSilenceLength {
+				maxSilenceLength = silenceLength
+			}
+		} else {
+			c.silenceStartsIdx = -1
+		}
+
+		// Enough speech to submit audio
+		if c.currentWindowIdx-c.speechStartsIdx >= c.speechThresholdCount {
+			if c.silenceStartsIdx > 0 && c.currentWindowIdx-c.silenceStartsIdx >= 100 {
+				submitAudio = true
+			}
+		}
+
+		if submitAudio {
+			rawSlice := c.allSamples[c.speechStartsIdx:c.silenceStartsIdx]
+			// Too short would result into garbage (or HTTP 4xx)
+			if len(rawSlice) >= c.sampleRate/10 {
+				log.Info().Bool("submit_prompt", submitPrompt).Int("all_size", len(c.allSamples)).Int("speechStartsIdx", c.speechStartsIdx).Int("silenceStartsIdx", c.silenceStartsIdx).Int("currentWindowIdx", c.currentWindowIdx).Msg("detected enough speech with enough silence to submit audio")
+
+				result = append(result, c.newAudioInput(c.speechStartsIdx, c.silenceStartsIdx))
+
+				c.speechStartsIdx = c.silenceStartsIdx // Note, this can make the next slice 0
+			}
+		}
+
+		// Note: By having a long silence required in the end, the TranscriberRoutine will
+		// be likely done, and we can submit chat request right away.
+		if submitPrompt {
+			log.Info().Int("all_size", len(c.allSamples)).Int("speechStartsIdx", c.speechStartsIdx).Int("silenceStartsIdx", c.silenceStartsIdx).Int("currentWindowIdx", c.currentWindowIdx).Msg("enough silence to submitPrompt")
+			result = append(result, models.NewAudioDataSubmit(c.traceName+".silence"))
+
+			c.speechStartsIdx = -1
+			c.silenceStartsIdx = -1
+		}
+	}
+	return result
+}
+
+// Flush submits whatever speech is buffered together with the prompt, e.g. when the client detected the end of speech.
+func (c *speechChunker) Flush() []models.AudioData {
+	var result []models.AudioData
+	if c.streamFrames {
+		// The frames were already passed on, the streaming transcriber only needs to know the utterance ended.
+		return append(result, models.NewAudioDataSubmit(c.traceName+".flush"))
+	}
+	if c.speechStartsIdx >= 0 && len(c.allSamples)-c.speechStartsIdx >= c.sampleRate/10 {
+		log.Info().Int("all_size", len(c.allSamples)).Int("speechStartsIdx", c.speechStartsIdx).Msg("flushing the speech to submit audio")
+		result = append(result, c.newAudioInput(c.speechStartsIdx, len(c.allSamples)))
+	}
+	result = append(result, models.NewAudioDataSubmit(c.traceName+".flush"))
+
+	c.speechStartsIdx = -1
+	c.silenceStartsIdx = -1
+	c.currentWindowIdx = len(c.allSamples)
+	return result
+}
+
+// newAudioInput encodes the samples between startIdx and endIdx as a wav for the transcriber.
+func (c *speechChunker) newAudioInput(startIdx int, endIdx int) models.AudioData {
+	rawSlice := c.allSamples[startIdx:endIdx]
+	intData := make([]int, len(rawSlice))
+	for i, sample := range rawSlice {
+		intData[i] = int(sample)
+	}
+	wavBytes, err := audio_utils.EncodeToWavSimple(&audio.IntBuffer{
+		Data: intData,
+		Format: &audio.Format{
+			SampleRate:  c.sampleRate,
+			NumChannels: 1,
+		},
+		SourceBitDepth: 16,
+	})
+	errLog(err, "speechChunker.newAudioInput.EncodeToWavSimple") // shouldn't happen
+
+	dbg(os.WriteFile(fmt.Sprintf("output/%d-%d.wav", startIdx, endIdx), wavBytes, 0644))
+	if c.onSpeech != nil {
+		c.onSpeech(startIdx, endIdx)
+	}
+
+	return models.AudioData{
+		EventType: models.AudioInput,
+		ByteData:  wavBytes,
+		Format:    "wav",
+		Length:    time.Duration(len(rawSlice)) * time.Second / time.Duration(c.sampleRate),
+		Trace:     models.NewTrace(c.traceName + ".stream"),
+	}
+}
@@ -0,0 +1,147 @@
+package audioio
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"strconv"
+	"time"
+)
+
+// TelnyxMessage is a base struct for all Websocket events with Telnyx, it is modelled after Twilio Media Streams.
+// https://developers.telnyx.com/docs/voice/programmable-voice/media-streaming
+type TelnyxMessage struct {
+	// Event either of "connected", "start", "media", "stop", "mark", "clear", "dtmf" or "error"
+	Event          string `json:"event"`
+	SequenceNumber string `json:"sequence_number,omitempty"`
+	StreamId       string `json:"stream_id,omitempty"`
+
+	Start *TelnyxStartPayload `json:"start,omitempty"`
+	Media *TelnyxMediaPayload `json:"media,omitempty"`
+	Mark  *TelnyxMarkPayload  `json:"mark,omitempty"`
+}
+
+type TelnyxStartPayload struct {
+	CallControlId    string            `json:"call_control_id"`
+	ClientState      string            `json:"client_state,omitempty"`
+	MediaFormat      TelnyxMediaFormat `json:"media_format"`
+	CustomParameters map[string]string `json:"custom_parameters,omitempty"`
+}
+
+type TelnyxMediaFormat struct {
+	// Encoding is "PCMU" for mulaw
+	Encoding   string `json:"encoding"`
+	SampleRate int    `json:"sample_rate"`
+	Channels   int    `json:"channels"`
+}
+
+type TelnyxMediaPayload struct {
+	// One of inbound or outbound, empty for the media we send.
+	Track     string `json:"track,omitempty"`
+	Chunk     string `json:"chunk,omitempty"`
+	Timestamp string `json:"timestamp,omitempty"`
+	Payload   string `json:"payload"`
+}
+
+type TelnyxMarkPayload struct {
+	Name string `json:"name"`
+}
+
+// telnyxProtocol is the MediaStreamProtocol of Telnyx media streaming with bidirectional mulaw (PCMU) audio.
+type telnyxProtocol struct{}
+
+// NewTelnyxProtocol returns a MediaStreamProtocol for a single Telnyx stream.
+func NewTelnyxProtocol() MediaStreamProtocol {
+	return &telnyxProtocol{}
+}
+
+// NewTelnyxHandler onStart can be nil, in which case all streams are accepted.
+// MediaStreamStart.CallId is the call_control_id.
+func NewTelnyxHandler(onStart MediaStreamStartHandler) *mediaStreamHandler {
+	return NewMediaStreamHandler(NewTelnyxProtocol(), onStart)
+}
+
+// Name implements MediaStreamProtocol.Name
+func (p *telnyxProtocol) Name() string {
+	return "telnyx"
+}
+
+// ParseEvent implements MediaStreamProtocol.ParseEvent
+func (p *telnyxProtocol) ParseEvent(msgBytes []byte) (MediaStreamEvent, error) {
+	result := MediaStreamEvent{Kind: MediaStreamIgnored, TimestampMs: -1}
+	var msg TelnyxMessage
+	if err := json.Unmarshal(msgBytes, &msg); err != nil {
+		return result, fmt.Errorf("couldn't decode telnyx message: %w", err)
+	}
+
+	switch msg.Event {
+	case "connected", "dtmf":
+	case "start":
+		if msg.Start == nil {
+			return result, fmt.Errorf("msg.Start is nil for msg.event = 'start'")
+		}
+		if msg.Start.MediaFormat.Encoding != "PCMU" || msg.Start.MediaFormat.SampleRate != MediaStreamSampleRate {
+			log.Error().Msgf("unexpected media format in start.media_format: %v", msg.Start.MediaFormat)
+		}
+		result.Kind = MediaStreamStarted
+		result.Start = &MediaStreamStart{
+			StreamId:         msg.StreamId,
+			CallId:           msg.Start.CallControlId,
+			CustomParameters: msg.Start.CustomParameters,
+		}
+	case "media":
+		if msg.Media == nil {
+			return result, fmt.Errorf("msg.Media is nil for msg.event = 'media'")
+		}
+		if msg.Media.Track != "" && msg.Media.Track != "inbound" {
+			log.Debug().Msgf("received track='%s' media type, ignoring", msg.Media.Track)
+			return result, nil
+		}
+		mulawAudioData, err := base64.StdEncoding.DecodeString(msg.Media.Payload)
+		if err != nil {
+			return result, fmt.Errorf("failed to decode base64 audio data: %w", err)
+		}
+		result.Kind = MediaStreamMedia
+		result.Media = mulawAudioData
+		result.Chunk, _ = strconv.Atoi(msg.Media.Chunk)
+		if timestampMs, err := strconv.Atoi(msg.Media.Timestamp); err == nil {
+			result.TimestampMs = timestampMs
+		}
+	case "stop":
+		result.Kind = MediaStreamStopped
+	case "mark":
+		if msg.Mark == nil {
+			return result, fmt.Errorf("msg.Mark is nil for msg.event = 'mark'")
+		}
+		result.Kind = MediaStreamMarked
+		result.MarkName = msg.Mark.Name
+	case "error":
+		log.Error().Msgf("telnyx stream error: %s", string(msgBytes))
+	default:
+		return result, fmt.Errorf("unknown msg.Event %s", msg.Event)
+	}
+	return result, nil
+}
+
+// NewMediaMessage implements MediaStreamProtocol.NewMediaMessage
+// Telnyx plays the outbound media in the order received, so no chunk nor timestamp is sent.
+func (p *telnyxProtocol) NewMediaMessage(_ MediaStreamStart, frame []byte, _ int, _ time.Duration) ([]byte, error) {
+	return json.Marshal(TelnyxMessage{
+		Event: "media",
+		Media: &TelnyxMediaPayload{Payload: base64.StdEncoding.EncodeToString(frame)},
+	})
+}
+
+// NewMarkMessage implements MediaStreamProtocol.NewMarkMessage
+func (p *telnyxProtocol) NewMarkMessage(_ MediaStreamStart, name string) ([]byte, error) {
+	return json.Marshal(TelnyxMessage{
+		Event: "mark",
+		Mark:  &TelnyxMarkPayload{Name: name},
+	})
+}
+
+// NewClearMessage implements MediaStreamProtocol.NewClearMessage
+func (p *telnyxProtocol) NewClearMessage(_ MediaStreamStart) ([]byte, error) {
+	return json.Marshal(TelnyxMessage{Event: "clear"})
+}
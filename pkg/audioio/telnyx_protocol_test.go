@@ -0,0 +1,153 @@
+package audioio
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// The frames are shaped as in https://developers.telnyx.com/docs/voice/programmable-voice/media-streaming
+const telnyxTestStreamId = "32de0dea-53cb-4b21-89d4-9d5ee4d93a0f"
+const telnyxTestCallControlId = "v3:T02llQxIyaRkhfRKxgAP8nY511EhFLizdvdUKJiSw8d6A9BborherQ"
+
+// mediaStreamTestPayload is "/v7+/n9/f38=" in base64, shared with the other media stream protocol tests.
+var mediaStreamTestPayload = []byte{0xfe, 0xfe, 0xfe, 0xfe, 0x7f, 0x7f, 0x7f, 0x7f}
+
+func TestTelnyxProtocolParseEvent(t *testing.T) {
+	protocol := NewTelnyxProtocol()
+	tests := []struct {
+		name     string
+		msg      string
+		expected MediaStreamEvent
+	}{
+		{
+			name:     "connected",
+			msg:      `{"event": "connected", "version": "1.0.0"}`,
+			expected: MediaStreamEvent{Kind: MediaStreamIgnored, TimestampMs: -1},
+		},
+		{
+			name: "start",
+			msg:  `{"event": "start", "sequence_number": "1", "start": {"user_id": "3e6f995f-85f7-4705-9741-53b116d28237", "call_control_id": "` + telnyxTestCallControlId + `", "client_state": "aGF2ZSBhIG5pY2UgZGF5ID1d", "media_format": {"encoding": "PCMU", "sample_rate": 8000, "channels": 1}, "custom_parameters": {"agent_profile_id": "sales"}}, "stream_id": "` + telnyxTestStreamId + `"}`,
+			expected: MediaStreamEvent{Kind: MediaStreamStarted, TimestampMs: -1, Start: &MediaStreamStart{
+				StreamId:         telnyxTestStreamId,
+				CallId:           telnyxTestCallControlId,
+				CustomParameters: map[string]string{"agent_profile_id": "sales"},
+			}},
+		},
+		{
+			name:     "inbound media",
+			msg:      `{"event": "media", "sequence_number": "4", "media": {"track": "inbound", "chunk": "2", "timestamp": "20", "payload": "/v7+/n9/f38="}, "stream_id": "` + telnyxTestStreamId + `"}`,
+			expected: MediaStreamEvent{Kind: MediaStreamMedia, Media: mediaStreamTestPayload, Chunk: 2, TimestampMs: 20},
+		},
+		{
+			// Only with stream_track=both_tracks, the audio we played is not what the caller said.
+			name:     "outbound media",
+			msg:      `{"event": "media", "sequence_number": "5", "media": {"track": "outbound", "chunk": "1", "timestamp": "20", "payload": "/v7+/n9/f38="}, "stream_id": "` + telnyxTestStreamId + `"}`,
+			expected: MediaStreamEvent{Kind: MediaStreamIgnored, TimestampMs: -1},
+		},
+		{
+			name:     "mark",
+			msg:      `{"event": "mark", "sequence_number": "6", "mark": {"name": "utterance-1"}, "stream_id": "` + telnyxTestStreamId + `"}`,
+			expected: MediaStreamEvent{Kind: MediaStreamMarked, TimestampMs: -1, MarkName: "utterance-1"},
+		},
+		{
+			name:     "dtmf",
+			msg:      `{"event": "dtmf", "sequence_number": "7", "dtmf": {"digit": "1"}, "stream_id": "` + telnyxTestStreamId + `"}`,
+			expected: MediaStreamEvent{Kind: MediaStreamIgnored, TimestampMs: -1},
+		},
+		{
+			name:     "stop",
+			msg:      `{"event": "stop", "sequence_number": "8", "stop": {"user_id": "3e6f995f-85f7-4705-9741-53b116d28237", "call_control_id": "` + telnyxTestCallControlId + `"}, "stream_id": "` + telnyxTestStreamId + `"}`,
+			expected: MediaStreamEvent{Kind: MediaStreamStopped, TimestampMs: -1},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			event, err := protocol.ParseEvent([]byte(test.msg))
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkMediaStreamEvent(t, test.expected, event)
+		})
+	}
+}
+
+func TestTelnyxProtocolParseEventMalformed(t *testing.T) {
+	protocol := NewTelnyxProtocol()
+	for _, msg := range []string{
+		`{"event": "media"`,
+		`{"event": "start", "stream_id": "` + telnyxTestStreamId + `"}`,
+		`{"event": "media", "stream_id": "` + telnyxTestStreamId + `"}`,
+		`{"event": "media", "media": {"track": "inbound", "payload": "not base64!"}}`,
+		`{"event": "mark"}`,
+		`{"event": "hangup"}`,
+	} {
+		if _, err := protocol.ParseEvent([]byte(msg)); err == nil {
+			t.Errorf("expected %s rejected", msg)
+		}
+	}
+}
+
+func TestTelnyxProtocolOutboundMessages(t *testing.T) {
+	protocol := NewTelnyxProtocol()
+	start := MediaStreamStart{StreamId: telnyxTestStreamId, CallId: telnyxTestCallControlId}
+
+	mediaMsg, err := protocol.NewMediaMessage(start, mediaStreamTestPayload, 3, 40*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Telnyx plays the media in order, so neither the chunk nor the timestamp is sent.
+	checkJson(t, `{"event":"media","media":{"payload":"/v7+/n9/f38="}}`, mediaMsg)
+	// Our own media has no track, so it parses back the same as the inbound.
+	event, err := protocol.ParseEvent(mediaMsg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkMediaStreamEvent(t, MediaStreamEvent{Kind: MediaStreamMedia, Media: mediaStreamTestPayload, TimestampMs: -1}, event)
+
+	markMsg, err := protocol.NewMarkMessage(start, "utterance-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkJson(t, `{"event":"mark","mark":{"name":"utterance-1"}}`, markMsg)
+	// Telnyx echoes the mark once played.
+	event, err = protocol.ParseEvent(markMsg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkMediaStreamEvent(t, MediaStreamEvent{Kind: MediaStreamMarked, TimestampMs: -1, MarkName: "utterance-1"}, event)
+
+	clearMsg, err := protocol.NewClearMessage(start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkJson(t, `{"event":"clear"}`, clearMsg)
+}
+
+func checkJson(t *testing.T, expected string, got []byte) {
+	t.Helper()
+	if string(got) != expected {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+}
+
+func checkMediaStreamEvent(t *testing.T, expected MediaStreamEvent, got MediaStreamEvent) {
+	t.Helper()
+	if got.Kind != expected.Kind || !bytes.Equal(got.Media, expected.Media) || got.Chunk != expected.Chunk || got.TimestampMs != expected.TimestampMs || got.MarkName != expected.MarkName {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+	if (got.Start == nil) != (expected.Start == nil) {
+		t.Fatalf("expected start %+v, got %+v", expected.Start, got.Start)
+	}
+	if expected.Start == nil {
+		return
+	}
+	if got.Start.StreamId != expected.Start.StreamId || got.Start.CallId != expected.Start.CallId || len(got.Start.CustomParameters) != len(expected.Start.CustomParameters) {
+		t.Errorf("expected start %+v, got %+v", *expected.Start, *got.Start)
+	}
+	for name, value := range expected.Start.CustomParameters {
+		if got.Start.CustomParameters[name] != value {
+			t.Errorf("expected custom parameter %s=%s, got %q", name, value, got.Start.CustomParameters[name])
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package audioio
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"strconv"
+	"time"
+)
+
+const MulawSilenceByte = 0xff
+const TwilioMulawSampleRate = MediaStreamSampleRate
+
+// TwilioMediaFrameDuration see MediaStreamFrameDuration
+const TwilioMediaFrameDuration = MediaStreamFrameDuration
+
+// TwilioMediaFrameSize see MediaStreamFrameSize
+const TwilioMediaFrameSize = MediaStreamFrameSize
+
+// twilioProtocol is the MediaStreamProtocol of Twilio Media Streams
+// https://www.twilio.com/docs/voice/twiml/stream#websocket-messages-from-twilio
+type twilioProtocol struct {
+	writeLastSeqNum int
+}
+
+// NewTwilioProtocol returns a MediaStreamProtocol for a single Twilio stream.
+func NewTwilioProtocol() MediaStreamProtocol {
+	return &twilioProtocol{writeLastSeqNum: 0}
+}
+
+// NewTwilioHandler onStart can be nil, in which case all streams are accepted.
+func NewTwilioHandler(onStart MediaStreamStartHandler) *mediaStreamHandler {
+	return NewMediaStreamHandler(NewTwilioProtocol(), onStart)
+}
+
+// Name implements MediaStreamProtocol.Name
+func (p *twilioProtocol) Name() string {
+	return "twilio"
+}
+
+// ParseEvent implements MediaStreamProtocol.ParseEvent
+func (p *twilioProtocol) ParseEvent(msgBytes []byte) (MediaStreamEvent, error) {
+	result := MediaStreamEvent{Kind: MediaStreamIgnored, TimestampMs: -1}
+	var msg TwilioMessage
+	if err := json.Unmarshal(msgBytes, &msg); err != nil {
+		// Maybe I just wrongfully implemented, or they changed the API
+		return result, fmt.Errorf("couldn't decode twilio message: %w", err)
+	}
+
+	switch msg.Event {
+	case "connected":
+		if msg.Protocol == nil || *msg.Protocol != "Call" {
+			log.Error().Msgf("msg.Protocol unexpected: %v", msg.Protocol)
+		}
+		if msg.Version == nil || *msg.Version != "1.0.0" {
+			log.Error().Msgf("msg.Version unexpected: %v", msg.Version)
+		}
+	case "start":
+		if msg.Start == nil {
+			return result, fmt.Errorf("msg.Start is nil for msg.event = 'start'")
+		}
+		validateTwilioStart(*msg.Start)
+		result.Kind = MediaStreamStarted
+		result.Start = &MediaStreamStart{
+			StreamId:         msg.Start.StreamSid,
+			CallId:           msg.Start.CallSid,
+			CustomParameters: msg.Start.CustomParameters,
+		}
+	case "media":
+		if msg.Media == nil {
+			return result, fmt.Errorf("msg.Media is nil for msg.event = 'media'")
+		}
+		if msg.Media.Track != "inbound" {
+			log.Debug().Msgf("received track='%s' media type, ignoring", msg.Media.Track)
+			return result, nil
+		}
+		// https://en.wikipedia.org/wiki/%CE%9C-law_algorithm
+		mulawAudioData, err := base64.StdEncoding.DecodeString(msg.Media.Payload)
+		if err != nil {
+			return result, fmt.Errorf("failed to decode base64 audio data: %w", err)
+		}
+		result.Kind = MediaStreamMedia
+		result.Media = mulawAudioData
+		result.Chunk, _ = strconv.Atoi(msg.Media.Chunk)
+		// Twilio timestamps are relative to the stream start, same as our outbound ones.
+		if timestampMs, err := strconv.Atoi(msg.Media.Timestamp); err == nil {
+			result.TimestampMs = timestampMs
+		}
+	case "stop":
+		if msg.Stop == nil {
+			return result, fmt.Errorf("msg.Stop is nil for msg.event = 'stop'")
+		}
+		result.Kind = MediaStreamStopped
+	case "mark", "clear":
+		if msg.Mark == nil {
+			return result, fmt.Errorf("msg.Mark is nil for msg.event = '%s'", msg.Event)
+		}
+		result.Kind = MediaStreamMarked
+		result.MarkName = msg.Mark.Name
+	default:
+		return result, fmt.Errorf("unknown msg.Event %s", msg.Event)
+	}
+	return result, nil
+}
+
+func validateTwilioStart(start TwilioStartPayload) {
+	if !isInList("inbound", start.Tracks) {
+		log.Error().Msgf("'inbound' NOT in Start.Tracks: %v", start.Tracks)
+	}
+	// Here "outbound" really means just what kind of events we get - since we send all outbound audio, we
+	// don't need to get it back.
+	// https://www.twilio.com/docs/voice/twiml/stream#attributes-track
+	if isInList("outbound", start.Tracks) {
+		log.Error().Msgf("'outbound' IS in Start.Tracks: %v", start.Tracks)
+	}
+	expectedMediaFormat := TwilioMediaFormat{
+		Encoding:   "audio/x-mulaw",
+		SampleRate: TwilioMulawSampleRate,
+		Channels:   1,
+	}
+	if start.MediaFormat != expectedMediaFormat {
+		log.Error().Msgf("unexpected media format in Start.MediaFormat: %v", start.MediaFormat)
+	}
+}
+
+// marshal numbers every message we send, as Twilio expects.
+func (p *twilioProtocol) marshal(start MediaStreamStart, msg TwilioMessage) ([]byte, error) {
+	p.writeLastSeqNum++
+	msg.SequenceNumber = strconv.Itoa(p.writeLastSeqNum)
+	msg.StreamSid = start.StreamId
+	return json.Marshal(msg)
+}
+
+// NewMediaMessage implements MediaStreamProtocol.NewMediaMessage
+// Twilio: The media payload should not contain audio file type header bytes.
+// Providing header bytes will cause the media to be streamed incorrectly.
+// https://www.twilio.com/docs/voice/twiml/stream#message-media-to-twilio
+func (p *twilioProtocol) NewMediaMessage(start MediaStreamStart, frame []byte, chunk int, timestamp time.Duration) ([]byte, error) {
+	return p.marshal(start, TwilioMessage{
+		Event: "media",
+		Media: &TwilioMediaPayload{
+			Track:     "outbound",
+			Chunk:     strconv.Itoa(chunk),
+			Timestamp: strconv.Itoa(int(timestamp.Milliseconds())),
+			Payload:   base64.StdEncoding.EncodeToString(frame),
+		},
+	})
+}
+
+// NewMarkMessage implements MediaStreamProtocol.NewMarkMessage
+// https://www.twilio.com/docs/voice/twiml/stream#message-mark-to-twilio
+func (p *twilioProtocol) NewMarkMessage(start MediaStreamStart, name string) ([]byte, error) {
+	return p.marshal(start, TwilioMessage{
+		Event: "mark",
+		Mark:  &TwilioMarkPayload{Name: name},
+	})
+}
+
+// NewClearMessage implements MediaStreamProtocol.NewClearMessage
+// https://www.twilio.com/docs/voice/twiml/stream#message-clear-to-twilio
+func (p *twilioProtocol) NewClearMessage(start MediaStreamStart) ([]byte, error) {
+	return p.marshal(start, TwilioMessage{Event: "clear"})
+}
@@ -0,0 +1,141 @@
+package audioio
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/go-audio/audio"
+	"github.com/petrzlen/vocode-golang/pkg/models"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const twilioTestStreamSid = "MZ18ad3ab5a668481ce02b83e7395059f0"
+
+// checkOutboundMediaTranscript checks the websocket messages we sent to Twilio, and returns their events in order:
+// * sequenceNumber increments by one on every message, and all are for streamSid,
+// * every media payload is exactly TwilioMediaFrameSize mulaw bytes of the outbound track,
+// * media chunks increment by one, also across a clear,
+// * media timestamps never go back and consecutive frames are at least TwilioMediaFrameDuration apart.
+func checkOutboundMediaTranscript(t *testing.T, transcript [][]byte, streamSid string) []string {
+	t.Helper()
+	var events []string
+	lastChunk := 0
+	lastTimestamp := -1
+	for i, msgBytes := range transcript {
+		var msg TwilioMessage
+		if err := json.Unmarshal(msgBytes, &msg); err != nil {
+			t.Fatalf("message %d is not a TwilioMessage: %v", i, err)
+		}
+		events = append(events, msg.Event)
+		if msg.SequenceNumber != strconv.Itoa(i+1) || msg.StreamSid != streamSid {
+			t.Errorf("message %d has sequenceNumber %q of stream %q, expected %d of %q", i, msg.SequenceNumber, msg.StreamSid, i+1, streamSid)
+		}
+		if msg.Event != "media" {
+			continue
+		}
+		if msg.Media == nil || msg.Media.Track != "outbound" {
+			t.Fatalf("message %d is not an outbound media message: %s", i, truncatePayload(string(msgBytes)))
+		}
+
+		if chunk, err := strconv.Atoi(msg.Media.Chunk); err != nil || chunk != lastChunk+1 {
+			t.Errorf("message %d has chunk %q, expected %d", i, msg.Media.Chunk, lastChunk+1)
+		}
+		lastChunk++
+
+		timestamp, err := strconv.Atoi(msg.Media.Timestamp)
+		if err != nil {
+			t.Fatalf("message %d has invalid timestamp %q: %v", i, msg.Media.Timestamp, err)
+		}
+		if lastTimestamp >= 0 && timestamp-lastTimestamp < int(TwilioMediaFrameDuration.Milliseconds()) {
+			t.Errorf("message %d has timestamp %d overlapping the previous frame at %d", i, timestamp, lastTimestamp)
+		}
+		lastTimestamp = timestamp
+
+		mulawBytes, err := base64.StdEncoding.DecodeString(msg.Media.Payload)
+		if err != nil || len(mulawBytes) != TwilioMediaFrameSize {
+			t.Errorf("message %d has %d payload bytes, expected %d: %v", i, len(mulawBytes), TwilioMediaFrameSize, err)
+		}
+	}
+	return events
+}
+
+func newTwilioTestTone(duration time.Duration) *audio.IntBuffer {
+	return &audio.IntBuffer{Data: newTestToneSamples(duration), Format: &audio.Format{SampleRate: TwilioMulawSampleRate, NumChannels: 1}}
+}
+
+func TestTwilioHandlerOutboundMedia(t *testing.T) {
+	startedChan := make(chan struct{})
+	handler := NewTwilioHandler(func(device DuplexDevice, start MediaStreamStart) error {
+		close(startedChan)
+		return nil
+	})
+	handler.SetRecordingSink(nil)
+	recordingChan := make(chan models.AudioData, 100)
+	if err := handler.StartRecording(recordingChan); err != nil {
+		t.Fatal(err)
+	}
+	transcriptChan := make(chan [][]byte)
+	go func() {
+		var transcript [][]byte
+		for msg := range handler.GetWriter() {
+			transcript = append(transcript, msg)
+		}
+		transcriptChan <- transcript
+	}()
+
+	handler.GetReader() <- []byte(`{"event": "start", "sequenceNumber": "1", "start": {"streamSid": "` + twilioTestStreamSid + `", "accountSid": "AC0123456789", "callSid": "CA1234567890ABCDE", "tracks": ["inbound"], "customParameters": {}, "mediaFormat": {"encoding": "audio/x-mulaw", "sampleRate": 8000, "channels": 1}}, "streamSid": "` + twilioTestStreamSid + `"}`)
+	<-startedChan
+
+	// 250ms is 12.5 frames, so the last one is padded with silence.
+	for _, duration := range []time.Duration{250 * time.Millisecond, 100 * time.Millisecond} {
+		if _, err := handler.Play(newTwilioTestTone(duration)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The caller barged in, the next utterance continues with the next chunk.
+	handler.ClearPlayback()
+	if _, err := handler.Play(newTwilioTestTone(40 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	// Without a call control client the stream closes once Twilio echoes the mark.
+	if err := handler.Hangup(); err != nil {
+		t.Fatal(err)
+	}
+	handler.GetReader() <- []byte(`{"event": "mark", "sequenceNumber": "2", "streamSid": "` + twilioTestStreamSid + `", "mark": {"name": "hangup-1"}}`)
+
+	var transcript [][]byte
+	select {
+	case transcript = <-transcriptChan:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the stream was not closed after the hangup mark")
+	}
+	close(handler.GetReader())
+	for range recordingChan {
+	}
+
+	events := checkOutboundMediaTranscript(t, transcript, twilioTestStreamSid)
+	var expected []string
+	for i := 0; i < 13+5; i++ {
+		expected = append(expected, "media")
+	}
+	expected = append(expected, "clear", "media", "media", "mark")
+	if len(events) != len(expected) {
+		t.Fatalf("expected events %v, got %v", expected, events)
+	}
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Fatalf("expected events %v, got %v", expected, events)
+		}
+	}
+
+	var lastFrame TwilioMessage
+	if err := json.Unmarshal(transcript[12], &lastFrame); err != nil {
+		t.Fatal(err)
+	}
+	mulawBytes, _ := base64.StdEncoding.DecodeString(lastFrame.Media.Payload)
+	if silence := mulawBytes[TwilioMediaFrameSize/2:]; !bytes.Equal(silence, bytes.Repeat([]byte{MulawSilenceByte}, len(silence))) {
+		t.Errorf("expected the last frame padded with silence, got %v", silence)
+	}
+}
@@ -0,0 +1,357 @@
+package audioio
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-audio/audio"
+	"github.com/gorilla/websocket"
+	"github.com/petrzlen/vocode-golang/internal/networking"
+	"github.com/petrzlen/vocode-golang/pkg/audio_utils"
+	"github.com/petrzlen/vocode-golang/pkg/models"
+	"github.com/petrzlen/vocode-golang/pkg/telephony"
+	"github.com/rs/zerolog/log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const VonageDefaultSampleRate = 16000
+
+// VonageFrameDuration is the length of each binary frame, in both directions.
+const VonageFrameDuration = 20 * time.Millisecond
+
+// vonageSilenceAmplitude below which a linear16 sample counts as silence, unlike Twilio Vonage sends the line noise.
+const vonageSilenceAmplitude = 100
+
+// VonageStartHandler is the MediaStreamStartHandler of Vonage, called on "websocket:connected".
+type VonageStartHandler func(device DuplexDevice, connected VonageConnectedMessage) error
+
+// vonageHandler is the twilioHandler for Vonage, with binary linear16 frames instead of base64 mulaw in JSON.
+type vonageHandler struct {
+	// Vonage Protocol
+	connected  *VonageConnectedMessage // To keep the initial config
+	startTime  time.Time
+	onStart    VonageStartHandler
+	isAccepted bool // Only true once onStart succeeded, audio before that is dropped.
+	sampleRate int
+	readChan   chan networking.WebsocketMessage
+	writeChan  chan networking.WebsocketMessage
+	// writeMutex guards isStopped, so nothing is sent after writeChan is closed. Also guards onShutdown.
+	writeMutex  sync.Mutex
+	isStopped   bool
+	onShutdown  func()
+	stoppedChan chan struct{}
+
+	// Vonage plays whatever it gets right away, so the outbound frames wait in playQueue to be sent in real time.
+	playMutex sync.Mutex
+	playQueue [][]byte
+	// Timestamp (since startTime) of the end of playQueue, to put the outbound audio on the recording timeline.
+	playNextTimestamp time.Duration
+
+	// Both sides of the call for QA, saved into recordingSink when the call ends.
+	recorder      *callRecorder
+	recordingSink RecordingSink
+
+	// Package interface
+	recordingChan chan models.AudioData
+	chunker       *speechChunker
+}
+
+// NewVonageHandler onStart can be nil, in which case all streams are accepted.
+func NewVonageHandler(onStart VonageStartHandler) *vonageHandler {
+	result := &vonageHandler{
+		// Vonage Protocol
+		connected:   nil,
+		onStart:     onStart,
+		isAccepted:  false,
+		sampleRate:  VonageDefaultSampleRate,
+		readChan:    make(chan networking.WebsocketMessage, 100),
+		writeChan:   make(chan networking.WebsocketMessage, 100),
+		isStopped:   false,
+		onShutdown:  nil,
+		stoppedChan: make(chan struct{}),
+
+		playQueue:         make([][]byte, 0),
+		playNextTimestamp: 0,
+
+		recorder:      nil,
+		recordingSink: NewLocalDirRecordingSink("output"),
+
+		// Package interface
+		recordingChan: nil,
+		chunker:       newVonageSpeechChunker(VonageDefaultSampleRate),
+	}
+	go result.readMessagesUntilChanClosed()
+	go result.playQueueRoutine()
+	return result
+}
+
+func newVonageSpeechChunker(sampleRate int) *speechChunker {
+	return newSpeechChunker(sampleRate, "vonage", func(sample int16) bool {
+		return -vonageSilenceAmplitude < sample && sample < vonageSilenceAmplitude
+	})
+}
+
+// GetReader implements networking.TypedWebsocketMessageHandler.GetReader
+func (vh *vonageHandler) GetReader() chan<- networking.WebsocketMessage {
+	return vh.readChan
+}
+
+// GetWriter implements networking.TypedWebsocketMessageHandler.GetWriter
+func (vh *vonageHandler) GetWriter() <-chan networking.WebsocketMessage {
+	return vh.writeChan
+}
+
+// StartRecording implements InputDevice.StartRecording
+func (vh *vonageHandler) StartRecording(recordingChan chan models.AudioData) error {
+	vh.recordingChan = recordingChan
+	return nil
+}
+
+// SetRecordingSink sets where the stereo call recording is saved once the call ends, nil disables it.
+func (vh *vonageHandler) SetRecordingSink(sink RecordingSink) {
+	vh.recordingSink = sink
+}
+
+// SetSilenceThresholds implements SilenceThresholdSetter.SetSilenceThresholds
+func (vh *vonageHandler) SetSilenceThresholds(speech time.Duration, silence time.Duration) {
+	vh.chunker.SetSilenceThresholds(speech, silence)
+}
+
+// StreamAudioFrames implements AudioFrameStreamer.StreamAudioFrames
+func (vh *vonageHandler) StreamAudioFrames() {
+	vh.chunker.StreamAudioFrames()
+}
+
+// OnShutdown implements ShutdownNotifier.OnShutdown
+func (vh *vonageHandler) OnShutdown(callback func()) {
+	vh.writeMutex.Lock()
+	defer vh.writeMutex.Unlock()
+	vh.onShutdown = callback
+}
+
+// Shutdown implements networking.ShutdownAwareHandler.Shutdown
+func (vh *vonageHandler) Shutdown() {
+	vh.writeMutex.Lock()
+	onShutdown := vh.onShutdown
+	vh.writeMutex.Unlock()
+
+	if onShutdown == nil {
+		errLog(vh.Stop(), "vonageHandler.Stop on shutdown")
+		return
+	}
+	log.Info().Str("call_uuid", vh.getCallUuid()).Msg("vonageHandler wrapping up the call on shutdown")
+	onShutdown()
+}
+
+func (vh *vonageHandler) StopRecording() ([]byte, error) {
+	err := vh.Stop()
+
+	return nil, err
+}
+
+func (vh *vonageHandler) Stop() error {
+	vh.writeMutex.Lock()
+	defer vh.writeMutex.Unlock()
+	if vh.isStopped {
+		log.Debug().Str("call_uuid", vh.getCallUuid()).Msg("vonageHandler already stopped")
+		return nil
+	}
+	vh.isStopped = true
+	log.Info().Str("call_uuid", vh.getCallUuid()).Msg("writeChan close")
+
+	// Same as for twilioHandler, this closes the websocket, then the readChan and then the recordingChan.
+	close(vh.stoppedChan)
+	close(vh.writeChan)
+
+	return nil
+}
+
+// Play implements OutputDevice.Play
+// The audio is queued, and sent one VonageFrameDuration frame at a time by playQueueRoutine.
+func (vh *vonageHandler) Play(intBuffer *audio.IntBuffer) (*sync.WaitGroup, error) {
+	if intBuffer.Format == nil || intBuffer.Format.NumChannels != 1 {
+		return nil, fmt.Errorf("vonageHandler can only play mono audio")
+	}
+	linear16Bytes := audio_utils.EncodeToLinear16(intBuffer, vh.sampleRate)
+
+	frameSize := vh.getFrameSize()
+	vh.playMutex.Lock()
+	defer vh.playMutex.Unlock()
+	// The queue was empty for a while, so this continues after a gap of silence.
+	if sinceStart := time.Since(vh.startTime); len(vh.playQueue) == 0 && !vh.startTime.IsZero() && vh.playNextTimestamp < sinceStart {
+		vh.playNextTimestamp = sinceStart.Truncate(time.Millisecond)
+	}
+	if vh.recorder != nil {
+		vh.recorder.AddOutbound(vh.playNextTimestamp.Milliseconds(), audio_utils.DecodeFromLinear16(linear16Bytes, vh.sampleRate).Data)
+	}
+
+	for start := 0; start < len(linear16Bytes); start += frameSize {
+		frame := make([]byte, frameSize) // Pads the last frame with silence.
+		copy(frame, linear16Bytes[start:min(start+frameSize, len(linear16Bytes))])
+		vh.playQueue = append(vh.playQueue, frame)
+		vh.playNextTimestamp += VonageFrameDuration
+	}
+	return nil, nil
+}
+
+// getFrameSize in bytes, e.g. 640 for 16000 sample rate.
+func (vh *vonageHandler) getFrameSize() int {
+	return 2 * vh.sampleRate * int(VonageFrameDuration/time.Millisecond) / 1000
+}
+
+func (vh *vonageHandler) playQueueRoutine() {
+	ticker := time.NewTicker(VonageFrameDuration)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-vh.stoppedChan:
+			return
+		case <-ticker.C:
+			vh.playMutex.Lock()
+			if len(vh.playQueue) == 0 {
+				vh.playMutex.Unlock()
+				continue
+			}
+			frame := vh.playQueue[0]
+			vh.playQueue = vh.playQueue[1:]
+			vh.playMutex.Unlock()
+
+			vh.sendMessage(networking.NewBinaryMessage(frame))
+		}
+	}
+}
+
+func (vh *vonageHandler) getCallUuid() string {
+	if vh.connected == nil {
+		return ""
+	}
+	return vh.connected.Headers[telephony.VonageCallUuidHeader]
+}
+
+func (vh *vonageHandler) handleConnectedMessage(msgBytes []byte) {
+	connected, err := parseVonageConnectedMessage(msgBytes)
+	if err != nil {
+		log.Error().Err(err).Msgf("couldn't decode first msg from vonage websocket: %s", string(msgBytes))
+		errLog(vh.Stop(), "vonageHandler.Stop after invalid connected message")
+		return
+	}
+	if connected.Event != "websocket:connected" {
+		log.Error().Msgf("unexpected first vonage event %s", connected.Event)
+	}
+	sampleRate, err := connected.getSampleRate()
+	if err != nil {
+		log.Error().Err(err).Msg("vonage stream has unsupported audio, closing")
+		errLog(vh.Stop(), "vonageHandler.Stop after unsupported content-type")
+		return
+	}
+
+	vh.connected = connected
+	vh.startTime = time.Now()
+	vh.sampleRate = sampleRate
+	vh.chunker = newVonageSpeechChunker(sampleRate)
+	vh.recorder = newCallRecorder(sampleRate)
+	vh.chunker.onSpeech = vh.recorder.AddCallerTurn
+
+	if vh.onStart != nil {
+		if err := vh.onStart(vh, *connected); err != nil {
+			log.Warn().Err(err).Str("call_uuid", vh.getCallUuid()).Msg("stream rejected on connected, closing")
+			errLog(vh.Stop(), "vonageHandler.Stop after rejected start")
+			return
+		}
+	}
+	vh.isAccepted = true
+}
+
+func (vh *vonageHandler) handleAudioMessage(linear16Bytes []byte) {
+	if !vh.isAccepted {
+		log.Trace().Msg("received audio before the stream was accepted, ignoring")
+		return
+	}
+	if len(linear16Bytes)%2 != 0 {
+		log.Warn().Str("call_uuid", vh.getCallUuid()).Int("byte_size", len(linear16Bytes)).Msg("received odd number of linear16 bytes, ignoring")
+		return
+	}
+
+	samples := audio_utils.DecodeFromLinear16(linear16Bytes, vh.sampleRate).Data
+	// Vonage frames have no timestamps, but they come at a steady pace.
+	vh.recorder.AddInbound(int64(vh.chunker.Len()*1000/vh.sampleRate), samples)
+	for _, audioData := range vh.chunker.Add(samples) {
+		vh.recordingChan <- audioData
+	}
+}
+
+func (vh *vonageHandler) handleEventMessage(msgBytes []byte) {
+	logMessage("received", msgBytes)
+	var event VonageEvent
+	if err := json.Unmarshal(msgBytes, &event); err != nil {
+		log.Error().Err(err).Msgf("couldn't decode msg from vonage websocket: %s", string(msgBytes))
+		return
+	}
+
+	switch event.Event {
+	case "websocket:dtmf":
+		log.Info().Str("call_uuid", vh.getCallUuid()).Str("digit", event.Digit).Msg("vonage caller pressed a digit")
+	default:
+		log.Debug().Str("call_uuid", vh.getCallUuid()).Msgf("ignoring vonage event %s", event.Event)
+	}
+}
+
+func (vh *vonageHandler) sendMessage(msg networking.WebsocketMessage) {
+	vh.writeMutex.Lock()
+	defer vh.writeMutex.Unlock()
+	if vh.isStopped {
+		log.Trace().Str("call_uuid", vh.getCallUuid()).Msg("cannot send message after vonageHandler isStopped")
+		return
+	}
+	vh.writeChan <- msg
+}
+
+func (vh *vonageHandler) readMessagesUntilChanClosed() {
+	for msg := range vh.readChan {
+		switch {
+		case msg.Type == websocket.BinaryMessage:
+			vh.handleAudioMessage(msg.Data)
+		case vh.connected == nil:
+			logMessage("received", msg.Data)
+			vh.handleConnectedMessage(msg.Data)
+		default:
+			vh.handleEventMessage(msg.Data)
+		}
+	}
+
+	// After reading done, there is no more to produce, nor anyone to write to.
+	errLog(vh.Stop(), "vonageHandler.Stop after readChan closed")
+	if vh.recordingChan != nil {
+		log.Info().Str("call_uuid", vh.getCallUuid()).Msg("vh.recordingChan CLOSE")
+		close(vh.recordingChan)
+	}
+
+	vh.saveRecording()
+}
+
+// saveRecording stores the stereo recording of both sides of the call into the recordingSink.
+func (vh *vonageHandler) saveRecording() {
+	if vh.recorder == nil || vh.recordingSink == nil {
+		log.Debug().Str("call_uuid", vh.getCallUuid()).Msg("no call recording to save")
+		return
+	}
+
+	wavBytes, metadata, err := vh.recorder.EncodeStereoWav()
+	if err != nil {
+		errLog(err, "callRecorder.EncodeStereoWav")
+		return
+	}
+	if len(wavBytes) == 0 {
+		log.Info().Str("call_uuid", vh.getCallUuid()).Msg("call recording is empty, not saving")
+		return
+	}
+	metadata.CallSid = vh.getCallUuid()
+	name := "call-vonage-" + metadata.CallSid
+	if metadata.CallSid == "" {
+		name += strconv.FormatInt(vh.startTime.Unix(), 10)
+	}
+
+	log.Info().Str("call_uuid", vh.getCallUuid()).Msgf("websocket finished, gonna save %d bytes of call recording", len(wavBytes))
+	errLog(vh.recordingSink.Save(name, wavBytes, metadata), "recordingSink.Save")
+}
@@ -0,0 +1,127 @@
+package audioio
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/go-audio/audio"
+	"github.com/gorilla/websocket"
+	"github.com/petrzlen/vocode-golang/internal/networking"
+	"github.com/petrzlen/vocode-golang/pkg/models"
+	"os"
+	"testing"
+	"time"
+)
+
+// recordedVonageMessage is one message of testdata/vonage_session.json, data is base64 for the binary ones.
+type recordedVonageMessage struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// chanRecordingSink passes the metadata of each saved recording to savedChan, as it is saved after the call ended.
+type chanRecordingSink struct {
+	savedChan chan RecordingMetadata
+}
+
+func (s chanRecordingSink) Save(name string, wavBytes []byte, metadata RecordingMetadata) error {
+	s.savedChan <- metadata
+	return nil
+}
+
+func loadRecordedVonageSession(t *testing.T) []networking.WebsocketMessage {
+	sessionBytes, err := os.ReadFile("testdata/vonage_session.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var recorded []recordedVonageMessage
+	if err := json.Unmarshal(sessionBytes, &recorded); err != nil {
+		t.Fatal(err)
+	}
+
+	result := make([]networking.WebsocketMessage, 0, len(recorded))
+	for _, msg := range recorded {
+		if msg.Type == "text" {
+			result = append(result, networking.NewTextMessage(msg.Data))
+			continue
+		}
+		var encoded string
+		if err := json.Unmarshal(msg.Data, &encoded); err != nil {
+			t.Fatal(err)
+		}
+		frame, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result = append(result, networking.NewBinaryMessage(frame))
+	}
+	return result
+}
+
+func TestVonageHandlerReplaysRecordedSession(t *testing.T) {
+	var connected VonageConnectedMessage
+	recordingChan := make(chan models.AudioData, 100)
+	handler := NewVonageHandler(func(device DuplexDevice, message VonageConnectedMessage) error {
+		connected = message
+		// Same as pipeline.Start does, which is only called once connected.
+		device.(SilenceThresholdSetter).SetSilenceThresholds(200*time.Millisecond, 300*time.Millisecond)
+		return device.StartRecording(recordingChan)
+	})
+	sink := chanRecordingSink{savedChan: make(chan RecordingMetadata, 1)}
+	handler.SetRecordingSink(sink)
+
+	for _, msg := range loadRecordedVonageSession(t) {
+		handler.GetReader() <- msg
+	}
+
+	var events []models.AudioData
+	for len(events) < 2 {
+		select {
+		case audioData := <-recordingChan:
+			events = append(events, audioData)
+		case <-time.After(time.Second):
+			t.Fatalf("expected the speech followed by a submit, got %d events", len(events))
+		}
+	}
+	if events[0].EventType != models.AudioInput || events[1].EventType != models.SubmitPrompt {
+		t.Fatalf("expected the speech followed by a submit, got %v and %v", events[0].EventType, events[1].EventType)
+	}
+	if events[0].Length < 500*time.Millisecond || events[0].Length > 700*time.Millisecond {
+		t.Errorf("expected about 600ms of speech, got %s", events[0].Length)
+	}
+	if connected.Headers["from"] != "14155550100" || handler.getCallUuid() != "63f61863-4a51-4f6b-86e1-46edebcf9356" {
+		t.Errorf("unexpected connected headers %v", connected.Headers)
+	}
+
+	// The agent answers, which has to go out as binary frames of 20ms.
+	_, err := handler.Play(&audio.IntBuffer{
+		Data:   make([]int, 480),
+		Format: &audio.Format{SampleRate: VonageDefaultSampleRate, NumChannels: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-handler.GetWriter():
+			if msg.Type != websocket.BinaryMessage || len(msg.Data) != 640 {
+				t.Errorf("expected a binary frame of 640 bytes, got type %d of %d bytes", msg.Type, len(msg.Data))
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no frame played")
+		}
+	}
+
+	// Vonage hangs up, which ends the recording and saves the call.
+	close(handler.GetReader())
+	for audioData := range recordingChan {
+		t.Errorf("unexpected event %v after the submit", audioData.EventType)
+	}
+	select {
+	case metadata := <-sink.savedChan:
+		if metadata.CallSid != handler.getCallUuid() || metadata.SampleRate != VonageDefaultSampleRate {
+			t.Errorf("unexpected call recording metadata %+v", metadata)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the call recording was not saved")
+	}
+}
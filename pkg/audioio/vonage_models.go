@@ -0,0 +1,73 @@
+package audioio
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// VonageConnectedMessage is the first (text) message on the Vonage websocket, all the other inbound messages
+// are binary audio frames, or text events like "websocket:dtmf".
+// https://developer.vonage.com/en/voice/voice-api/concepts/websockets
+type VonageConnectedMessage struct {
+	// Event is "websocket:connected"
+	Event string
+	// ContentType is the audio format of the binary frames, e.g. "audio/l16;rate=16000"
+	ContentType string
+	// Headers are the custom "headers" of the NCCO websocket endpoint, the Vonage counterpart of
+	// Twilio <Parameter>-s. Vonage merges them into the connected message itself.
+	Headers map[string]string
+}
+
+// VonageEvent is any of the text messages Vonage sends after "websocket:connected".
+type VonageEvent struct {
+	Event string `json:"event"`
+	// Digit for event = "websocket:dtmf"
+	Digit string `json:"digit,omitempty"`
+}
+
+// parseVonageConnectedMessage keeps all non-string headers as their JSON representation.
+func parseVonageConnectedMessage(msgBytes []byte) (*VonageConnectedMessage, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(msgBytes, &fields); err != nil {
+		return nil, fmt.Errorf("cannot decode vonage connected message: %w", err)
+	}
+
+	result := &VonageConnectedMessage{Headers: make(map[string]string)}
+	for name, rawValue := range fields {
+		var value string
+		if err := json.Unmarshal(rawValue, &value); err != nil {
+			value = string(rawValue)
+		}
+		switch name {
+		case "event":
+			result.Event = value
+		case "content-type":
+			result.ContentType = value
+		default:
+			result.Headers[name] = value
+		}
+	}
+	return result, nil
+}
+
+// getSampleRate parses "audio/l16;rate=16000", Vonage only supports linear16 in 8000 and 16000.
+func (m VonageConnectedMessage) getSampleRate() (int, error) {
+	parts := strings.Split(m.ContentType, ";")
+	if strings.TrimSpace(parts[0]) != "audio/l16" {
+		return 0, fmt.Errorf("unsupported vonage content-type %q", m.ContentType)
+	}
+	for _, part := range parts[1:] {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if name != "rate" {
+			continue
+		}
+		sampleRate, err := strconv.Atoi(value)
+		if err != nil || (sampleRate != 8000 && sampleRate != 16000) {
+			return 0, fmt.Errorf("unsupported vonage sample rate in content-type %q", m.ContentType)
+		}
+		return sampleRate, nil
+	}
+	return 0, fmt.Errorf("no rate in vonage content-type %q", m.ContentType)
+}
@@ -0,0 +1,106 @@
+package audioio
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/go-audio/audio"
+	"github.com/petrzlen/vocode-golang/pkg/audio_utils"
+	"github.com/petrzlen/vocode-golang/pkg/telephony"
+	"github.com/pion/webrtc/v4"
+	"strings"
+)
+
+// webrtcCodec converts between the telephony.SdpSampleRate samples of webrtcHandler and the RTP payloads
+// of RtpFrameDuration. It is NOT safe for concurrent use, the Opus encoder and decoder keep state between frames.
+type webrtcCodec interface {
+	// Encode returns the payloads of all the frames, the last frame is padded with silence.
+	Encode(samples []int) ([][]byte, error)
+	// Silence is the payload of one frame of it, sent when there is nothing to play.
+	Silence() []byte
+	// Decode a nil payload conceals a lost packet.
+	Decode(payload []byte) ([]int, error)
+}
+
+// newWebrtcCodec for the negotiated mimeType, Opus is only supported with the opus build tag.
+func newWebrtcCodec(mimeType string) (webrtcCodec, error) {
+	switch {
+	case strings.EqualFold(mimeType, webrtc.MimeTypePCMU):
+		return pcmuCodec{}, nil
+	case strings.EqualFold(mimeType, webrtc.MimeTypeOpus):
+		// Everything else in the handler is 8kHz, so is the Opus, the browser decodes whatever it gets.
+		encoder, err := audio_utils.NewOpusEncoder(telephony.SdpSampleRate)
+		if err != nil {
+			return nil, err
+		}
+		decoder, err := audio_utils.NewOpusDecoder(telephony.SdpSampleRate)
+		if err != nil {
+			return nil, err
+		}
+		return &opusCodec{encoder: encoder, decoder: decoder}, nil
+	default:
+		return nil, fmt.Errorf("unsupported webrtc codec %s", mimeType)
+	}
+}
+
+type pcmuCodec struct{}
+
+func (c pcmuCodec) Encode(samples []int) ([][]byte, error) {
+	mulawBytes, err := audio_utils.EncodeToMulaw(&audio.IntBuffer{
+		Data:   samples,
+		Format: &audio.Format{SampleRate: telephony.SdpSampleRate, NumChannels: 1},
+	}, telephony.SdpSampleRate)
+	if err != nil {
+		return nil, err
+	}
+	result := make([][]byte, 0, len(mulawBytes)/RtpFrameSize+1)
+	for start := 0; start < len(mulawBytes); start += RtpFrameSize {
+		frame := c.Silence()
+		copy(frame, mulawBytes[start:min(start+RtpFrameSize, len(mulawBytes))])
+		result = append(result, frame)
+	}
+	return result, nil
+}
+
+func (c pcmuCodec) Silence() []byte {
+	return bytes.Repeat([]byte{MulawSilenceByte}, RtpFrameSize)
+}
+
+func (c pcmuCodec) Decode(payload []byte) ([]int, error) {
+	if payload == nil {
+		return make([]int, RtpFrameSize), nil
+	}
+	return audio_utils.DecodeFromMulaw(payload, telephony.SdpSampleRate).Data, nil
+}
+
+type opusCodec struct {
+	encoder *audio_utils.OpusEncoder
+	decoder *audio_utils.OpusDecoder
+}
+
+func (c *opusCodec) Encode(samples []int) ([][]byte, error) {
+	result := make([][]byte, 0, len(samples)/RtpFrameSize+1)
+	for start := 0; start < len(samples); start += RtpFrameSize {
+		frame := make([]int, RtpFrameSize)
+		copy(frame, samples[start:min(start+RtpFrameSize, len(samples))])
+		packet, err := c.encoder.Encode(frame)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, packet)
+	}
+	return result, nil
+}
+
+func (c *opusCodec) Silence() []byte {
+	// Through the encoder, so its state follows what the browser got.
+	packets, err := c.Encode(make([]int, RtpFrameSize))
+	if err != nil {
+		errLog(err, "opusCodec.Silence")
+		return nil
+	}
+	return packets[0]
+}
+
+func (c *opusCodec) Decode(payload []byte) ([]int, error) {
+	return c.decoder.Decode(payload, RtpFrameSize)
+}
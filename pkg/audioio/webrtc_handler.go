@@ -0,0 +1,478 @@
+package audioio
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-audio/audio"
+	"github.com/petrzlen/vocode-golang/internal/networking"
+	"github.com/petrzlen/vocode-golang/pkg/audio_utils"
+	"github.com/petrzlen/vocode-golang/pkg/models"
+	"github.com/petrzlen/vocode-golang/pkg/telephony"
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media"
+	"github.com/rs/zerolog/log"
+	"sync"
+	"time"
+)
+
+// WebrtcEventsChannelLabel is the data channel the page opens for the BrowserEvent-s, e.g. the live transcript.
+const WebrtcEventsChannelLabel = "events"
+
+// webrtcJitterDelay in packets, more than over SIP as the browser is usually on Wi-Fi or mobile data.
+const webrtcJitterDelay = 5
+
+// webrtcHandler is the rtpHandler for a WebRTC call, the audio goes in Opus or PCMU over the (DTLS-SRTP) peer connection,
+// and the BrowserEvent-s over a data channel, same as they go over the websocket for browserHandler.
+// The signaling is done by networking.WebrtcServer, which creates it for each offer.
+type webrtcHandler struct {
+	call      networking.WebrtcCall
+	startTime time.Time
+	// writeMutex guards isStarted, isStopped, onShutdown, eventsChannel and pendingEvents.
+	writeMutex  sync.Mutex
+	isStarted   bool
+	isStopped   bool
+	onShutdown  func()
+	stoppedChan chan struct{}
+	finishOnce  sync.Once
+	// hasTrack is true once the receiveRoutine runs, which then finishes the call.
+	hasTrack      bool
+	eventsChannel *webrtc.DataChannel
+	// pendingEvents were sent before the data channel opened, e.g. the greeting transcript.
+	pendingEvents []string
+	// clockWaitGroup lets finish wait for the clockRoutine, so nothing is sent into the closed recordingChan.
+	clockWaitGroup sync.WaitGroup
+
+	// The outbound frames wait in playQueue to be sent in real time, same as for rtpHandler.
+	// playMutex also guards outboundCodec, so the frames are encoded in the order they are sent.
+	playMutex     sync.Mutex
+	playQueue     [][]byte
+	outboundCodec webrtcCodec
+	// Timestamp (since startTime) of the end of playQueue, to put the outbound audio on the recording timeline.
+	playNextTimestamp time.Duration
+
+	// jitterMutex also guards inboundCodec, which is only known once the track arrives.
+	jitterMutex  sync.Mutex
+	jitterBuffer *rtpJitterBuffer
+	inboundCodec webrtcCodec
+
+	// Both sides of the call for QA, saved into recordingSink when the call ends.
+	recorder      *callRecorder
+	recordingSink RecordingSink
+
+	// Package interface
+	recordingChan chan models.AudioData
+	chunker       *speechChunker
+}
+
+// NewWebrtcHandler the audio only starts flowing on networking.WebrtcCallHandler.Start, what is played before is queued.
+func NewWebrtcHandler(call networking.WebrtcCall) (*webrtcHandler, error) {
+	outboundCodec, err := newWebrtcCodec(call.LocalTrack.Codec().MimeType)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create the outbound codec: %w", err)
+	}
+	result := &webrtcHandler{
+		call:          call,
+		isStarted:     false,
+		isStopped:     false,
+		onShutdown:    nil,
+		stoppedChan:   make(chan struct{}),
+		hasTrack:      false,
+		eventsChannel: nil,
+		pendingEvents: make([]string, 0),
+
+		playQueue:         make([][]byte, 0),
+		outboundCodec:     outboundCodec,
+		playNextTimestamp: 0,
+
+		jitterBuffer: newRtpJitterBuffer(webrtcJitterDelay),
+		inboundCodec: nil,
+
+		recorder:      newCallRecorder(telephony.SdpSampleRate),
+		recordingSink: NewLocalDirRecordingSink("output"),
+
+		// Package interface
+		recordingChan: nil,
+		chunker: newSpeechChunker(telephony.SdpSampleRate, "webrtc", func(sample int16) bool {
+			return -browserSilenceAmplitude < sample && sample < browserSilenceAmplitude
+		}),
+	}
+	result.chunker.onSpeech = result.recorder.AddCallerTurn
+	call.PeerConnection.OnTrack(result.handleTrack)
+	call.PeerConnection.OnDataChannel(result.handleDataChannel)
+	return result, nil
+}
+
+// Start implements networking.WebrtcCallHandler.Start
+func (wh *webrtcHandler) Start() {
+	wh.writeMutex.Lock()
+	defer wh.writeMutex.Unlock()
+	// The connection can go through disconnected and back to connected, e.g. on a network switch.
+	if wh.isStarted || wh.isStopped {
+		return
+	}
+	wh.isStarted = true
+	// Play reads startTime under playMutex.
+	wh.playMutex.Lock()
+	wh.startTime = time.Now()
+	wh.playMutex.Unlock()
+
+	log.Info().Str("call_id", wh.call.CallId).Msg("webrtc started")
+	wh.clockWaitGroup.Add(1)
+	go wh.clockRoutine()
+}
+
+// StartRecording implements InputDevice.StartRecording
+func (wh *webrtcHandler) StartRecording(recordingChan chan models.AudioData) error {
+	wh.recordingChan = recordingChan
+	return nil
+}
+
+// SetRecordingSink sets where the stereo call recording is saved once the call ends, nil disables it.
+func (wh *webrtcHandler) SetRecordingSink(sink RecordingSink) {
+	wh.recordingSink = sink
+}
+
+// SetSilenceThresholds implements SilenceThresholdSetter.SetSilenceThresholds
+func (wh *webrtcHandler) SetSilenceThresholds(speech time.Duration, silence time.Duration) {
+	wh.chunker.SetSilenceThresholds(speech, silence)
+}
+
+// StreamAudioFrames implements AudioFrameStreamer.StreamAudioFrames
+func (wh *webrtcHandler) StreamAudioFrames() {
+	wh.chunker.StreamAudioFrames()
+}
+
+// OnShutdown implements ShutdownNotifier.OnShutdown
+func (wh *webrtcHandler) OnShutdown(callback func()) {
+	wh.writeMutex.Lock()
+	defer wh.writeMutex.Unlock()
+	wh.onShutdown = callback
+}
+
+// Shutdown implements networking.ShutdownAwareHandler.Shutdown
+func (wh *webrtcHandler) Shutdown() {
+	wh.writeMutex.Lock()
+	onShutdown := wh.onShutdown
+	wh.writeMutex.Unlock()
+
+	if onShutdown == nil {
+		errLog(wh.Stop(), "webrtcHandler.Stop on shutdown")
+		return
+	}
+	log.Info().Str("call_id", wh.call.CallId).Msg("webrtcHandler wrapping up the call on shutdown")
+	onShutdown()
+}
+
+// OnTranscript implements TranscriptListener.OnTranscript
+func (wh *webrtcHandler) OnTranscript(role string, text string) {
+	wh.sendEvent(BrowserEvent{Event: "transcript", Role: role, Text: text})
+}
+
+func (wh *webrtcHandler) StopRecording() ([]byte, error) {
+	err := wh.Stop()
+
+	return nil, err
+}
+
+// Stop implements OutputDevice.Stop and networking.WebrtcCallHandler.Stop
+// It closes the peer connection, unless the other peer already did.
+func (wh *webrtcHandler) Stop() error {
+	wh.writeMutex.Lock()
+	if wh.isStopped {
+		wh.writeMutex.Unlock()
+		log.Debug().Str("call_id", wh.call.CallId).Msg("webrtcHandler already stopped")
+		return nil
+	}
+	wh.isStopped = true
+	hasTrack := wh.hasTrack
+	close(wh.stoppedChan)
+	wh.writeMutex.Unlock()
+	log.Info().Str("call_id", wh.call.CallId).Msg("webrtcHandler stop")
+
+	// This ends the receiveRoutine, which then closes the recordingChan.
+	if wh.call.Hangup != nil {
+		wh.call.Hangup()
+	}
+	if !hasTrack {
+		// Never received anything, so there is no receiveRoutine to finish.
+		wh.finish()
+	}
+	return nil
+}
+
+// Hangup implements CallController.Hangup, once everything queued was played to the other peer,
+// and the "hangup" event went out.
+func (wh *webrtcHandler) Hangup() error {
+	wh.sendEvent(BrowserEvent{Event: "hangup"})
+	go func() {
+		for {
+			wh.playMutex.Lock()
+			isPlaying := len(wh.playQueue) > 0
+			wh.playMutex.Unlock()
+			if !isPlaying && !wh.hasPendingEvents() {
+				errLog(wh.Stop(), "webrtcHandler.Stop on hangup")
+				return
+			}
+			select {
+			case <-wh.stoppedChan:
+				return
+			case <-time.After(RtpFrameDuration):
+			}
+		}
+	}()
+	return nil
+}
+
+// Transfer implements CallController.Transfer
+func (wh *webrtcHandler) Transfer(target string) error {
+	return fmt.Errorf("cannot transfer a webrtc call to %s", target)
+}
+
+// SendDigits implements CallController.SendDigits
+func (wh *webrtcHandler) SendDigits(digits string) error {
+	return fmt.Errorf("cannot send digits %s to a webrtc call", digits)
+}
+
+// Play implements OutputDevice.Play
+// The audio is queued, and sent one RtpFrameDuration sample at a time by clockRoutine.
+func (wh *webrtcHandler) Play(intBuffer *audio.IntBuffer) (*sync.WaitGroup, error) {
+	if intBuffer.Format == nil || intBuffer.Format.NumChannels != 1 {
+		return nil, fmt.Errorf("webrtcHandler can only play mono audio")
+	}
+	samples := intBuffer.Data
+	if intBuffer.Format.SampleRate != telephony.SdpSampleRate {
+		samples = audio_utils.ResampleSimple(samples, intBuffer.Format.SampleRate, telephony.SdpSampleRate)
+	}
+
+	wh.playMutex.Lock()
+	defer wh.playMutex.Unlock()
+	frames, err := wh.outboundCodec.Encode(samples)
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode the webrtc frames: %w", err)
+	}
+	// The queue was empty for a while, so this continues after a gap of silence.
+	if sinceStart := time.Since(wh.startTime); len(wh.playQueue) == 0 && !wh.startTime.IsZero() && wh.playNextTimestamp < sinceStart {
+		wh.playNextTimestamp = sinceStart.Truncate(time.Millisecond)
+	}
+	wh.recorder.AddOutbound(wh.playNextTimestamp.Milliseconds(), samples)
+
+	wh.playQueue = append(wh.playQueue, frames...)
+	wh.playNextTimestamp += time.Duration(len(frames)) * RtpFrameDuration
+	return nil, nil
+}
+
+// clockRoutine sends one frame, and plays out one inbound packet each RtpFrameDuration.
+func (wh *webrtcHandler) clockRoutine() {
+	defer wh.clockWaitGroup.Done()
+	ticker := time.NewTicker(RtpFrameDuration)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-wh.stoppedChan:
+			return
+		case <-ticker.C:
+			wh.sendFrame()
+			wh.playoutFrame()
+		}
+	}
+}
+
+// sendFrame sends silence when there is nothing to play, so the browser keeps a steady playout.
+func (wh *webrtcHandler) sendFrame() {
+	wh.playMutex.Lock()
+	var frame []byte
+	if len(wh.playQueue) > 0 {
+		frame = wh.playQueue[0]
+		wh.playQueue = wh.playQueue[1:]
+	} else {
+		frame = wh.outboundCodec.Silence()
+	}
+	wh.playMutex.Unlock()
+	if frame == nil {
+		return
+	}
+
+	// Sequence numbers and timestamps are taken care of by the track.
+	err := wh.call.LocalTrack.WriteSample(media.Sample{Data: frame, Duration: RtpFrameDuration})
+	if err != nil {
+		log.Trace().Err(err).Str("call_id", wh.call.CallId).Msg("cannot write webrtc sample")
+	}
+}
+
+// playoutFrame takes the next inbound packet out of the jitter buffer, lost packets are replaced by silence.
+func (wh *webrtcHandler) playoutFrame() {
+	wh.jitterMutex.Lock()
+	packet, ok := wh.jitterBuffer.Pop()
+	inboundCodec := wh.inboundCodec
+	wh.jitterMutex.Unlock()
+	if !ok || inboundCodec == nil {
+		return
+	}
+
+	var payload []byte
+	if packet != nil {
+		payload = packet.Payload
+	}
+	samples, err := inboundCodec.Decode(payload)
+	if err != nil {
+		log.Debug().Err(err).Str("call_id", wh.call.CallId).Msg("cannot decode webrtc packet, concealing it")
+		samples = make([]int, RtpFrameSize)
+	}
+	// RTP timestamps are not wall-clock, so the inbound timeline is just the samples received.
+	wh.recorder.AddInbound(int64(wh.chunker.Len()*1000/telephony.SdpSampleRate), samples)
+	for _, audioData := range wh.chunker.Add(samples) {
+		wh.recordingChan <- audioData
+	}
+}
+
+// handleTrack is the PeerConnection.OnTrack callback, only the first audio track is listened to.
+func (wh *webrtcHandler) handleTrack(track *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+	if track.Kind() != webrtc.RTPCodecTypeAudio {
+		log.Warn().Str("call_id", wh.call.CallId).Str("kind", track.Kind().String()).Msg("ignoring unsupported webrtc track")
+		return
+	}
+	inboundCodec, err := newWebrtcCodec(track.Codec().MimeType)
+	if err != nil {
+		log.Warn().Err(err).Str("call_id", wh.call.CallId).Str("mime_type", track.Codec().MimeType).Msg("ignoring unsupported webrtc track")
+		return
+	}
+	wh.writeMutex.Lock()
+	if wh.hasTrack || wh.isStopped {
+		wh.writeMutex.Unlock()
+		log.Warn().Str("call_id", wh.call.CallId).Msg("ignoring another webrtc audio track")
+		return
+	}
+	wh.hasTrack = true
+	wh.writeMutex.Unlock()
+
+	wh.jitterMutex.Lock()
+	wh.inboundCodec = inboundCodec
+	wh.jitterMutex.Unlock()
+	wh.receiveRoutine(track)
+}
+
+func (wh *webrtcHandler) receiveRoutine(track *webrtc.TrackRemote) {
+	log.Info().Str("call_id", wh.call.CallId).Uint32("ssrc", uint32(track.SSRC())).Msg("webrtc receiving audio")
+	for {
+		// The packets are already decrypted, and sent through the interceptors (NACK-s, RTCP reports).
+		rtpPacket, _, err := track.ReadRTP()
+		if err != nil {
+			log.Debug().Err(err).Str("call_id", wh.call.CallId).Msg("webrtc track ended")
+			break
+		}
+		packet := &RtpPacket{
+			PayloadType:    int(rtpPacket.PayloadType),
+			Marker:         rtpPacket.Marker,
+			SequenceNumber: rtpPacket.SequenceNumber,
+			Timestamp:      rtpPacket.Timestamp,
+			Ssrc:           rtpPacket.SSRC,
+			Payload:        rtpPacket.Payload,
+		}
+
+		wh.jitterMutex.Lock()
+		isPushed := wh.jitterBuffer.Push(packet)
+		wh.jitterMutex.Unlock()
+		if !isPushed {
+			log.Trace().Str("call_id", wh.call.CallId).Uint16("sequence_number", packet.SequenceNumber).Msg("dropped late rtp packet")
+		}
+	}
+
+	// After reading done, there is no more to produce, nor anyone to write to.
+	errLog(wh.Stop(), "webrtcHandler.Stop after track ended")
+	wh.finish()
+}
+
+// handleDataChannel is the PeerConnection.OnDataChannel callback.
+func (wh *webrtcHandler) handleDataChannel(dataChannel *webrtc.DataChannel) {
+	if dataChannel.Label() != WebrtcEventsChannelLabel {
+		log.Warn().Str("call_id", wh.call.CallId).Str("label", dataChannel.Label()).Msg("ignoring unknown webrtc data channel")
+		return
+	}
+	dataChannel.OnOpen(func() {
+		wh.writeMutex.Lock()
+		defer wh.writeMutex.Unlock()
+		wh.eventsChannel = dataChannel
+		for _, msg := range wh.pendingEvents {
+			errLog(dataChannel.SendText(msg), "webrtc events SendText")
+		}
+		wh.pendingEvents = nil
+	})
+	dataChannel.OnMessage(func(msg webrtc.DataChannelMessage) {
+		logMessage("received", msg.Data)
+		var event BrowserEvent
+		if err := json.Unmarshal(msg.Data, &event); err != nil {
+			log.Error().Err(err).Msgf("couldn't decode msg from webrtc events: %s", string(msg.Data))
+			return
+		}
+		switch event.Event {
+		case "stop":
+			log.Info().Str("call_id", wh.call.CallId).Msg("the user ended the webrtc call")
+			errLog(wh.Stop(), "webrtcHandler.Stop on stop event")
+		default:
+			log.Debug().Str("call_id", wh.call.CallId).Msgf("ignoring webrtc event %s", event.Event)
+		}
+	})
+}
+
+// sendEvent queues the event until the data channel is open.
+func (wh *webrtcHandler) sendEvent(event BrowserEvent) {
+	msgBytes, err := json.Marshal(event)
+	if err != nil {
+		errLog(err, "json.Marshal webrtc event")
+		return
+	}
+	logMessage("sending", msgBytes)
+
+	wh.writeMutex.Lock()
+	defer wh.writeMutex.Unlock()
+	if wh.isStopped {
+		log.Trace().Str("call_id", wh.call.CallId).Msg("cannot send event after webrtcHandler isStopped")
+		return
+	}
+	if wh.eventsChannel == nil {
+		wh.pendingEvents = append(wh.pendingEvents, string(msgBytes))
+		return
+	}
+	errLog(wh.eventsChannel.SendText(string(msgBytes)), "webrtc events SendText")
+}
+
+// hasPendingEvents is true while the data channel still has events to send, i.e. closing now would lose them.
+func (wh *webrtcHandler) hasPendingEvents() bool {
+	wh.writeMutex.Lock()
+	defer wh.writeMutex.Unlock()
+	return wh.eventsChannel != nil && wh.eventsChannel.BufferedAmount() > 0
+}
+
+// finish closes the recordingChan and saves the recording, only once.
+func (wh *webrtcHandler) finish() {
+	wh.finishOnce.Do(func() {
+		wh.clockWaitGroup.Wait()
+		if wh.recordingChan != nil {
+			log.Info().Str("call_id", wh.call.CallId).Msg("wh.recordingChan CLOSE")
+			close(wh.recordingChan)
+		}
+		wh.saveRecording()
+	})
+}
+
+// saveRecording stores the stereo recording of both sides of the call into the recordingSink.
+func (wh *webrtcHandler) saveRecording() {
+	if wh.recordingSink == nil {
+		log.Debug().Str("call_id", wh.call.CallId).Msg("no call recording to save")
+		return
+	}
+
+	wavBytes, metadata, err := wh.recorder.EncodeStereoWav()
+	if err != nil {
+		errLog(err, "callRecorder.EncodeStereoWav")
+		return
+	}
+	if len(wavBytes) == 0 {
+		log.Info().Str("call_id", wh.call.CallId).Msg("call recording is empty, not saving")
+		return
+	}
+	metadata.CallSid = wh.call.CallId
+
+	log.Info().Str("call_id", wh.call.CallId).Msgf("webrtc finished, gonna save %d bytes of call recording", len(wavBytes))
+	errLog(wh.recordingSink.Save("call-webrtc-"+wh.call.CallId, wavBytes, metadata), "recordingSink.Save")
+}
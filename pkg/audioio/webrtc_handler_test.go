@@ -0,0 +1,214 @@
+package audioio
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/go-audio/audio"
+	"github.com/petrzlen/vocode-golang/internal/networking"
+	"github.com/petrzlen/vocode-golang/pkg/audio_utils"
+	"github.com/petrzlen/vocode-golang/pkg/models"
+	"github.com/petrzlen/vocode-golang/pkg/telephony"
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newToneFrames is an "aaa" of the caller, at telephony.SdpSampleRate.
+func newToneFrames(t *testing.T, codec webrtcCodec, duration time.Duration, amplitude float64) [][]byte {
+	samples := make([]int, int(duration.Seconds()*telephony.SdpSampleRate))
+	for i := range samples {
+		samples[i] = int(amplitude * math.Sin(2*math.Pi*440*float64(i)/telephony.SdpSampleRate))
+	}
+	frames, err := codec.Encode(samples)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return frames
+}
+
+// newLoopbackPeerConnection is the browser, it offers the same codecs a browser does but only gathers on loopback.
+func newLoopbackPeerConnection(t *testing.T) *webrtc.PeerConnection {
+	mediaEngine := &webrtc.MediaEngine{}
+	if err := mediaEngine.RegisterDefaultCodecs(); err != nil {
+		t.Fatal(err)
+	}
+	settingEngine := webrtc.SettingEngine{}
+	settingEngine.SetIncludeLoopbackCandidate(true)
+	settingEngine.SetNetworkTypes([]webrtc.NetworkType{webrtc.NetworkTypeUDP4})
+	settingEngine.SetInterfaceFilter(func(name string) bool { return name == "lo" })
+	peerConnection, err := webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine), webrtc.WithSettingEngine(settingEngine)).NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return peerConnection
+}
+
+func TestWebrtcHandlerLoopbackCall(t *testing.T) {
+	handlerChan := make(chan *webrtcHandler, 1)
+	recordingChan := make(chan models.AudioData, 100)
+	sink := chanRecordingSink{savedChan: make(chan RecordingMetadata, 1)}
+	server, err := networking.NewWebrtcServer(networking.WebrtcServerConfig{IncludeLoopbackCandidates: true}, func(call networking.WebrtcCall) (networking.WebrtcCallHandler, error) {
+		handler, err := NewWebrtcHandler(call)
+		if err != nil {
+			return nil, err
+		}
+		handler.SetRecordingSink(sink)
+		handler.SetSilenceThresholds(200*time.Millisecond, 300*time.Millisecond)
+		if err := handler.StartRecording(recordingChan); err != nil {
+			return nil, err
+		}
+		// Queued until the data channel opens.
+		handler.OnTranscript("agent", "Hello, how can I help?")
+		handlerChan <- handler
+		return handler, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpServer := httptest.NewServer(http.HandlerFunc(server.HandleOffer))
+	defer httpServer.Close()
+
+	// The caller side, Opus when the server supports it, same as with a browser.
+	callerCapability := webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypePCMU, ClockRate: 8000, Channels: 1}
+	if audio_utils.IsOpusSupported {
+		callerCapability = webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2}
+	}
+	expectedMimeType := callerCapability.MimeType
+	peerConnection := newLoopbackPeerConnection(t)
+	defer peerConnection.Close()
+	callerTrack, err := webrtc.NewTrackLocalStaticSample(callerCapability, "audio", "caller")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := peerConnection.AddTrack(callerTrack); err != nil {
+		t.Fatal(err)
+	}
+	eventsChan := make(chan BrowserEvent, 10)
+	eventsChannel, err := peerConnection.CreateDataChannel(WebrtcEventsChannelLabel, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eventsChannel.OnMessage(func(msg webrtc.DataChannelMessage) {
+		var event BrowserEvent
+		if json.Unmarshal(msg.Data, &event) == nil {
+			eventsChan <- event
+		}
+	})
+	agentAudioChan := make(chan string, 1)
+	peerConnection.OnTrack(func(track *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		for {
+			if _, _, err := track.ReadRTP(); err != nil {
+				return
+			}
+			select {
+			case agentAudioChan <- track.Codec().MimeType:
+			default:
+			}
+		}
+	})
+
+	// The plain HTTP signaling, with all the candidates in the offer.
+	offer, err := peerConnection.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gatheringComplete := webrtc.GatheringCompletePromise(peerConnection)
+	if err := peerConnection.SetLocalDescription(offer); err != nil {
+		t.Fatal(err)
+	}
+	<-gatheringComplete
+	response, err := http.Post(httpServer.URL+"?transport=webrtc", "application/sdp", bytes.NewReader([]byte(peerConnection.LocalDescription().SDP)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	answer, err := io.ReadAll(response.Body)
+	errLog(response.Body.Close(), "response.Body.Close")
+	if err != nil || response.StatusCode != http.StatusCreated {
+		t.Fatalf("offer not answered %d: %s %v", response.StatusCode, answer, err)
+	}
+	if err := peerConnection.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: string(answer)}); err != nil {
+		t.Fatal(err)
+	}
+	handler := <-handlerChan
+
+	// The agent speaks, which the caller has to hear.
+	_, err = handler.Play(&audio.IntBuffer{
+		Data:   make([]int, telephony.SdpSampleRate/2),
+		Format: &audio.Format{SampleRate: telephony.SdpSampleRate, NumChannels: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case mimeType := <-agentAudioChan:
+		if mimeType != expectedMimeType {
+			t.Errorf("expected the agent audio in %s, got %s", expectedMimeType, mimeType)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the caller did not hear the agent")
+	}
+	select {
+	case event := <-eventsChan:
+		if event.Event != "transcript" || event.Text != "Hello, how can I help?" {
+			t.Errorf("unexpected event %+v", event)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the caller did not get the transcript")
+	}
+
+	// The caller speaks, and pauses.
+	callerCodec, err := newWebrtcCodec(expectedMimeType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames := append(newToneFrames(t, callerCodec, 600*time.Millisecond, 4000), newToneFrames(t, callerCodec, 600*time.Millisecond, 0)...)
+	go func() {
+		ticker := time.NewTicker(RtpFrameDuration)
+		defer ticker.Stop()
+		for _, frame := range frames {
+			<-ticker.C
+			errLog(callerTrack.WriteSample(media.Sample{Data: frame, Duration: RtpFrameDuration}), "callerTrack.WriteSample")
+		}
+	}()
+	var events []models.AudioData
+	for len(events) < 2 {
+		select {
+		case audioData := <-recordingChan:
+			events = append(events, audioData)
+		case <-time.After(10 * time.Second):
+			t.Fatalf("expected the speech followed by a submit, got %d events", len(events))
+		}
+	}
+	if events[0].EventType != models.AudioInput || events[1].EventType != models.SubmitPrompt {
+		t.Fatalf("expected the speech followed by a submit, got %v and %v", events[0].EventType, events[1].EventType)
+	}
+	if events[0].Length < 400*time.Millisecond || events[0].Length > 800*time.Millisecond {
+		t.Errorf("expected about 600ms of speech, got %s", events[0].Length)
+	}
+
+	// The caller hangs up, which ends the call on our side too.
+	if err := peerConnection.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for audioData := range recordingChan {
+		if audioData.EventType != models.AudioInput && audioData.EventType != models.SubmitPrompt {
+			t.Errorf("unexpected event %v after the hangup", audioData.EventType)
+		}
+	}
+	select {
+	case metadata := <-sink.savedChan:
+		if metadata.CallSid != handler.call.CallId {
+			t.Errorf("unexpected call recording metadata %+v", metadata)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the call recording was not saved")
+	}
+	if server.Count() != 0 {
+		t.Errorf("expected no active calls, got %d", server.Count())
+	}
+}
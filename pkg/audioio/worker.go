@@ -15,6 +15,11 @@ func PlayAudioChunksRoutine(outputDevice OutputDevice, audioDataChan chan models
 
 	i := 0
 	for audioData := range audioDataChan {
+		if audioData.EventType == models.CallActionRequest {
+			errLog(ExecuteCallAction(outputDevice, *audioData.Action), "ExecuteCallAction")
+			continue
+		}
+
 		rawAudioBytes := audioData.ByteData
 		fileFormat := audioData.Format
 
@@ -66,6 +71,29 @@ func PlayAudioChunksRoutine(outputDevice OutputDevice, audioDataChan chan models
 	log.Info().Msgf("playAudioChunksRoutine finished")
 }
 
+// ExecuteCallAction runs the action if outputDevice is a CallController, e.g. a phone call.
+func ExecuteCallAction(outputDevice OutputDevice, action models.CallAction) error {
+	controller, ok := outputDevice.(CallController)
+	if !ok {
+		return fmt.Errorf("output device %T cannot execute call action %s", outputDevice, action.Tag())
+	}
+
+	if err := action.Validate(); err != nil {
+		return fmt.Errorf("refusing call action %s: %w", action.Tag(), err)
+	}
+	log.Info().Str("action", action.Tag()).Msg("executing call action")
+	switch action.Kind {
+	case models.CallActionHangup:
+		return controller.Hangup()
+	case models.CallActionTransfer:
+		return controller.Transfer(action.Argument)
+	case models.CallActionSendDigits:
+		return controller.SendDigits(action.Argument)
+	default:
+		return fmt.Errorf("unknown call action %s", action.Tag())
+	}
+}
+
 func dbg(err error) {
 	if err != nil {
 		log.Debug().Err(err).Msg("sth non-essential failed")
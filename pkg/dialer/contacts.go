@@ -0,0 +1,118 @@
+package dialer
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NumberColumn is the required csv column with the phone number to dial, all other columns are Contact.Variables.
+const NumberColumn = "number"
+
+// Contact is one row of the campaign csv.
+type Contact struct {
+	Number string
+	// Variables are passed to the call as stream custom parameters, e.g. "agent_profile_id", "greeting" or "name",
+	// the agent only sees the ones in its profile CallerMetadataKeys.
+	// Set "answering_machine_action" to "hangup" or "leave_message" (with "voicemail_message") to detect voicemails.
+	Variables map[string]string
+}
+
+// ReadContactsCsv reads a csv with a header row, which has to include NumberColumn.
+func ReadContactsCsv(r io.Reader) ([]Contact, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read contacts csv header: %w", err)
+	}
+	numberIdx := -1
+	for i, column := range header {
+		header[i] = strings.TrimSpace(column)
+		if header[i] == NumberColumn {
+			numberIdx = i
+		}
+	}
+	if numberIdx < 0 {
+		return nil, fmt.Errorf("contacts csv header %v has no %q column", header, NumberColumn)
+	}
+
+	var result []Contact
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot read contacts csv row %d: %w", len(result)+1, err)
+		}
+
+		contact := Contact{
+			Number:    strings.TrimSpace(row[numberIdx]),
+			Variables: make(map[string]string),
+		}
+		if contact.Number == "" {
+			return nil, fmt.Errorf("contacts csv row %d has an empty %s", len(result)+1, NumberColumn)
+		}
+		for i, value := range row {
+			if i != numberIdx && value != "" {
+				contact.Variables[header[i]] = value
+			}
+		}
+		result = append(result, contact)
+	}
+	return result, nil
+}
+
+// Result of dialing one Contact.
+type Result struct {
+	Number    string
+	CallSid   string
+	Status    string // Last known call status, see telephony.FinalCallStatuses
+	Error     string
+	StartedAt time.Time
+	EndedAt   time.Time
+}
+
+var resultsCsvHeader = []string{NumberColumn, "call_sid", "status", "error", "started_at", "ended_at", "duration_seconds"}
+
+// NewResultsCsvWriter writes the header, and then returns a func to write one Result per row as they come.
+func NewResultsCsvWriter(w io.Writer) (func(result Result) error, error) {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(resultsCsvHeader); err != nil {
+		return nil, fmt.Errorf("cannot write results csv header: %w", err)
+	}
+	writer.Flush()
+
+	return func(result Result) error {
+		duration := ""
+		if !result.StartedAt.IsZero() && !result.EndedAt.IsZero() {
+			duration = strconv.Itoa(int(result.EndedAt.Sub(result.StartedAt).Seconds()))
+		}
+		row := []string{
+			result.Number,
+			result.CallSid,
+			result.Status,
+			result.Error,
+			formatTime(result.StartedAt),
+			formatTime(result.EndedAt),
+			duration,
+		}
+		if err := writer.Write(row); err != nil {
+			return fmt.Errorf("cannot write results csv row for %s: %w", result.Number, err)
+		}
+		writer.Flush()
+		return writer.Error()
+	}, nil
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,66 @@
+package dialer
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadContactsCsv(t *testing.T) {
+	contacts, err := ReadContactsCsv(strings.NewReader("name, number ,agent_profile_id\nAlice, +14155550100,sales\nBob,+14155550101,\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(contacts) != 2 {
+		t.Fatalf("expected 2 contacts, got %d", len(contacts))
+	}
+	if alice := contacts[0]; alice.Number != "+14155550100" || alice.Variables["name"] != "Alice" || alice.Variables["agent_profile_id"] != "sales" {
+		t.Errorf("unexpected contact %+v", alice)
+	}
+	// Empty values are left out, so the agent profile defaults apply.
+	if bob := contacts[1]; len(bob.Variables) != 1 || bob.Variables["name"] != "Bob" {
+		t.Errorf("unexpected contact %+v", bob)
+	}
+}
+
+func TestReadContactsCsvMalformed(t *testing.T) {
+	tests := map[string]string{
+		"empty file":          "",
+		"no number column":    "name,phone\nAlice,+14155550100\n",
+		"missing field":       "name,number\nAlice,+14155550100\nBob\n",
+		"extra field":         "name,number\nAlice,+14155550100,sales\n",
+		"empty number":        "name,number\nAlice,+14155550100\nBob, \n",
+		"unterminated quotes": "name,number\n\"Alice,+14155550100\n",
+	}
+	for name, csvText := range tests {
+		t.Run(name, func(t *testing.T) {
+			if contacts, err := ReadContactsCsv(strings.NewReader(csvText)); err == nil {
+				t.Errorf("expected an error, got %+v", contacts)
+			}
+		})
+	}
+}
+
+func TestResultsCsvWriter(t *testing.T) {
+	var buffer bytes.Buffer
+	write, err := NewResultsCsvWriter(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	startedAt := time.Date(2024, 3, 5, 9, 0, 0, 0, time.UTC)
+	err = write(Result{Number: "+14155550100", CallSid: "CA1", Status: "completed", StartedAt: startedAt, EndedAt: startedAt.Add(95 * time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = write(Result{Number: "+14155550101", Error: "invalid number, \"To\""}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "number,call_sid,status,error,started_at,ended_at,duration_seconds\n" +
+		"+14155550100,CA1,completed,,2024-03-05T09:00:00Z,2024-03-05T09:01:35Z,95\n" +
+		"+14155550101,,,\"invalid number, \"\"To\"\"\",,,\n"
+	if buffer.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, buffer.String())
+	}
+}
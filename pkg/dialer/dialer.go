@@ -0,0 +1,237 @@
+// Package dialer places outbound calls for a campaign of contacts, and connects the answered ones
+// to the same TwiML voice webhook (and so the websocket pipeline) as inbound calls.
+package dialer
+
+import (
+	"context"
+	"fmt"
+	"github.com/petrzlen/vocode-golang/pkg/telephony"
+	"github.com/rs/zerolog/log"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// CallingHours is the daily window when we are allowed to call, e.g. 9:00 - 18:00 in the contacts time zone.
+type CallingHours struct {
+	Start time.Duration // Since midnight
+	// End since midnight, End < Start wraps around midnight e.g. 22:00 - 06:00, End == Start means no restriction.
+	End      time.Duration
+	Location *time.Location
+}
+
+// ParseCallingHours parses "09:00-18:00" in timeZone, empty hours mean no restriction.
+// An overnight window like "22:00-06:00" is allowed, an empty one like "09:00-09:00" is not.
+func ParseCallingHours(hours string, timeZone string) (CallingHours, error) {
+	location, err := time.LoadLocation(timeZone)
+	if err != nil {
+		return CallingHours{}, fmt.Errorf("cannot load time zone %s: %w", timeZone, err)
+	}
+	result := CallingHours{Location: location}
+	if hours == "" {
+		return result, nil
+	}
+
+	var startHour, startMinute, endHour, endMinute int
+	if _, err = fmt.Sscanf(hours, "%d:%d-%d:%d", &startHour, &startMinute, &endHour, &endMinute); err != nil {
+		return result, fmt.Errorf("calling hours %q are not in format 09:00-18:00: %w", hours, err)
+	}
+	for _, hour := range []int{startHour, endHour} {
+		if hour < 0 || hour > 23 {
+			return result, fmt.Errorf("calling hours %q have an invalid hour %d", hours, hour)
+		}
+	}
+	for _, minute := range []int{startMinute, endMinute} {
+		if minute < 0 || minute > 59 {
+			return result, fmt.Errorf("calling hours %q have an invalid minute %d", hours, minute)
+		}
+	}
+	result.Start = time.Duration(startHour)*time.Hour + time.Duration(startMinute)*time.Minute
+	result.End = time.Duration(endHour)*time.Hour + time.Duration(endMinute)*time.Minute
+	if result.Start == result.End {
+		return result, fmt.Errorf("calling hours %q are empty, leave them out to call at any time", hours)
+	}
+	return result, nil
+}
+
+// NextAllowed returns now if we can call right away, otherwise the next time the calling hours start.
+func (h CallingHours) NextAllowed(now time.Time) time.Time {
+	if h.End == h.Start {
+		return now
+	}
+	local := now.In(h.Location)
+	// Wall clock, as a day with a daylight saving change does not have 24 hours.
+	sinceMidnight := time.Duration(local.Hour())*time.Hour + time.Duration(local.Minute())*time.Minute +
+		time.Duration(local.Second())*time.Second + time.Duration(local.Nanosecond())
+	if h.End < h.Start {
+		// Overnight, so the calls are allowed before End and after Start, and not in between.
+		if sinceMidnight < h.End || sinceMidnight >= h.Start {
+			return now
+		}
+		return h.startOn(local, 0)
+	}
+	switch {
+	case sinceMidnight < h.Start:
+		return h.startOn(local, 0)
+	case sinceMidnight < h.End:
+		return now
+	default:
+		return h.startOn(local, 1)
+	}
+}
+
+// startOn is the Start of the calling hours on the day of local, plus days.
+func (h CallingHours) startOn(local time.Time, days int) time.Time {
+	minutes := int(h.Start / time.Minute)
+	return time.Date(local.Year(), local.Month(), local.Day()+days, minutes/60, minutes%60, 0, 0, h.Location)
+}
+
+type Config struct {
+	From string
+	// VoiceUrl is our TwiML voice webhook, Contact.Variables are added as its query parameters.
+	VoiceUrl          string
+	StatusCallbackUrl string
+	// Concurrency is the max number of calls in progress at the same time.
+	Concurrency  int
+	CallingHours CallingHours
+	// PollInterval is how often we check the status of calls in progress.
+	PollInterval time.Duration
+	// MaxCallDuration after which we stop waiting for a call to finish, and free up its slot.
+	MaxCallDuration time.Duration
+}
+
+// Run dials all contacts while respecting the concurrency and calling hours, passing each Result to onResult
+// once the call has finished. It returns when all contacts were dialed, or the ctx was cancelled.
+func Run(ctx context.Context, client telephony.Client, config Config, contacts []Contact, onResult func(result Result)) error {
+	if config.Concurrency <= 0 {
+		config.Concurrency = 1
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = 5 * time.Second
+	}
+	if config.MaxCallDuration <= 0 {
+		config.MaxCallDuration = time.Hour
+	}
+	log.Info().Int("num_contacts", len(contacts)).Int("concurrency", config.Concurrency).Msg("dialer starting")
+
+	slots := make(chan struct{}, config.Concurrency)
+	var wg sync.WaitGroup
+	var resultMutex sync.Mutex
+	for _, contact := range contacts {
+		if err := waitUntil(ctx, config.CallingHours.NextAllowed(time.Now())); err != nil {
+			break
+		}
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(contact Contact) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			result := dial(ctx, client, config, contact)
+			resultMutex.Lock()
+			defer resultMutex.Unlock()
+			onResult(result)
+		}(contact)
+	}
+
+	wg.Wait()
+	log.Info().Msg("dialer finished")
+	return ctx.Err()
+}
+
+// dial places the call and waits until it reaches one of telephony.FinalCallStatuses.
+func dial(ctx context.Context, client telephony.Client, config Config, contact Contact) (result Result) {
+	result = Result{
+		Number:    contact.Number,
+		StartedAt: time.Now(),
+	}
+	defer func() { result.EndedAt = time.Now() }()
+
+	voiceUrl, err := addQueryValues(config.VoiceUrl, contact.Variables)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	result.CallSid, err = client.CreateCall(telephony.OutboundCallRequest{
+		To:                contact.Number,
+		From:              config.From,
+		VoiceUrl:          voiceUrl,
+		StatusCallbackUrl: config.StatusCallbackUrl,
+	})
+	if err != nil {
+		log.Error().Err(err).Str("number", contact.Number).Msg("dialer cannot create call")
+		result.Error = err.Error()
+		return result
+	}
+	log.Info().Str("number", contact.Number).Str("call_sid", result.CallSid).Msg("dialer created call")
+
+	deadline := time.Now().Add(config.MaxCallDuration)
+	for time.Now().Before(deadline) {
+		if err = waitUntil(ctx, time.Now().Add(config.PollInterval)); err != nil {
+			result.Error = err.Error()
+			return result
+		}
+
+		status, err := client.GetCallStatus(result.CallSid)
+		if err != nil {
+			// Might be a temporary failure, the call itself is still going.
+			log.Warn().Err(err).Str("call_sid", result.CallSid).Msg("dialer cannot get call status")
+			continue
+		}
+		result.Status = status
+		if isInList(status, telephony.FinalCallStatuses) {
+			log.Info().Str("number", contact.Number).Str("call_sid", result.CallSid).Str("status", status).Msg("dialer call finished")
+			return result
+		}
+	}
+	result.Error = fmt.Sprintf("call did not finish within %s", config.MaxCallDuration)
+	return result
+}
+
+func addQueryValues(rawUrl string, values map[string]string) (string, error) {
+	parsedUrl, err := url.Parse(rawUrl)
+	if err != nil {
+		return "", fmt.Errorf("invalid voice url %s: %w", rawUrl, err)
+	}
+	query := parsedUrl.Query()
+	for name, value := range values {
+		query.Set(name, value)
+	}
+	parsedUrl.RawQuery = query.Encode()
+	return parsedUrl.String(), nil
+}
+
+// waitUntil sleeps until t, or returns the ctx error when cancelled sooner.
+func waitUntil(ctx context.Context, t time.Time) error {
+	waitFor := time.Until(t)
+	if waitFor <= 0 {
+		return ctx.Err()
+	}
+	log.Debug().Dur("wait_for", waitFor).Msg("dialer waiting")
+	timer := time.NewTimer(waitFor)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// isInList checks if a string is present in a slice of strings.
+func isInList(str string, list []string) bool {
+	for _, v := range list {
+		if v == str {
+			return true
+		}
+	}
+	return false
+}
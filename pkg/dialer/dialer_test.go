@@ -0,0 +1,279 @@
+package dialer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/petrzlen/vocode-golang/pkg/telephony"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return location
+}
+
+func TestParseCallingHours(t *testing.T) {
+	hours, err := ParseCallingHours("09:30-18:00", "America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hours.Start != 9*time.Hour+30*time.Minute || hours.End != 18*time.Hour || hours.Location.String() != "America/New_York" {
+		t.Errorf("unexpected calling hours %+v", hours)
+	}
+
+	overnight, err := ParseCallingHours("22:00-06:00", "Europe/Bratislava")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if overnight.Start != 22*time.Hour || overnight.End != 6*time.Hour {
+		t.Errorf("unexpected overnight calling hours %+v", overnight)
+	}
+
+	anyTime, err := ParseCallingHours("", "UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if next := anyTime.NextAllowed(now); !next.Equal(now) {
+		t.Errorf("expected no restriction, got %s", next)
+	}
+
+	for _, invalid := range [][2]string{
+		{"9-18", "UTC"},
+		{"24:00-18:00", "UTC"},
+		{"09:00-18:60", "UTC"},
+		{"09:00-09:00", "UTC"},
+		{"09:00-18:00", "Mars/Olympus_Mons"},
+	} {
+		if _, err := ParseCallingHours(invalid[0], invalid[1]); err == nil {
+			t.Errorf("expected %q in %s rejected", invalid[0], invalid[1])
+		}
+	}
+}
+
+func TestNextAllowed(t *testing.T) {
+	newYork := mustLoadLocation(t, "America/New_York")
+	bratislava := mustLoadLocation(t, "Europe/Bratislava")
+	daytime := CallingHours{Start: 9 * time.Hour, End: 18 * time.Hour, Location: newYork}
+	overnight := CallingHours{Start: 22 * time.Hour, End: 6 * time.Hour, Location: bratislava}
+
+	tests := []struct {
+		name     string
+		hours    CallingHours
+		now      time.Time
+		expected time.Time // Zero means now.
+	}{
+		// 13:00 UTC is 08:00 in New York, in winter.
+		{"before the window", daytime, time.Date(2024, 1, 15, 13, 0, 0, 0, time.UTC), time.Date(2024, 1, 15, 9, 0, 0, 0, newYork)},
+		{"within the window", daytime, time.Date(2024, 1, 15, 17, 0, 0, 0, time.UTC), time.Time{}},
+		{"at the end of the window", daytime, time.Date(2024, 1, 15, 18, 0, 0, 0, newYork), time.Date(2024, 1, 16, 9, 0, 0, 0, newYork)},
+		// 02:00 UTC on the 16th is still the 15th evening in New York.
+		{"after the window", daytime, time.Date(2024, 1, 16, 2, 0, 0, 0, time.UTC), time.Date(2024, 1, 16, 9, 0, 0, 0, newYork)},
+		// The clocks go forward at 02:00 on March 10th, so the day is only 23 hours long.
+		{"on the daylight saving change", daytime, time.Date(2024, 3, 10, 1, 0, 0, 0, newYork), time.Date(2024, 3, 10, 9, 0, 0, 0, newYork)},
+		{"within the window after the change", daytime, time.Date(2024, 3, 10, 17, 30, 0, 0, newYork), time.Time{}},
+		{"overnight before midnight", overnight, time.Date(2024, 1, 15, 23, 0, 0, 0, bratislava), time.Time{}},
+		{"overnight after midnight", overnight, time.Date(2024, 1, 16, 5, 59, 0, 0, bratislava), time.Time{}},
+		{"overnight at the end", overnight, time.Date(2024, 1, 16, 6, 0, 0, 0, bratislava), time.Date(2024, 1, 16, 22, 0, 0, 0, bratislava)},
+		// 12:00 UTC is 13:00 in Bratislava.
+		{"overnight during the day", overnight, time.Date(2024, 1, 16, 12, 0, 0, 0, time.UTC), time.Date(2024, 1, 16, 22, 0, 0, 0, bratislava)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expected := test.expected
+			if expected.IsZero() {
+				expected = test.now
+			}
+			if next := test.hours.NextAllowed(test.now); !next.Equal(expected) {
+				t.Errorf("expected %s, got %s", expected.In(test.hours.Location), next.In(test.hours.Location))
+			}
+		})
+	}
+}
+
+// twilioStub is the Twilio calls REST API, each call completes after a few status polls.
+type twilioStub struct {
+	t     *testing.T
+	mutex sync.Mutex
+	// polls of each call sid, and the calls created but not yet reported as completed.
+	polls          map[string]int
+	numActive      int
+	maxNumActive   int
+	createdCalls   []url.Values
+	failingNumbers map[string]bool
+}
+
+const twilioTestAccountSid = "AC0123456789"
+
+func newTwilioStub(t *testing.T) (*twilioStub, telephony.Client) {
+	stub := &twilioStub{t: t, polls: make(map[string]int), failingNumbers: make(map[string]bool)}
+	server := httptest.NewServer(stub)
+	t.Cleanup(server.Close)
+	return stub, telephony.NewTwilioClient(telephony.TwilioClientConfig{AccountSid: twilioTestAccountSid, AuthToken: "token", BaseUrl: server.URL})
+}
+
+func (stub *twilioStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if accountSid, authToken, ok := r.BasicAuth(); !ok || accountSid != twilioTestAccountSid || authToken != "token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	stub.mutex.Lock()
+	defer stub.mutex.Unlock()
+
+	callsPath := "/2010-04-01/Accounts/" + twilioTestAccountSid + "/Calls"
+	if r.Method == http.MethodPost && r.URL.Path == callsPath+".json" {
+		if err := r.ParseForm(); err != nil {
+			stub.t.Error(err)
+			return
+		}
+		if stub.failingNumbers[r.PostForm.Get("To")] {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"code": 21211, "message": "The 'To' number is not a valid phone number."}`))
+			return
+		}
+		stub.createdCalls = append(stub.createdCalls, r.PostForm)
+		callSid := fmt.Sprintf("CA%d", len(stub.createdCalls))
+		stub.polls[callSid] = 0
+		stub.numActive++
+		stub.maxNumActive = max(stub.maxNumActive, stub.numActive)
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]string{"sid": callSid, "status": "queued"})
+		return
+	}
+
+	callSid := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, callsPath+"/"), ".json")
+	polls, ok := stub.polls[callSid]
+	if r.Method != http.MethodGet || !ok {
+		stub.t.Errorf("unexpected twilio request %s %s", r.Method, r.URL.Path)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	stub.polls[callSid] = polls + 1
+	status := "in-progress"
+	switch {
+	case polls == 0:
+		status = "ringing"
+	case polls == 3:
+		status = "completed"
+		stub.numActive--
+	case polls > 3:
+		stub.t.Errorf("call %s polled after it completed", callSid)
+	}
+	_ = json.NewEncoder(w).Encode(map[string]string{"sid": callSid, "status": status})
+}
+
+func TestRunRespectsConcurrency(t *testing.T) {
+	stub, client := newTwilioStub(t)
+	stub.failingNumbers["+1415555"] = true
+	contacts := []Contact{
+		{Number: "+14155550100", Variables: map[string]string{"agent_profile_id": "sales", "name": "Alice"}},
+		{Number: "+14155550101", Variables: map[string]string{}},
+		{Number: "+1415555", Variables: map[string]string{}},
+		{Number: "+14155550102", Variables: map[string]string{}},
+		{Number: "+14155550103", Variables: map[string]string{}},
+	}
+	config := Config{
+		From:              "+14155550199",
+		VoiceUrl:          "https://example.com/twiml/voice?source=campaign",
+		StatusCallbackUrl: "https://example.com/twiml/status",
+		Concurrency:       2,
+		PollInterval:      5 * time.Millisecond,
+		MaxCallDuration:   time.Second,
+	}
+
+	results := make(map[string]Result)
+	err := Run(context.Background(), client, config, contacts, func(result Result) {
+		results[result.Number] = result
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stub.maxNumActive != config.Concurrency {
+		t.Errorf("expected at most %d calls at the same time, got %d", config.Concurrency, stub.maxNumActive)
+	}
+	if len(results) != len(contacts) || len(stub.createdCalls) != len(contacts)-1 {
+		t.Fatalf("expected %d results of %d calls, got %d of %d", len(contacts), len(contacts)-1, len(results), len(stub.createdCalls))
+	}
+	for _, contact := range contacts {
+		result := results[contact.Number]
+		if contact.Number == "+1415555" {
+			if result.CallSid != "" || !strings.Contains(result.Error, "not a valid phone number") {
+				t.Errorf("expected the twilio error, got %+v", result)
+			}
+			continue
+		}
+		if result.Status != "completed" || result.Error != "" || result.CallSid == "" || result.EndedAt.Before(result.StartedAt) {
+			t.Errorf("unexpected result %+v", result)
+		}
+	}
+
+	for _, form := range stub.createdCalls {
+		if form.Get("To") != "+14155550100" {
+			continue
+		}
+		voiceUrl, err := url.Parse(form.Get("Url"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The contact variables become the stream custom parameters.
+		query := voiceUrl.Query()
+		if voiceUrl.Host != "example.com" || query.Get("source") != "campaign" || query.Get("agent_profile_id") != "sales" || query.Get("name") != "Alice" {
+			t.Errorf("unexpected voice url %s", voiceUrl)
+		}
+		if form.Get("From") != config.From || form.Get("StatusCallback") != config.StatusCallbackUrl || len(form["StatusCallbackEvent"]) != 4 {
+			t.Errorf("unexpected call request %v", form)
+		}
+	}
+}
+
+func TestRunStopsOnCancel(t *testing.T) {
+	stub, client := newTwilioStub(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	contacts := []Contact{{Number: "+14155550100"}, {Number: "+14155550101"}}
+	config := Config{
+		Concurrency: 1,
+		// Not within this test.
+		PollInterval: time.Minute,
+	}
+
+	errChan := make(chan error, 1)
+	var results []Result
+	go func() {
+		errChan <- Run(ctx, client, config, contacts, func(result Result) {
+			results = append(results, result)
+		})
+	}()
+	for {
+		stub.mutex.Lock()
+		numCreated := len(stub.createdCalls)
+		stub.mutex.Unlock()
+		if numCreated > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+
+	select {
+	case err := <-errChan:
+		if err != context.Canceled {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run did not stop on cancel")
+	}
+	if len(stub.createdCalls) != 1 || len(results) != 1 || results[0].Error != context.Canceled.Error() {
+		t.Errorf("expected only the first call placed and cancelled, got %d calls and %+v", len(stub.createdCalls), results)
+	}
+}
@@ -0,0 +1,81 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+)
+
+type CallActionKind string
+
+const (
+	CallActionHangup     CallActionKind = "hangup"
+	CallActionTransfer   CallActionKind = "transfer"
+	CallActionSendDigits CallActionKind = "dtmf"
+)
+
+// CallAction is something the agent wants to do with the call itself, instead of just saying something.
+// In the chat output it is written as a tag like "[[hangup]]" or "[[transfer:+14155550100]]".
+type CallAction struct {
+	Kind     CallActionKind
+	Argument string // Transfer target or digits to send, empty for hangup
+}
+
+var callActionTagRegex = regexp.MustCompile(`^\[\[(hangup|transfer|dtmf)(?::([^\]]*))?\]\]$`)
+
+// sendDigitsRegex are the phone keys, "w" waits half a second in between.
+var sendDigitsRegex = regexp.MustCompile(`^[0-9*#w]+$`)
+
+// Tag is the text representation of the action as the agent writes it.
+func (a CallAction) Tag() string {
+	if a.Argument == "" {
+		return fmt.Sprintf("[[%s]]", a.Kind)
+	}
+	return fmt.Sprintf("[[%s:%s]]", a.Kind, a.Argument)
+}
+
+// ParseCallActionTag returns the action if tag is exactly one valid action tag.
+// NOTE: The transfer target is whatever the tag says, the caller can talk the agent into anything,
+// so it must be replaced with a configured one before executing it.
+func ParseCallActionTag(tag string) (CallAction, bool) {
+	if !IsCallActionTag(tag) {
+		return CallAction{}, false
+	}
+	matches := callActionTagRegex.FindStringSubmatch(tag)
+	action := CallAction{Kind: CallActionKind(matches[1]), Argument: matches[2]}
+	return action, action.Validate() == nil
+}
+
+// IsCallActionTag is true for anything written as an action tag, even when its argument is invalid.
+func IsCallActionTag(tag string) bool {
+	return callActionTagRegex.MatchString(tag)
+}
+
+// Validate the argument of the action.
+func (a CallAction) Validate() error {
+	switch a.Kind {
+	case CallActionHangup:
+		if a.Argument != "" {
+			return fmt.Errorf("hangup takes no argument, got %q", a.Argument)
+		}
+	case CallActionTransfer:
+		if a.Argument == "" {
+			return fmt.Errorf("transfer without a target")
+		}
+	case CallActionSendDigits:
+		if !sendDigitsRegex.MatchString(a.Argument) {
+			return fmt.Errorf("invalid digits %q", a.Argument)
+		}
+	default:
+		return fmt.Errorf("unknown call action %s", a.Kind)
+	}
+	return nil
+}
+
+func NewAudioDataCallAction(action CallAction, creator string) AudioData {
+	return AudioData{
+		EventType: CallActionRequest,
+		Action:    &action,
+		Text:      action.Tag(),
+		Trace:     NewTrace(creator),
+	}
+}
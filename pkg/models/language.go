@@ -0,0 +1,120 @@
+package models
+
+import (
+	"strings"
+	"sync"
+)
+
+// LanguageAuto as the configured language means it is detected from what the caller says, same as empty.
+const LanguageAuto = "auto"
+
+// minWordsToSwitchLanguage so a short "Okay" or a name misdetected by Whisper does not switch the whole call.
+const minWordsToSwitchLanguage = 3
+
+// languageNames by ISO 639-1, the names are how Whisper reports the detected language in verbose_json.
+var languageNames = map[string]string{
+	"en": "English",
+	"es": "Spanish",
+	"fr": "French",
+	"de": "German",
+	"it": "Italian",
+	"pt": "Portuguese",
+	"nl": "Dutch",
+	"pl": "Polish",
+	"cs": "Czech",
+	"sk": "Slovak",
+	"ru": "Russian",
+	"uk": "Ukrainian",
+	"bg": "Bulgarian",
+	"el": "Greek",
+	"ar": "Arabic",
+	"fa": "Persian",
+	"he": "Hebrew",
+	"hi": "Hindi",
+	"th": "Thai",
+	"ko": "Korean",
+	"ja": "Japanese",
+	"zh": "Chinese",
+	"tr": "Turkish",
+	"vi": "Vietnamese",
+}
+
+// LanguageCode returns the ISO 639-1 code of a language as the transcribers or the configs name it,
+// e.g. "english", "Spanish", "en-US" or "es".
+func LanguageCode(language string) string {
+	language = strings.ToLower(strings.TrimSpace(language))
+	for code, name := range languageNames {
+		if language == strings.ToLower(name) {
+			return code
+		}
+	}
+	// e.g. Deepgram "en-US"
+	code, _, _ := strings.Cut(language, "-")
+	return code
+}
+
+// LanguageName for the prompts, the code itself when it is not known.
+func LanguageName(code string) string {
+	if name, ok := languageNames[code]; ok {
+		return name
+	}
+	return code
+}
+
+// ConversationLanguage is the language of one call, either forced by the config, or detected from what the caller says.
+// It is safe for concurrent use, as the transcriber detects it while the agent and the synthesizer follow it.
+type ConversationLanguage struct {
+	mutex    sync.RWMutex
+	code     string
+	isForced bool
+}
+
+// NewConversationLanguage language is a code or a name, empty or LanguageAuto detects it from the first utterance.
+func NewConversationLanguage(language string) *ConversationLanguage {
+	code := LanguageCode(language)
+	if code == LanguageAuto {
+		code = ""
+	}
+	return &ConversationLanguage{
+		code:     code,
+		isForced: code != "",
+	}
+}
+
+// Get returns the ISO 639-1 code, empty while it is not known yet.
+func (l *ConversationLanguage) Get() string {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	return l.code
+}
+
+// IsForced is true when the language is not up to the detection.
+func (l *ConversationLanguage) IsForced() bool {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	return l.isForced
+}
+
+// Set forces the language from now on, e.g. when the caller asked for it.
+func (l *ConversationLanguage) Set(language string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.code = LanguageCode(language)
+	l.isForced = l.code != ""
+}
+
+// Detected takes the language the transcriber detected in what the caller said, the first one is taken right away,
+// a different one later only with at least minWordsToSwitchLanguage words. Returns true when the language changed.
+func (l *ConversationLanguage) Detected(language string, wordCount int) bool {
+	code := LanguageCode(language)
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.isForced || code == "" || code == l.code || wordCount == 0 {
+		return false
+	}
+	if l.code != "" && wordCount < minWordsToSwitchLanguage {
+		return false
+	}
+	l.code = code
+	return true
+}
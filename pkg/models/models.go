@@ -27,6 +27,11 @@ const (
 	AudioInput AudioDataEvent = iota
 	AudioOutput
 	SubmitPrompt
+	// CallActionRequest travels in order with AudioOutput, so the action happens after everything before was played.
+	CallActionRequest
+	// AudioFrame is raw mono audio as it was captured, linear16 or mulaw by Format,
+	// for a transcriber.StreamingTranscriber to do its own turn detection.
+	AudioFrame
 )
 
 // AudioData
@@ -38,8 +43,11 @@ type AudioData struct {
 	ByteData  []byte
 	Format    string
 	Length    time.Duration
-	Text      string // text representation
-	Trace     Trace
+	Text      string      // text representation
+	Action    *CallAction // only set for CallActionRequest
+	// SampleRate is only set for AudioFrame, the other formats carry it themselves.
+	SampleRate int
+	Trace      Trace
 }
 
 func NewAudioDataSubmit(creator string) AudioData {
@@ -49,6 +57,30 @@ func NewAudioDataSubmit(creator string) AudioData {
 	}
 }
 
+// NewAudioDataFrame linear16Bytes are mono signed 16bit little-endian samples.
+func NewAudioDataFrame(linear16Bytes []byte, sampleRate int, creator string) AudioData {
+	return AudioData{
+		EventType:  AudioFrame,
+		ByteData:   linear16Bytes,
+		Format:     "linear16",
+		Length:     time.Duration(len(linear16Bytes)/2) * time.Second / time.Duration(sampleRate),
+		SampleRate: sampleRate,
+		Trace:      NewTrace(creator),
+	}
+}
+
+// NewAudioDataMulawFrame mulawBytes are mono G.711 mu-law samples as the phone calls carry them.
+func NewAudioDataMulawFrame(mulawBytes []byte, sampleRate int, creator string) AudioData {
+	return AudioData{
+		EventType:  AudioFrame,
+		ByteData:   mulawBytes,
+		Format:     "mulaw",
+		Length:     time.Duration(len(mulawBytes)) * time.Second / time.Duration(sampleRate),
+		SampleRate: sampleRate,
+		Trace:      NewTrace(creator),
+	}
+}
+
 func NewTrace(creator string) Trace {
 	return Trace{
 		CreatedAt: time.Now(),
@@ -0,0 +1,81 @@
+package pipeline
+
+import (
+	"github.com/petrzlen/vocode-golang/pkg/audio_utils"
+	"github.com/petrzlen/vocode-golang/pkg/audioio"
+	"github.com/petrzlen/vocode-golang/pkg/models"
+	"github.com/rs/zerolog/log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// AnsweringMachineAction is what an outbound call does when a machine picks up.
+type AnsweringMachineAction string
+
+const (
+	AnsweringMachineHangup       AnsweringMachineAction = "hangup"
+	AnsweringMachineLeaveMessage AnsweringMachineAction = "leave_message"
+)
+
+// maxWaitForBeep after the machine verdict, some voicemails have no (or an undetectable) beep.
+const maxWaitForBeep = 20 * time.Second
+
+var hangupTag = models.CallAction{Kind: models.CallActionHangup}.Tag()
+
+// answeringMachineHandler holds back the greeting and the agent until we know a human picked up.
+type answeringMachineHandler struct {
+	config            CallConfig
+	allChatOutputChan chan string
+	isAgentEnabled    *atomic.Bool
+	leaveMessageOnce  sync.Once
+}
+
+// startAnsweringMachineDetection returns false if the detection is disabled, or not supported by input.
+// Otherwise isAgentEnabled is only set once the detection says a human picked up, and the greeting is pushed at that time.
+func startAnsweringMachineDetection(config CallConfig, input audioio.InputDevice, allChatOutputChan chan string, isAgentEnabled *atomic.Bool) bool {
+	if config.AnsweringMachineAction == "" {
+		return false
+	}
+	detectable, ok := input.(audioio.AnsweringMachineDetectable)
+	if !ok {
+		log.Warn().Str("answering_machine_action", string(config.AnsweringMachineAction)).Msg("input device cannot detect answering machines, treating the call as answered by a human")
+		return false
+	}
+
+	handler := &answeringMachineHandler{
+		config:            config,
+		allChatOutputChan: allChatOutputChan,
+		isAgentEnabled:    isAgentEnabled,
+	}
+	detectable.DetectAnsweringMachine(audio_utils.DefaultAnsweringMachineConfig, handler.onEvent)
+	return true
+}
+
+func (h *answeringMachineHandler) onEvent(event audio_utils.AnsweringMachineEvent) {
+	switch event.Kind {
+	case audio_utils.AnsweringMachineHuman, audio_utils.AnsweringMachineNotSure:
+		h.isAgentEnabled.Store(true)
+		if h.config.Greeting != "" {
+			h.allChatOutputChan <- h.config.Greeting
+		}
+	case audio_utils.AnsweringMachineMachine:
+		if h.config.AnsweringMachineAction != AnsweringMachineLeaveMessage || h.config.VoicemailMessage == "" {
+			h.allChatOutputChan <- hangupTag
+			return
+		}
+		time.AfterFunc(maxWaitForBeep, func() {
+			h.leaveMessage("no beep detected")
+		})
+	case audio_utils.AnsweringMachineBeep:
+		h.leaveMessage("beep detected")
+	}
+}
+
+func (h *answeringMachineHandler) leaveMessage(reason string) {
+	h.leaveMessageOnce.Do(func() {
+		log.Info().Str("reason", reason).Msg("leaving voicemail message")
+		h.allChatOutputChan <- h.config.VoicemailMessage
+		h.allChatOutputChan <- hangupTag
+	})
+}
@@ -0,0 +1,112 @@
+package pipeline
+
+import (
+	"github.com/petrzlen/vocode-golang/pkg/models"
+	"github.com/rs/zerolog/log"
+	"strings"
+)
+
+// maxCallActionTagLength protects us from waiting forever on "[[" which is NOT a call action tag.
+const maxCallActionTagLength = 64
+
+// callActionParser cuts call action tags (e.g. "[[hangup]]") out of the streamed chat output,
+// even when a tag is split across multiple tokens.
+type callActionParser struct {
+	pending string
+	// isEnabled passes the valid tags on, otherwise all are dropped, so they are never said nor executed.
+	isEnabled bool
+	// transferTarget is the only one "[[transfer]]" goes to, whatever target the agent wrote,
+	// as the caller could talk it into e.g. a premium rate number. Empty drops the transfers.
+	transferTarget string
+}
+
+// Feed returns what to pass on in order, plain text and action tags each as a separate item.
+func (p *callActionParser) Feed(token string) []string {
+	p.pending += token
+
+	var result []string
+	for p.pending != "" {
+		start := strings.Index(p.pending, "[[")
+		if start < 0 {
+			text := p.pending
+			p.pending = ""
+			// A trailing "[" might start a tag with the next token.
+			if strings.HasSuffix(text, "[") {
+				text, p.pending = text[:len(text)-1], "["
+			}
+			if text != "" {
+				result = append(result, text)
+			}
+			break
+		}
+		if start > 0 {
+			result = append(result, p.pending[:start])
+			p.pending = p.pending[start:]
+		}
+
+		end := strings.Index(p.pending, "]]")
+		if end < 0 {
+			if len(p.pending) > maxCallActionTagLength {
+				// Not a tag after all, move on.
+				result = append(result, p.pending[:2])
+				p.pending = p.pending[2:]
+				continue
+			}
+			break // wait for more tokens
+		}
+
+		tag := p.pending[:end+2]
+		p.pending = p.pending[end+2:]
+		if !models.IsCallActionTag(tag) {
+			result = append(result, tag)
+			continue
+		}
+		if action, ok := p.parseAction(tag); ok {
+			result = append(result, action.Tag())
+		}
+	}
+	return result
+}
+
+// parseAction returns false for the tags to drop.
+func (p *callActionParser) parseAction(tag string) (models.CallAction, bool) {
+	if !p.isEnabled {
+		log.Warn().Str("tag", tag).Msg("dropping call action tag as call actions are not enabled")
+		return models.CallAction{}, false
+	}
+	action, ok := models.ParseCallActionTag(tag)
+	if action.Kind == models.CallActionTransfer {
+		if p.transferTarget == "" {
+			log.Warn().Str("tag", tag).Msg("dropping transfer as there is no transfer target configured")
+			return models.CallAction{}, false
+		}
+		if action.Argument != "" && action.Argument != p.transferTarget {
+			log.Warn().Str("tag", tag).Str("transfer_target", p.transferTarget).Msg("ignoring the transfer target written by the agent")
+		}
+		action.Argument = p.transferTarget
+		ok = true
+	}
+	if !ok {
+		log.Warn().Str("tag", tag).Msg("dropping invalid call action tag")
+		return models.CallAction{}, false
+	}
+	return action, true
+}
+
+// Flush returns the leftover text once the chat output is done.
+func (p *callActionParser) Flush() string {
+	result := p.pending
+	p.pending = ""
+	return result
+}
+
+// getCallActionsPrompt explains the agent how to use the call actions.
+func getCallActionsPrompt(transferTarget string) string {
+	prompt := " You can act on the call by writing one of these tags in your response:" +
+		" [[hangup]] ends the call, only use it after you said goodbye;" +
+		" [[dtmf:<digits>]] presses phone keys, e.g. to navigate a phone menu"
+	if transferTarget != "" {
+		prompt += "; [[transfer]] hands the caller over to a human, when they ask for one or you cannot help them"
+	}
+	return prompt + "."
+}
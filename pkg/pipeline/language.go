@@ -0,0 +1,60 @@
+package pipeline
+
+import (
+	"fmt"
+	"github.com/petrzlen/vocode-golang/pkg/models"
+	"github.com/petrzlen/vocode-golang/pkg/synthesizer"
+	"github.com/rs/zerolog/log"
+	"sync"
+)
+
+// getLanguagePrompt for the system prompt, an unknown language is left to the caller.
+func getLanguagePrompt(language string) string {
+	if language == "" {
+		return " Respond in the language the caller speaks."
+	}
+	return fmt.Sprintf(" Always respond in this language: %s.", models.LanguageName(language))
+}
+
+// getLanguageSwitchPrompt is added to the conversation when the caller switched the language mid-call.
+func getLanguageSwitchPrompt(language string) string {
+	return fmt.Sprintf("The caller now speaks %s, from now on always respond in %s.", models.LanguageName(language), models.LanguageName(language))
+}
+
+// languageSynthesizer follows the conversation language, with its voice and text normalization.
+type languageSynthesizer struct {
+	newSynthesizer func(voice string) synthesizer.Synthesizer
+	config         CallConfig
+	language       *models.ConversationLanguage
+
+	// synthesizers by voice, so switching back and forth does not create new ones.
+	mutex        sync.Mutex
+	synthesizers map[string]synthesizer.Synthesizer
+}
+
+func newLanguageSynthesizer(newSynthesizer func(voice string) synthesizer.Synthesizer, config CallConfig, language *models.ConversationLanguage) synthesizer.Synthesizer {
+	return &languageSynthesizer{
+		newSynthesizer: newSynthesizer,
+		config:         config,
+		language:       language,
+		synthesizers:   make(map[string]synthesizer.Synthesizer),
+	}
+}
+
+// CreateSpeech implements synthesizer.Synthesizer.CreateSpeech
+func (s *languageSynthesizer) CreateSpeech(text string, speed float64) (models.AudioData, error) {
+	language := s.language.Get()
+	return s.get(s.config.GetVoice(language)).CreateSpeech(synthesizer.NormalizeText(language, text), speed)
+}
+
+func (s *languageSynthesizer) get(voice string) synthesizer.Synthesizer {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	result, ok := s.synthesizers[voice]
+	if !ok {
+		log.Info().Str("voice", voice).Str("language", s.language.Get()).Msg("languageSynthesizer creating synthesizer")
+		result = s.newSynthesizer(voice)
+		s.synthesizers[voice] = result
+	}
+	return result
+}
@@ -0,0 +1,155 @@
+// Package pipeline wires up the transcriber -> agent -> synthesizer routines of a single call
+// between an audioio.InputDevice and an audioio.OutputDevice.
+package pipeline
+
+import (
+	"github.com/petrzlen/vocode-golang/pkg/agent"
+	"github.com/petrzlen/vocode-golang/pkg/audioio"
+	"github.com/petrzlen/vocode-golang/pkg/models"
+	"github.com/petrzlen/vocode-golang/pkg/synthesizer"
+	"github.com/petrzlen/vocode-golang/pkg/transcriber"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"sync/atomic"
+)
+
+// Providers are shared by all calls, so they must be safe for concurrent use.
+type Providers struct {
+	// Transcriber is wrapped by transcriber.NewVadStreamingTranscriber, unless StreamingTranscriber is set.
+	Transcriber transcriber.Transcriber
+	// StreamingTranscriber is optional, when set it is used instead of Transcriber.
+	// Either does the turn detection instead of the silence thresholds of the audioio.AudioFrameStreamer devices.
+	StreamingTranscriber transcriber.StreamingTranscriber
+	// HallucinationFilter is optional, it cleans up what the Transcriber made up, nil uses the default one.
+	HallucinationFilter *transcriber.HallucinationFilter
+	ChatAgent           agent.ChatAgent
+	// NewSynthesizer returns a synthesizer speaking with voice, empty voice means the synthesizer default.
+	NewSynthesizer func(voice string) synthesizer.Synthesizer
+}
+
+// Start runs the routines of one call until the input device closes its recordingChan.
+func Start(providers Providers, config CallConfig, input audioio.InputDevice, output audioio.OutputDevice) error {
+	log.Info().Str("agent_profile_id", config.AgentProfileId).Str("language", config.Language).Str("voice", config.Voice).Interface("caller_metadata", config.CallerMetadata).Msg("pipeline starting")
+
+	if setter, ok := input.(audioio.SilenceThresholdSetter); ok && config.SpeechThreshold > 0 && config.SilenceThreshold > 0 {
+		setter.SetSilenceThresholds(config.SpeechThreshold, config.SilenceThreshold)
+	}
+
+	// language is forced by the config, or followed from what the caller says by the transcriber.
+	language := models.NewConversationLanguage(config.Language)
+
+	inputAudioChunksChan := make(chan models.AudioData, 100000)
+	inputTextChunksChan := make(chan models.AudioData, 100000)
+	earlyTranscriptChan := make(chan string, 10)
+
+	allChatOutputChan := make(chan string, 100000)
+	// isAgentEnabled gates the agent, so it does not talk to an answering machine, nor after the shutdown message.
+	isAgentEnabled := &atomic.Bool{}
+	if !startAnsweringMachineDetection(config, input, allChatOutputChan, isAgentEnabled) {
+		isAgentEnabled.Store(true)
+		if config.Greeting != "" {
+			allChatOutputChan <- config.Greeting
+		}
+	}
+	if notifier, ok := input.(audioio.ShutdownNotifier); ok && config.ShutdownMessage != "" {
+		notifier.OnShutdown(func() {
+			isAgentEnabled.Store(false)
+			allChatOutputChan <- config.ShutdownMessage
+			allChatOutputChan <- hangupTag
+		})
+	}
+	audioToPlayChan := make(chan models.AudioData) // non-buffer
+
+	// The agent writes into allChatOutputChan, and the synthesizer reads what is to be said from chatOutputToSayChan.
+	transcribedTextChan, chatOutputToSayChan := inputTextChunksChan, allChatOutputChan
+	if listener, ok := output.(audioio.TranscriptListener); ok {
+		transcribedTextChan = teeUserTranscripts(listener, inputTextChunksChan)
+		chatOutputToSayChan = teeAgentTranscripts(listener, allChatOutputChan)
+	}
+
+	streamingTranscriber := providers.StreamingTranscriber
+	if streamingTranscriber == nil {
+		vadConfig := transcriber.DefaultVadConfig
+		vadConfig.Filter = providers.HallucinationFilter
+		if config.SilenceThreshold > 0 {
+			vadConfig.EndOfUtterance = config.SilenceThreshold
+		}
+		streamingTranscriber = transcriber.NewVadStreamingTranscriber(providers.Transcriber, vadConfig)
+	}
+	// The devices which cannot stream send their chunks, which go through the same turn detection.
+	if streamer, ok := input.(audioio.AudioFrameStreamer); ok {
+		streamer.StreamAudioFrames()
+	}
+	go transcriber.TranscribeAudioRoutine(streamingTranscriber, language, inputAudioChunksChan, inputTextChunksChan, earlyTranscriptChan)
+	go synthesizer.TextToSpeechAndEncodeRoutine(newLanguageSynthesizer(providers.NewSynthesizer, config, language), chatOutputToSayChan, audioToPlayChan)
+
+	go submitChatPromptRoutine(providers.ChatAgent, config, language, isAgentEnabled, transcribedTextChan, allChatOutputChan)
+
+	// TODO: need to add output buffer to collect what was actually played
+	go audioio.PlayAudioChunksRoutine(output, audioToPlayChan)
+
+	return input.StartRecording(inputAudioChunksChan)
+}
+
+func submitChatPromptRoutine(chatAgent agent.ChatAgent, config CallConfig, language *models.ConversationLanguage, isAgentEnabled *atomic.Bool, transcribedTextChan chan models.AudioData, allChatOutputChan chan string) {
+	var fullConvo models.Conversation
+	fullConvo.Add("system", config.GetSystemPrompt())
+	promptLanguage := language.Get()
+
+	chatPrompt := ""
+	for inputTextChunk := range transcribedTextChan {
+		// NOTE: This also gets emitted when silence is detected, and if there is too much silence it can spam this.
+		if inputTextChunk.EventType == models.SubmitPrompt {
+			// TODO: There are garbage prompts like " ", " You ", "Bye-bye" which we should ignore
+			// IDEA: We might want to use words-per-minute as good detection if someone is speaking or not.
+			// * i.e. we can refactor models.AudioData into input/output ones, and trace steps for it,
+			//   then we submit ONLY IF there were say three words said within 3 seconds or so.
+			if len(chatPrompt) < 15 {
+				log.Warn().Msgf("chatPrompt is too short %d, skipping", len(chatPrompt))
+				chatPrompt = ""
+				continue
+			}
+			if !isAgentEnabled.Load() {
+				log.Info().Msgf("skipping chatPrompt '%s' as the agent is not enabled", chatPrompt)
+				chatPrompt = ""
+				continue
+			}
+
+			// The transcriber detected it from this very prompt, so the agent should already respond in it.
+			if current := language.Get(); current != promptLanguage {
+				log.Info().Str("from", promptLanguage).Str("to", current).Msg("conversation language switched")
+				fullConvo.Add("system", getLanguageSwitchPrompt(current))
+				promptLanguage = current
+			}
+			fullConvo.Add("user", chatPrompt)
+			chatPrompt = ""
+
+			// TODO: this can lead to multiple agents producing at the same time.
+			subChatOutputChan := make(chan string, 10)
+			go func() {
+				// TODO: memory of what system said
+				errLog(chatAgent.RunPrompt(agent.SlowerAndSmarter, fullConvo, subChatOutputChan), "chatAgent.RunPrompt")
+			}()
+			go func() {
+				// The call action tags go as separate items, so the synthesizer can pass them on in order.
+				// Without call actions the tags are still cut out, so a caller cannot make the agent emit one.
+				parser := callActionParser{isEnabled: config.EnableCallActions, transferTarget: config.TransferTarget}
+				for chatOutput := range subChatOutputChan {
+					for _, output := range parser.Feed(chatOutput) {
+						allChatOutputChan <- output
+					}
+				}
+				if rest := parser.Flush(); rest != "" {
+					allChatOutputChan <- rest
+				}
+			}()
+		}
+		chatPrompt += inputTextChunk.Text + " "
+	}
+}
+
+func errLog(err error, what string) {
+	if err != nil {
+		log.Error().Err(errors.WithStack(err)).Msg(what)
+	}
+}
@@ -0,0 +1,36 @@
+package pipeline
+
+import (
+	"github.com/petrzlen/vocode-golang/pkg/audioio"
+	"github.com/petrzlen/vocode-golang/pkg/models"
+)
+
+// teeUserTranscripts passes the transcribed chunks on, and shows their text to listener on the way.
+func teeUserTranscripts(listener audioio.TranscriptListener, textChunksChan chan models.AudioData) chan models.AudioData {
+	result := make(chan models.AudioData, cap(textChunksChan))
+	go func() {
+		for textChunk := range textChunksChan {
+			if textChunk.EventType != models.SubmitPrompt && textChunk.Text != "" {
+				listener.OnTranscript("user", textChunk.Text)
+			}
+			result <- textChunk
+		}
+		close(result)
+	}()
+	return result
+}
+
+// teeAgentTranscripts passes the chat output on, and shows it to listener without the call action tags.
+func teeAgentTranscripts(listener audioio.TranscriptListener, chatOutputChan chan string) chan string {
+	result := make(chan string, cap(chatOutputChan))
+	go func() {
+		for chatOutput := range chatOutputChan {
+			if _, isAction := models.ParseCallActionTag(chatOutput); !isAction {
+				listener.OnTranscript("assistant", chatOutput)
+			}
+			result <- chatOutput
+		}
+		close(result)
+	}()
+	return result
+}
@@ -0,0 +1,95 @@
+package synthesizer
+
+import (
+	"regexp"
+	"strings"
+)
+
+// normalizationRule replaces what the TTS would read wrong, or in the wrong language, e.g. "Dr." or "%".
+type normalizationRule struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// newWordRule only matches whole words, so "Dr." does not match in "Mr. Dr.o".
+func newWordRule(abbreviation string, replacement string) normalizationRule {
+	return normalizationRule{
+		pattern:     regexp.MustCompile(`(^|[\s(¡¿"])` + regexp.QuoteMeta(abbreviation) + `($|[\s,;:)"])`),
+		replacement: "${1}" + replacement + "${2}",
+	}
+}
+
+// newSymbolRule puts the word after the number, e.g. "50%" is "50 por ciento".
+func newSymbolRule(symbol string, replacement string) normalizationRule {
+	return normalizationRule{
+		pattern:     regexp.MustCompile(`\s?` + regexp.QuoteMeta(symbol)),
+		replacement: " " + replacement,
+	}
+}
+
+// normalizationRules by ISO 639-1, the languages without any are passed as is.
+var normalizationRules = map[string][]normalizationRule{
+	"en": {
+		newWordRule("Dr.", "Doctor"),
+		newWordRule("Mr.", "Mister"),
+		newWordRule("Mrs.", "Missus"),
+		newWordRule("e.g.", "for example"),
+		newWordRule("i.e.", "that is"),
+		newWordRule("etc.", "et cetera"),
+		newSymbolRule("%", "percent"),
+		newSymbolRule("&", "and"),
+	},
+	"es": {
+		newWordRule("Dr.", "doctor"),
+		newWordRule("Dra.", "doctora"),
+		newWordRule("Sr.", "señor"),
+		newWordRule("Sra.", "señora"),
+		newWordRule("Ud.", "usted"),
+		newWordRule("Uds.", "ustedes"),
+		newWordRule("p. ej.", "por ejemplo"),
+		newWordRule("etc.", "etcétera"),
+		newSymbolRule("%", "por ciento"),
+		newSymbolRule("&", "y"),
+	},
+	"fr": {
+		newWordRule("M.", "Monsieur"),
+		newWordRule("Mme", "Madame"),
+		newWordRule("Dr", "Docteur"),
+		newWordRule("etc.", "et cetera"),
+		newSymbolRule("%", "pour cent"),
+		newSymbolRule("&", "et"),
+	},
+	"de": {
+		newWordRule("Dr.", "Doktor"),
+		newWordRule("z.B.", "zum Beispiel"),
+		newWordRule("z. B.", "zum Beispiel"),
+		newWordRule("usw.", "und so weiter"),
+		newWordRule("bzw.", "beziehungsweise"),
+		newSymbolRule("%", "Prozent"),
+		newSymbolRule("&", "und"),
+	},
+	"it": {
+		newWordRule("Dott.", "dottore"),
+		newWordRule("Sig.", "signore"),
+		newWordRule("Sig.ra", "signora"),
+		newWordRule("ecc.", "eccetera"),
+		newSymbolRule("%", "per cento"),
+		newSymbolRule("&", "e"),
+	},
+	"pt": {
+		newWordRule("Dr.", "doutor"),
+		newWordRule("Sr.", "senhor"),
+		newWordRule("Sra.", "senhora"),
+		newWordRule("etc.", "et cetera"),
+		newSymbolRule("%", "por cento"),
+		newSymbolRule("&", "e"),
+	},
+}
+
+// NormalizeText rewrites what a TTS would read wrong in the given ISO 639-1 language.
+func NormalizeText(language string, text string) string {
+	for _, rule := range normalizationRules[language] {
+		text = rule.pattern.ReplaceAllString(text, rule.replacement)
+	}
+	return strings.ReplaceAll(text, "  ", " ")
+}
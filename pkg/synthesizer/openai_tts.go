@@ -13,13 +13,26 @@ import (
 
 var httpClient = &http.Client{}
 
+const OpenAITTSDefaultVoice = "echo"
+
 type openAITTS struct {
 	apiKey string
+	voice  string
 }
 
 func NewOpenAITTS(openAIAPIKey string) Synthesizer {
+	return NewOpenAITTSWithVoice(openAIAPIKey, OpenAITTSDefaultVoice)
+}
+
+// NewOpenAITTSWithVoice voice is one of alloy, echo, fable, onyx, nova, and shimmer,
+// empty means OpenAITTSDefaultVoice https://platform.openai.com/docs/guides/text-to-speech/voice-options
+func NewOpenAITTSWithVoice(openAIAPIKey string, voice string) Synthesizer {
+	if voice == "" {
+		voice = OpenAITTSDefaultVoice
+	}
 	return &openAITTS{
 		apiKey: openAIAPIKey,
+		voice:  voice,
 	}
 }
 
@@ -32,12 +45,12 @@ func (o *openAITTS) CreateSpeech(text string, speed float64) (audioOutput models
 	// responseFormat := "flac"
 	// TODO(P2, ux): Opus should be a better format for streaming BUT I would probably painfully die making it work in Golang.
 
-	log.Debug().Str("input", text).Float64("speed", speed).Str("output_format", responseFormat).Str("model", model).Msg("sendTTSRequest start")
+	log.Debug().Str("input", text).Float64("speed", speed).Str("output_format", responseFormat).Str("model", model).Str("voice", o.voice).Msg("sendTTSRequest start")
 
 	payload := TTSPayload{
 		Model:          model,
 		Input:          text,
-		Voice:          "echo",
+		Voice:          o.voice,
 		ResponseFormat: responseFormat,
 		Speed:          speed,
 	}
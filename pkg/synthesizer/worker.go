@@ -5,6 +5,7 @@ import (
 	"github.com/petrzlen/vocode-golang/pkg/models"
 	"github.com/rs/zerolog/log"
 	"os"
+	"unicode/utf8"
 )
 
 // MinTextBufferForTtsCharLength is mostly to prevent saying like "1,"
@@ -15,47 +16,64 @@ func isPunctuationMarkAtEnd(s string) bool {
 	if len(s) == 0 {
 		return false
 	}
-	lastChar := s[len(s)-1]
+	lastChar, _ := utf8.DecodeLastRuneInString(s)
 	switch lastChar {
 	case ',', '.', '?', '!', ';', ':':
 		return true
+	// Chinese / Japanese and Arabic have their own.
+	case '、', '，', '。', '？', '！', '；', '：', '،', '؟':
+		return true
 	default:
 		return false
 	}
 }
 
 // TextToSpeechAndEncodeRoutine
+// Call action tags (see models.ParseCallActionTag) are not spoken, but passed on as models.CallActionRequest
+// in order with the audio, so the action only happens after the text before it was played.
 // TODO: We should implement some interrupt / stoppage here.
 func TextToSpeechAndEncodeRoutine(tts Synthesizer, textChan <-chan string, audioOutputChan chan<- models.AudioData) {
 	log.Info().Msgf("textToSpeechAndEncodeRoutine started")
 	var buffer string
 
 	i := 0
+	flushBuffer := func() {
+		i++
+		if i == 1 {
+			log.Warn().Msg("TRACING HACK: first eligible buffer triggered")
+		}
+		// Process the buffer;
+		// Speed 1.15 was reverse engineered from the ChatGPT app
+		audioOutput, err := tts.CreateSpeech(buffer, 1.15)
+		if err == nil {
+			// TODO(prod, P1): Only do this locally to debug stuff
+			debugFilename := fmt.Sprintf("output/tts-%d.%s", i, audioOutput.Format)
+			dbg(os.WriteFile(debugFilename, audioOutput.ByteData, 0644))
+
+			audioOutputChan <- audioOutput
+		} else {
+			log.Error().Msgf("cannot buffer tts text for %s cause %v", buffer, err)
+		}
+		buffer = "" // Clear the buffer after processing
+	}
+
 	for {
 		select {
 		case text, ok := <-textChan:
+			if action, isAction := models.ParseCallActionTag(text); ok && isAction {
+				if buffer != "" {
+					flushBuffer()
+				}
+				log.Info().Str("action", action.Tag()).Msg("textToSpeechAndEncodeRoutine passing on call action")
+				audioOutputChan <- models.NewAudioDataCallAction(action, "synthesizer.worker")
+				continue
+			}
 			if ok {
 				buffer += text
 			}
 			// log.Trace().Str("text", text).Bool("ok", ok).Str("buffer", buffer).Msg("text received")
 			if (len(buffer) > MinTextBufferForTtsCharLength && isPunctuationMarkAtEnd(buffer)) || (!ok && buffer != "") {
-				i++
-				if i == 1 {
-					log.Warn().Msg("TRACING HACK: first eligible buffer triggered")
-				}
-				// Process the buffer;
-				// Speed 1.15 was reverse engineered from the ChatGPT app
-				audioOutput, err := tts.CreateSpeech(buffer, 1.15)
-				if err == nil {
-					// TODO(prod, P1): Only do this locally to debug stuff
-					debugFilename := fmt.Sprintf("output/tts-%d.%s", i, audioOutput.Format)
-					dbg(os.WriteFile(debugFilename, audioOutput.ByteData, 0644))
-
-					audioOutputChan <- audioOutput
-				} else {
-					log.Error().Msgf("cannot buffer tts text for %s cause %v", buffer, err)
-				}
-				buffer = "" // Clear the buffer after processing
+				flushBuffer()
 			}
 			if !ok {
 				log.Info().Msgf("textToSpeechAndEncodeRoutine ended")
@@ -0,0 +1,190 @@
+package telephony
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TwilioApiBaseUrl is the default TwilioClientConfig.BaseUrl
+const TwilioApiBaseUrl = "https://api.twilio.com"
+
+// OutboundCallRequest for Client.CreateCall
+type OutboundCallRequest struct {
+	To   string // E.164 phone number
+	From string // One of your provider phone numbers
+	// VoiceUrl is our TwiML voice webhook, which connects the answered call to the websocket stream.
+	VoiceUrl string
+	// StatusCallbackUrl optionally receives the call status changes.
+	StatusCallbackUrl string
+}
+
+// Terminal call statuses, after these the call status never changes.
+var FinalCallStatuses = []string{"completed", "busy", "failed", "no-answer", "canceled"}
+
+// Client is the REST API of a telephony provider, acting on calls outside of their media stream.
+type Client interface {
+	// CreateCall dials out and returns the call sid.
+	CreateCall(request OutboundCallRequest) (callSid string, err error)
+	// GetCallStatus returns one of queued, ringing, in-progress or FinalCallStatuses.
+	GetCallStatus(callSid string) (status string, err error)
+
+	// Hangup ends the call.
+	Hangup(callSid string) error
+	// Transfer connects the caller to target, which is either a phone number in E.164 or a "sip:" URI.
+	Transfer(callSid string, target string) error
+	// SendDigits plays DTMF tones into the call, "w" waits for half a second.
+	SendDigits(callSid string, digits string) error
+}
+
+type TwilioClientConfig struct {
+	AccountSid string
+	AuthToken  string
+	// BaseUrl of the REST API, defaults to TwilioApiBaseUrl. Override it to test against a local stub.
+	BaseUrl string
+	// VoiceUrl is our TwiML voice webhook, after SendDigits the call is redirected there to reconnect the stream.
+	// If empty, the call ends after the digits are played.
+	VoiceUrl string
+}
+
+type twilioClient struct {
+	config     TwilioClientConfig
+	httpClient *http.Client
+}
+
+func NewTwilioClient(config TwilioClientConfig) Client {
+	if config.BaseUrl == "" {
+		config.BaseUrl = TwilioApiBaseUrl
+	}
+	return &twilioClient{
+		config:     config,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// twilioCallResource are the fields we need from https://www.twilio.com/docs/voice/api/call-resource
+type twilioCallResource struct {
+	Sid    string `json:"sid"`
+	Status string `json:"status"`
+}
+
+// CreateCall implements Client.CreateCall
+// https://www.twilio.com/docs/voice/api/call-resource#create-a-call-resource
+func (c *twilioClient) CreateCall(request OutboundCallRequest) (callSid string, err error) {
+	form := url.Values{
+		"To":   {request.To},
+		"From": {request.From},
+		"Url":  {request.VoiceUrl},
+	}
+	if request.StatusCallbackUrl != "" {
+		form.Set("StatusCallback", request.StatusCallbackUrl)
+		form["StatusCallbackEvent"] = []string{"initiated", "ringing", "answered", "completed"}
+	}
+
+	endpoint := fmt.Sprintf("Accounts/%s/Calls.json", c.config.AccountSid)
+	respBytes, err := c.sendRequest(http.MethodPost, endpoint, form)
+	if err != nil {
+		return
+	}
+	var call twilioCallResource
+	if err = json.Unmarshal(respBytes, &call); err != nil {
+		err = fmt.Errorf("cannot decode created call: %w", err)
+		return
+	}
+	callSid = call.Sid
+	return
+}
+
+// GetCallStatus implements Client.GetCallStatus
+func (c *twilioClient) GetCallStatus(callSid string) (status string, err error) {
+	endpoint := fmt.Sprintf("Accounts/%s/Calls/%s.json", c.config.AccountSid, callSid)
+	respBytes, err := c.sendRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return
+	}
+	var call twilioCallResource
+	if err = json.Unmarshal(respBytes, &call); err != nil {
+		err = fmt.Errorf("cannot decode call %s: %w", callSid, err)
+		return
+	}
+	status = call.Status
+	return
+}
+
+// Hangup implements Client.Hangup
+func (c *twilioClient) Hangup(callSid string) error {
+	return c.updateCall(callSid, url.Values{"Status": {"completed"}})
+}
+
+// Transfer implements Client.Transfer
+func (c *twilioClient) Transfer(callSid string, target string) error {
+	dial := &TwimlDial{}
+	if strings.HasPrefix(target, "sip:") {
+		dial.Sip = target
+	} else {
+		dial.Number = target
+	}
+	return c.updateCallTwiml(callSid, TwimlResponse{Dial: dial})
+}
+
+// SendDigits implements Client.SendDigits
+// NOTE: Updating the call TwiML ends the current stream, so the conversation continues on a new stream.
+func (c *twilioClient) SendDigits(callSid string, digits string) error {
+	twiml := TwimlResponse{Play: &TwimlPlay{Digits: digits}}
+	if c.config.VoiceUrl != "" {
+		twiml.Redirect = &TwimlRedirect{Url: c.config.VoiceUrl}
+	}
+	return c.updateCallTwiml(callSid, twiml)
+}
+
+func (c *twilioClient) updateCallTwiml(callSid string, twiml TwimlResponse) error {
+	twimlBytes, err := twiml.Render()
+	if err != nil {
+		return err
+	}
+	return c.updateCall(callSid, url.Values{"Twiml": {string(twimlBytes)}})
+}
+
+// updateCall https://www.twilio.com/docs/voice/api/call-resource#update-a-call-resource
+func (c *twilioClient) updateCall(callSid string, form url.Values) error {
+	endpoint := fmt.Sprintf("Accounts/%s/Calls/%s.json", c.config.AccountSid, callSid)
+	_, err := c.sendRequest(http.MethodPost, endpoint, form)
+	return err
+}
+
+func (c *twilioClient) sendRequest(method string, endpoint string, form url.Values) (result []byte, err error) {
+	requestStart := time.Now()
+	req, err := http.NewRequest(method, c.config.BaseUrl+"/2010-04-01/"+endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return
+	}
+	req.SetBasicAuth(c.config.AccountSid, c.config.AuthToken)
+	if form != nil {
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		err = fmt.Errorf("twilio request to %s failed: %w", endpoint, err)
+		return
+	}
+	defer func() { resp.Body.Close() }()
+
+	log.Debug().Dur("request_time", time.Since(requestStart)).Str("method", method).Str("endpoint", endpoint).Int("status_code", resp.StatusCode).Msg("twilio request done")
+
+	result, err = io.ReadAll(resp.Body)
+	if err != nil {
+		err = fmt.Errorf("could not read twilio response %w", err)
+		return
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		err = fmt.Errorf("received non-2xx status %d from %s: %s", resp.StatusCode, endpoint, result)
+		return
+	}
+	return
+}
@@ -0,0 +1,52 @@
+package telephony
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// VonageCallUuidHeader is the NCCO websocket header we pass the call uuid in, as Vonage does not add it itself.
+const VonageCallUuidHeader = "uuid"
+
+// NccoAction is one action of a Vonage Call Control Object https://developer.vonage.com/en/voice/voice-api/ncco-reference
+// Only the connect action to a websocket is modelled, as that is all we need to stream the call audio.
+type NccoAction struct {
+	Action   string         `json:"action"`
+	Endpoint []NccoEndpoint `json:"endpoint"`
+}
+
+// NccoEndpoint https://developer.vonage.com/en/voice/voice-api/ncco-reference#websocket-endpoint-connect-to-a-websocket
+type NccoEndpoint struct {
+	Type string `json:"type"`
+	Uri  string `json:"uri"`
+	// ContentType is the audio format, e.g. "audio/l16;rate=16000".
+	ContentType string `json:"content-type"`
+	// Headers end up in the first "websocket:connected" message of the stream.
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// NewConnectWebsocketNcco returns the NCCO which makes Vonage connect the call to our websocket at streamUrl.
+func NewConnectWebsocketNcco(streamUrl string, sampleRate int, headers map[string]string) []NccoAction {
+	return []NccoAction{
+		{
+			Action: "connect",
+			Endpoint: []NccoEndpoint{
+				{
+					Type:        "websocket",
+					Uri:         streamUrl,
+					ContentType: fmt.Sprintf("audio/l16;rate=%d", sampleRate),
+					Headers:     headers,
+				},
+			},
+		},
+	}
+}
+
+// RenderNcco returns the JSON document Vonage expects from the answer webhook.
+func RenderNcco(ncco []NccoAction) ([]byte, error) {
+	result, err := json.Marshal(ncco)
+	if err != nil {
+		return nil, fmt.Errorf("cannot marshal ncco: %w", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,123 @@
+package telephony
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// RTP payload types of the codecs we can speak https://datatracker.ietf.org/doc/html/rfc3551#section-6
+const (
+	RtpPayloadTypePcmu = 0
+	RtpPayloadTypePcma = 8
+)
+
+// SdpSampleRate both PCMU and PCMA are narrowband only.
+const SdpSampleRate = 8000
+
+var sdpPayloadTypeNames = map[int]string{
+	RtpPayloadTypePcmu: "PCMU",
+	RtpPayloadTypePcma: "PCMA",
+}
+
+// SdpSession is the audio part of a session description https://datatracker.ietf.org/doc/html/rfc4566
+// Only a single audio stream over RTP/AVP is modelled, which is all a PBX offers for a plain phone call.
+type SdpSession struct {
+	// Address is the connection address of the audio, e.g. "192.168.1.10".
+	Address string
+	Port    int
+	// PayloadTypes in the order of preference.
+	PayloadTypes []int
+	// SessionId is the o= line session id, it should stay the same for the whole call.
+	SessionId int64
+}
+
+// ParseSdp extracts the first audio stream, the media level c= line overrides the session level one.
+func ParseSdp(body []byte) (*SdpSession, error) {
+	result := &SdpSession{}
+	isInAudio := false
+	hasAudio := false
+	for _, line := range strings.Split(strings.ReplaceAll(string(body), "\r\n", "\n"), "\n") {
+		kind, value, found := strings.Cut(strings.TrimSpace(line), "=")
+		if !found {
+			continue
+		}
+		switch kind {
+		case "m":
+			fields := strings.Fields(value)
+			isInAudio = !hasAudio && len(fields) >= 4 && fields[0] == "audio"
+			if !isInAudio {
+				continue
+			}
+			if fields[2] != "RTP/AVP" {
+				return nil, fmt.Errorf("unsupported sdp transport %q, only RTP/AVP is supported", fields[2])
+			}
+			port, err := strconv.Atoi(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("malformed sdp media line %q", value)
+			}
+			result.Port = port
+			for _, field := range fields[3:] {
+				payloadType, err := strconv.Atoi(field)
+				if err != nil {
+					return nil, fmt.Errorf("malformed sdp payload type %q", field)
+				}
+				result.PayloadTypes = append(result.PayloadTypes, payloadType)
+			}
+			hasAudio = true
+		case "c":
+			// Only the session level, or the audio media level.
+			if hasAudio && !isInAudio {
+				continue
+			}
+			fields := strings.Fields(value)
+			if len(fields) != 3 || fields[0] != "IN" || fields[1] != "IP4" {
+				return nil, fmt.Errorf("unsupported sdp connection %q, only IN IP4 is supported", value)
+			}
+			result.Address, _, _ = strings.Cut(fields[2], "/")
+		}
+	}
+
+	if !hasAudio {
+		return nil, fmt.Errorf("sdp has no audio media")
+	}
+	if result.Address == "" {
+		return nil, fmt.Errorf("sdp has no connection address")
+	}
+	return result, nil
+}
+
+// NegotiateSdpPayloadType picks the first payload type of the offer we support, i.e. respecting its preference.
+func NegotiateSdpPayloadType(offer *SdpSession) (int, error) {
+	for _, payloadType := range offer.PayloadTypes {
+		if _, ok := sdpPayloadTypeNames[payloadType]; ok {
+			return payloadType, nil
+		}
+	}
+	return 0, fmt.Errorf("no supported codec in sdp payload types %v, only PCMU and PCMA are supported", offer.PayloadTypes)
+}
+
+// RenderSdp returns the session description, e.g. the answer with the single negotiated payload type.
+func RenderSdp(session SdpSession) []byte {
+	var result bytes.Buffer
+	fmt.Fprintf(&result, "v=0\r\n")
+	fmt.Fprintf(&result, "o=vocode %d %d IN IP4 %s\r\n", session.SessionId, session.SessionId, session.Address)
+	fmt.Fprintf(&result, "s=vocode\r\n")
+	fmt.Fprintf(&result, "c=IN IP4 %s\r\n", session.Address)
+	fmt.Fprintf(&result, "t=0 0\r\n")
+
+	payloadTypes := make([]string, len(session.PayloadTypes))
+	for i, payloadType := range session.PayloadTypes {
+		payloadTypes[i] = strconv.Itoa(payloadType)
+	}
+	fmt.Fprintf(&result, "m=audio %d RTP/AVP %s\r\n", session.Port, strings.Join(payloadTypes, " "))
+	for _, payloadType := range session.PayloadTypes {
+		if name, ok := sdpPayloadTypeNames[payloadType]; ok {
+			fmt.Fprintf(&result, "a=rtpmap:%d %s/%d\r\n", payloadType, name, SdpSampleRate)
+		}
+	}
+	fmt.Fprintf(&result, "a=ptime:20\r\n")
+	fmt.Fprintf(&result, "a=sendrecv\r\n")
+	return result.Bytes()
+}
@@ -0,0 +1,271 @@
+package telephony
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SipBranchPrefix is the "magic cookie" every RFC 3261 Via branch starts with.
+const SipBranchPrefix = "z9hG4bK"
+
+// SipHeader keeps the header order, as e.g. the order of Via-s matters for routing responses back.
+type SipHeader struct {
+	Name  string
+	Value string
+}
+
+// SipMessage is either a SIP request or response https://datatracker.ietf.org/doc/html/rfc3261#section-7
+// Only what a user agent needs for INVITE / ACK / BYE is modelled, e.g. there is no multipart body.
+type SipMessage struct {
+	// Method and RequestUri are set for requests, e.g. "INVITE".
+	Method     string
+	RequestUri string
+	// StatusCode and Reason are set for responses, e.g. 200 and "OK".
+	StatusCode int
+	Reason     string
+
+	Headers []SipHeader
+	Body    []byte
+}
+
+// sipCompactHeaders https://datatracker.ietf.org/doc/html/rfc3261#section-7.3.3
+var sipCompactHeaders = map[string]string{
+	"v": "Via",
+	"f": "From",
+	"t": "To",
+	"i": "Call-ID",
+	"m": "Contact",
+	"l": "Content-Length",
+	"c": "Content-Type",
+	"k": "Supported",
+	"s": "Subject",
+}
+
+// canonicalSipHeaderName so that lookups work regardless of the case or the compact form the peer used.
+func canonicalSipHeaderName(name string) string {
+	name = strings.TrimSpace(name)
+	if fullName, ok := sipCompactHeaders[strings.ToLower(name)]; ok {
+		return fullName
+	}
+	switch strings.ToLower(name) {
+	case "call-id":
+		return "Call-ID"
+	case "cseq":
+		return "CSeq"
+	case "www-authenticate":
+		return "WWW-Authenticate"
+	}
+	parts := strings.Split(strings.ToLower(name), "-")
+	for i, part := range parts {
+		if part != "" {
+			parts[i] = strings.ToUpper(part[:1]) + part[1:]
+		}
+	}
+	return strings.Join(parts, "-")
+}
+
+// ParseSipMessage parses a single SIP message from one UDP datagram.
+func ParseSipMessage(data []byte) (*SipMessage, error) {
+	headerBytes, body, found := bytes.Cut(data, []byte("\r\n\r\n"))
+	if !found {
+		// Some (broken) peers only use LF.
+		headerBytes, body, found = bytes.Cut(data, []byte("\n\n"))
+		if !found {
+			return nil, fmt.Errorf("sip message has no end of headers")
+		}
+	}
+	lines := strings.Split(strings.ReplaceAll(string(headerBytes), "\r\n", "\n"), "\n")
+
+	result := &SipMessage{}
+	startLine := strings.SplitN(lines[0], " ", 3)
+	if len(startLine) != 3 {
+		return nil, fmt.Errorf("malformed sip start line %q", lines[0])
+	}
+	if strings.HasPrefix(startLine[0], "SIP/") {
+		statusCode, err := strconv.Atoi(startLine[1])
+		if err != nil {
+			return nil, fmt.Errorf("malformed sip status code in %q", lines[0])
+		}
+		result.StatusCode = statusCode
+		result.Reason = startLine[2]
+	} else {
+		if !strings.HasPrefix(startLine[2], "SIP/") {
+			return nil, fmt.Errorf("malformed sip request line %q", lines[0])
+		}
+		result.Method = strings.ToUpper(startLine[0])
+		result.RequestUri = startLine[1]
+	}
+
+	for _, line := range lines[1:] {
+		if line == "" {
+			continue
+		}
+		// Header folding, i.e. a continuation of the previous header value.
+		if (line[0] == ' ' || line[0] == '\t') && len(result.Headers) > 0 {
+			last := &result.Headers[len(result.Headers)-1]
+			last.Value += " " + strings.TrimSpace(line)
+			continue
+		}
+		name, value, found := strings.Cut(line, ":")
+		if !found {
+			return nil, fmt.Errorf("malformed sip header line %q", line)
+		}
+		result.Headers = append(result.Headers, SipHeader{Name: canonicalSipHeaderName(name), Value: strings.TrimSpace(value)})
+	}
+
+	// Content-Length is mandatory over TCP only, for UDP the datagram end is the body end.
+	if contentLength, err := strconv.Atoi(result.GetHeader("Content-Length")); err == nil && contentLength < len(body) {
+		body = body[:contentLength]
+	}
+	result.Body = body
+	return result, nil
+}
+
+// IsRequest is false for responses.
+func (m *SipMessage) IsRequest() bool {
+	return m.Method != ""
+}
+
+// GetHeader returns the value of the first header with name, or "" if there is none.
+func (m *SipMessage) GetHeader(name string) string {
+	name = canonicalSipHeaderName(name)
+	for _, header := range m.Headers {
+		if header.Name == name {
+			return header.Value
+		}
+	}
+	return ""
+}
+
+// GetHeaders returns all the values of name in order, e.g. for Via.
+func (m *SipMessage) GetHeaders(name string) []string {
+	name = canonicalSipHeaderName(name)
+	var result []string
+	for _, header := range m.Headers {
+		if header.Name == name {
+			result = append(result, header.Value)
+		}
+	}
+	return result
+}
+
+// AddHeader appends the header, keeping the existing ones with the same name.
+func (m *SipMessage) AddHeader(name string, value string) {
+	m.Headers = append(m.Headers, SipHeader{Name: canonicalSipHeaderName(name), Value: value})
+}
+
+// SetHeader replaces all the headers with name by a single one, appended if there was none.
+func (m *SipMessage) SetHeader(name string, value string) {
+	name = canonicalSipHeaderName(name)
+	headers := make([]SipHeader, 0, len(m.Headers)+1)
+	isSet := false
+	for _, header := range m.Headers {
+		if header.Name != name {
+			headers = append(headers, header)
+		} else if !isSet {
+			headers = append(headers, SipHeader{Name: name, Value: value})
+			isSet = true
+		}
+	}
+	if !isSet {
+		headers = append(headers, SipHeader{Name: name, Value: value})
+	}
+	m.Headers = headers
+}
+
+// Bytes serializes the message, Content-Length is always set from the Body.
+func (m *SipMessage) Bytes() []byte {
+	var result bytes.Buffer
+	if m.IsRequest() {
+		fmt.Fprintf(&result, "%s %s SIP/2.0\r\n", m.Method, m.RequestUri)
+	} else {
+		fmt.Fprintf(&result, "SIP/2.0 %d %s\r\n", m.StatusCode, m.Reason)
+	}
+	for _, header := range m.Headers {
+		if header.Name == "Content-Length" {
+			continue
+		}
+		fmt.Fprintf(&result, "%s: %s\r\n", header.Name, header.Value)
+	}
+	fmt.Fprintf(&result, "Content-Length: %d\r\n\r\n", len(m.Body))
+	result.Write(m.Body)
+	return result.Bytes()
+}
+
+// NewSipResponse copies the headers which identify the transaction and dialog from request,
+// the To tag has to be added by the caller if the response establishes a dialog.
+func NewSipResponse(request *SipMessage, statusCode int, reason string) *SipMessage {
+	result := &SipMessage{StatusCode: statusCode, Reason: reason}
+	for _, header := range request.Headers {
+		switch header.Name {
+		case "Via", "From", "To", "Call-ID", "CSeq", "Record-Route":
+			result.Headers = append(result.Headers, header)
+		}
+	}
+	return result
+}
+
+// GetCSeq parses e.g. "314159 INVITE".
+func (m *SipMessage) GetCSeq() (int, string, error) {
+	value := m.GetHeader("CSeq")
+	number, method, found := strings.Cut(value, " ")
+	if !found {
+		return 0, "", fmt.Errorf("malformed sip CSeq %q", value)
+	}
+	sequenceNumber, err := strconv.Atoi(strings.TrimSpace(number))
+	if err != nil {
+		return 0, "", fmt.Errorf("malformed sip CSeq %q", value)
+	}
+	return sequenceNumber, strings.TrimSpace(method), nil
+}
+
+// GetSipParameter returns the value of a ";name=value" parameter of a header like From, To or Via,
+// e.g. GetSipParameter(`"Bob" <sip:bob@example.com>;tag=a6c85cf`, "tag") returns "a6c85cf".
+func GetSipParameter(headerValue string, name string) string {
+	// Parameters of the URI itself are inside <>, those are not header parameters.
+	if idx := strings.LastIndex(headerValue, ">"); idx >= 0 {
+		headerValue = headerValue[idx+1:]
+	}
+	for _, parameter := range strings.Split(headerValue, ";")[1:] {
+		parameterName, value, _ := strings.Cut(parameter, "=")
+		if strings.EqualFold(strings.TrimSpace(parameterName), name) {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+// GetSipUri extracts the URI of a From, To or Contact header, e.g. "sip:bob@example.com".
+func GetSipUri(headerValue string) string {
+	if start := strings.Index(headerValue, "<"); start >= 0 {
+		if end := strings.Index(headerValue[start:], ">"); end >= 0 {
+			return headerValue[start+1 : start+end]
+		}
+	}
+	uri, _, _ := strings.Cut(headerValue, ";")
+	return strings.TrimSpace(uri)
+}
+
+// GetSipUser extracts the user part of a SIP URI, e.g. the phone number of "sip:+14155550100@pbx.local".
+func GetSipUser(uri string) string {
+	_, rest, found := strings.Cut(uri, ":")
+	if !found {
+		return ""
+	}
+	user, _, found := strings.Cut(rest, "@")
+	if !found {
+		return ""
+	}
+	return user
+}
+
+// NewSipTag returns a random tag for From / To, or with SipBranchPrefix a Via branch.
+func NewSipTag() string {
+	randomBytes := make([]byte, 8)
+	_, _ = rand.Read(randomBytes)
+	return hex.EncodeToString(randomBytes)
+}
@@ -0,0 +1,82 @@
+package telephony
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StreamTokenParameter is the stream <Parameter> name carrying the token from NewStreamToken.
+const StreamTokenParameter = "stream_token"
+
+// ComputeTwilioSignature is the expected X-Twilio-Signature for a webhook request
+// https://www.twilio.com/docs/usage/webhooks/webhooks-security#validating-signatures-from-twilio
+// i.e. base64(HMAC-SHA1(authToken, fullUrl + sorted POST params concatenated as key+value)).
+func ComputeTwilioSignature(authToken string, fullUrl string, postParams url.Values) string {
+	keys := make([]string, 0, len(postParams))
+	for key := range postParams {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var payload strings.Builder
+	payload.WriteString(fullUrl)
+	for _, key := range keys {
+		for _, value := range postParams[key] {
+			payload.WriteString(key)
+			payload.WriteString(value)
+		}
+	}
+
+	mac := hmac.New(sha1.New, []byte(authToken))
+	mac.Write([]byte(payload.String()))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// ValidateTwilioSignature compares signature with ComputeTwilioSignature in constant time.
+func ValidateTwilioSignature(authToken string, fullUrl string, postParams url.Values, signature string) bool {
+	if signature == "" {
+		return false
+	}
+	expected := ComputeTwilioSignature(authToken, fullUrl, postParams)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+// NewStreamToken signs callSid with an expiry, to be passed as StreamTokenParameter from the (already validated)
+// voice webhook to the websocket stream. The format is "<expires_at_unix>.<base64url(HMAC-SHA256)>".
+func NewStreamToken(secret string, callSid string, ttl time.Duration) string {
+	expiresAt := strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	return expiresAt + "." + signStreamToken(secret, callSid, expiresAt)
+}
+
+// ValidateStreamToken checks the token was issued by NewStreamToken for callSid and has not expired yet.
+func ValidateStreamToken(secret string, callSid string, token string) error {
+	expiresAt, signature, found := strings.Cut(token, ".")
+	if !found {
+		return fmt.Errorf("stream token is malformed")
+	}
+	expiresAtUnix, err := strconv.ParseInt(expiresAt, 10, 64)
+	if err != nil {
+		return fmt.Errorf("stream token has invalid expiry: %w", err)
+	}
+	if !hmac.Equal([]byte(signature), []byte(signStreamToken(secret, callSid, expiresAt))) {
+		return fmt.Errorf("stream token signature mismatch for call %s", callSid)
+	}
+	if time.Now().Unix() > expiresAtUnix {
+		return fmt.Errorf("stream token expired at %s", time.Unix(expiresAtUnix, 0))
+	}
+	return nil
+}
+
+func signStreamToken(secret string, callSid string, expiresAt string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(callSid + "." + expiresAt))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,95 @@
+package telephony
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The example of https://www.twilio.com/docs/usage/webhooks/webhooks-security#validating-signatures-from-twilio
+const twilioExampleAuthToken = "12345"
+const twilioExampleUrl = "https://mycompany.com/myapp.php?foo=1&bar=2"
+const twilioExampleSignature = "RSOYDt4T1cUTdK1PDd93/VVr8B8="
+
+var twilioExampleParams = url.Values{
+	"CallSid": {"CA1234567890ABCDE"},
+	"Caller":  {"+14158675309"},
+	"Digits":  {"1234"},
+	"From":    {"+14158675309"},
+	"To":      {"+18005551212"},
+}
+
+func TestComputeTwilioSignature(t *testing.T) {
+	if signature := ComputeTwilioSignature(twilioExampleAuthToken, twilioExampleUrl, twilioExampleParams); signature != twilioExampleSignature {
+		t.Errorf("expected the documented signature %s, got %s", twilioExampleSignature, signature)
+	}
+}
+
+func TestValidateTwilioSignature(t *testing.T) {
+	otherParams := url.Values{}
+	for key, values := range twilioExampleParams {
+		otherParams[key] = values
+	}
+	otherParams.Set("Digits", "9999")
+
+	tests := []struct {
+		name      string
+		authToken string
+		fullUrl   string
+		params    url.Values
+		signature string
+		isValid   bool
+	}{
+		{"valid", twilioExampleAuthToken, twilioExampleUrl, twilioExampleParams, twilioExampleSignature, true},
+		{"missing", twilioExampleAuthToken, twilioExampleUrl, twilioExampleParams, "", false},
+		{"other auth token", "54321", twilioExampleUrl, twilioExampleParams, twilioExampleSignature, false},
+		{"other url", twilioExampleAuthToken, "https://mycompany.com/myapp.php?foo=1&bar=3", twilioExampleParams, twilioExampleSignature, false},
+		{"other params", twilioExampleAuthToken, twilioExampleUrl, otherParams, twilioExampleSignature, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if isValid := ValidateTwilioSignature(test.authToken, test.fullUrl, test.params, test.signature); isValid != test.isValid {
+				t.Errorf("expected valid %v, got %v", test.isValid, isValid)
+			}
+		})
+	}
+}
+
+func TestValidateStreamToken(t *testing.T) {
+	const secret = "auth-token"
+	const callSid = "CA1234567890ABCDE"
+	token := NewStreamToken(secret, callSid, time.Minute)
+	expiresAt, signature, _ := strings.Cut(token, ".")
+	// A later expiry with the same signature, as somebody trying to extend the token.
+	extended := "9999999999." + signature
+
+	tests := []struct {
+		name    string
+		secret  string
+		callSid string
+		token   string
+		isValid bool
+	}{
+		{"valid", secret, callSid, token, true},
+		{"expired", secret, callSid, NewStreamToken(secret, callSid, -time.Second), false},
+		{"wrong call sid", secret, "CA0000000000OTHER", token, false},
+		{"other secret", "other-token", callSid, token, false},
+		{"tampered signature", secret, callSid, expiresAt + "." + strings.ToUpper(signature), false},
+		{"tampered expiry", secret, callSid, extended, false},
+		{"missing", secret, callSid, "", false},
+		{"malformed", secret, callSid, signature, false},
+		{"invalid expiry", secret, callSid, "soon." + signature, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateStreamToken(test.secret, test.callSid, test.token)
+			if test.isValid && err != nil {
+				t.Errorf("expected valid, got %v", err)
+			}
+			if !test.isValid && err == nil {
+				t.Errorf("expected invalid, got valid")
+			}
+		})
+	}
+}
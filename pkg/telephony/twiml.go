@@ -0,0 +1,78 @@
+// Package telephony has the provider specific bits around a phone call which are NOT the audio stream itself,
+// e.g. TwiML documents Twilio executes for our calls.
+package telephony
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sort"
+)
+
+// TwimlResponse is the root of a TwiML document https://www.twilio.com/docs/voice/twiml
+// Only the verbs we actually use are modelled, unset ones are omitted from the output.
+// The verbs are executed in the order of the struct fields.
+type TwimlResponse struct {
+	XMLName  xml.Name       `xml:"Response"`
+	Play     *TwimlPlay     `xml:"Play,omitempty"`
+	Dial     *TwimlDial     `xml:"Dial,omitempty"`
+	Connect  *TwimlConnect  `xml:"Connect,omitempty"`
+	Redirect *TwimlRedirect `xml:"Redirect,omitempty"`
+}
+
+// TwimlPlay https://www.twilio.com/docs/voice/twiml/play we only use it to send DTMF digits.
+type TwimlPlay struct {
+	Digits string `xml:"digits,attr,omitempty"`
+}
+
+// TwimlDial https://www.twilio.com/docs/voice/twiml/dial set either Number or Sip.
+type TwimlDial struct {
+	Number string `xml:"Number,omitempty"`
+	Sip    string `xml:"Sip,omitempty"`
+}
+
+// TwimlRedirect https://www.twilio.com/docs/voice/twiml/redirect
+type TwimlRedirect struct {
+	Url string `xml:",chardata"`
+}
+
+// TwimlConnect https://www.twilio.com/docs/voice/twiml/connect
+type TwimlConnect struct {
+	Stream TwimlStream `xml:"Stream"`
+}
+
+// TwimlStream https://www.twilio.com/docs/voice/twiml/stream
+type TwimlStream struct {
+	Url        string           `xml:"url,attr"`
+	Parameters []TwimlParameter `xml:"Parameter"`
+}
+
+// TwimlParameter ends up in TwilioStartPayload.CustomParameters of the stream "start" message.
+type TwimlParameter struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+// NewConnectStreamTwiml returns the <Connect><Stream> document which makes Twilio open a bidirectional
+// websocket to streamUrl. Parameters are sorted by name, so the output is deterministic.
+func NewConnectStreamTwiml(streamUrl string, parameters map[string]string) TwimlResponse {
+	names := make([]string, 0, len(parameters))
+	for name := range parameters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	stream := TwimlStream{Url: streamUrl}
+	for _, name := range names {
+		stream.Parameters = append(stream.Parameters, TwimlParameter{Name: name, Value: parameters[name]})
+	}
+	return TwimlResponse{Connect: &TwimlConnect{Stream: stream}}
+}
+
+// Render encodes the TwiML document including the xml header.
+func (t TwimlResponse) Render() ([]byte, error) {
+	body, err := xml.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("cannot marshal twiml: %w", err)
+	}
+	return append([]byte(xml.Header), body...), nil
+}
@@ -0,0 +1,96 @@
+package telephony
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// VonageSignatureMaxAge is how old the iat of a signed webhook can be, so a captured request cannot be replayed later.
+const VonageSignatureMaxAge = 5 * time.Minute
+
+// vonageSignatureClockSkew is how much in the future the iat can be, as our clock is not exactly the Vonage one.
+const vonageSignatureClockSkew = time.Minute
+
+type vonageSignatureHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// vonageSignatureClaims are the claims we check, Vonage also sends jti, iss, api_key and application_id.
+type vonageSignatureClaims struct {
+	IssuedAt int64 `json:"iat"`
+	// PayloadHash is the hex SHA-256 of the request body.
+	PayloadHash string `json:"payload_hash,omitempty"`
+}
+
+// SignVonageWebhook returns the "Authorization" header of a signed webhook as Vonage sends it, e.g. to test with.
+func SignVonageWebhook(signatureSecret string, body []byte, issuedAt time.Time) string {
+	header, _ := json.Marshal(vonageSignatureHeader{Alg: "HS256", Typ: "JWT"})
+	claims, _ := json.Marshal(vonageSignatureClaims{IssuedAt: issuedAt.Unix(), PayloadHash: hashVonagePayload(body)})
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	return "Bearer " + unsigned + "." + signVonageJwt(signatureSecret, unsigned)
+}
+
+// ValidateVonageSignature checks the "Authorization: Bearer <jwt>" header of a Vonage signed webhook
+// https://developer.vonage.com/en/getting-started/concepts/webhooks#validating-signed-webhooks
+// i.e. a HS256 JWT signed with the signature secret, with the payload_hash of the body and a recent iat.
+func ValidateVonageSignature(signatureSecret string, authorization string, body []byte) error {
+	token, found := strings.CutPrefix(authorization, "Bearer ")
+	if !found {
+		return fmt.Errorf("vonage signature is missing")
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("vonage signature is malformed")
+	}
+
+	var header vonageSignatureHeader
+	if err := decodeJwtPart(parts[0], &header); err != nil {
+		return fmt.Errorf("cannot decode vonage signature header: %w", err)
+	}
+	// Only HS256, otherwise "none" or an asymmetric alg would let anyone sign.
+	if header.Alg != "HS256" {
+		return fmt.Errorf("vonage signature has unsupported alg %q", header.Alg)
+	}
+	if !hmac.Equal([]byte(parts[2]), []byte(signVonageJwt(signatureSecret, parts[0]+"."+parts[1]))) {
+		return fmt.Errorf("vonage signature mismatch")
+	}
+
+	var claims vonageSignatureClaims
+	if err := decodeJwtPart(parts[1], &claims); err != nil {
+		return fmt.Errorf("cannot decode vonage signature claims: %w", err)
+	}
+	issuedAt := time.Unix(claims.IssuedAt, 0)
+	if claims.IssuedAt == 0 || time.Since(issuedAt) > VonageSignatureMaxAge || time.Until(issuedAt) > vonageSignatureClockSkew {
+		return fmt.Errorf("vonage signature issued at %s is out of the allowed window", issuedAt)
+	}
+	if (claims.PayloadHash != "" || len(body) > 0) && !hmac.Equal([]byte(strings.ToLower(claims.PayloadHash)), []byte(hashVonagePayload(body))) {
+		return fmt.Errorf("vonage signature payload_hash does not match the body")
+	}
+	return nil
+}
+
+func decodeJwtPart(part string, result interface{}) error {
+	decoded, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(decoded, result)
+}
+
+func signVonageJwt(signatureSecret string, unsigned string) string {
+	mac := hmac.New(sha256.New, []byte(signatureSecret))
+	mac.Write([]byte(unsigned))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func hashVonagePayload(body []byte) string {
+	hash := sha256.Sum256(body)
+	return hex.EncodeToString(hash[:])
+}
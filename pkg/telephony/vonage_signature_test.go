@@ -0,0 +1,40 @@
+package telephony
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateVonageSignature(t *testing.T) {
+	body := []byte(`{"uuid":"call-1","from":"14155550100","to":"14155550199"}`)
+	now := time.Now()
+
+	tests := []struct {
+		name          string
+		authorization string
+		body          []byte
+		isValid       bool
+	}{
+		{"valid", SignVonageWebhook("secret", body, now), body, true},
+		{"valid without body", SignVonageWebhook("secret", nil, now), nil, true},
+		{"missing", "", body, false},
+		{"not bearer", strings.TrimPrefix(SignVonageWebhook("secret", body, now), "Bearer "), body, false},
+		{"other secret", SignVonageWebhook("other", body, now), body, false},
+		{"other body", SignVonageWebhook("secret", body, now), []byte(`{"uuid":"call-2"}`), false},
+		{"too old", SignVonageWebhook("secret", body, now.Add(-VonageSignatureMaxAge-time.Minute)), body, false},
+		{"from the future", SignVonageWebhook("secret", body, now.Add(time.Hour)), body, false},
+		{"alg none", "Bearer eyJhbGciOiJub25lIn0.eyJpYXQiOjF9.", body, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateVonageSignature("secret", test.authorization, test.body)
+			if test.isValid && err != nil {
+				t.Errorf("expected valid, got %v", err)
+			}
+			if !test.isValid && err == nil {
+				t.Errorf("expected invalid, got valid")
+			}
+		})
+	}
+}
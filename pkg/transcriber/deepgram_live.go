@@ -0,0 +1,335 @@
+package transcriber
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-audio/audio"
+	"github.com/gorilla/websocket"
+	"github.com/petrzlen/vocode-golang/pkg/audio_utils"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// deepgramCloseTimeout is how long Close waits for the last results, before giving up on the connection.
+const deepgramCloseTimeout = 10 * time.Second
+
+// deepgramKeepAliveMessage keeps the stream open without audio, Deepgram closes it after 10 seconds of nothing.
+const deepgramKeepAliveMessage = `{"type":"KeepAlive"}`
+
+// DeepgramMultiLanguage transcribes and detects any of the languages the model knows, also switching mid-sentence.
+const DeepgramMultiLanguage = "multi"
+
+// DeepgramConfig see https://developers.deepgram.com/reference/listen-live for the details.
+type DeepgramConfig struct {
+	// Url of the live endpoint, e.g. "ws://localhost:8081/v1/listen" to test against a stub.
+	Url   string
+	Model string
+	// Language of the streams which are not forced to one, e.g. "en".
+	// DeepgramMultiLanguage detects it, see https://developers.deepgram.com/docs/multilingual-code-switching
+	Language string
+	// Encoding is "linear16" or "mulaw", mulaw halves the bandwidth and it is what the phone calls have anyway.
+	Encoding string
+	// EndpointingMs of silence marks the final as speech_final, 0 keeps the Deepgram default.
+	EndpointingMs int
+	// UtteranceEndMs of no words sends the UtteranceEnd message, which also works in noisy environments.
+	UtteranceEndMs int
+	// KeepAliveInterval is the longest the stream goes without sending anything, e.g. when the client sends no audio
+	// during silence, 0 means 5 seconds.
+	KeepAliveInterval time.Duration
+}
+
+var DefaultDeepgramConfig = DeepgramConfig{
+	Url:               "wss://api.deepgram.com/v1/listen",
+	Model:             "nova-3",
+	Language:          DeepgramMultiLanguage,
+	Encoding:          "linear16",
+	EndpointingMs:     300,
+	UtteranceEndMs:    1000,
+	KeepAliveInterval: 5 * time.Second,
+}
+
+// deepgramMessage is the union of the live messages we care about, by Type:
+// "Results", "UtteranceEnd", "SpeechStarted" and "Metadata".
+type deepgramMessage struct {
+	Type         string          `json:"type"`
+	Start        float64         `json:"start"`
+	Duration     float64         `json:"duration"`
+	IsFinal      bool            `json:"is_final"`
+	SpeechFinal  bool            `json:"speech_final"`
+	FromFinalize bool            `json:"from_finalize"`
+	Channel      json.RawMessage `json:"channel"` // An object for Results, an array for UtteranceEnd.
+	LastWordEnd  float64         `json:"last_word_end"`
+}
+
+type deepgramChannel struct {
+	Alternatives []struct {
+		Transcript string  `json:"transcript"`
+		Confidence float64 `json:"confidence"`
+		// Languages detected in the transcript, the most used first, only with DeepgramMultiLanguage.
+		Languages []string `json:"languages"`
+	} `json:"alternatives"`
+}
+
+type deepgramLive struct {
+	apiKey string
+	config DeepgramConfig
+}
+
+// NewDeepgramLive transcribes over the Deepgram live websocket, with interim results.
+func NewDeepgramLive(apiKey string, config DeepgramConfig) StreamingTranscriber {
+	return &deepgramLive{
+		apiKey: apiKey,
+		config: config,
+	}
+}
+
+// NewStream implements StreamingTranscriber.NewStream
+func (d *deepgramLive) NewStream(sampleRate int, language string) (TranscriptionStream, error) {
+	if d.config.Encoding != "linear16" && d.config.Encoding != "mulaw" {
+		return nil, fmt.Errorf("unsupported deepgram encoding %s", d.config.Encoding)
+	}
+	streamUrl, err := url.Parse(d.config.Url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid deepgram url %s: %w", d.config.Url, err)
+	}
+	query := streamUrl.Query()
+	query.Set("encoding", d.config.Encoding)
+	query.Set("sample_rate", strconv.Itoa(sampleRate))
+	query.Set("channels", "1")
+	query.Set("interim_results", "true")
+	query.Set("smart_format", "true")
+	if d.config.Model != "" {
+		query.Set("model", d.config.Model)
+	}
+	if language == "" {
+		language = d.config.Language
+	}
+	if language != "" {
+		query.Set("language", language)
+	}
+	if d.config.EndpointingMs > 0 {
+		query.Set("endpointing", strconv.Itoa(d.config.EndpointingMs))
+	}
+	if d.config.UtteranceEndMs > 0 {
+		query.Set("utterance_end_ms", strconv.Itoa(d.config.UtteranceEndMs))
+		// UtteranceEnd requires the VAD events.
+		query.Set("vad_events", "true")
+	}
+	streamUrl.RawQuery = query.Encode()
+
+	header := http.Header{}
+	header.Set("Authorization", "Token "+d.apiKey)
+	conn, response, err := websocket.DefaultDialer.Dial(streamUrl.String(), header)
+	if err != nil {
+		if response != nil {
+			// Deepgram puts the reason into the dg-error header, e.g. on an invalid api key.
+			return nil, fmt.Errorf("cannot connect to deepgram status=%d dg-error=%s: %w", response.StatusCode, response.Header.Get("dg-error"), err)
+		}
+		return nil, fmt.Errorf("cannot connect to deepgram: %w", err)
+	}
+	log.Info().Str("url", d.config.Url).Int("sample_rate", sampleRate).Str("language", language).Str("encoding", d.config.Encoding).Str("dg_request_id", response.Header.Get("dg-request-id")).Msg("deepgram stream connected")
+
+	stream := &deepgramStream{
+		conn:          conn,
+		encoding:      d.config.Encoding,
+		language:      language,
+		sampleRate:    sampleRate,
+		events:        make(chan TranscriptEvent, 100),
+		isClosed:      false,
+		lastWriteTime: time.Now(),
+		hasUtterance:  false,
+	}
+	keepAliveInterval := d.config.KeepAliveInterval
+	if keepAliveInterval <= 0 {
+		keepAliveInterval = 5 * time.Second
+	}
+	go stream.readRoutine()
+	go stream.keepAliveRoutine(keepAliveInterval)
+	return stream, nil
+}
+
+type deepgramStream struct {
+	conn     *websocket.Conn
+	encoding string
+	// language the stream was opened with, the results carry the detected one with DeepgramMultiLanguage.
+	language   string
+	sampleRate int
+	events     chan TranscriptEvent
+	// writeMutex guards isClosed, lastWriteTime and the writes into conn.
+	writeMutex    sync.Mutex
+	isClosed      bool
+	lastWriteTime time.Time
+
+	// hasUtterance is true since a final with some text, so both speech_final and UtteranceEnd end it only once.
+	hasUtterance bool
+}
+
+// SendAudio implements TranscriptionStream.SendAudio
+func (s *deepgramStream) SendAudio(samples []int) error {
+	intBuffer := &audio.IntBuffer{
+		Data:   samples,
+		Format: &audio.Format{SampleRate: s.sampleRate, NumChannels: 1},
+	}
+	var audioBytes []byte
+	if s.encoding == "mulaw" {
+		var err error
+		if audioBytes, err = audio_utils.EncodeToMulaw(intBuffer, s.sampleRate); err != nil {
+			return fmt.Errorf("cannot encode deepgram audio: %w", err)
+		}
+	} else {
+		audioBytes = audio_utils.EncodeToLinear16(intBuffer, s.sampleRate)
+	}
+	return s.write(websocket.BinaryMessage, audioBytes)
+}
+
+// Encoding implements EncodedAudioSender.Encoding
+func (s *deepgramStream) Encoding() string {
+	return s.encoding
+}
+
+// SendEncodedAudio implements EncodedAudioSender.SendEncodedAudio
+func (s *deepgramStream) SendEncodedAudio(frame []byte) error {
+	return s.write(websocket.BinaryMessage, frame)
+}
+
+// Flush implements TranscriptionStream.Flush
+// Deepgram finalizes what it has, and the end of utterance is emitted with the result marked from_finalize.
+func (s *deepgramStream) Flush() error {
+	return s.write(websocket.TextMessage, []byte(`{"type":"Finalize"}`))
+}
+
+// Events implements TranscriptionStream.Events
+func (s *deepgramStream) Events() <-chan TranscriptEvent {
+	return s.events
+}
+
+// Close implements TranscriptionStream.Close
+// Deepgram sends the remaining results, and closes the websocket itself.
+func (s *deepgramStream) Close() error {
+	err := s.write(websocket.TextMessage, []byte(`{"type":"CloseStream"}`))
+
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	s.isClosed = true
+	// So the readRoutine ends even if Deepgram does not.
+	if deadlineErr := s.conn.SetReadDeadline(time.Now().Add(deepgramCloseTimeout)); deadlineErr != nil {
+		log.Warn().Err(deadlineErr).Msg("cannot set the deepgram read deadline")
+	}
+	return err
+}
+
+func (s *deepgramStream) write(messageType int, data []byte) error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	if s.isClosed {
+		return fmt.Errorf("deepgram stream is already closed")
+	}
+	if err := s.conn.WriteMessage(messageType, data); err != nil {
+		// Most likely the readRoutine already ended, and logged why.
+		s.isClosed = true
+		return fmt.Errorf("cannot write to deepgram: %w", err)
+	}
+	s.lastWriteTime = time.Now()
+	return nil
+}
+
+// keepAliveRoutine sends the KeepAlive when nothing else was sent for a while, until the stream is closed.
+func (s *deepgramStream) keepAliveRoutine(interval time.Duration) {
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.writeMutex.Lock()
+		isClosed := s.isClosed
+		isIdle := time.Since(s.lastWriteTime) >= interval/2
+		s.writeMutex.Unlock()
+		if isClosed {
+			return
+		}
+		if !isIdle {
+			continue
+		}
+		if err := s.write(websocket.TextMessage, []byte(deepgramKeepAliveMessage)); err != nil {
+			log.Warn().Err(err).Msg("cannot send the deepgram keep alive")
+			return
+		}
+	}
+}
+
+func (s *deepgramStream) readRoutine() {
+	defer close(s.events)
+	defer func() {
+		errLog(s.conn.Close(), "deepgram conn.Close()")
+	}()
+
+	for {
+		_, msgBytes, err := s.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				log.Info().Msg("deepgram stream closed")
+			} else {
+				// e.g. Deepgram closes with 1011 when it did not get any audio for 10 seconds.
+				log.Warn().Err(err).Msg("deepgram stream ended")
+			}
+			return
+		}
+
+		var msg deepgramMessage
+		if err := json.Unmarshal(msgBytes, &msg); err != nil {
+			log.Warn().Err(err).Str("msg", string(msgBytes)).Msg("cannot parse deepgram message, skipping")
+			continue
+		}
+		switch msg.Type {
+		case "Results":
+			s.handleResults(msg)
+		case "UtteranceEnd":
+			end := toDuration(msg.LastWordEnd)
+			s.endUtterance(end, end)
+		case "SpeechStarted", "Metadata":
+			log.Debug().Str("type", msg.Type).Msg("deepgram message")
+		default:
+			log.Warn().Str("msg", string(msgBytes)).Msg("unexpected deepgram message")
+		}
+	}
+}
+
+func (s *deepgramStream) handleResults(msg deepgramMessage) {
+	var channel deepgramChannel
+	if err := json.Unmarshal(msg.Channel, &channel); err != nil || len(channel.Alternatives) == 0 {
+		log.Warn().Err(err).Msg("deepgram results without alternatives, skipping")
+		return
+	}
+	alternative := channel.Alternatives[0]
+	start := toDuration(msg.Start)
+	end := toDuration(msg.Start + msg.Duration)
+
+	if alternative.Transcript != "" {
+		// Deepgram has no stability, the confidence is the closest to it.
+		stability := alternative.Confidence
+		if msg.IsFinal {
+			stability = 1
+			s.hasUtterance = true
+		}
+		language := s.language
+		if len(alternative.Languages) > 0 {
+			language = alternative.Languages[0]
+		} else if language == DeepgramMultiLanguage {
+			language = ""
+		}
+		s.events <- TranscriptEvent{Text: alternative.Transcript, IsFinal: msg.IsFinal, Stability: stability, Start: start, End: end, Language: language}
+	}
+	if msg.SpeechFinal || msg.FromFinalize {
+		s.endUtterance(end, end)
+	}
+}
+
+func (s *deepgramStream) endUtterance(start time.Duration, end time.Duration) {
+	if !s.hasUtterance {
+		return
+	}
+	s.hasUtterance = false
+	s.events <- TranscriptEvent{IsFinal: true, Stability: 1, Start: start, End: end, IsEndOfUtterance: true}
+}
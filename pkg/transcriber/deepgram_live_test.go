@@ -0,0 +1,272 @@
+package transcriber
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"github.com/petrzlen/vocode-golang/pkg/models"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const deepgramTestApiKey = "test-api-key"
+
+// deepgramStub is the Deepgram live endpoint, which replays the canned responses of testdata/deepgram_session.json
+// once it gets the first audio frame.
+type deepgramStub struct {
+	t         *testing.T
+	responses []json.RawMessage
+	// firstFrames get the first audio frame of each connection, textMessages all the text ones, e.g. KeepAlive.
+	firstFrames  chan []byte
+	textMessages chan string
+	// languages get the language query parameter of each connection.
+	languages chan string
+	// failConnections is the number of the first connections to drop right after their first frame.
+	failConnections int32
+	numConnections  atomic.Int32
+}
+
+func newDeepgramStub(t *testing.T, failConnections int32) (*deepgramStub, string) {
+	sessionBytes, err := os.ReadFile("testdata/deepgram_session.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub := &deepgramStub{
+		t:               t,
+		firstFrames:     make(chan []byte, 10),
+		textMessages:    make(chan string, 100),
+		languages:       make(chan string, 10),
+		failConnections: failConnections,
+	}
+	if err := json.Unmarshal(sessionBytes, &stub.responses); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(stub)
+	t.Cleanup(server.Close)
+	return stub, "ws" + strings.TrimPrefix(server.URL, "http") + "/v1/listen"
+}
+
+func (stub *deepgramStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Token "+deepgramTestApiKey {
+		w.Header().Set("dg-error", "Invalid credentials.")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	query := r.URL.Query()
+	if query.Get("encoding") != "mulaw" || query.Get("sample_rate") != "8000" || query.Get("interim_results") != "true" {
+		stub.t.Errorf("unexpected deepgram query %s", r.URL.RawQuery)
+	}
+	stub.languages <- query.Get("language")
+	upgrader := websocket.Upgrader{}
+	conn, err := upgrader.Upgrade(w, r, http.Header{"dg-request-id": []string{"5c3b6a0e"}})
+	if err != nil {
+		stub.t.Error(err)
+		return
+	}
+	defer conn.Close()
+	connectionIdx := stub.numConnections.Add(1)
+
+	isReplayed := false
+	for {
+		messageType, msgBytes, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if messageType == websocket.TextMessage {
+			stub.textMessages <- string(msgBytes)
+			if string(msgBytes) == `{"type":"CloseStream"}` {
+				_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+				return
+			}
+			continue
+		}
+		if isReplayed {
+			continue
+		}
+		isReplayed = true
+		stub.firstFrames <- msgBytes
+		if connectionIdx <= stub.failConnections {
+			// Same as Deepgram does on an internal error.
+			_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "internal error"))
+			return
+		}
+		for _, response := range stub.responses {
+			if err := conn.WriteMessage(websocket.TextMessage, response); err != nil {
+				stub.t.Error(err)
+				return
+			}
+		}
+	}
+}
+
+func newDeepgramTestConfig(url string) DeepgramConfig {
+	config := DefaultDeepgramConfig
+	config.Url = url
+	config.Encoding = "mulaw"
+	config.KeepAliveInterval = 100 * time.Millisecond
+	return config
+}
+
+func TestDeepgramLiveReplaysCannedResponses(t *testing.T) {
+	stub, url := newDeepgramStub(t, 0)
+	stream, err := NewDeepgramLive(deepgramTestApiKey, newDeepgramTestConfig(url)).NewStream(8000, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Without a forced language, Deepgram detects it.
+	if language := <-stub.languages; language != DeepgramMultiLanguage {
+		t.Errorf("expected the %s language, got %s", DeepgramMultiLanguage, language)
+	}
+
+	// The mu-law of a phone call goes as it is.
+	frame := bytes.Repeat([]byte{0x7e, 0xfe}, 80)
+	if err := stream.(EncodedAudioSender).SendEncodedAudio(frame); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case received := <-stub.firstFrames:
+		if !bytes.Equal(received, frame) {
+			t.Errorf("expected the mulaw frame as it is, got %d bytes", len(received))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the stub got no audio")
+	}
+
+	expected := []TranscriptEvent{
+		{Text: "hello", IsFinal: false, Stability: 0.82, Start: 0, End: 1020 * time.Millisecond},
+		{Text: "Hello there.", IsFinal: true, Stability: 1, Start: 0, End: 1500 * time.Millisecond, Language: "en"},
+		{Text: "How are you?", IsFinal: true, Stability: 1, Start: 1500 * time.Millisecond, End: 2 * time.Second, Language: "en"},
+		{IsFinal: true, Stability: 1, Start: 2 * time.Second, End: 2 * time.Second, IsEndOfUtterance: true},
+		{Text: "Bye.", IsFinal: true, Stability: 1, Start: 2500 * time.Millisecond, End: 3400 * time.Millisecond, Language: "en"},
+		{IsFinal: true, Stability: 1, Start: 3400 * time.Millisecond, End: 3400 * time.Millisecond, IsEndOfUtterance: true},
+	}
+	for i, want := range expected {
+		select {
+		case got := <-stream.Events():
+			// The durations are parsed from float seconds.
+			got.Start = got.Start.Round(time.Millisecond)
+			got.End = got.End.Round(time.Millisecond)
+			if got != want {
+				t.Errorf("event %d: expected %+v, got %+v", i, want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected %d events, got %d", len(expected), i)
+		}
+	}
+
+	// No audio, e.g. the browser does not send the silence, so the stream is kept alive.
+	select {
+	case msg := <-stub.textMessages:
+		if msg != deepgramKeepAliveMessage {
+			t.Errorf("expected a keep alive, got %s", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no keep alive without audio")
+	}
+
+	if err := stream.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for event := range stream.Events() {
+		t.Errorf("unexpected event %+v after close", event)
+	}
+}
+
+// sendMulawFramesUntilDone is a phone call, which sends 20ms frames whether anybody speaks or not.
+func sendMulawFramesUntilDone(audioChunksChan chan models.AudioData, done chan struct{}) {
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			close(audioChunksChan)
+			return
+		case <-ticker.C:
+			audioChunksChan <- models.NewAudioDataMulawFrame(bytes.Repeat([]byte{0xff}, 160), 8000, "test")
+		}
+	}
+}
+
+func TestTranscribeAudioRoutineReconnectsDeepgram(t *testing.T) {
+	stub, url := newDeepgramStub(t, 1)
+	language := models.NewConversationLanguage(models.LanguageAuto)
+	audioChunksChan := make(chan models.AudioData, 1000)
+	textChunksChan := make(chan models.AudioData, 100)
+	finalTranscriptChan := make(chan string, 1)
+	go func() {
+		finalTranscriptChan <- TranscribeAudioRoutine(NewDeepgramLive(deepgramTestApiKey, newDeepgramTestConfig(url)), language, audioChunksChan, textChunksChan, make(chan string, 10))
+	}()
+
+	// The phone keeps sending frames, also while the first connection fails.
+	done := make(chan struct{})
+	go sendMulawFramesUntilDone(audioChunksChan, done)
+
+	var texts []string
+	for len(texts) < 3 {
+		select {
+		case textChunk := <-textChunksChan:
+			if textChunk.EventType == models.SubmitPrompt {
+				texts = append(texts, "<submit>")
+				continue
+			}
+			texts = append(texts, textChunk.Text)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("the transcription did not recover, got %v", texts)
+		}
+	}
+	close(done)
+	if strings.Join(texts, "|") != "Hello there.|How are you?|<submit>" {
+		t.Errorf("unexpected transcription %v", texts)
+	}
+	if numConnections := stub.numConnections.Load(); numConnections != 2 {
+		t.Errorf("expected a single reconnect, got %d connections", numConnections)
+	}
+	if language.Get() != "en" {
+		t.Errorf("expected the conversation language detected as en, got %q", language.Get())
+	}
+
+	select {
+	case finalTranscript := <-finalTranscriptChan:
+		if strings.TrimSpace(finalTranscript) != "Bye." {
+			t.Errorf("unexpected final transcript %q", finalTranscript)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("TranscribeAudioRoutine did not end")
+	}
+}
+
+func TestTranscribeAudioRoutineReopensDeepgramInForcedLanguage(t *testing.T) {
+	stub, url := newDeepgramStub(t, 0)
+	language := models.NewConversationLanguage("en")
+	audioChunksChan := make(chan models.AudioData, 1000)
+	textChunksChan := make(chan models.AudioData, 100)
+	go TranscribeAudioRoutine(NewDeepgramLive(deepgramTestApiKey, newDeepgramTestConfig(url)), language, audioChunksChan, textChunksChan, make(chan string, 10))
+	done := make(chan struct{})
+	go sendMulawFramesUntilDone(audioChunksChan, done)
+	defer close(done)
+
+	if streamLanguage := <-stub.languages; streamLanguage != "en" {
+		t.Errorf("expected the stream in the forced en, got %s", streamLanguage)
+	}
+	select {
+	case <-textChunksChan:
+	case <-time.After(2 * time.Second):
+		t.Fatal("no transcription")
+	}
+
+	// The caller asked to continue in Spanish.
+	language.Set("es")
+	select {
+	case streamLanguage := <-stub.languages:
+		if streamLanguage != "es" {
+			t.Errorf("expected the stream reopened in es, got %s", streamLanguage)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the stream was not reopened in the forced language")
+	}
+}
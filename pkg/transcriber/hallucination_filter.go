@@ -0,0 +1,433 @@
+package transcriber
+
+import (
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// FilterInput is a single transcribed chunk, with what is known about it.
+type FilterInput struct {
+	Transcription Transcription
+	// AudioLength of the transcribed chunk, Transcription.Duration is used when 0.
+	AudioLength time.Duration
+	// PreviousText of the current utterance, i.e. what was passed to the Transcriber as the prompt.
+	PreviousText string
+	// Language of the conversation as ISO 639-1, e.g. "es", empty when unknown.
+	Language string
+}
+
+// TranscriptionFilter removes what Whisper made up, and drops the text altogether when nothing real is left.
+type TranscriptionFilter interface {
+	// Filter returns the filtered text, and why when it changed anything.
+	Filter(text string, input FilterInput) (filtered string, reason string)
+}
+
+// HallucinationFilterConfig the zero value of each field disables its filter.
+type HallucinationFilterConfig struct {
+	// SilencePhrases per ISO 639-1 language, which Whisper makes up for silence or noise, e.g. the subtitle credits
+	// of its training data. These are removed when they are the whole chunk, or its trailing sentences,
+	// whatever the conversation language is. So "Thank you for watching my dog." is kept.
+	// As leading sentences, only when of another language than the conversation, e.g. a Korean news sign-off
+	// before English speech, while "Thanks for watching! I mean the kids, ..." is real.
+	SilencePhrases map[string][]string
+	// SilenceOnlyPhrases per ISO 639-1 language, are common enough to be said, so these are only dropped
+	// when they are the whole chunk, in a conversation of the same (or unknown) language,
+	// and Whisper itself was not sure it was speech, see SilenceOnlyNoSpeechProb.
+	SilenceOnlyPhrases map[string][]string
+	// SilenceOnlyNoSpeechProb a SilenceOnlyPhrases chunk is only dropped with a segment of at least this no_speech_prob,
+	// i.e. never without segments, as a real "Thank you." has it close to 0.
+	SilenceOnlyNoSpeechProb float64
+	// NoSpeechProb and AvgLogProb drop a segment when it is beyond both, the same as Whisper skips silence.
+	NoSpeechProb float64
+	AvgLogProb   float64
+	// MaxCompressionRatio drops a segment stuck in a repetition loop, Whisper itself retries above 2.4.
+	MaxCompressionRatio float64
+	// MaxRepeats of the same words in a row, more collapse into one, and a chunk repeating the previous words is dropped.
+	MaxRepeats int
+	// MaxWordsPerSecond more words than could be said in the audio were made up, people say about 2.5 per second.
+	MaxWordsPerSecond float64
+	// DropForeignScript removes words in a script the conversation language is not written in, e.g. Hangul in English.
+	DropForeignScript bool
+}
+
+// DefaultHallucinationFilterConfig the phrases are from real transcriptions of silence, see testdata/hallucinations.json
+var DefaultHallucinationFilterConfig = HallucinationFilterConfig{
+	SilencePhrases: map[string][]string{
+		"en": {"Thanks for watching!", "Thank you for watching!", "Thank you for watching.", "Please subscribe to my channel.", "Subtitles by the Amara.org community"},
+		"es": {"Subtítulos realizados por la comunidad de Amara.org", "Subtítulos por la comunidad de Amara.org", "¡Gracias por ver!", "Gracias por ver el video."},
+		"fr": {"Sous-titres réalisés par la communauté d'Amara.org", "Sous-titrage Société Radio-Canada", "Merci d'avoir regardé cette vidéo !"},
+		"de": {"Untertitel der Amara.org-Community", "Untertitel im Auftrag des ZDF", "Untertitelung des ZDF", "Vielen Dank fürs Zuschauen!"},
+		"it": {"Sottotitoli creati dalla comunità Amara.org", "Grazie per la visione!"},
+		"pt": {"Legendas pela comunidade Amara.org", "Obrigado por assistir."},
+		"ko": {"MBC 뉴스 이덕영입니다.", "시청해주셔서 감사합니다."},
+		"ja": {"ご視聴ありがとうございました"},
+		"zh": {"字幕由Amara.org社区提供", "请不吝点赞 订阅 转发 打赏支持明镜与点点栏目"},
+		"ru": {"Продолжение следует...", "Редактор субтитров А.Семкин Корректор А.Егорова"},
+	},
+	SilenceOnlyPhrases: map[string][]string{
+		"en": {"Thank you.", "Thanks.", "You", "Bye.", "Bye-bye."},
+		"es": {"Gracias.", "Adiós."},
+		"de": {"Danke."},
+		"fr": {"Merci."},
+	},
+	SilenceOnlyNoSpeechProb: 0.3,
+	NoSpeechProb:            noSpeechProbThreshold,
+	AvgLogProb:              avgLogProbThreshold,
+	MaxCompressionRatio:     2.4,
+	MaxRepeats:              3,
+	MaxWordsPerSecond:       6,
+	DropForeignScript:       true,
+}
+
+// HallucinationFilter runs its TranscriptionFilter-s in order, it is safe for concurrent use.
+type HallucinationFilter struct {
+	filters []TranscriptionFilter
+}
+
+func NewHallucinationFilter(filters ...TranscriptionFilter) *HallucinationFilter {
+	return &HallucinationFilter{
+		filters: filters,
+	}
+}
+
+// NewHallucinationFilterFromConfig the segments go first as they carry the confidence, the plausibility last
+// as it only makes sense on what is left.
+func NewHallucinationFilterFromConfig(config HallucinationFilterConfig) *HallucinationFilter {
+	filters := make([]TranscriptionFilter, 0)
+	if config.NoSpeechProb > 0 || config.MaxCompressionRatio > 0 {
+		filters = append(filters, NewSegmentFilter(config.NoSpeechProb, config.AvgLogProb, config.MaxCompressionRatio))
+	}
+	if len(config.SilencePhrases) > 0 || len(config.SilenceOnlyPhrases) > 0 {
+		filters = append(filters, NewSilencePhraseFilter(config.SilencePhrases, config.SilenceOnlyPhrases, config.SilenceOnlyNoSpeechProb))
+	}
+	if config.DropForeignScript {
+		filters = append(filters, NewForeignScriptFilter())
+	}
+	if config.MaxRepeats > 0 {
+		filters = append(filters, NewRepetitionFilter(config.MaxRepeats))
+	}
+	if config.MaxWordsPerSecond > 0 {
+		filters = append(filters, NewPlausibilityFilter(config.MaxWordsPerSecond))
+	}
+	return NewHallucinationFilter(filters...)
+}
+
+// Filter returns what is left of the Transcription.Text, empty when it was all made up.
+func (f *HallucinationFilter) Filter(input FilterInput) string {
+	text := strings.TrimSpace(input.Transcription.Text)
+	reasons := make([]string, 0)
+	for _, filter := range f.filters {
+		if text == "" {
+			break
+		}
+		filtered, reason := filter.Filter(text, input)
+		filtered = strings.TrimSpace(filtered)
+		if !hasLetterOrDigit(filtered) {
+			filtered = ""
+		}
+		if reason != "" {
+			reasons = append(reasons, reason)
+		}
+		text = filtered
+	}
+	if len(reasons) > 0 {
+		log.Info().Str("original_text", input.Transcription.Text).Str("filtered_text", text).Strs("reasons", reasons).Msg("hallucination filter changed the transcription")
+	}
+	return text
+}
+
+// segmentFilter drops the segments Whisper was not confident about.
+type segmentFilter struct {
+	noSpeechProb        float64
+	avgLogProb          float64
+	maxCompressionRatio float64
+}
+
+func NewSegmentFilter(noSpeechProb float64, avgLogProb float64, maxCompressionRatio float64) TranscriptionFilter {
+	return &segmentFilter{
+		noSpeechProb:        noSpeechProb,
+		avgLogProb:          avgLogProb,
+		maxCompressionRatio: maxCompressionRatio,
+	}
+}
+
+// Filter implements TranscriptionFilter.Filter
+// When it drops a segment, the text is rebuilt from the confident segments, so the same words said
+// in a confident one are kept. Therefore, it has to go before the filters which change the text.
+func (f *segmentFilter) Filter(text string, input FilterInput) (string, string) {
+	var kept strings.Builder
+	dropped := make([]string, 0)
+	for _, segment := range input.Transcription.Segments {
+		segmentText := strings.TrimSpace(segment.Text)
+		isSilence := f.noSpeechProb > 0 && segment.NoSpeechProb > f.noSpeechProb && segment.AvgLogProb < f.avgLogProb
+		isLoop := f.maxCompressionRatio > 0 && segment.CompressionRatio > f.maxCompressionRatio
+		if segmentText == "" || !(isSilence || isLoop) {
+			// Whisper keeps the space before each word in the segments, and none for languages without spaces.
+			kept.WriteString(segment.Text)
+			continue
+		}
+		dropped = append(dropped, fmt.Sprintf("%q no_speech_prob=%.2f avg_log_prob=%.2f compression_ratio=%.2f", segmentText, segment.NoSpeechProb, segment.AvgLogProb, segment.CompressionRatio))
+	}
+	if len(dropped) == 0 {
+		return text, ""
+	}
+	return kept.String(), "unconfident segments " + strings.Join(dropped, ", ")
+}
+
+// silencePhraseFilter removes the known phrases when they are the whole chunk or its trailing sentences,
+// or its leading sentences in a foreign language, with any punctuation around them, and a year after the credits.
+type silencePhraseFilter struct {
+	phrases []*regexp.Regexp
+	// leadingPhrases per language are the same phrases, when they start the chunk and end a sentence.
+	leadingPhrases   map[string][]*regexp.Regexp
+	onlyPhrases      map[string][]string
+	onlyNoSpeechProb float64
+}
+
+func NewSilencePhraseFilter(phrases map[string][]string, onlyPhrases map[string][]string, onlyNoSpeechProb float64) TranscriptionFilter {
+	filter := &silencePhraseFilter{
+		phrases:          make([]*regexp.Regexp, 0),
+		leadingPhrases:   make(map[string][]*regexp.Regexp),
+		onlyPhrases:      make(map[string][]string),
+		onlyNoSpeechProb: onlyNoSpeechProb,
+	}
+	for language, languagePhrases := range phrases {
+		for _, phrase := range languagePhrases {
+			quoted := regexp.QuoteMeta(strings.TrimRight(phrase, ".!?。！ "))
+			// Starts the chunk or a sentence, and ends the chunk, so it is a sentence on its own.
+			filter.phrases = append(filter.phrases, regexp.MustCompile(`(?i)(^|[.!?。！…]\s*)[¡¿]?`+quoted+`(,? \d{4})?[.!?。！…]*$`))
+			// Starts the chunk, and ends a sentence.
+			filter.leadingPhrases[language] = append(filter.leadingPhrases[language], regexp.MustCompile(`(?i)^[¡¿]?`+quoted+`(,? \d{4})?[.!?。！…]+\s*`))
+		}
+	}
+	for language, languagePhrases := range onlyPhrases {
+		for _, phrase := range languagePhrases {
+			filter.onlyPhrases[language] = append(filter.onlyPhrases[language], normalizeForMatch(phrase))
+		}
+	}
+	return filter
+}
+
+// Filter implements TranscriptionFilter.Filter
+func (f *silencePhraseFilter) Filter(text string, input FilterInput) (string, string) {
+	filtered := text
+	// Until none is left at either end, e.g. "Thanks for watching! Please subscribe to my channel."
+	for isRemoved := true; isRemoved; {
+		isRemoved = false
+		for _, phrase := range f.phrases {
+			if trimmed := strings.TrimSpace(phrase.ReplaceAllString(filtered, "${1}")); trimmed != filtered {
+				filtered = trimmed
+				isRemoved = true
+			}
+		}
+		for language, languagePhrases := range f.leadingPhrases {
+			if input.Language == "" || input.Language == language {
+				continue
+			}
+			for _, phrase := range languagePhrases {
+				if trimmed := strings.TrimSpace(phrase.ReplaceAllString(filtered, "")); trimmed != filtered {
+					filtered = trimmed
+					isRemoved = true
+				}
+			}
+		}
+	}
+	if filtered != text {
+		return strings.Join(strings.Fields(filtered), " "), "silence phrase"
+	}
+
+	if !f.isUnsureOfSpeech(input.Transcription) {
+		return text, ""
+	}
+	normalized := normalizeForMatch(text)
+	for language, onlyPhrases := range f.onlyPhrases {
+		if input.Language != "" && input.Language != language {
+			continue
+		}
+		for _, phrase := range onlyPhrases {
+			if normalized == phrase {
+				return "", "only a silence phrase"
+			}
+		}
+	}
+	return text, ""
+}
+
+// isUnsureOfSpeech is true when a segment has at least the onlyNoSpeechProb.
+func (f *silencePhraseFilter) isUnsureOfSpeech(transcription Transcription) bool {
+	for _, segment := range transcription.Segments {
+		if segment.NoSpeechProb >= f.onlyNoSpeechProb {
+			return true
+		}
+	}
+	return false
+}
+
+// foreignScriptFilter Latin is allowed in any language, as names and brands are often written in it.
+type foreignScriptFilter struct{}
+
+func NewForeignScriptFilter() TranscriptionFilter {
+	return &foreignScriptFilter{}
+}
+
+// languageScripts of the languages which are not written in Latin.
+var languageScripts = map[string][]*unicode.RangeTable{
+	"ru": {unicode.Cyrillic},
+	"uk": {unicode.Cyrillic},
+	"bg": {unicode.Cyrillic},
+	"el": {unicode.Greek},
+	"ar": {unicode.Arabic},
+	"fa": {unicode.Arabic},
+	"he": {unicode.Hebrew},
+	"hi": {unicode.Devanagari},
+	"th": {unicode.Thai},
+	"ko": {unicode.Hangul, unicode.Han},
+	"ja": {unicode.Hiragana, unicode.Katakana, unicode.Han},
+	"zh": {unicode.Han},
+}
+
+// Filter implements TranscriptionFilter.Filter
+// An unknown conversation language could be anything, so it keeps all.
+func (f *foreignScriptFilter) Filter(text string, input FilterInput) (string, string) {
+	if input.Language == "" {
+		return text, ""
+	}
+	allowed := append([]*unicode.RangeTable{unicode.Latin}, languageScripts[input.Language]...)
+
+	kept := make([]string, 0)
+	dropped := make([]string, 0)
+	for _, word := range strings.Fields(text) {
+		isForeign := false
+		for _, r := range word {
+			if unicode.IsLetter(r) && !unicode.In(r, allowed...) {
+				isForeign = true
+				break
+			}
+		}
+		if isForeign {
+			dropped = append(dropped, word)
+		} else {
+			kept = append(kept, word)
+		}
+	}
+	if len(dropped) == 0 {
+		return text, ""
+	}
+	return strings.Join(kept, " "), fmt.Sprintf("not written in the script of %s: %s", input.Language, strings.Join(dropped, " "))
+}
+
+// repetitionFilter Whisper repeats the prompt, or itself, when it has nothing to transcribe, e.g.
+// .. in 100 words. All right. All right. Well, please, let's do it. All right. Go. All right. All right.
+type repetitionFilter struct {
+	maxRepeats int
+}
+
+// repetitionMaxWords is the longest phrase checked for repeating in a row.
+const repetitionMaxWords = 6
+
+func NewRepetitionFilter(maxRepeats int) TranscriptionFilter {
+	return &repetitionFilter{
+		maxRepeats: maxRepeats,
+	}
+}
+
+// Filter implements TranscriptionFilter.Filter
+func (f *repetitionFilter) Filter(text string, input FilterInput) (string, string) {
+	normalized := normalizeForMatch(text)
+	if len(normalized) >= 3 && strings.HasSuffix(normalizeForMatch(input.PreviousText), normalized) {
+		return "", "repeats the previous words"
+	}
+
+	words := strings.Fields(text)
+	keys := make([]string, len(words))
+	for i, word := range words {
+		keys[i] = normalizeForMatch(word)
+	}
+	kept := make([]string, 0, len(words))
+	collapsed := make([]string, 0)
+	for i := 0; i < len(words); {
+		isCollapsed := false
+		for n := 1; n <= repetitionMaxWords && i+n <= len(words); n++ {
+			count := 1
+			for i+(count+1)*n <= len(words) && equalKeys(keys[i:i+n], keys[i+count*n:i+(count+1)*n]) {
+				count++
+			}
+			if count > f.maxRepeats {
+				kept = append(kept, words[i:i+n]...)
+				collapsed = append(collapsed, fmt.Sprintf("%q x%d", strings.Join(words[i:i+n], " "), count))
+				i += count * n
+				isCollapsed = true
+				break
+			}
+		}
+		if !isCollapsed {
+			kept = append(kept, words[i])
+			i++
+		}
+	}
+	if len(collapsed) == 0 {
+		return text, ""
+	}
+	return strings.Join(kept, " "), "repetition loop " + strings.Join(collapsed, ", ")
+}
+
+// plausibilityFilter drops the text with more words than could have been said in the audio.
+type plausibilityFilter struct {
+	maxWordsPerSecond float64
+}
+
+// plausibilityWordSlack so a quick "Yes, sure." in a short chunk passes.
+const plausibilityWordSlack = 2
+
+func NewPlausibilityFilter(maxWordsPerSecond float64) TranscriptionFilter {
+	return &plausibilityFilter{
+		maxWordsPerSecond: maxWordsPerSecond,
+	}
+}
+
+// Filter implements TranscriptionFilter.Filter
+// NOTE: Languages without spaces, e.g. Japanese, count as a few words so they mostly pass.
+func (f *plausibilityFilter) Filter(text string, input FilterInput) (string, string) {
+	audioLength := input.AudioLength
+	if audioLength <= 0 {
+		audioLength = input.Transcription.Duration
+	}
+	if audioLength <= 0 {
+		return text, ""
+	}
+	wordCount := len(strings.Fields(text))
+	maxWordCount := f.maxWordsPerSecond*audioLength.Seconds() + plausibilityWordSlack
+	if float64(wordCount) > maxWordCount {
+		return "", fmt.Sprintf("%d words cannot be said in %s", wordCount, audioLength)
+	}
+	return text, ""
+}
+
+// normalizeForMatch lower cases the letters and digits, and separates them by single spaces.
+func normalizeForMatch(text string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), " ")
+}
+
+func equalKeys(a []string, b []string) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func hasLetterOrDigit(text string) bool {
+	for _, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,7 +1,79 @@
 package transcriber
 
-import "io"
+import (
+	"io"
+	"time"
+)
 
 type Transcriber interface {
-	SendAudio(input io.Reader, fileExtension string, prompt string) (result string, err error)
+	// SendAudio language is ISO 639-1, empty detects it.
+	SendAudio(input io.Reader, fileExtension string, prompt string, language string) (result Transcription, err error)
+}
+
+// Whisper marks a segment as silence when both are beyond these, see no_speech_threshold and logprob_threshold
+// in https://github.com/openai/whisper/blob/main/whisper/transcribe.py
+const (
+	noSpeechProbThreshold = 0.6
+	avgLogProbThreshold   = -1.0
+)
+
+// Transcription is what the Transcriber heard, the Segments and Words are only as detailed as the backend provides.
+type Transcription struct {
+	Text string
+	// Language as detected, or as forced by the request, empty if unknown.
+	Language string
+	Duration time.Duration
+	Segments []TranscriptionSegment
+	Words    []TranscriptionWord
+}
+
+type TranscriptionSegment struct {
+	Text  string
+	Start time.Duration
+	End   time.Duration
+	// AvgLogProb of the tokens, the closer to 0 the more confident the model is.
+	AvgLogProb float64
+	// NoSpeechProb is the probability of the segment being silence, i.e. the Text being made up.
+	NoSpeechProb float64
+	// CompressionRatio of the Text, a high one means a repetition loop.
+	CompressionRatio float64
+}
+
+type TranscriptionWord struct {
+	Word  string
+	Start time.Duration
+	End   time.Duration
+}
+
+// IsLikelySilence is true when all segments are most likely silence, same as Whisper itself skips them.
+// Without any segments, e.g. from a backend which only returns the text, it cannot tell.
+func (t Transcription) IsLikelySilence() bool {
+	if len(t.Segments) == 0 {
+		return false
+	}
+	for _, segment := range t.Segments {
+		if segment.NoSpeechProb <= noSpeechProbThreshold || segment.AvgLogProb >= avgLogProbThreshold {
+			return false
+		}
+	}
+	return true
+}
+
+// AvgLogProb over all segments weighted by their length, 0 without any segments.
+func (t Transcription) AvgLogProb() float64 {
+	sum := 0.0
+	var total time.Duration
+	for _, segment := range t.Segments {
+		length := max(segment.End-segment.Start, time.Millisecond)
+		sum += segment.AvgLogProb * float64(length)
+		total += length
+	}
+	if total == 0 {
+		return 0
+	}
+	return sum / float64(total)
+}
+
+func toDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
 }
@@ -6,8 +6,6 @@ import (
 	"github.com/rs/zerolog/log"
 	"github.com/sashabaranov/go-openai"
 	"io"
-	"regexp"
-	"strings"
 	"time"
 )
 
@@ -23,7 +21,7 @@ func NewOpenAIWhisper(client *openai.Client) Transcriber {
 
 // SendAudio TODO(P1, latency): Figure out by how much mp3 is faster than .WAV
 // 3 tests on a 260KB wav vs 67KB mp3 it seems maybe 1100ms vs 1000ms, but there was a run when wav beat mp3 :/
-func (o *openAIWhisper) SendAudio(input io.Reader, fileExtension string, prompt string) (result string, err error) {
+func (o *openAIWhisper) SendAudio(input io.Reader, fileExtension string, prompt string, language string) (result Transcription, err error) {
 	startTime := time.Now()
 	// TODO(P0, ux): Try running Whisper locally for quicker transcription speeds (and maybe no filler words needed).
 	req := openai.AudioRequest{
@@ -34,49 +32,57 @@ func (o *openAIWhisper) SendAudio(input io.Reader, fileExtension string, prompt
 		// NOTE: Giving the model the previous words improves accuracy.
 		// Whisper can take up to 244 tokens, if more are passed than only the last are used.
 		// TODO(P0, ux): Adding prompt with previous words should improve transcription
-		// Language: "en",
-		Prompt: prompt,
+		// NOTE: Whisper detects the language from the first 30 seconds, short chunks are often misdetected.
+		Language: language,
+		Prompt:   prompt,
+		// verbose_json has the segments with their confidence, and the words only come with it too.
+		Format:                 openai.AudioResponseFormatVerboseJSON,
+		TimestampGranularities: []openai.TranscriptionTimestampGranularity{openai.TranscriptionTimestampGranularityWord, openai.TranscriptionTimestampGranularitySegment},
 	}
 
-	log.Debug().Str("model", req.Model).Str("prompt", prompt).Msg("create transcription request")
+	log.Debug().Str("model", req.Model).Str("prompt", prompt).Str("language", language).Msg("create transcription request")
 	resp, err := o.client.CreateTranscription(context.Background(), req)
 	if err != nil {
 		err = fmt.Errorf("cannot create transcription %w", err)
 		return
 	}
 
-	//var contentBuilder strings.Builder
-	//for _, segment := range resp.Segments {
-	//	contentBuilder.WriteString(segment.Text)
-	//}
-	//result = contentBuilder.String()
-
-	// TODO: Better "silence" detection
-	// On my semi-professional microphone, silence is so pronounced it actually transcribes stuff
-	// (that's also because they trained whisper on movies WITH captions - so non-speaking is often so interpreted too)
-	result = removeNonEnglishAndMBC(resp.Text)
-	if result != resp.Text {
-		log.Info().Str("original_text", resp.Text).Str("processed_text", result).Msg("transcription post-processing removed some text")
-	}
+	// NOTE: What Whisper made up for silence is removed later by the HallucinationFilter, as it needs the context.
+	result = toTranscription(resp)
 
 	// TODO(P1, ux): Sometimes it really hangs time_elapsed=27332.972959 transcription="Tell me about San Francisco."
 	//   For such we should either:
 	//    * Run Whisper locally to have more control over it
 	//    * Generate some audio output
-	log.Debug().Str("transcription", result).Dur("time_elapsed", time.Since(startTime)).Msg("received transcription")
+	log.Debug().Str("transcription", result.Text).Str("language", result.Language).Float64("avg_log_prob", result.AvgLogProb()).Dur("time_elapsed", time.Since(startTime)).Msg("received transcription")
 	return
 }
 
-// removeNonEnglishAndMBC removes non-English characters and the "MBC" string from the input text.
-// TODO: HACK, somewhat "silence" is transcribed with random Chinese characters for example:
-// MBC 뉴스 이덕영입니다. Yeah, tell me. a bit about uh, written  in 100 words.  MBC 뉴스 이덕영입니다.
-func removeNonEnglishAndMBC(text string) string {
-	// Regular expression to match non-English characters.
-	nonEnglishRegex := regexp.MustCompile(`[^\x00-\x7F]+`)
-	text = nonEnglishRegex.ReplaceAllString(text, "")
-
-	// Remove all occurrences of "MBC".
-	text = strings.ReplaceAll(text, "MBC", "")
-
-	return text
+// toTranscription NOTE: the verbose_json language is the full name, e.g. "english".
+func toTranscription(resp openai.AudioResponse) Transcription {
+	result := Transcription{
+		Text:     resp.Text,
+		Language: resp.Language,
+		Duration: toDuration(resp.Duration),
+		Segments: make([]TranscriptionSegment, 0, len(resp.Segments)),
+		Words:    make([]TranscriptionWord, 0, len(resp.Words)),
+	}
+	for _, segment := range resp.Segments {
+		result.Segments = append(result.Segments, TranscriptionSegment{
+			Text:             segment.Text,
+			Start:            toDuration(segment.Start),
+			End:              toDuration(segment.End),
+			AvgLogProb:       segment.AvgLogprob,
+			NoSpeechProb:     segment.NoSpeechProb,
+			CompressionRatio: segment.CompressionRatio,
+		})
+	}
+	for _, word := range resp.Words {
+		result.Words = append(result.Words, TranscriptionWord{
+			Word:  word.Word,
+			Start: toDuration(word.Start),
+			End:   toDuration(word.End),
+		})
+	}
+	return result
 }
@@ -0,0 +1,49 @@
+package transcriber
+
+import (
+	"time"
+)
+
+// TranscriptEvent is a hypothesis of a StreamingTranscriber about a piece of the audio.
+// Interim ones get refined until a final one for the same audio, the finals do not overlap.
+type TranscriptEvent struct {
+	Text    string
+	IsFinal bool
+	// Stability is how likely the interim Text stays as is, from 0 to 1, finals are always 1.
+	Stability float64
+	// Start and End of the audio the Text is about, relative to the start of the stream.
+	Start time.Duration
+	End   time.Duration
+	// Language of the Text as ISO 639-1, when the stream detected it, empty otherwise.
+	Language string
+	// IsEndOfUtterance means the speaker finished their turn, the Text is empty for it.
+	IsEndOfUtterance bool
+}
+
+// StreamingTranscriber transcribes the audio as it is captured, instead of waiting for whole chunks as Transcriber does.
+// It also does the turn detection, as it knows better when a sentence ended than a silence threshold does.
+type StreamingTranscriber interface {
+	// NewStream starts transcribing one conversation with mono linear16 samples at sampleRate,
+	// language is ISO 639-1, empty detects it, or keeps the configured one if the backend cannot detect it.
+	NewStream(sampleRate int, language string) (TranscriptionStream, error)
+}
+
+// TranscriptionStream is a single conversation of a StreamingTranscriber, SendAudio and Flush are not concurrency safe.
+type TranscriptionStream interface {
+	// SendAudio pushes the next frame of samples, it should not block on the transcription itself.
+	SendAudio(samples []int) error
+	// Flush ends the current utterance right away, e.g. when the client detected the end of speech.
+	Flush() error
+	// Events are in the order of the audio, the channel is closed after Close once everything was transcribed.
+	Events() <-chan TranscriptEvent
+	// Close ends the audio, the remaining events still come.
+	Close() error
+}
+
+// EncodedAudioSender is implemented by the TranscriptionStream-s which take the encoded frames as they are captured,
+// e.g. the mu-law of a phone call, which saves decoding and re-encoding every frame.
+type EncodedAudioSender interface {
+	// Encoding of the frames SendEncodedAudio takes, e.g. "mulaw", same as models.AudioData.Format.
+	Encoding() string
+	SendEncodedAudio(frame []byte) error
+}
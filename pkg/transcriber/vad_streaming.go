@@ -0,0 +1,292 @@
+package transcriber
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/go-audio/audio"
+	"github.com/petrzlen/vocode-golang/pkg/audio_utils"
+	"github.com/petrzlen/vocode-golang/pkg/models"
+	"github.com/rs/zerolog/log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// VadConfig tunes how NewVadStreamingTranscriber cuts the audio into segments for the batch Transcriber.
+type VadConfig struct {
+	// SpeechAmplitude is the average absolute amplitude of a frame from which it counts as speech.
+	SpeechAmplitude float64
+	// MinSpeech is the least speech in a segment worth transcribing, shorter ones are most likely noise.
+	MinSpeech time.Duration
+	// Pause ends a segment, and it gets transcribed, while the speaker might still continue.
+	Pause time.Duration
+	// EndOfUtterance is the silence after which the speaker finished their turn.
+	EndOfUtterance time.Duration
+	// MaxSegment cuts a segment even without a pause, so the transcriber keeps up with a long monologue.
+	MaxSegment time.Duration
+	// Filter of the segment transcriptions, nil uses DefaultHallucinationFilterConfig.
+	Filter *HallucinationFilter
+}
+
+// DefaultVadConfig is about the speechChunker defaults of the telephony handlers, just quicker to the end of utterance.
+var DefaultVadConfig = VadConfig{
+	SpeechAmplitude: 300,
+	MinSpeech:       100 * time.Millisecond,
+	Pause:           500 * time.Millisecond,
+	EndOfUtterance:  1500 * time.Millisecond,
+	MaxSegment:      15 * time.Second,
+	Filter:          nil,
+}
+
+// vadStreamingTranscriber is a StreamingTranscriber for any batch Transcriber, with a simple amplitude VAD.
+// It only emits finals, as a batch Transcriber has nothing better to offer for the audio still being said.
+type vadStreamingTranscriber struct {
+	transcriber Transcriber
+	config      VadConfig
+}
+
+func NewVadStreamingTranscriber(transcriber Transcriber, config VadConfig) StreamingTranscriber {
+	return &vadStreamingTranscriber{
+		transcriber: transcriber,
+		config:      config,
+	}
+}
+
+// NewStream implements StreamingTranscriber.NewStream
+func (t *vadStreamingTranscriber) NewStream(sampleRate int, language string) (TranscriptionStream, error) {
+	if sampleRate <= 0 {
+		return nil, fmt.Errorf("invalid sample rate %d", sampleRate)
+	}
+	filter := t.config.Filter
+	if filter == nil {
+		filter = NewHallucinationFilterFromConfig(DefaultHallucinationFilterConfig)
+	}
+	stream := &vadStream{
+		transcriber:  t.transcriber,
+		config:       t.config,
+		filter:       filter,
+		language:     language,
+		sampleRate:   sampleRate,
+		events:       make(chan TranscriptEvent, 100),
+		jobs:         make(chan vadJob, 100),
+		isClosed:     false,
+		position:     0,
+		segment:      make([]int, 0),
+		segmentStart: 0,
+		speechCount:  0,
+		silenceCount: 0,
+		hasUtterance: false,
+	}
+	go stream.transcribeRoutine()
+	return stream, nil
+}
+
+// vadJob is either a segment to transcribe, or the end of utterance (without samples).
+type vadJob struct {
+	samples          []int
+	start            time.Duration
+	end              time.Duration
+	isEndOfUtterance bool
+}
+
+type vadStream struct {
+	transcriber Transcriber
+	config      VadConfig
+	filter      *HallucinationFilter
+	// language of all the segments, empty detects it on the first segment of each utterance.
+	language   string
+	sampleRate int
+	events     chan TranscriptEvent
+	// jobs keep the segments in order, as they get transcribed one by one by the transcribeRoutine.
+	jobs chan vadJob
+	// closeMutex guards isClosed, so nothing gets into the closed jobs.
+	closeMutex sync.Mutex
+	isClosed   bool
+
+	// VAD state, all counts are in samples.
+	position     int
+	segment      []int
+	segmentStart int
+	speechCount  int
+	// silenceCount is since the last speech frame, also across the segments.
+	silenceCount int
+	// hasUtterance is true since a speech frame, until the end of utterance was emitted.
+	hasUtterance bool
+}
+
+// SendAudio implements TranscriptionStream.SendAudio
+// The whole frame is either speech or silence, so it should be short, e.g. 20ms.
+func (s *vadStream) SendAudio(samples []int) error {
+	if len(samples) == 0 {
+		return nil
+	}
+	isSpeech := averageAbsAmplitude(samples) >= s.config.SpeechAmplitude
+
+	if isSpeech || len(s.segment) > 0 {
+		if len(s.segment) == 0 {
+			s.segmentStart = s.position
+		}
+		s.segment = append(s.segment, samples...)
+	}
+	s.position += len(samples)
+	if isSpeech {
+		s.speechCount += len(samples)
+		s.silenceCount = 0
+		s.hasUtterance = true
+	} else {
+		s.silenceCount += len(samples)
+	}
+
+	if len(s.segment) > 0 && (s.silenceCount >= s.samplesOf(s.config.Pause) || len(s.segment) >= s.samplesOf(s.config.MaxSegment)) {
+		if err := s.submitSegment(); err != nil {
+			return err
+		}
+	}
+	if s.hasUtterance && s.silenceCount >= s.samplesOf(s.config.EndOfUtterance) {
+		return s.submitEndOfUtterance()
+	}
+	return nil
+}
+
+// Flush implements TranscriptionStream.Flush
+func (s *vadStream) Flush() error {
+	if err := s.submitSegment(); err != nil {
+		return err
+	}
+	if s.hasUtterance {
+		return s.submitEndOfUtterance()
+	}
+	return nil
+}
+
+// Events implements TranscriptionStream.Events
+func (s *vadStream) Events() <-chan TranscriptEvent {
+	return s.events
+}
+
+// Close implements TranscriptionStream.Close
+func (s *vadStream) Close() error {
+	// Whatever was said last still gets transcribed.
+	err := s.Flush()
+
+	s.closeMutex.Lock()
+	defer s.closeMutex.Unlock()
+	if !s.isClosed {
+		s.isClosed = true
+		close(s.jobs)
+	}
+	return err
+}
+
+func (s *vadStream) samplesOf(duration time.Duration) int {
+	return int(duration.Seconds() * float64(s.sampleRate))
+}
+
+func (s *vadStream) durationOf(numSamples int) time.Duration {
+	return time.Duration(numSamples) * time.Second / time.Duration(s.sampleRate)
+}
+
+// submitSegment drops the trailing silence, and resets the segment.
+func (s *vadStream) submitSegment() error {
+	segment := s.segment
+	if trailingSilence := min(s.silenceCount, len(segment)); trailingSilence > 0 {
+		segment = segment[:len(segment)-trailingSilence]
+	}
+	speechCount := s.speechCount
+	s.segment = make([]int, 0)
+	s.speechCount = 0
+	if speechCount < s.samplesOf(s.config.MinSpeech) {
+		if speechCount > 0 {
+			log.Debug().Dur("speech", s.durationOf(speechCount)).Msg("vadStream dropping too short segment")
+		}
+		return nil
+	}
+
+	return s.submitJob(vadJob{
+		samples: segment,
+		start:   s.durationOf(s.segmentStart),
+		end:     s.durationOf(s.segmentStart + len(segment)),
+	})
+}
+
+func (s *vadStream) submitEndOfUtterance() error {
+	s.hasUtterance = false
+	return s.submitJob(vadJob{
+		start:            s.durationOf(s.position - s.silenceCount),
+		end:              s.durationOf(s.position),
+		isEndOfUtterance: true,
+	})
+}
+
+func (s *vadStream) submitJob(job vadJob) error {
+	s.closeMutex.Lock()
+	defer s.closeMutex.Unlock()
+	if s.isClosed {
+		return fmt.Errorf("vadStream is already closed")
+	}
+	s.jobs <- job
+	return nil
+}
+
+func (s *vadStream) transcribeRoutine() {
+	// previousWords are the prompt for the next segment, reset on each utterance.
+	var previousWords strings.Builder
+	// Unless forced, the language is detected on the first segment of each utterance, and the rest is transcribed in it.
+	utteranceLanguage := s.language
+	for job := range s.jobs {
+		if job.isEndOfUtterance {
+			previousWords.Reset()
+			utteranceLanguage = s.language
+			s.events <- TranscriptEvent{IsFinal: true, Stability: 1, Start: job.start, End: job.end, IsEndOfUtterance: true}
+			continue
+		}
+
+		startTime := time.Now()
+		wavBytes, err := audio_utils.EncodeToWavSimple(&audio.IntBuffer{
+			Data:           job.samples,
+			Format:         &audio.Format{SampleRate: s.sampleRate, NumChannels: 1},
+			SourceBitDepth: 16,
+		})
+		if err != nil {
+			log.Error().Err(err).Msg("vadStream cannot encode segment, skipping")
+			continue
+		}
+		transcription, err := s.transcriber.SendAudio(bytes.NewReader(wavBytes), "wav", previousWords.String(), utteranceLanguage)
+		if err != nil {
+			log.Error().Err(err).Int("wav_chunk_byte_length", len(wavBytes)).Msg("cannot transcribe segment, skipping")
+			continue
+		}
+		// The detected language goes first, so e.g. Hangul is not dropped as foreign before the switch to Korean.
+		language := utteranceLanguage
+		if language == "" {
+			language = models.LanguageCode(transcription.Language)
+		}
+		// The amplitude VAD lets through noise, which Whisper happily makes up words for.
+		text := s.filter.Filter(FilterInput{
+			Transcription: transcription,
+			AudioLength:   job.end - job.start,
+			PreviousText:  previousWords.String(),
+			Language:      language,
+		})
+		log.Debug().Str("transcription", text).Dur("start", job.start).Dur("end", job.end).Dur("time_elapsed", time.Since(startTime)).Msg("vadStream transcribed segment")
+		if text == "" {
+			continue
+		}
+		previousWords.WriteString(" ")
+		previousWords.WriteString(text)
+		utteranceLanguage = language
+		s.events <- TranscriptEvent{Text: text, IsFinal: true, Stability: 1, Start: job.start, End: job.end, Language: language}
+	}
+	close(s.events)
+}
+
+func averageAbsAmplitude(samples []int) float64 {
+	sum := 0
+	for _, sample := range samples {
+		if sample < 0 {
+			sample = -sample
+		}
+		sum += sample
+	}
+	return float64(sum) / float64(len(samples))
+}
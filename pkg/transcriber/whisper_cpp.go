@@ -0,0 +1,287 @@
+package transcriber
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/go-audio/audio"
+	"github.com/petrzlen/vocode-golang/pkg/audio_utils"
+	"github.com/rs/zerolog/log"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// whisperCppSampleRate is the only one whisper.cpp takes, without its ffmpeg --convert.
+const whisperCppSampleRate = 16000
+
+// whisperCppBlankAudio is what whisper.cpp transcribes silence as.
+const whisperCppBlankAudio = "[BLANK_AUDIO]"
+
+// WhisperCppConfig is either for a whisper.cpp server (ServerUrl), or for running its CLI (BinaryPath).
+type WhisperCppConfig struct {
+	// ServerUrl of the whisper.cpp server, e.g. "http://127.0.0.1:8080", the CLI is run when empty.
+	// NOTE: The server loads its model with its own threads on start, so ModelPath and Threads are only for the CLI.
+	ServerUrl string
+	// BinaryPath of the whisper.cpp CLI, it was called "main" before being renamed to "whisper-cli".
+	BinaryPath string
+	ModelPath  string
+	// Threads 0 keeps the whisper.cpp default.
+	Threads int
+	// Language e.g. "en", "auto" detects it, empty keeps the whisper.cpp default.
+	Language string
+	// Timeout of a single transcription, so a stuck process does not stall the conversation.
+	Timeout time.Duration
+}
+
+var DefaultWhisperCppConfig = WhisperCppConfig{
+	ServerUrl:  "",
+	BinaryPath: "whisper-cli",
+	ModelPath:  "models/ggml-base.en.bin",
+	Threads:    0,
+	Language:   "en",
+	Timeout:    30 * time.Second,
+}
+
+type whisperCpp struct {
+	config     WhisperCppConfig
+	httpClient *http.Client
+}
+
+// NewWhisperCpp transcribes locally with https://github.com/ggerganov/whisper.cpp, so it works without OpenAI.
+func NewWhisperCpp(config WhisperCppConfig) Transcriber {
+	return &whisperCpp{
+		config:     config,
+		httpClient: &http.Client{Timeout: config.Timeout},
+	}
+}
+
+// SendAudio implements Transcriber.SendAudio
+// Only the server gives the segments, the words and the language, the CLI only the text.
+// The language overrides the configured one, NOTE: the ".en" models only know English.
+func (w *whisperCpp) SendAudio(input io.Reader, fileExtension string, prompt string, language string) (result Transcription, err error) {
+	startTime := time.Now()
+	wavBytes, err := toWhisperCppWav(input, fileExtension)
+	if err != nil {
+		return Transcription{}, err
+	}
+
+	if w.config.ServerUrl != "" {
+		result, err = w.sendToServer(wavBytes, prompt, language)
+	} else {
+		result.Text, err = w.runCli(wavBytes, prompt, language)
+	}
+	if result.Language == "" {
+		result.Language = language
+	}
+	if err != nil {
+		return Transcription{}, err
+	}
+
+	result.Text = removeWhisperCppBlankAudio(result.Text)
+	for i := range result.Segments {
+		result.Segments[i].Text = removeWhisperCppBlankAudio(result.Segments[i].Text)
+	}
+	log.Debug().Str("transcription", result.Text).Str("language", result.Language).Float64("avg_log_prob", result.AvgLogProb()).Dur("time_elapsed", time.Since(startTime)).Msg("received whisper.cpp transcription")
+	return result, nil
+}
+
+// sendToServer POSTs to the /inference endpoint of the whisper.cpp server example.
+func (w *whisperCpp) sendToServer(wavBytes []byte, prompt string, language string) (Transcription, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	fileWriter, err := writer.CreateFormFile("file", "audio.wav")
+	if err != nil {
+		return Transcription{}, fmt.Errorf("cannot create whisper.cpp form file: %w", err)
+	}
+	if _, err = fileWriter.Write(wavBytes); err != nil {
+		return Transcription{}, fmt.Errorf("cannot write whisper.cpp form file: %w", err)
+	}
+	fields := map[string]string{
+		"response_format": "verbose_json",
+		"temperature":     "0.0",
+		"prompt":          prompt,
+	}
+	if language = w.getLanguage(language); language != "" {
+		fields["language"] = language
+	}
+	for name, value := range fields {
+		if err = writer.WriteField(name, value); err != nil {
+			return Transcription{}, fmt.Errorf("cannot write whisper.cpp form field %s: %w", name, err)
+		}
+	}
+	if err = writer.Close(); err != nil {
+		return Transcription{}, fmt.Errorf("cannot finish whisper.cpp form: %w", err)
+	}
+
+	url := strings.TrimSuffix(w.config.ServerUrl, "/") + "/inference"
+	resp, err := w.httpClient.Post(url, writer.FormDataContentType(), &body)
+	if err != nil {
+		return Transcription{}, fmt.Errorf("cannot reach whisper.cpp server: %w", err)
+	}
+	defer resp.Body.Close()
+	respBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Transcription{}, fmt.Errorf("cannot read whisper.cpp response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Transcription{}, fmt.Errorf("whisper.cpp server status=%d body=%s", resp.StatusCode, string(respBytes))
+	}
+
+	var inference whisperCppInference
+	if err = json.Unmarshal(respBytes, &inference); err != nil {
+		return Transcription{}, fmt.Errorf("cannot parse whisper.cpp response %s: %w", string(respBytes), err)
+	}
+	if inference.Error != "" {
+		return Transcription{}, fmt.Errorf("whisper.cpp server error: %s", inference.Error)
+	}
+	return inference.toTranscription(), nil
+}
+
+// whisperCppInference is the verbose_json of the whisper.cpp server, which mimics the OpenAI one.
+type whisperCppInference struct {
+	Error    string  `json:"error"`
+	Text     string  `json:"text"`
+	Language string  `json:"language"`
+	Duration float64 `json:"duration"`
+	Segments []struct {
+		Text         string  `json:"text"`
+		Start        float64 `json:"start"`
+		End          float64 `json:"end"`
+		AvgLogprob   float64 `json:"avg_logprob"`
+		NoSpeechProb float64 `json:"no_speech_prob"`
+		Words        []struct {
+			Word  string  `json:"word"`
+			Start float64 `json:"start"`
+			End   float64 `json:"end"`
+		} `json:"words"`
+	} `json:"segments"`
+}
+
+func (i whisperCppInference) toTranscription() Transcription {
+	result := Transcription{
+		Text:     i.Text,
+		Language: i.Language,
+		Duration: toDuration(i.Duration),
+		Segments: make([]TranscriptionSegment, 0, len(i.Segments)),
+		Words:    make([]TranscriptionWord, 0),
+	}
+	for _, segment := range i.Segments {
+		result.Segments = append(result.Segments, TranscriptionSegment{
+			Text:         segment.Text,
+			Start:        toDuration(segment.Start),
+			End:          toDuration(segment.End),
+			AvgLogProb:   segment.AvgLogprob,
+			NoSpeechProb: segment.NoSpeechProb,
+		})
+		// The words are per segment, and the tokens are not always whole words, e.g. " Hel" "lo".
+		for _, word := range segment.Words {
+			result.Words = append(result.Words, TranscriptionWord{
+				Word:  word.Word,
+				Start: toDuration(word.Start),
+				End:   toDuration(word.End),
+			})
+		}
+	}
+	return result
+}
+
+// runCli runs the whisper.cpp CLI on a temporary wav, and reads the transcript from its stdout.
+func (w *whisperCpp) runCli(wavBytes []byte, prompt string, language string) (string, error) {
+	wavFile, err := os.CreateTemp("", "vocode-whisper-cpp-*.wav")
+	if err != nil {
+		return "", fmt.Errorf("cannot create whisper.cpp input file: %w", err)
+	}
+	defer func() {
+		errLog(os.Remove(wavFile.Name()), "remove whisper.cpp input file")
+	}()
+	_, err = wavFile.Write(wavBytes)
+	errLog(wavFile.Close(), "close whisper.cpp input file")
+	if err != nil {
+		return "", fmt.Errorf("cannot write whisper.cpp input file: %w", err)
+	}
+
+	// -nt without timestamps, -np without anything but the transcript.
+	args := []string{"-m", w.config.ModelPath, "-f", wavFile.Name(), "-nt", "-np"}
+	if w.config.Threads > 0 {
+		args = append(args, "-t", strconv.Itoa(w.config.Threads))
+	}
+	if language = w.getLanguage(language); language != "" {
+		args = append(args, "-l", language)
+	}
+	if prompt != "" {
+		args = append(args, "--prompt", prompt)
+	}
+
+	ctx := context.Background()
+	if w.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.config.Timeout)
+		defer cancel()
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, w.config.BinaryPath, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err = cmd.Run(); err != nil {
+		return "", fmt.Errorf("whisper.cpp cli failed: %w stderr=%s", err, lastLines(stderr.String(), 5))
+	}
+
+	// Each segment is on its own line.
+	return strings.Join(strings.Fields(stdout.String()), " "), nil
+}
+
+// getLanguage without the request language, detects it when configured so, i.e. with "auto".
+func (w *whisperCpp) getLanguage(language string) string {
+	if language != "" {
+		return language
+	}
+	return w.config.Language
+}
+
+// toWhisperCppWav converts the input into a mono 16kHz 16bit wav.
+func toWhisperCppWav(input io.Reader, fileExtension string) ([]byte, error) {
+	rawBytes, err := io.ReadAll(input)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read audio: %w", err)
+	}
+
+	var intBuffer *audio.IntBuffer
+	switch fileExtension {
+	case "wav":
+		intBuffer, err = audio_utils.DecodeFromWav(rawBytes)
+	case "mp3":
+		intBuffer, err = audio_utils.DecodeFromMp3(rawBytes)
+	default:
+		return nil, fmt.Errorf("whisper.cpp transcriber does not support %s", fileExtension)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode audio for whisper.cpp: %w", err)
+	}
+
+	samples := make([]int, len(intBuffer.Data))
+	for i, sample := range intBuffer.Data {
+		// go-mp3 gives the samples as unsigned.
+		samples[i] = int(int16(sample))
+	}
+	return audio_utils.EncodeToWavSimple(&audio.IntBuffer{
+		Data:           audio_utils.ResampleSimple(samples, intBuffer.Format.SampleRate, whisperCppSampleRate),
+		Format:         &audio.Format{SampleRate: whisperCppSampleRate, NumChannels: 1},
+		SourceBitDepth: 16,
+	})
+}
+
+func removeWhisperCppBlankAudio(text string) string {
+	return strings.TrimSpace(strings.ReplaceAll(text, whisperCppBlankAudio, ""))
+}
+
+func lastLines(text string, count int) string {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	return strings.Join(lines[max(0, len(lines)-count):], "\n")
+}
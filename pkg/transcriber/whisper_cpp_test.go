@@ -0,0 +1,199 @@
+package transcriber
+
+import (
+	"bytes"
+	"github.com/go-audio/audio"
+	"github.com/petrzlen/vocode-golang/pkg/audio_utils"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestWav is half a second of silence at the telephony sample rate, which whisper.cpp has to get at 16kHz.
+func newTestWav(t *testing.T) []byte {
+	wavBytes, err := audio_utils.EncodeToWavSimple(&audio.IntBuffer{
+		Data:           make([]int, 4000),
+		Format:         &audio.Format{SampleRate: 8000, NumChannels: 1},
+		SourceBitDepth: 16,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wavBytes
+}
+
+func checkWhisperCppWav(t *testing.T, wavBytes []byte) {
+	intBuffer, err := audio_utils.DecodeFromWav(wavBytes)
+	if err != nil {
+		// Not Fatal, as the server checks it in its own goroutine.
+		t.Error(err)
+		return
+	}
+	if intBuffer.Format.SampleRate != whisperCppSampleRate || len(intBuffer.Data) != whisperCppSampleRate/2 {
+		t.Errorf("expected half a second at %d Hz, got %d samples at %d Hz", whisperCppSampleRate, len(intBuffer.Data), intBuffer.Format.SampleRate)
+	}
+}
+
+// whisperCppVerboseJson is what the whisper.cpp server example answers with response_format=verbose_json.
+const whisperCppVerboseJson = `{
+  "task": "transcribe",
+  "language": "spanish",
+  "duration": 2.5,
+  "text": " Hola, ¿qué tal? [BLANK_AUDIO]",
+  "segments": [
+    {"id": 0, "text": " Hola, ¿qué tal?", "start": 0.0, "end": 1.8, "temperature": 0.0, "avg_logprob": -0.21, "no_speech_prob": 0.02,
+     "words": [{"word": " Hola,", "start": 0.1, "end": 0.6, "probability": 0.93}, {"word": " ¿qué", "start": 0.7, "end": 1.1, "probability": 0.88}, {"word": " tal?", "start": 1.1, "end": 1.8, "probability": 0.9}]},
+    {"id": 1, "text": " [BLANK_AUDIO]", "start": 1.8, "end": 2.5, "temperature": 0.0, "avg_logprob": -0.9, "no_speech_prob": 0.85, "words": []}
+  ]
+}`
+
+func TestWhisperCppServer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/inference" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Error(err)
+			return
+		}
+		expectedFields := map[string]string{
+			"response_format": "verbose_json",
+			"temperature":     "0.0",
+			"prompt":          "Buenos días.",
+			"language":        "es",
+		}
+		for name, expected := range expectedFields {
+			if value := r.FormValue(name); value != expected {
+				t.Errorf("expected the %s field %q, got %q", name, expected, value)
+			}
+		}
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		wavBytes, err := io.ReadAll(file)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		checkWhisperCppWav(t, wavBytes)
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(whisperCppVerboseJson))
+	}))
+	defer server.Close()
+
+	config := DefaultWhisperCppConfig
+	config.ServerUrl = server.URL + "/"
+	transcription, err := NewWhisperCpp(config).SendAudio(bytes.NewReader(newTestWav(t)), "wav", "Buenos días.", "es")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if transcription.Text != "Hola, ¿qué tal?" || transcription.Language != "spanish" || transcription.Duration != 2500*time.Millisecond {
+		t.Errorf("unexpected transcription %+v", transcription)
+	}
+	if len(transcription.Segments) != 2 || len(transcription.Words) != 3 {
+		t.Fatalf("expected 2 segments and 3 words, got %d and %d", len(transcription.Segments), len(transcription.Words))
+	}
+	if segment := transcription.Segments[1]; segment.Text != "" || segment.NoSpeechProb != 0.85 || segment.AvgLogProb != -0.9 || segment.Start != 1800*time.Millisecond {
+		t.Errorf("unexpected blank segment %+v", segment)
+	}
+	if word := transcription.Words[2]; word.Word != " tal?" || word.Start != 1100*time.Millisecond || word.End != 1800*time.Millisecond {
+		t.Errorf("unexpected word %+v", word)
+	}
+}
+
+func TestWhisperCppServerError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"error": "failed to read WAV file"}`))
+	}))
+	defer server.Close()
+
+	config := DefaultWhisperCppConfig
+	config.ServerUrl = server.URL
+	_, err := NewWhisperCpp(config).SendAudio(bytes.NewReader(newTestWav(t)), "wav", "", "")
+	if err == nil || !strings.Contains(err.Error(), "failed to read WAV file") {
+		t.Errorf("expected the whisper.cpp server error, got %v", err)
+	}
+}
+
+// whisperCppStubScript prints its args and copies the input wav next to itself, then prints what the CLI would.
+const whisperCppStubScript = `#!/bin/sh
+printf '%s\n' "$@" > "$0.args"
+while [ $# -gt 0 ]; do
+  if [ "$1" = "-f" ]; then cp "$2" "$0.wav"; fi
+  shift
+done
+printf ' Hello from\n the CLI.\n [BLANK_AUDIO]\n'
+`
+
+func TestWhisperCppCli(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the whisper.cpp stub is a shell script")
+	}
+	binaryPath := filepath.Join(t.TempDir(), "whisper-cli")
+	if err := os.WriteFile(binaryPath, []byte(whisperCppStubScript), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	config := DefaultWhisperCppConfig
+	config.BinaryPath = binaryPath
+	config.ModelPath = "models/ggml-small.bin"
+	config.Threads = 4
+	config.Language = "auto"
+	transcription, err := NewWhisperCpp(config).SendAudio(bytes.NewReader(newTestWav(t)), "wav", "Hi there.", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transcription.Text != "Hello from the CLI." || len(transcription.Segments) != 0 {
+		t.Errorf("unexpected transcription %+v", transcription)
+	}
+
+	argsBytes, err := os.ReadFile(binaryPath + ".args")
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := strings.Split(strings.TrimSpace(string(argsBytes)), "\n")
+	if len(args) != 12 {
+		t.Fatalf("unexpected whisper.cpp args %q", args)
+	}
+	inputPath := args[3]
+	expected := []string{"-m", "models/ggml-small.bin", "-f", inputPath, "-nt", "-np", "-t", "4", "-l", "auto", "--prompt", "Hi there."}
+	if strings.Join(args, " ") != strings.Join(expected, " ") {
+		t.Errorf("expected the whisper.cpp args %q, got %q", expected, args)
+	}
+	if _, err := os.Stat(inputPath); !os.IsNotExist(err) {
+		t.Errorf("expected the input wav %s removed, got %v", inputPath, err)
+	}
+	wavBytes, err := os.ReadFile(binaryPath + ".wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkWhisperCppWav(t, wavBytes)
+}
+
+func TestWhisperCppCliFailure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the whisper.cpp stub is a shell script")
+	}
+	binaryPath := filepath.Join(t.TempDir(), "whisper-cli")
+	script := "#!/bin/sh\necho 'error: failed to open the model' >&2\nexit 1\n"
+	if err := os.WriteFile(binaryPath, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	config := DefaultWhisperCppConfig
+	config.BinaryPath = binaryPath
+	_, err := NewWhisperCpp(config).SendAudio(bytes.NewReader(newTestWav(t)), "wav", "", "")
+	if err == nil || !strings.Contains(err.Error(), "failed to open the model") {
+		t.Errorf("expected the whisper.cpp stderr in the error, got %v", err)
+	}
+}
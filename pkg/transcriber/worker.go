@@ -1,77 +1,209 @@
 package transcriber
 
 import (
-	"bytes"
+	"fmt"
+	"github.com/petrzlen/vocode-golang/pkg/audio_utils"
 	"github.com/petrzlen/vocode-golang/pkg/models"
+	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 	"strings"
 	"time"
 )
 
+// chunkFrameDuration is the size of the frames a models.AudioInput wav chunk is split into.
+const chunkFrameDuration = 20 * time.Millisecond
+
+// A failed TranscriptionStream is reopened after a backoff, doubled on each failure in a row up to maxStreamBackoff.
+const (
+	minStreamBackoff = 500 * time.Millisecond
+	maxStreamBackoff = 30 * time.Second
+)
+
 // TranscribeAudioRoutine is intended to run for the entire lifespan of a conversation
-func TranscribeAudioRoutine(transcriber Transcriber, audioChunksChan chan models.AudioData, textChunksChan chan models.AudioData, earlyTranscriptChan chan string) string {
+// A batch Transcriber goes wrapped by NewVadStreamingTranscriber, so both kinds share the turn detection below.
+// It takes models.AudioFrame-s, and the models.AudioInput wav chunks of the input devices which cannot stream frames,
+// a models.SubmitPrompt ends the current utterance right away.
+// Unless forced, the stream detects the language and the conversation follows it, so the caller can switch it mid-call.
+// A forced language reopens the stream in it, e.g. when it was set mid-call. A nil language is never forced.
+// The finals go into textChunksChan as models.AudioInput with the Text, and the end of utterance as models.SubmitPrompt.
+func TranscribeAudioRoutine(streamingTranscriber StreamingTranscriber, language *models.ConversationLanguage, audioChunksChan chan models.AudioData, textChunksChan chan models.AudioData, earlyTranscriptChan chan string) string {
 	log.Info().Msgf("TranscribeAudioRoutine started")
 
-	var earlyTranscriptStartTime *time.Time
-	sendEarlyTranscript := true
+	var stream TranscriptionStream
+	var streamStartTime time.Time
+	streamLanguage := ""
+	// The audio until nextStreamAttempt is drained, so the input device does not block on a failed stream.
+	streamBackoff := time.Duration(0)
+	var nextStreamAttempt time.Time
+	eventsDone := make(chan string)
+	finalTranscript := ""
+	for audioChunk := range audioChunksChan {
+		switch audioChunk.EventType {
+		case models.AudioFrame, models.AudioInput:
+			// e.g. the caller asked for another language, which the stream would not switch to on its own.
+			if stream != nil && forcedLanguage(language) != streamLanguage {
+				log.Info().Str("language", forcedLanguage(language)).Str("stream_language", streamLanguage).Msg("TranscribeAudioRoutine reopening the stream in the forced language")
+				errLog(stream.Close(), "TranscriptionStream.Close")
+				finalTranscript = <-eventsDone
+				stream = nil
+			}
+			if stream == nil {
+				if time.Now().Before(nextStreamAttempt) {
+					continue
+				}
+				sampleRate, err := audioChunkSampleRate(audioChunk)
+				if err != nil {
+					log.Error().Err(err).Str("format", audioChunk.Format).Msg("cannot decode audio chunk, skipping")
+					continue
+				}
+				streamLanguage = forcedLanguage(language)
+				if stream, err = streamingTranscriber.NewStream(sampleRate, streamLanguage); err != nil {
+					streamBackoff = nextStreamBackoff(streamBackoff)
+					nextStreamAttempt = time.Now().Add(streamBackoff)
+					log.Error().Err(err).Int("sample_rate", sampleRate).Dur("backoff", streamBackoff).Msg("cannot start transcription stream, skipping audio")
+					stream = nil
+					continue
+				}
+				streamStartTime = time.Now()
+				go func(events <-chan TranscriptEvent) {
+					eventsDone <- forwardTranscriptEvents(events, language, textChunksChan, earlyTranscriptChan)
+				}(stream.Events())
+			}
+			if err := sendAudioChunk(stream, audioChunk); err != nil {
+				// A stream which lasted a while failed on its own, so it is not counted as a failure in a row.
+				if time.Since(streamStartTime) > maxStreamBackoff {
+					streamBackoff = 0
+				}
+				streamBackoff = nextStreamBackoff(streamBackoff)
+				nextStreamAttempt = time.Now().Add(streamBackoff)
+				log.Error().Err(err).Dur("backoff", streamBackoff).Msg("cannot send audio to transcription stream, gonna reconnect")
+				errLog(stream.Close(), "TranscriptionStream.Close")
+				finalTranscript = <-eventsDone
+				stream = nil
+			}
+		case models.SubmitPrompt:
+			if stream != nil {
+				log.Info().Msg("TranscribeAudioRoutine encountered SubmitPrompt; will flush the stream")
+				errLog(stream.Flush(), "TranscriptionStream.Flush")
+			}
+		default:
+			log.Warn().Int("event_type", int(audioChunk.EventType)).Msg("TranscribeAudioRoutine only takes audio, skipping")
+		}
+	}
 
-	// Replace 'client' and 'transcribeAudio' with your actual client and function
-	var transcriptBuilder strings.Builder
-	transcriptRepetitions := 0
+	if stream != nil {
+		errLog(stream.Close(), "TranscriptionStream.Close")
+		finalTranscript = <-eventsDone
+	}
+	log.Info().Msgf("TranscribeAudioRoutine ended with finalTranscript %s", finalTranscript)
+	close(textChunksChan)
+	return finalTranscript
+}
 
-	for audioChunk := range audioChunksChan {
-		if earlyTranscriptStartTime == nil {
-			earlyTranscriptStartTime = &audioChunk.Trace.CreatedAt
+// forcedLanguage is the language to open the stream in, empty leaves the detection to the stream.
+func forcedLanguage(language *models.ConversationLanguage) string {
+	if language == nil || !language.IsForced() {
+		return ""
+	}
+	return language.Get()
+}
+
+func nextStreamBackoff(backoff time.Duration) time.Duration {
+	return min(max(2*backoff, minStreamBackoff), maxStreamBackoff)
+}
+
+// sendAudioChunk forwards the encoded frame as it is when the stream takes its encoding, otherwise the samples.
+// Only the stream errors are returned, an audio chunk which cannot be decoded is logged and skipped.
+func sendAudioChunk(stream TranscriptionStream, audioChunk models.AudioData) error {
+	if sender, ok := stream.(EncodedAudioSender); ok && audioChunk.EventType == models.AudioFrame && sender.Encoding() == audioChunk.Format {
+		return sender.SendEncodedAudio(audioChunk.ByteData)
+	}
+	samples, sampleRate, err := decodeAudioChunk(audioChunk)
+	if err != nil {
+		log.Error().Err(err).Str("format", audioChunk.Format).Msg("cannot decode audio chunk, skipping")
+		return nil
+	}
+	// A wav chunk is split, as the stream expects short frames, e.g. for its VAD.
+	frameSize := sampleRate * int(chunkFrameDuration/time.Millisecond) / 1000
+	for frameStart := 0; frameStart < len(samples); frameStart += frameSize {
+		if err := stream.SendAudio(samples[frameStart:min(frameStart+frameSize, len(samples))]); err != nil {
+			return err
 		}
-		audioChunk.Trace.ReceivedAt = time.Now()
+	}
+	return nil
+}
+
+func audioChunkSampleRate(audioChunk models.AudioData) (int, error) {
+	if audioChunk.EventType == models.AudioFrame {
+		return audioChunk.SampleRate, nil
+	}
+	_, sampleRate, err := decodeAudioChunk(audioChunk)
+	return sampleRate, err
+}
 
-		if audioChunk.EventType == models.SubmitPrompt {
-			log.Info().Msg("TranscribeAudioRoutine encountered SubmitPrompt; will clear state to start working on the next")
+// decodeAudioChunk returns the mono samples of a models.AudioFrame (linear16 or mulaw) or a models.AudioInput (wav).
+func decodeAudioChunk(audioChunk models.AudioData) ([]int, int, error) {
+	switch {
+	case audioChunk.EventType == models.AudioFrame && audioChunk.Format == "mulaw":
+		return audio_utils.DecodeFromMulaw(audioChunk.ByteData, audioChunk.SampleRate).Data, audioChunk.SampleRate, nil
+	case audioChunk.EventType == models.AudioFrame:
+		return audio_utils.DecodeFromLinear16(audioChunk.ByteData, audioChunk.SampleRate).Data, audioChunk.SampleRate, nil
+	case audioChunk.Format != "wav":
+		return nil, 0, fmt.Errorf("cannot transcribe %s audio input", audioChunk.Format)
+	}
+	intBuffer, err := audio_utils.DecodeFromWav(audioChunk.ByteData)
+	if err != nil {
+		return nil, 0, err
+	}
+	return intBuffer.Data, intBuffer.Format.SampleRate, nil
+}
+
+// forwardTranscriptEvents until events get closed, and returns the transcript of the last utterance.
+// The language detected in the finals is passed to the conversation language, so the agent follows the caller.
+func forwardTranscriptEvents(events <-chan TranscriptEvent, language *models.ConversationLanguage, textChunksChan chan models.AudioData, earlyTranscriptChan chan string) string {
+	var transcriptBuilder strings.Builder
+	var utteranceStartTime *time.Time
+	sendEarlyTranscript := true
+	lastTranscript := ""
 
+	for event := range events {
+		if event.IsEndOfUtterance {
+			log.Info().Dur("end", event.End).Msg("transcription stream detected the end of utterance")
+			lastTranscript = transcriptBuilder.String()
 			transcriptBuilder.Reset()
-			earlyTranscriptStartTime = nil
+			utteranceStartTime = nil
 			sendEarlyTranscript = true
-
-			textChunksChan <- audioChunk
+			textChunksChan <- models.NewAudioDataSubmit("transcriber.stream")
 			continue
 		}
-
-		recordingBytes := audioChunk.ByteData
-		previousWords := transcriptBuilder.String()
-		transcript, err := transcriber.SendAudio(bytes.NewReader(recordingBytes), "wav", previousWords)
-		if err != nil {
-			log.Error().Err(err).Int("wav_chunk_byte_length", len(recordingBytes)).Msg("cannot transcribe audio, skipping chunk")
+		if !event.IsFinal {
+			// TODO(P1, latency): Interim results could start the agent early, when they are stable enough.
+			log.Debug().Str("transcription", event.Text).Float64("stability", event.Stability).Dur("end", event.End).Msg("interim transcription")
 			continue
 		}
-		// TODO(P0, ux): Here, we need to detect if a question was finished, interrupt voiced or passed turn to agent
-		// E.g. silence in whisper can be repeating last prompt words over and over like:
-		// * .. in 100 words. All right. All right. Well, please, let's do it. All right. Go. All right. All right.
-		// TODO: Add audio length here as a threshold
-		if len(transcript) >= 3 && strings.HasSuffix(previousWords, transcript) {
-			transcriptRepetitions += 1
-		} else {
-			transcriptRepetitions = 0
-		}
-		if transcriptRepetitions >= 2 {
-			log.Info().Msgf("transcripts repeated itself for %d times, gonna submit prompt. Transcript: %s", transcriptRepetitions, transcript)
-			textChunksChan <- models.NewAudioDataSubmit("transcriber.worker")
-			continue
+		if language != nil && language.Detected(event.Language, len(strings.Fields(event.Text))) {
+			log.Info().Str("language", language.Get()).Str("transcript", event.Text).Msg("TranscribeAudioRoutine switched the conversation language")
 		}
-		if transcriptRepetitions > 0 {
-			log.Info().Msgf("transcript repeated previous words, skipping audio for: %s", transcript)
-			continue
+		if utteranceStartTime == nil {
+			now := time.Now()
+			utteranceStartTime = &now
 		}
 
 		transcriptBuilder.WriteString(" ")
-		transcriptBuilder.WriteString(transcript)
+		transcriptBuilder.WriteString(event.Text)
 
-		audioChunk.Text = transcript
+		audioChunk := models.AudioData{
+			EventType: models.AudioInput,
+			Length:    event.End - event.Start,
+			Text:      event.Text,
+			Trace:     models.NewTrace("transcriber.stream"),
+		}
 		audioChunk.Trace.ProcessedAt = time.Now()
-		audioChunk.Trace.Processor = "transcribe_open_ai_whisper"
+		audioChunk.Trace.Processor = "transcribe_stream"
 		audioChunk.Trace.Log()
 		textChunksChan <- audioChunk
 
-		if sendEarlyTranscript && time.Since(*earlyTranscriptStartTime).Seconds() > 7 {
+		if sendEarlyTranscript && time.Since(*utteranceStartTime).Seconds() > 7 {
 			sendEarlyTranscript = false
 			select {
 			case earlyTranscriptChan <- transcriptBuilder.String():
@@ -81,9 +213,14 @@ func TranscribeAudioRoutine(transcriber Transcriber, audioChunksChan chan models
 			}
 		}
 	}
+	if transcriptBuilder.Len() > 0 {
+		lastTranscript = transcriptBuilder.String()
+	}
+	return lastTranscript
+}
 
-	finalTranscript := transcriptBuilder.String()
-	log.Info().Msgf("TranscribeAudioRoutine ended with finalTranscript %s", finalTranscript)
-	close(textChunksChan)
-	return finalTranscript
+func errLog(err error, what string) {
+	if err != nil {
+		log.Error().Err(errors.WithStack(err)).Msg(what)
+	}
 }
@@ -0,0 +1,6 @@
+// Package voicepb is the generated protobuf and gRPC code of voice.proto, regenerate it after editing with:
+//
+//	go generate ./pkg/voicepb
+package voicepb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative voice.proto
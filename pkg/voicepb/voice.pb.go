@@ -0,0 +1,988 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.34.2
+// 	protoc        (unknown)
+// source: voice.proto
+
+package voicepb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type ClientMessage struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Types that are assignable to Message:
+	//	*ClientMessage_Start
+	//	*ClientMessage_Audio
+	//	*ClientMessage_EndOfSpeech
+	//	*ClientMessage_Interrupt
+	//	*ClientMessage_HangUp
+	Message isClientMessage_Message `protobuf_oneof:"message"`
+}
+
+func (x *ClientMessage) Reset() {
+	*x = ClientMessage{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_voice_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ClientMessage) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ClientMessage) ProtoMessage() {}
+
+func (x *ClientMessage) ProtoReflect() protoreflect.Message {
+	mi := &file_voice_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ClientMessage.ProtoReflect.Descriptor instead.
+func (*ClientMessage) Descriptor() ([]byte, []int) {
+	return file_voice_proto_rawDescGZIP(), []int{0}
+}
+
+func (m *ClientMessage) GetMessage() isClientMessage_Message {
+	if m != nil {
+		return m.Message
+	}
+	return nil
+}
+
+func (x *ClientMessage) GetStart() *StartConversation {
+	if x, ok := x.GetMessage().(*ClientMessage_Start); ok {
+		return x.Start
+	}
+	return nil
+}
+
+func (x *ClientMessage) GetAudio() *AudioFrame {
+	if x, ok := x.GetMessage().(*ClientMessage_Audio); ok {
+		return x.Audio
+	}
+	return nil
+}
+
+func (x *ClientMessage) GetEndOfSpeech() *EndOfSpeech {
+	if x, ok := x.GetMessage().(*ClientMessage_EndOfSpeech); ok {
+		return x.EndOfSpeech
+	}
+	return nil
+}
+
+func (x *ClientMessage) GetInterrupt() *Interrupt {
+	if x, ok := x.GetMessage().(*ClientMessage_Interrupt); ok {
+		return x.Interrupt
+	}
+	return nil
+}
+
+func (x *ClientMessage) GetHangUp() *HangUp {
+	if x, ok := x.GetMessage().(*ClientMessage_HangUp); ok {
+		return x.HangUp
+	}
+	return nil
+}
+
+type isClientMessage_Message interface {
+	isClientMessage_Message()
+}
+
+type ClientMessage_Start struct {
+	Start *StartConversation `protobuf:"bytes,1,opt,name=start,proto3,oneof"`
+}
+
+type ClientMessage_Audio struct {
+	Audio *AudioFrame `protobuf:"bytes,2,opt,name=audio,proto3,oneof"`
+}
+
+type ClientMessage_EndOfSpeech struct {
+	EndOfSpeech *EndOfSpeech `protobuf:"bytes,3,opt,name=end_of_speech,json=endOfSpeech,proto3,oneof"`
+}
+
+type ClientMessage_Interrupt struct {
+	Interrupt *Interrupt `protobuf:"bytes,4,opt,name=interrupt,proto3,oneof"`
+}
+
+type ClientMessage_HangUp struct {
+	HangUp *HangUp `protobuf:"bytes,5,opt,name=hang_up,json=hangUp,proto3,oneof"`
+}
+
+func (*ClientMessage_Start) isClientMessage_Message() {}
+
+func (*ClientMessage_Audio) isClientMessage_Message() {}
+
+func (*ClientMessage_EndOfSpeech) isClientMessage_Message() {}
+
+func (*ClientMessage_Interrupt) isClientMessage_Message() {}
+
+func (*ClientMessage_HangUp) isClientMessage_Message() {}
+
+type ServerMessage struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Types that are assignable to Message:
+	//	*ServerMessage_Started
+	//	*ServerMessage_Transcript
+	//	*ServerMessage_AgentToken
+	//	*ServerMessage_Audio
+	//	*ServerMessage_Ended
+	Message isServerMessage_Message `protobuf_oneof:"message"`
+}
+
+func (x *ServerMessage) Reset() {
+	*x = ServerMessage{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_voice_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ServerMessage) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ServerMessage) ProtoMessage() {}
+
+func (x *ServerMessage) ProtoReflect() protoreflect.Message {
+	mi := &file_voice_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ServerMessage.ProtoReflect.Descriptor instead.
+func (*ServerMessage) Descriptor() ([]byte, []int) {
+	return file_voice_proto_rawDescGZIP(), []int{1}
+}
+
+func (m *ServerMessage) GetMessage() isServerMessage_Message {
+	if m != nil {
+		return m.Message
+	}
+	return nil
+}
+
+func (x *ServerMessage) GetStarted() *ConversationStarted {
+	if x, ok := x.GetMessage().(*ServerMessage_Started); ok {
+		return x.Started
+	}
+	return nil
+}
+
+func (x *ServerMessage) GetTranscript() *Transcript {
+	if x, ok := x.GetMessage().(*ServerMessage_Transcript); ok {
+		return x.Transcript
+	}
+	return nil
+}
+
+func (x *ServerMessage) GetAgentToken() *AgentToken {
+	if x, ok := x.GetMessage().(*ServerMessage_AgentToken); ok {
+		return x.AgentToken
+	}
+	return nil
+}
+
+func (x *ServerMessage) GetAudio() *AudioFrame {
+	if x, ok := x.GetMessage().(*ServerMessage_Audio); ok {
+		return x.Audio
+	}
+	return nil
+}
+
+func (x *ServerMessage) GetEnded() *ConversationEnded {
+	if x, ok := x.GetMessage().(*ServerMessage_Ended); ok {
+		return x.Ended
+	}
+	return nil
+}
+
+type isServerMessage_Message interface {
+	isServerMessage_Message()
+}
+
+type ServerMessage_Started struct {
+	Started *ConversationStarted `protobuf:"bytes,1,opt,name=started,proto3,oneof"`
+}
+
+type ServerMessage_Transcript struct {
+	Transcript *Transcript `protobuf:"bytes,2,opt,name=transcript,proto3,oneof"`
+}
+
+type ServerMessage_AgentToken struct {
+	AgentToken *AgentToken `protobuf:"bytes,3,opt,name=agent_token,json=agentToken,proto3,oneof"`
+}
+
+type ServerMessage_Audio struct {
+	Audio *AudioFrame `protobuf:"bytes,4,opt,name=audio,proto3,oneof"`
+}
+
+type ServerMessage_Ended struct {
+	Ended *ConversationEnded `protobuf:"bytes,5,opt,name=ended,proto3,oneof"`
+}
+
+func (*ServerMessage_Started) isServerMessage_Message() {}
+
+func (*ServerMessage_Transcript) isServerMessage_Message() {}
+
+func (*ServerMessage_AgentToken) isServerMessage_Message() {}
+
+func (*ServerMessage_Audio) isServerMessage_Message() {}
+
+func (*ServerMessage_Ended) isServerMessage_Message() {}
+
+type StartConversation struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// sample_rate of the AudioFrame-s in both directions, between 8000 and 48000.
+	SampleRate int32 `protobuf:"varint,1,opt,name=sample_rate,json=sampleRate,proto3" json:"sample_rate,omitempty"`
+	// parameters are the Twilio <Parameter>-s counterpart, e.g. agent_profile_id.
+	Parameters map[string]string `protobuf:"bytes,2,rep,name=parameters,proto3" json:"parameters,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+}
+
+func (x *StartConversation) Reset() {
+	*x = StartConversation{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_voice_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *StartConversation) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StartConversation) ProtoMessage() {}
+
+func (x *StartConversation) ProtoReflect() protoreflect.Message {
+	mi := &file_voice_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StartConversation.ProtoReflect.Descriptor instead.
+func (*StartConversation) Descriptor() ([]byte, []int) {
+	return file_voice_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *StartConversation) GetSampleRate() int32 {
+	if x != nil {
+		return x.SampleRate
+	}
+	return 0
+}
+
+func (x *StartConversation) GetParameters() map[string]string {
+	if x != nil {
+		return x.Parameters
+	}
+	return nil
+}
+
+// AudioFrame is mono linear16 (signed 16-bit little endian) at the StartConversation.sample_rate.
+// Outbound frames are sent in real time, so an Interrupt drops what was not sent yet.
+type AudioFrame struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Linear16 []byte `protobuf:"bytes,1,opt,name=linear16,proto3" json:"linear16,omitempty"`
+}
+
+func (x *AudioFrame) Reset() {
+	*x = AudioFrame{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_voice_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *AudioFrame) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AudioFrame) ProtoMessage() {}
+
+func (x *AudioFrame) ProtoReflect() protoreflect.Message {
+	mi := &file_voice_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AudioFrame.ProtoReflect.Descriptor instead.
+func (*AudioFrame) Descriptor() ([]byte, []int) {
+	return file_voice_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *AudioFrame) GetLinear16() []byte {
+	if x != nil {
+		return x.Linear16
+	}
+	return nil
+}
+
+// EndOfSpeech is for clients with their own VAD, it submits what the user said so far to the agent
+// without waiting for the server side silence detection.
+type EndOfSpeech struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *EndOfSpeech) Reset() {
+	*x = EndOfSpeech{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_voice_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *EndOfSpeech) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EndOfSpeech) ProtoMessage() {}
+
+func (x *EndOfSpeech) ProtoReflect() protoreflect.Message {
+	mi := &file_voice_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EndOfSpeech.ProtoReflect.Descriptor instead.
+func (*EndOfSpeech) Descriptor() ([]byte, []int) {
+	return file_voice_proto_rawDescGZIP(), []int{4}
+}
+
+// Interrupt drops the agent audio which was not sent yet, e.g. when the user barges in.
+type Interrupt struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *Interrupt) Reset() {
+	*x = Interrupt{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_voice_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Interrupt) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Interrupt) ProtoMessage() {}
+
+func (x *Interrupt) ProtoReflect() protoreflect.Message {
+	mi := &file_voice_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Interrupt.ProtoReflect.Descriptor instead.
+func (*Interrupt) Descriptor() ([]byte, []int) {
+	return file_voice_proto_rawDescGZIP(), []int{5}
+}
+
+// HangUp ends the conversation, the server then closes the stream.
+type HangUp struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *HangUp) Reset() {
+	*x = HangUp{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_voice_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *HangUp) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HangUp) ProtoMessage() {}
+
+func (x *HangUp) ProtoReflect() protoreflect.Message {
+	mi := &file_voice_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HangUp.ProtoReflect.Descriptor instead.
+func (*HangUp) Descriptor() ([]byte, []int) {
+	return file_voice_proto_rawDescGZIP(), []int{6}
+}
+
+type ConversationStarted struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	ConversationId string `protobuf:"bytes,1,opt,name=conversation_id,json=conversationId,proto3" json:"conversation_id,omitempty"`
+}
+
+func (x *ConversationStarted) Reset() {
+	*x = ConversationStarted{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_voice_proto_msgTypes[7]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ConversationStarted) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ConversationStarted) ProtoMessage() {}
+
+func (x *ConversationStarted) ProtoReflect() protoreflect.Message {
+	mi := &file_voice_proto_msgTypes[7]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ConversationStarted.ProtoReflect.Descriptor instead.
+func (*ConversationStarted) Descriptor() ([]byte, []int) {
+	return file_voice_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *ConversationStarted) GetConversationId() string {
+	if x != nil {
+		return x.ConversationId
+	}
+	return ""
+}
+
+// Transcript is a piece of what the user said, as it gets transcribed.
+type Transcript struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Text string `protobuf:"bytes,1,opt,name=text,proto3" json:"text,omitempty"`
+}
+
+func (x *Transcript) Reset() {
+	*x = Transcript{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_voice_proto_msgTypes[8]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Transcript) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Transcript) ProtoMessage() {}
+
+func (x *Transcript) ProtoReflect() protoreflect.Message {
+	mi := &file_voice_proto_msgTypes[8]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Transcript.ProtoReflect.Descriptor instead.
+func (*Transcript) Descriptor() ([]byte, []int) {
+	return file_voice_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *Transcript) GetText() string {
+	if x != nil {
+		return x.Text
+	}
+	return ""
+}
+
+// AgentToken is a piece of what the agent says, as it gets generated.
+type AgentToken struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Text string `protobuf:"bytes,1,opt,name=text,proto3" json:"text,omitempty"`
+}
+
+func (x *AgentToken) Reset() {
+	*x = AgentToken{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_voice_proto_msgTypes[9]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *AgentToken) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AgentToken) ProtoMessage() {}
+
+func (x *AgentToken) ProtoReflect() protoreflect.Message {
+	mi := &file_voice_proto_msgTypes[9]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AgentToken.ProtoReflect.Descriptor instead.
+func (*AgentToken) Descriptor() ([]byte, []int) {
+	return file_voice_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *AgentToken) GetText() string {
+	if x != nil {
+		return x.Text
+	}
+	return ""
+}
+
+type ConversationEnded struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// reason is e.g. "agent_hangup", "client_hangup" or "shutdown".
+	Reason string `protobuf:"bytes,1,opt,name=reason,proto3" json:"reason,omitempty"`
+}
+
+func (x *ConversationEnded) Reset() {
+	*x = ConversationEnded{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_voice_proto_msgTypes[10]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ConversationEnded) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ConversationEnded) ProtoMessage() {}
+
+func (x *ConversationEnded) ProtoReflect() protoreflect.Message {
+	mi := &file_voice_proto_msgTypes[10]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ConversationEnded.ProtoReflect.Descriptor instead.
+func (*ConversationEnded) Descriptor() ([]byte, []int) {
+	return file_voice_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *ConversationEnded) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+var File_voice_proto protoreflect.FileDescriptor
+
+var file_voice_proto_rawDesc = []byte{
+	0x0a, 0x0b, 0x76, 0x6f, 0x69, 0x63, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x0f, 0x76,
+	0x6f, 0x63, 0x6f, 0x64, 0x65, 0x2e, 0x76, 0x6f, 0x69, 0x63, 0x65, 0x2e, 0x76, 0x31, 0x22, 0xbf,
+	0x02, 0x0a, 0x0d, 0x43, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65,
+	0x12, 0x3a, 0x0a, 0x05, 0x73, 0x74, 0x61, 0x72, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32,
+	0x22, 0x2e, 0x76, 0x6f, 0x63, 0x6f, 0x64, 0x65, 0x2e, 0x76, 0x6f, 0x69, 0x63, 0x65, 0x2e, 0x76,
+	0x31, 0x2e, 0x53, 0x74, 0x61, 0x72, 0x74, 0x43, 0x6f, 0x6e, 0x76, 0x65, 0x72, 0x73, 0x61, 0x74,
+	0x69, 0x6f, 0x6e, 0x48, 0x00, 0x52, 0x05, 0x73, 0x74, 0x61, 0x72, 0x74, 0x12, 0x33, 0x0a, 0x05,
+	0x61, 0x75, 0x64, 0x69, 0x6f, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1b, 0x2e, 0x76, 0x6f,
+	0x63, 0x6f, 0x64, 0x65, 0x2e, 0x76, 0x6f, 0x69, 0x63, 0x65, 0x2e, 0x76, 0x31, 0x2e, 0x41, 0x75,
+	0x64, 0x69, 0x6f, 0x46, 0x72, 0x61, 0x6d, 0x65, 0x48, 0x00, 0x52, 0x05, 0x61, 0x75, 0x64, 0x69,
+	0x6f, 0x12, 0x42, 0x0a, 0x0d, 0x65, 0x6e, 0x64, 0x5f, 0x6f, 0x66, 0x5f, 0x73, 0x70, 0x65, 0x65,
+	0x63, 0x68, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1c, 0x2e, 0x76, 0x6f, 0x63, 0x6f, 0x64,
+	0x65, 0x2e, 0x76, 0x6f, 0x69, 0x63, 0x65, 0x2e, 0x76, 0x31, 0x2e, 0x45, 0x6e, 0x64, 0x4f, 0x66,
+	0x53, 0x70, 0x65, 0x65, 0x63, 0x68, 0x48, 0x00, 0x52, 0x0b, 0x65, 0x6e, 0x64, 0x4f, 0x66, 0x53,
+	0x70, 0x65, 0x65, 0x63, 0x68, 0x12, 0x3a, 0x0a, 0x09, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x72, 0x75,
+	0x70, 0x74, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x76, 0x6f, 0x63, 0x6f, 0x64,
+	0x65, 0x2e, 0x76, 0x6f, 0x69, 0x63, 0x65, 0x2e, 0x76, 0x31, 0x2e, 0x49, 0x6e, 0x74, 0x65, 0x72,
+	0x72, 0x75, 0x70, 0x74, 0x48, 0x00, 0x52, 0x09, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x72, 0x75, 0x70,
+	0x74, 0x12, 0x32, 0x0a, 0x07, 0x68, 0x61, 0x6e, 0x67, 0x5f, 0x75, 0x70, 0x18, 0x05, 0x20, 0x01,
+	0x28, 0x0b, 0x32, 0x17, 0x2e, 0x76, 0x6f, 0x63, 0x6f, 0x64, 0x65, 0x2e, 0x76, 0x6f, 0x69, 0x63,
+	0x65, 0x2e, 0x76, 0x31, 0x2e, 0x48, 0x61, 0x6e, 0x67, 0x55, 0x70, 0x48, 0x00, 0x52, 0x06, 0x68,
+	0x61, 0x6e, 0x67, 0x55, 0x70, 0x42, 0x09, 0x0a, 0x07, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65,
+	0x22, 0xcc, 0x02, 0x0a, 0x0d, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72, 0x4d, 0x65, 0x73, 0x73, 0x61,
+	0x67, 0x65, 0x12, 0x40, 0x0a, 0x07, 0x73, 0x74, 0x61, 0x72, 0x74, 0x65, 0x64, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x0b, 0x32, 0x24, 0x2e, 0x76, 0x6f, 0x63, 0x6f, 0x64, 0x65, 0x2e, 0x76, 0x6f, 0x69,
+	0x63, 0x65, 0x2e, 0x76, 0x31, 0x2e, 0x43, 0x6f, 0x6e, 0x76, 0x65, 0x72, 0x73, 0x61, 0x74, 0x69,
+	0x6f, 0x6e, 0x53, 0x74, 0x61, 0x72, 0x74, 0x65, 0x64, 0x48, 0x00, 0x52, 0x07, 0x73, 0x74, 0x61,
+	0x72, 0x74, 0x65, 0x64, 0x12, 0x3d, 0x0a, 0x0a, 0x74, 0x72, 0x61, 0x6e, 0x73, 0x63, 0x72, 0x69,
+	0x70, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1b, 0x2e, 0x76, 0x6f, 0x63, 0x6f, 0x64,
+	0x65, 0x2e, 0x76, 0x6f, 0x69, 0x63, 0x65, 0x2e, 0x76, 0x31, 0x2e, 0x54, 0x72, 0x61, 0x6e, 0x73,
+	0x63, 0x72, 0x69, 0x70, 0x74, 0x48, 0x00, 0x52, 0x0a, 0x74, 0x72, 0x61, 0x6e, 0x73, 0x63, 0x72,
+	0x69, 0x70, 0x74, 0x12, 0x3e, 0x0a, 0x0b, 0x61, 0x67, 0x65, 0x6e, 0x74, 0x5f, 0x74, 0x6f, 0x6b,
+	0x65, 0x6e, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1b, 0x2e, 0x76, 0x6f, 0x63, 0x6f, 0x64,
+	0x65, 0x2e, 0x76, 0x6f, 0x69, 0x63, 0x65, 0x2e, 0x76, 0x31, 0x2e, 0x41, 0x67, 0x65, 0x6e, 0x74,
+	0x54, 0x6f, 0x6b, 0x65, 0x6e, 0x48, 0x00, 0x52, 0x0a, 0x61, 0x67, 0x65, 0x6e, 0x74, 0x54, 0x6f,
+	0x6b, 0x65, 0x6e, 0x12, 0x33, 0x0a, 0x05, 0x61, 0x75, 0x64, 0x69, 0x6f, 0x18, 0x04, 0x20, 0x01,
+	0x28, 0x0b, 0x32, 0x1b, 0x2e, 0x76, 0x6f, 0x63, 0x6f, 0x64, 0x65, 0x2e, 0x76, 0x6f, 0x69, 0x63,
+	0x65, 0x2e, 0x76, 0x31, 0x2e, 0x41, 0x75, 0x64, 0x69, 0x6f, 0x46, 0x72, 0x61, 0x6d, 0x65, 0x48,
+	0x00, 0x52, 0x05, 0x61, 0x75, 0x64, 0x69, 0x6f, 0x12, 0x3a, 0x0a, 0x05, 0x65, 0x6e, 0x64, 0x65,
+	0x64, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x22, 0x2e, 0x76, 0x6f, 0x63, 0x6f, 0x64, 0x65,
+	0x2e, 0x76, 0x6f, 0x69, 0x63, 0x65, 0x2e, 0x76, 0x31, 0x2e, 0x43, 0x6f, 0x6e, 0x76, 0x65, 0x72,
+	0x73, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x45, 0x6e, 0x64, 0x65, 0x64, 0x48, 0x00, 0x52, 0x05, 0x65,
+	0x6e, 0x64, 0x65, 0x64, 0x42, 0x09, 0x0a, 0x07, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x22,
+	0xc7, 0x01, 0x0a, 0x11, 0x53, 0x74, 0x61, 0x72, 0x74, 0x43, 0x6f, 0x6e, 0x76, 0x65, 0x72, 0x73,
+	0x61, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x1f, 0x0a, 0x0b, 0x73, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x5f,
+	0x72, 0x61, 0x74, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x05, 0x52, 0x0a, 0x73, 0x61, 0x6d, 0x70,
+	0x6c, 0x65, 0x52, 0x61, 0x74, 0x65, 0x12, 0x52, 0x0a, 0x0a, 0x70, 0x61, 0x72, 0x61, 0x6d, 0x65,
+	0x74, 0x65, 0x72, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x32, 0x2e, 0x76, 0x6f, 0x63,
+	0x6f, 0x64, 0x65, 0x2e, 0x76, 0x6f, 0x69, 0x63, 0x65, 0x2e, 0x76, 0x31, 0x2e, 0x53, 0x74, 0x61,
+	0x72, 0x74, 0x43, 0x6f, 0x6e, 0x76, 0x65, 0x72, 0x73, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2e, 0x50,
+	0x61, 0x72, 0x61, 0x6d, 0x65, 0x74, 0x65, 0x72, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x52, 0x0a,
+	0x70, 0x61, 0x72, 0x61, 0x6d, 0x65, 0x74, 0x65, 0x72, 0x73, 0x1a, 0x3d, 0x0a, 0x0f, 0x50, 0x61,
+	0x72, 0x61, 0x6d, 0x65, 0x74, 0x65, 0x72, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x12, 0x10, 0x0a,
+	0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12,
+	0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05,
+	0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a, 0x02, 0x38, 0x01, 0x22, 0x28, 0x0a, 0x0a, 0x41, 0x75, 0x64,
+	0x69, 0x6f, 0x46, 0x72, 0x61, 0x6d, 0x65, 0x12, 0x1a, 0x0a, 0x08, 0x6c, 0x69, 0x6e, 0x65, 0x61,
+	0x72, 0x31, 0x36, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x08, 0x6c, 0x69, 0x6e, 0x65, 0x61,
+	0x72, 0x31, 0x36, 0x22, 0x0d, 0x0a, 0x0b, 0x45, 0x6e, 0x64, 0x4f, 0x66, 0x53, 0x70, 0x65, 0x65,
+	0x63, 0x68, 0x22, 0x0b, 0x0a, 0x09, 0x49, 0x6e, 0x74, 0x65, 0x72, 0x72, 0x75, 0x70, 0x74, 0x22,
+	0x08, 0x0a, 0x06, 0x48, 0x61, 0x6e, 0x67, 0x55, 0x70, 0x22, 0x3e, 0x0a, 0x13, 0x43, 0x6f, 0x6e,
+	0x76, 0x65, 0x72, 0x73, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x53, 0x74, 0x61, 0x72, 0x74, 0x65, 0x64,
+	0x12, 0x27, 0x0a, 0x0f, 0x63, 0x6f, 0x6e, 0x76, 0x65, 0x72, 0x73, 0x61, 0x74, 0x69, 0x6f, 0x6e,
+	0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0e, 0x63, 0x6f, 0x6e, 0x76, 0x65,
+	0x72, 0x73, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x49, 0x64, 0x22, 0x20, 0x0a, 0x0a, 0x54, 0x72, 0x61,
+	0x6e, 0x73, 0x63, 0x72, 0x69, 0x70, 0x74, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x65, 0x78, 0x74, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x74, 0x65, 0x78, 0x74, 0x22, 0x20, 0x0a, 0x0a, 0x41,
+	0x67, 0x65, 0x6e, 0x74, 0x54, 0x6f, 0x6b, 0x65, 0x6e, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x65, 0x78,
+	0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x74, 0x65, 0x78, 0x74, 0x22, 0x2b, 0x0a,
+	0x11, 0x43, 0x6f, 0x6e, 0x76, 0x65, 0x72, 0x73, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x45, 0x6e, 0x64,
+	0x65, 0x64, 0x12, 0x16, 0x0a, 0x06, 0x72, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x06, 0x72, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x32, 0x5e, 0x0a, 0x0c, 0x56, 0x6f,
+	0x69, 0x63, 0x65, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x12, 0x4e, 0x0a, 0x08, 0x43, 0x6f,
+	0x6e, 0x76, 0x65, 0x72, 0x73, 0x65, 0x12, 0x1e, 0x2e, 0x76, 0x6f, 0x63, 0x6f, 0x64, 0x65, 0x2e,
+	0x76, 0x6f, 0x69, 0x63, 0x65, 0x2e, 0x76, 0x31, 0x2e, 0x43, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x4d,
+	0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x1a, 0x1e, 0x2e, 0x76, 0x6f, 0x63, 0x6f, 0x64, 0x65, 0x2e,
+	0x76, 0x6f, 0x69, 0x63, 0x65, 0x2e, 0x76, 0x31, 0x2e, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72, 0x4d,
+	0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x28, 0x01, 0x30, 0x01, 0x42, 0x2f, 0x5a, 0x2d, 0x67, 0x69,
+	0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x70, 0x65, 0x74, 0x72, 0x7a, 0x6c, 0x65,
+	0x6e, 0x2f, 0x76, 0x6f, 0x63, 0x6f, 0x64, 0x65, 0x2d, 0x67, 0x6f, 0x6c, 0x61, 0x6e, 0x67, 0x2f,
+	0x70, 0x6b, 0x67, 0x2f, 0x76, 0x6f, 0x69, 0x63, 0x65, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x33,
+}
+
+var (
+	file_voice_proto_rawDescOnce sync.Once
+	file_voice_proto_rawDescData = file_voice_proto_rawDesc
+)
+
+func file_voice_proto_rawDescGZIP() []byte {
+	file_voice_proto_rawDescOnce.Do(func() {
+		file_voice_proto_rawDescData = protoimpl.X.CompressGZIP(file_voice_proto_rawDescData)
+	})
+	return file_voice_proto_rawDescData
+}
+
+var file_voice_proto_msgTypes = make([]protoimpl.MessageInfo, 12)
+var file_voice_proto_goTypes = []any{
+	(*ClientMessage)(nil),       // 0: vocode.voice.v1.ClientMessage
+	(*ServerMessage)(nil),       // 1: vocode.voice.v1.ServerMessage
+	(*StartConversation)(nil),   // 2: vocode.voice.v1.StartConversation
+	(*AudioFrame)(nil),          // 3: vocode.voice.v1.AudioFrame
+	(*EndOfSpeech)(nil),         // 4: vocode.voice.v1.EndOfSpeech
+	(*Interrupt)(nil),           // 5: vocode.voice.v1.Interrupt
+	(*HangUp)(nil),              // 6: vocode.voice.v1.HangUp
+	(*ConversationStarted)(nil), // 7: vocode.voice.v1.ConversationStarted
+	(*Transcript)(nil),          // 8: vocode.voice.v1.Transcript
+	(*AgentToken)(nil),          // 9: vocode.voice.v1.AgentToken
+	(*ConversationEnded)(nil),   // 10: vocode.voice.v1.ConversationEnded
+	nil,                         // 11: vocode.voice.v1.StartConversation.ParametersEntry
+}
+var file_voice_proto_depIdxs = []int32{
+	2,  // 0: vocode.voice.v1.ClientMessage.start:type_name -> vocode.voice.v1.StartConversation
+	3,  // 1: vocode.voice.v1.ClientMessage.audio:type_name -> vocode.voice.v1.AudioFrame
+	4,  // 2: vocode.voice.v1.ClientMessage.end_of_speech:type_name -> vocode.voice.v1.EndOfSpeech
+	5,  // 3: vocode.voice.v1.ClientMessage.interrupt:type_name -> vocode.voice.v1.Interrupt
+	6,  // 4: vocode.voice.v1.ClientMessage.hang_up:type_name -> vocode.voice.v1.HangUp
+	7,  // 5: vocode.voice.v1.ServerMessage.started:type_name -> vocode.voice.v1.ConversationStarted
+	8,  // 6: vocode.voice.v1.ServerMessage.transcript:type_name -> vocode.voice.v1.Transcript
+	9,  // 7: vocode.voice.v1.ServerMessage.agent_token:type_name -> vocode.voice.v1.AgentToken
+	3,  // 8: vocode.voice.v1.ServerMessage.audio:type_name -> vocode.voice.v1.AudioFrame
+	10, // 9: vocode.voice.v1.ServerMessage.ended:type_name -> vocode.voice.v1.ConversationEnded
+	11, // 10: vocode.voice.v1.StartConversation.parameters:type_name -> vocode.voice.v1.StartConversation.ParametersEntry
+	0,  // 11: vocode.voice.v1.VoiceService.Converse:input_type -> vocode.voice.v1.ClientMessage
+	1,  // 12: vocode.voice.v1.VoiceService.Converse:output_type -> vocode.voice.v1.ServerMessage
+	12, // [12:13] is the sub-list for method output_type
+	11, // [11:12] is the sub-list for method input_type
+	11, // [11:11] is the sub-list for extension type_name
+	11, // [11:11] is the sub-list for extension extendee
+	0,  // [0:11] is the sub-list for field type_name
+}
+
+func init() { file_voice_proto_init() }
+func file_voice_proto_init() {
+	if File_voice_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_voice_proto_msgTypes[0].Exporter = func(v any, i int) any {
+			switch v := v.(*ClientMessage); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_voice_proto_msgTypes[1].Exporter = func(v any, i int) any {
+			switch v := v.(*ServerMessage); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_voice_proto_msgTypes[2].Exporter = func(v any, i int) any {
+			switch v := v.(*StartConversation); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_voice_proto_msgTypes[3].Exporter = func(v any, i int) any {
+			switch v := v.(*AudioFrame); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_voice_proto_msgTypes[4].Exporter = func(v any, i int) any {
+			switch v := v.(*EndOfSpeech); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_voice_proto_msgTypes[5].Exporter = func(v any, i int) any {
+			switch v := v.(*Interrupt); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_voice_proto_msgTypes[6].Exporter = func(v any, i int) any {
+			switch v := v.(*HangUp); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_voice_proto_msgTypes[7].Exporter = func(v any, i int) any {
+			switch v := v.(*ConversationStarted); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_voice_proto_msgTypes[8].Exporter = func(v any, i int) any {
+			switch v := v.(*Transcript); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_voice_proto_msgTypes[9].Exporter = func(v any, i int) any {
+			switch v := v.(*AgentToken); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_voice_proto_msgTypes[10].Exporter = func(v any, i int) any {
+			switch v := v.(*ConversationEnded); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	file_voice_proto_msgTypes[0].OneofWrappers = []any{
+		(*ClientMessage_Start)(nil),
+		(*ClientMessage_Audio)(nil),
+		(*ClientMessage_EndOfSpeech)(nil),
+		(*ClientMessage_Interrupt)(nil),
+		(*ClientMessage_HangUp)(nil),
+	}
+	file_voice_proto_msgTypes[1].OneofWrappers = []any{
+		(*ServerMessage_Started)(nil),
+		(*ServerMessage_Transcript)(nil),
+		(*ServerMessage_AgentToken)(nil),
+		(*ServerMessage_Audio)(nil),
+		(*ServerMessage_Ended)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_voice_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   12,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_voice_proto_goTypes,
+		DependencyIndexes: file_voice_proto_depIdxs,
+		MessageInfos:      file_voice_proto_msgTypes,
+	}.Build()
+	File_voice_proto = out.File
+	file_voice_proto_rawDesc = nil
+	file_voice_proto_goTypes = nil
+	file_voice_proto_depIdxs = nil
+}
@@ -0,0 +1,123 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: voice.proto
+
+package voicepb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	VoiceService_Converse_FullMethodName = "/vocode.voice.v1.VoiceService/Converse"
+)
+
+// VoiceServiceClient is the client API for VoiceService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// VoiceService runs vocode conversations for other services, without a telephony provider in between.
+type VoiceServiceClient interface {
+	// Converse is one conversation, the first client message must be start.
+	// The server ends the stream once the conversation is over, e.g. after hang_up or when the agent hangs up.
+	Converse(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[ClientMessage, ServerMessage], error)
+}
+
+type voiceServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewVoiceServiceClient(cc grpc.ClientConnInterface) VoiceServiceClient {
+	return &voiceServiceClient{cc}
+}
+
+func (c *voiceServiceClient) Converse(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[ClientMessage, ServerMessage], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &VoiceService_ServiceDesc.Streams[0], VoiceService_Converse_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ClientMessage, ServerMessage]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type VoiceService_ConverseClient = grpc.BidiStreamingClient[ClientMessage, ServerMessage]
+
+// VoiceServiceServer is the server API for VoiceService service.
+// All implementations must embed UnimplementedVoiceServiceServer
+// for forward compatibility.
+//
+// VoiceService runs vocode conversations for other services, without a telephony provider in between.
+type VoiceServiceServer interface {
+	// Converse is one conversation, the first client message must be start.
+	// The server ends the stream once the conversation is over, e.g. after hang_up or when the agent hangs up.
+	Converse(grpc.BidiStreamingServer[ClientMessage, ServerMessage]) error
+	mustEmbedUnimplementedVoiceServiceServer()
+}
+
+// UnimplementedVoiceServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedVoiceServiceServer struct{}
+
+func (UnimplementedVoiceServiceServer) Converse(grpc.BidiStreamingServer[ClientMessage, ServerMessage]) error {
+	return status.Errorf(codes.Unimplemented, "method Converse not implemented")
+}
+func (UnimplementedVoiceServiceServer) mustEmbedUnimplementedVoiceServiceServer() {}
+func (UnimplementedVoiceServiceServer) testEmbeddedByValue()                      {}
+
+// UnsafeVoiceServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to VoiceServiceServer will
+// result in compilation errors.
+type UnsafeVoiceServiceServer interface {
+	mustEmbedUnimplementedVoiceServiceServer()
+}
+
+func RegisterVoiceServiceServer(s grpc.ServiceRegistrar, srv VoiceServiceServer) {
+	// If the following call pancis, it indicates UnimplementedVoiceServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&VoiceService_ServiceDesc, srv)
+}
+
+func _VoiceService_Converse_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(VoiceServiceServer).Converse(&grpc.GenericServerStream[ClientMessage, ServerMessage]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type VoiceService_ConverseServer = grpc.BidiStreamingServer[ClientMessage, ServerMessage]
+
+// VoiceService_ServiceDesc is the grpc.ServiceDesc for VoiceService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var VoiceService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "vocode.voice.v1.VoiceService",
+	HandlerType: (*VoiceServiceServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Converse",
+			Handler:       _VoiceService_Converse_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "voice.proto",
+}